@@ -0,0 +1,426 @@
+// Package admin provides an HTTP/JSON API for operating a dhcp4.Server:
+// inspecting and releasing leases, managing host reservations, and
+// reporting pool utilisation and configuration.
+//
+// Paths are relative to where the API is mounted:
+//
+//	GET    /leases               All leases, filtered by ?ip=, ?mac= and ?hostname=
+//	GET    /leases/{ip}
+//	DELETE /leases/{ip}          Force release
+//	GET    /reservations
+//	POST   /reservations
+//	GET    /reservations/{name}
+//	PUT    /reservations/{name}
+//	DELETE /reservations/{name}
+//	GET    /pools                Utilisation of each pool
+//	GET    /stats                Lease counts and overall utilisation
+//	GET    /config
+//
+// For example:
+//
+//	api := admin.New(server)
+//	api.Auth = admin.BearerToken(token)
+//	api.Mount(http.DefaultServeMux, "/dhcp")
+//
+// Reservation changes apply to the running server only, and are lost if its
+// configuration is reloaded from file.
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Middleware wraps a handler, typically to authenticate requests.
+type Middleware func(http.Handler) http.Handler
+
+// API is an http.Handler serving the admin API of a server.
+type API struct {
+	Server *dhcp4.Server
+	Auth   Middleware // If set, requests must pass it
+
+	mu sync.Mutex // Serialises configuration changes
+}
+
+// New returns the API of s.
+func New(s *dhcp4.Server) *API {
+	return &API{Server: s}
+}
+
+// Mount registers the API at prefix (e.g. "/dhcp") in mux.
+func (a *API) Mount(mux *http.ServeMux, prefix string) {
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, a))
+}
+
+func (a *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if a.Auth != nil {
+		a.Auth(http.HandlerFunc(a.serve)).ServeHTTP(w, r)
+		return
+	}
+	a.serve(w, r)
+}
+
+// httpError is an error with an HTTP status.
+type httpError struct {
+	status int
+	msg    string
+}
+
+func (e *httpError) Error() string { return e.msg }
+
+var (
+	errNotFound         = &httpError{http.StatusNotFound, "not found"}
+	errMethodNotAllowed = &httpError{http.StatusMethodNotAllowed, "method not allowed"}
+)
+
+func badRequest(err error) error { return &httpError{http.StatusBadRequest, err.Error()} }
+
+func (a *API) serve(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	resource, id := path, ""
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		resource, id = path[:i], path[i+1:]
+	}
+	var v interface{}
+	var err error
+	switch {
+	case resource == "leases" && id == "":
+		v, err = a.leases(r)
+	case resource == "leases":
+		v, err = a.lease(r, id)
+	case resource == "reservations" && id == "":
+		v, err = a.reservations(r)
+	case resource == "reservations":
+		v, err = a.reservation(r, id)
+	case path == "pools" && r.Method == http.MethodGet:
+		v = a.pools()
+	case path == "stats" && r.Method == http.MethodGet:
+		v = a.stats()
+	case path == "config" && r.Method == http.MethodGet:
+		v = configView(a.Server.Config())
+	case path == "pools" || path == "stats" || path == "config":
+		err = errMethodNotAllowed
+	default:
+		err = errNotFound
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch e, ok := err.(*httpError); {
+	case ok:
+		w.WriteHeader(e.status)
+		v = map[string]string{"error": e.msg}
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		v = map[string]string{"error": err.Error()}
+	case v == nil:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case r.Method == http.MethodPost:
+		w.WriteHeader(http.StatusCreated)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// leases lists leases, in address order.
+func (a *API) leases(r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+	q := r.URL.Query()
+	var ip net.IP
+	var mac net.HardwareAddr
+	var err error
+	if s := q.Get("ip"); s != "" {
+		if ip = net.ParseIP(s); ip == nil {
+			return nil, badRequest(errors.New("bad ip"))
+		}
+	}
+	if s := q.Get("mac"); s != "" {
+		if mac, err = net.ParseMAC(s); err != nil {
+			return nil, badRequest(err)
+		}
+	}
+	hostname := strings.ToLower(q.Get("hostname"))
+
+	var leases []dhcp4.Lease
+	a.Server.Leases().Iterate(func(l dhcp4.Lease) bool {
+		if (ip == nil || l.IP.Equal(ip)) &&
+			(mac == nil || l.HardwareAddr.String() == mac.String()) &&
+			(hostname == "" || strings.Contains(strings.ToLower(l.Hostname), hostname)) {
+			leases = append(leases, l)
+		}
+		return true
+	})
+	sort.Slice(leases, func(i, j int) bool { return dhcp4.IPLess(leases[i].IP, leases[j].IP) })
+	now := time.Now()
+	views := make([]Lease, len(leases))
+	for i, l := range leases {
+		views[i] = leaseView(l, now)
+	}
+	return views, nil
+}
+
+// lease gets or force releases the lease of address s.
+func (a *API) lease(r *http.Request, s string) (interface{}, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return nil, errNotFound
+	}
+	l, ok := a.Server.Leases().Get(ip)
+	if !ok {
+		return nil, errNotFound
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return leaseView(l, time.Now()), nil
+	case http.MethodDelete:
+		return nil, a.Server.Leases().Release(ip)
+	}
+	return nil, errMethodNotAllowed
+}
+
+func (a *API) reservations(r *http.Request) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		views := []Reservation{}
+		for _, res := range a.Server.Config().Reservations {
+			views = append(views, reservationView(res))
+		}
+		return views, nil
+	case http.MethodPost:
+		res, err := readReservation(r)
+		if err != nil {
+			return nil, err
+		}
+		err = a.updateReservations(func(rs []*dhcp4.Reservation) ([]*dhcp4.Reservation, error) {
+			if find(rs, res.Name) >= 0 {
+				return nil, &httpError{http.StatusConflict, "reservation " + res.Name + " exists"}
+			}
+			return append(rs, res), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return reservationView(res), nil
+	}
+	return nil, errMethodNotAllowed
+}
+
+// reservation gets, replaces or deletes the reservation named name.
+func (a *API) reservation(r *http.Request, name string) (interface{}, error) {
+	switch r.Method {
+	case http.MethodGet:
+		rs := a.Server.Config().Reservations
+		if i := find(rs, name); i >= 0 {
+			return reservationView(rs[i]), nil
+		}
+		return nil, errNotFound
+	case http.MethodPut:
+		res, err := readReservation(r)
+		if err != nil {
+			return nil, err
+		}
+		if res.Name != name {
+			return nil, badRequest(errors.New("reservation name doesn't match path"))
+		}
+		err = a.updateReservations(func(rs []*dhcp4.Reservation) ([]*dhcp4.Reservation, error) {
+			if i := find(rs, name); i >= 0 {
+				rs[i] = res
+				return rs, nil
+			}
+			return append(rs, res), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return reservationView(res), nil
+	case http.MethodDelete:
+		return nil, a.updateReservations(func(rs []*dhcp4.Reservation) ([]*dhcp4.Reservation, error) {
+			i := find(rs, name)
+			if i < 0 {
+				return nil, errNotFound
+			}
+			return append(rs[:i], rs[i+1:]...), nil
+		})
+	}
+	return nil, errMethodNotAllowed
+}
+
+func readReservation(r *http.Request) (*dhcp4.Reservation, error) {
+	var v Reservation
+	if err := json.NewDecoder(r.Body).Decode(&v); err != nil {
+		return nil, badRequest(err)
+	}
+	res, err := v.reservation()
+	if err != nil {
+		return nil, badRequest(err)
+	}
+	return res, nil
+}
+
+func find(rs []*dhcp4.Reservation, name string) int {
+	for i, r := range rs {
+		if r.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// updateReservations applies fn to a copy of the server's reservations, and
+// if the result is consistent, has the server use it.
+func (a *API) updateReservations(fn func([]*dhcp4.Reservation) ([]*dhcp4.Reservation, error)) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c := *a.Server.Config()
+	rs, err := fn(append([]*dhcp4.Reservation(nil), c.Reservations...))
+	if err != nil {
+		return err
+	}
+	if err := checkReservations(&c, rs); err != nil {
+		return err
+	}
+	c.Reservations = rs
+	a.Server.SetConfig(&c)
+	return nil
+}
+
+// checkReservations returns an error if reservations clash with each other,
+// or reserve addresses off c's subnets.
+func checkReservations(c *dhcp4.ServerConfig, rs []*dhcp4.Reservation) error {
+	ids := make(map[string]string)
+	for _, r := range rs {
+		var keys []string
+		if len(r.HardwareAddr) > 0 {
+			keys = append(keys, "mac="+r.HardwareAddr.String())
+		}
+		if len(r.ClientID) > 0 {
+			keys = append(keys, "client_id="+string(r.ClientID))
+		}
+		if r.IP != nil {
+			keys = append(keys, "ip="+r.IP.String())
+			if !onSubnet(c, r.IP) {
+				return badRequest(errors.New("address " + r.IP.String() + " isn't on a subnet"))
+			}
+		}
+		for _, k := range keys {
+			if other, ok := ids[k]; ok {
+				return &httpError{http.StatusConflict, "reservation " + r.Name + " has the same " + strings.SplitN(k, "=", 2)[0] + " as " + other}
+			}
+			ids[k] = r.Name
+		}
+	}
+	return nil
+}
+
+func onSubnet(c *dhcp4.ServerConfig, ip net.IP) bool {
+	if c.Subnets == nil {
+		return false
+	}
+	for _, n := range c.Subnets.Networks() {
+		for _, sn := range n.Subnets {
+			if sn.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *API) pools() []Pool { return Pools(a.Server) }
+
+// Pools reports the utilisation of each of s's pools.
+func Pools(s *dhcp4.Server) []Pool {
+	c := s.Config()
+	var pools []Pool
+	var index []*dhcp4.Pool
+	if c.Subnets != nil {
+		for _, n := range c.Subnets.Networks() {
+			for _, sn := range n.Subnets {
+				for _, p := range sn.Pools {
+					pools = append(pools, Pool{
+						Network: n.Name,
+						Subnet:  sn.Net.String(),
+						Start:   p.Start.String(),
+						End:     dhcp4.IPAdd(p.Start, p.Range-1).String(),
+						Classes: p.Classes,
+						Size:    p.Range,
+					})
+					index = append(index, p)
+				}
+			}
+		}
+	}
+	now := time.Now()
+	s.Leases().Iterate(func(l dhcp4.Lease) bool {
+		if l.Expired(now) {
+			return true
+		}
+		for i, p := range index {
+			if p.Contains(l.IP) {
+				if l.Quarantined {
+					pools[i].Quarantined++
+				} else {
+					pools[i].Leased++
+				}
+				break
+			}
+		}
+		return true
+	})
+	for i := range pools {
+		p := &pools[i]
+		p.Free = p.Size - p.Leased - p.Quarantined
+		if p.Size > 0 {
+			p.Utilisation = 100 * float64(p.Leased+p.Quarantined) / float64(p.Size)
+		}
+	}
+	if pools == nil {
+		pools = []Pool{}
+	}
+	return pools
+}
+
+// Stats summarises a server's leases and pools.
+type Stats struct {
+	Active      int     `json:"active"`      // Unexpired leases
+	Expired     int     `json:"expired"`     // Expired leases still stored
+	Quarantined int     `json:"quarantined"` // Addresses held back from clients
+	PoolSize    int     `json:"pool_size"`
+	Free        int     `json:"free"`
+	Utilisation float64 `json:"utilisation"` // Percentage of pool addresses used
+}
+
+func (a *API) stats() Stats {
+	var s Stats
+	now := time.Now()
+	a.Server.Leases().Iterate(func(l dhcp4.Lease) bool {
+		switch {
+		case l.Expired(now):
+			s.Expired++
+		case l.Quarantined:
+			s.Quarantined++
+		default:
+			s.Active++
+		}
+		return true
+	})
+	for _, p := range a.pools() {
+		s.PoolSize += p.Size
+		s.Free += p.Free
+	}
+	if s.PoolSize > 0 {
+		s.Utilisation = 100 * float64(s.PoolSize-s.Free) / float64(s.PoolSize)
+	}
+	return s
+}
@@ -0,0 +1,205 @@
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func newTestServer() *dhcp4.Server {
+	_, n, _ := net.ParseCIDR("192.168.1.0/24")
+	s := dhcp4.NewConfiguredServer(&dhcp4.ServerConfig{
+		ServerID:  net.IP{192, 168, 1, 1},
+		LeaseTime: time.Hour,
+		Global:    dhcp4.OptionScope{Options: dhcp4.Options{dhcp4.OptionRouter: []byte{192, 168, 1, 1}}},
+		Subnets:   dhcp4.NewSubnetSelector(nil, &dhcp4.Subnet{Net: *n, Pools: []*dhcp4.Pool{{Start: net.IP{192, 168, 1, 10}, Range: 4}}}),
+		Reservations: []*dhcp4.Reservation{
+			{Name: "printer", HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 9}, IP: net.IP{192, 168, 1, 5}},
+		},
+	}, nil)
+	now := time.Now()
+	s.Leases().Bind(dhcp4.Lease{IP: net.IP{192, 168, 1, 10}, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}, Hostname: "Laptop", Start: now, Expiry: now.Add(time.Hour)})
+	s.Leases().Bind(dhcp4.Lease{IP: net.IP{192, 168, 1, 11}, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 6}, Hostname: "phone", Start: now, Expiry: now.Add(time.Hour)})
+	s.Leases().Quarantine(net.IP{192, 168, 1, 12}, time.Hour)
+	return s
+}
+
+// do makes a request of h, decoding the response into v if not nil.
+func do(t *testing.T, h http.Handler, method, path, body string, v interface{}) int {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	if v != nil && w.Code < 300 {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatalf("%s %s, bad response: %v", method, path, err)
+		}
+	}
+	return w.Code
+}
+
+func TestLeases(t *testing.T) {
+	s := newTestServer()
+	api := New(s)
+
+	for i, test := range []struct {
+		query string
+		want  []string
+	}{
+		{"", []string{"192.168.1.10", "192.168.1.11", "192.168.1.12"}},
+		{"?ip=192.168.1.11", []string{"192.168.1.11"}},
+		{"?mac=00:01:02:03:04:05", []string{"192.168.1.10"}},
+		{"?hostname=lap", []string{"192.168.1.10"}},
+		{"?hostname=none", []string{}},
+	} {
+		var leases []Lease
+		if code := do(t, api, "GET", "/leases"+test.query, "", &leases); code != http.StatusOK {
+			t.Fatalf("%02d: test %q, unexpected status: %v", i, test.query, code)
+		}
+		var got []string
+		for _, l := range leases {
+			got = append(got, l.IP)
+		}
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Fatalf("%02d: test %q, unexpected leases: %v != %v", i, test.query, got, test.want)
+		}
+	}
+
+	var l Lease
+	if code := do(t, api, "GET", "/leases/192.168.1.12", "", &l); code != http.StatusOK || l.State != "quarantined" {
+		t.Fatalf("GET lease, unexpected response: %v %v", code, l)
+	}
+	if code := do(t, api, "GET", "/leases/192.168.1.13", "", nil); code != http.StatusNotFound {
+		t.Fatalf("GET missing lease, unexpected status: %v", code)
+	}
+	if code := do(t, api, "GET", "/leases?mac=bad", "", nil); code != http.StatusBadRequest {
+		t.Fatalf("GET bad mac, unexpected status: %v", code)
+	}
+
+	// Force release publishes LeaseReleased
+	var events []dhcp4.LeaseEventType
+	s.Leases().Subscribe(func(e dhcp4.LeaseEvent) { events = append(events, e.Type) })
+	if code := do(t, api, "DELETE", "/leases/192.168.1.10", "", nil); code != http.StatusNoContent {
+		t.Fatalf("DELETE lease, unexpected status: %v", code)
+	}
+	if _, ok := s.Leases().Get(net.IP{192, 168, 1, 10}); ok || len(events) != 1 || events[0] != dhcp4.LeaseReleased {
+		t.Fatalf("DELETE lease, lease not released: %v", events)
+	}
+}
+
+func TestReservations(t *testing.T) {
+	s := newTestServer()
+	api := New(s)
+
+	body := `{"name": "camera", "mac": "00:01:02:03:04:0a", "ip": "192.168.1.6", "hostname": "cam", "options": {"OptionDomainNameServer": "c0a80102", "15": "6c616e"}}`
+	if code := do(t, api, "POST", "/reservations", body, nil); code != http.StatusCreated {
+		t.Fatalf("POST, unexpected status: %v", code)
+	}
+	rs := s.Config().Reservations
+	if len(rs) != 2 || rs[1].Name != "camera" || !rs[1].IP.Equal(net.IP{192, 168, 1, 6}) || string(rs[1].Scope.Options[dhcp4.OptionDomainName]) != "lan" {
+		t.Fatalf("POST, reservation not added: %v", rs)
+	}
+	// The server uses the new reservation
+	req := dhcp4.RequestPacket(dhcp4.Discover, net.HardwareAddr{0, 1, 2, 3, 4, 10}, nil, []byte{1, 2, 3, 4}, true, nil)
+	if res := s.ServeDHCP(req, dhcp4.Discover, req.ParseOptions()); res == nil || !res.YIAddr().Equal(net.IP{192, 168, 1, 6}) {
+		t.Fatalf("Discover, reserved address not offered: %v", res)
+	}
+
+	for i, test := range []struct {
+		method, path, body string
+		status             int
+	}{
+		{"POST", "/reservations", body, http.StatusConflict},
+		{"POST", "/reservations", `{"name": "x", "mac": "00:01:02:03:04:09"}`, http.StatusConflict},
+		{"POST", "/reservations", `{"name": "x", "mac": "00:01:02:03:04:0b", "ip": "192.168.1.5"}`, http.StatusConflict},
+		{"POST", "/reservations", `{"name": "x", "mac": "00:01:02:03:04:0b", "ip": "10.0.0.1"}`, http.StatusBadRequest},
+		{"POST", "/reservations", `{"name": "x"}`, http.StatusBadRequest},
+		{"POST", "/reservations", `{"name": "x", "mac": "00:01:02:03:04:0b", "options": {"nope": "00"}}`, http.StatusBadRequest},
+		{"PUT", "/reservations/camera", `{"name": "other", "mac": "00:01:02:03:04:0a"}`, http.StatusBadRequest},
+		{"PUT", "/reservations/camera", `{"name": "camera", "client_id": "01000102030410"}`, http.StatusOK},
+		{"GET", "/reservations/camera", "", http.StatusOK},
+		{"DELETE", "/reservations/printer", "", http.StatusNoContent},
+		{"DELETE", "/reservations/printer", "", http.StatusNotFound},
+		{"GET", "/reservations/printer", "", http.StatusNotFound},
+	} {
+		if code := do(t, api, test.method, test.path, test.body, nil); code != test.status {
+			t.Fatalf("%02d: test %s %s, unexpected status: %v != %v", i, test.method, test.path, code, test.status)
+		}
+	}
+
+	var views []Reservation
+	do(t, api, "GET", "/reservations", "", &views)
+	if len(views) != 1 || views[0].Name != "camera" || views[0].ClientID != "01000102030410" || views[0].IP != "" {
+		t.Fatalf("unexpected reservations: %v", views)
+	}
+}
+
+func TestPoolsStatsConfig(t *testing.T) {
+	api := New(newTestServer())
+
+	var pools []Pool
+	if code := do(t, api, "GET", "/pools", "", &pools); code != http.StatusOK || len(pools) != 1 {
+		t.Fatalf("GET pools, unexpected response: %v %v", code, pools)
+	}
+	if p := pools[0]; p.Start != "192.168.1.10" || p.End != "192.168.1.13" || p.Leased != 2 || p.Quarantined != 1 || p.Free != 1 || p.Utilisation != 75 {
+		t.Fatalf("GET pools, unexpected utilisation: %+v", p)
+	}
+
+	var stats Stats
+	if do(t, api, "GET", "/stats", "", &stats); stats.Active != 2 || stats.Quarantined != 1 || stats.PoolSize != 4 || stats.Free != 1 {
+		t.Fatalf("GET stats, unexpected stats: %+v", stats)
+	}
+
+	var c Config
+	if code := do(t, api, "GET", "/config", "", &c); code != http.StatusOK {
+		t.Fatalf("GET config, unexpected status: %v", code)
+	}
+	if c.ServerID != "192.168.1.1" || len(c.Networks) != 1 || c.Networks[0].Subnets[0].Net != "192.168.1.0/24" ||
+		*c.Global.Options["OptionRouter"] != "c0a80101" || len(c.Reservations) != 1 {
+		t.Fatalf("GET config, unexpected config: %+v", c)
+	}
+	if code := do(t, api, "POST", "/config", "", nil); code != http.StatusMethodNotAllowed {
+		t.Fatalf("POST config, unexpected status: %v", code)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	api := New(newTestServer())
+	api.Auth = BearerToken("secret")
+	mux := http.NewServeMux()
+	api.Mount(mux, "/dhcp")
+
+	for i, test := range []struct {
+		path, auth string
+		status     int
+	}{
+		{"/dhcp/stats", "", http.StatusUnauthorized},
+		{"/dhcp/stats", "Bearer wrong", http.StatusUnauthorized},
+		{"/dhcp/stats", "Bearer secret", http.StatusOK},
+		{"/dhcp/nothing", "Bearer secret", http.StatusNotFound},
+		{"/other", "Bearer secret", http.StatusNotFound},
+	} {
+		req := httptest.NewRequest("GET", test.path, nil)
+		if test.auth != "" {
+			req.Header.Set("Authorization", test.auth)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, req)
+		if w.Code != test.status {
+			t.Fatalf("%02d: test %q, unexpected status: %v != %v", i, test.path, w.Code, test.status)
+		}
+	}
+
+	api.Auth = BasicAuth("dhcp", func(user, password string) bool { return user == "admin" && password == "pw" })
+	req := httptest.NewRequest("GET", "/stats", nil)
+	req.SetBasicAuth("admin", "pw")
+	w := httptest.NewRecorder()
+	if api.ServeHTTP(w, req); w.Code != http.StatusOK {
+		t.Fatalf("basic auth, unexpected status: %v", w.Code)
+	}
+}
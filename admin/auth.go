@@ -0,0 +1,49 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// BasicAuth returns Middleware admitting requests with HTTP basic
+// credentials accepted by check.
+func BasicAuth(realm string, check func(user, password string) bool) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if user, password, ok := r.BasicAuth(); ok && check(user, password) {
+				h.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+realm+`"`)
+			unauthorized(w)
+		})
+	}
+}
+
+// BearerToken returns Middleware admitting requests bearing one of tokens
+// in their Authorization header.
+func BearerToken(tokens ...string) Middleware {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			auth := r.Header.Get("Authorization")
+			if strings.HasPrefix(auth, "Bearer ") {
+				got := []byte(strings.TrimPrefix(auth, "Bearer "))
+				for _, t := range tokens {
+					if subtle.ConstantTimeCompare(got, []byte(t)) == 1 {
+						h.ServeHTTP(w, r)
+						return
+					}
+				}
+			}
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			unauthorized(w)
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	w.Write([]byte(`{"error": "unauthorized"}` + "\n"))
+}
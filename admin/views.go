@@ -0,0 +1,244 @@
+package admin
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Lease is the JSON form of a dhcp4.Lease.
+type Lease struct {
+	IP           string    `json:"ip"`
+	HardwareAddr string    `json:"mac,omitempty"`
+	ClientID     string    `json:"client_id,omitempty"` // Hex
+	Hostname     string    `json:"hostname,omitempty"`
+	Start        time.Time `json:"start"`
+	Expiry       time.Time `json:"expiry,omitempty"`
+	State        string    `json:"state"` // active, expired or quarantined
+}
+
+func leaseView(l dhcp4.Lease, now time.Time) Lease {
+	v := Lease{
+		IP:       l.IP.String(),
+		ClientID: hex.EncodeToString(l.ClientID),
+		Hostname: l.Hostname,
+		Start:    l.Start,
+		Expiry:   l.Expiry,
+		State:    "active",
+	}
+	if len(l.HardwareAddr) > 0 {
+		v.HardwareAddr = l.HardwareAddr.String()
+	}
+	switch {
+	case l.Quarantined:
+		v.State = "quarantined"
+	case l.Expired(now):
+		v.State = "expired"
+	}
+	return v
+}
+
+// Options is the JSON form of dhcp4.Options: hex values keyed by option
+// name (e.g. "OptionRouter").  Codes are also accepted as keys.  A null
+// value removes an option set by an outer scope.
+type Options map[string]*string
+
+// Scope is the JSON form of a dhcp4.OptionScope.
+type Scope struct {
+	Options   Options  `json:"options,omitempty"`
+	ForceSend []string `json:"force_send,omitempty"`
+}
+
+func scopeView(s dhcp4.OptionScope) Scope {
+	v := Scope{}
+	if len(s.Options) > 0 {
+		v.Options = make(Options, len(s.Options))
+		for code, value := range s.Options {
+			if value == nil {
+				v.Options[code.String()] = nil
+			} else {
+				h := hex.EncodeToString(value)
+				v.Options[code.String()] = &h
+			}
+		}
+	}
+	for _, code := range s.ForceSend {
+		v.ForceSend = append(v.ForceSend, code.String())
+	}
+	return v
+}
+
+// optionCode returns the code named name, either as an OptionCode string or
+// a number.
+func optionCode(name string) (dhcp4.OptionCode, error) {
+	if n, err := strconv.ParseUint(name, 10, 8); err == nil {
+		return dhcp4.OptionCode(n), nil
+	}
+	for code := 0; code < 256; code++ {
+		if strings.EqualFold(dhcp4.OptionCode(code).String(), name) {
+			return dhcp4.OptionCode(code), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown option %q", name)
+}
+
+func (v Scope) scope() (dhcp4.OptionScope, error) {
+	s := dhcp4.OptionScope{}
+	if len(v.Options) > 0 {
+		s.Options = make(dhcp4.Options, len(v.Options))
+	}
+	for name, value := range v.Options {
+		code, err := optionCode(name)
+		if err != nil {
+			return s, err
+		}
+		if value == nil {
+			s.Options[code] = nil
+			continue
+		}
+		b, err := hex.DecodeString(*value)
+		if err != nil || len(b) > 255 {
+			return s, fmt.Errorf("bad value for option %q", name)
+		}
+		s.Options[code] = b
+	}
+	for _, name := range v.ForceSend {
+		code, err := optionCode(name)
+		if err != nil {
+			return s, err
+		}
+		s.ForceSend = append(s.ForceSend, code)
+	}
+	return s, nil
+}
+
+// Reservation is the JSON form of a dhcp4.Reservation.
+type Reservation struct {
+	Name         string `json:"name"`
+	HardwareAddr string `json:"mac,omitempty"`
+	ClientID     string `json:"client_id,omitempty"` // Hex
+	IP           string `json:"ip,omitempty"`
+	Hostname     string `json:"hostname,omitempty"`
+	Scope
+}
+
+func reservationView(r *dhcp4.Reservation) Reservation {
+	v := Reservation{
+		Name:     r.Name,
+		ClientID: hex.EncodeToString(r.ClientID),
+		Hostname: r.Hostname,
+		Scope:    scopeView(r.Scope),
+	}
+	if len(r.HardwareAddr) > 0 {
+		v.HardwareAddr = r.HardwareAddr.String()
+	}
+	if r.IP != nil {
+		v.IP = r.IP.String()
+	}
+	return v
+}
+
+func (v Reservation) reservation() (*dhcp4.Reservation, error) {
+	r := &dhcp4.Reservation{Name: v.Name, Hostname: v.Hostname}
+	var err error
+	if v.Name == "" {
+		return nil, fmt.Errorf("reservation has no name")
+	}
+	if v.HardwareAddr != "" {
+		if r.HardwareAddr, err = net.ParseMAC(v.HardwareAddr); err != nil {
+			return nil, err
+		}
+	}
+	if r.ClientID, err = hex.DecodeString(v.ClientID); err != nil {
+		return nil, fmt.Errorf("bad client_id %q", v.ClientID)
+	}
+	if len(r.HardwareAddr) == 0 && len(r.ClientID) == 0 {
+		return nil, fmt.Errorf("reservation %q has no mac or client_id", v.Name)
+	}
+	if v.IP != "" {
+		if r.IP = net.ParseIP(v.IP).To4(); r.IP == nil {
+			return nil, fmt.Errorf("bad ip %q", v.IP)
+		}
+	}
+	if r.Scope, err = v.Scope.scope(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Pool is a pool's utilisation.
+type Pool struct {
+	Network     string   `json:"network"`
+	Subnet      string   `json:"subnet"`
+	Start       string   `json:"start"`
+	End         string   `json:"end"`
+	Classes     []string `json:"classes,omitempty"`
+	Size        int      `json:"size"`
+	Leased      int      `json:"leased"`
+	Quarantined int      `json:"quarantined"`
+	Free        int      `json:"free"`
+	Utilisation float64  `json:"utilisation"` // Percentage leased or quarantined
+}
+
+// PoolConfig is the JSON form of a dhcp4.Pool.
+type PoolConfig struct {
+	Start   string   `json:"start"`
+	Range   int      `json:"range"`
+	Classes []string `json:"classes,omitempty"`
+	Scope
+}
+
+// Subnet is the JSON form of a dhcp4.Subnet.
+type Subnet struct {
+	Net   string       `json:"net"`
+	Pools []PoolConfig `json:"pools"`
+	Scope
+}
+
+// Network is the JSON form of a dhcp4.SharedNetwork.
+type Network struct {
+	Name    string   `json:"name"`
+	Subnets []Subnet `json:"subnets"`
+	Scope
+}
+
+// Config is the JSON form of a dhcp4.ServerConfig.
+type Config struct {
+	ServerID     string        `json:"server_id"`
+	LeaseTime    string        `json:"lease_time"`
+	Global       Scope         `json:"global"`
+	Networks     []Network     `json:"networks"`
+	Reservations []Reservation `json:"reservations"`
+}
+
+func configView(c *dhcp4.ServerConfig) Config {
+	v := Config{
+		ServerID:     c.ServerID.String(),
+		LeaseTime:    c.LeaseTime.String(),
+		Global:       scopeView(c.Global),
+		Networks:     []Network{},
+		Reservations: []Reservation{},
+	}
+	if c.Subnets != nil {
+		for _, n := range c.Subnets.Networks() {
+			nv := Network{Name: n.Name, Scope: scopeView(n.Scope)}
+			for _, sn := range n.Subnets {
+				sv := Subnet{Net: sn.Net.String(), Scope: scopeView(sn.Scope)}
+				for _, p := range sn.Pools {
+					sv.Pools = append(sv.Pools, PoolConfig{p.Start.String(), p.Range, p.Classes, scopeView(p.Scope)})
+				}
+				nv.Subnets = append(nv.Subnets, sv)
+			}
+			v.Networks = append(v.Networks, nv)
+		}
+	}
+	for _, r := range c.Reservations {
+		v.Reservations = append(v.Reservations, reservationView(r))
+	}
+	return v
+}
@@ -0,0 +1,411 @@
+// Package balance implements a load balancing relay tier, in the manner of
+// dhcplb: it receives requests from first hop relay agents and forwards each
+// to one of a pool of upstream servers, chosen by consistent hashing of the
+// client's identity, so a client keeps going to the same server, and few
+// clients move when servers come and go.
+//
+// Clients may be sent to pools other than the default by hardware address,
+// such as to roll out a new server version (or configuration) to a few
+// clients first.  Servers which stop answering are passed over until they
+// are given another chance, and requests from each relay agent may be
+// throttled.
+//
+// Servers must send their replies back through the balancer, which passes
+// them on to the relay agent.  Serve (of package dhcp4) replies to relayed
+// requests this way.  Servers replying directly to the relay agent work too,
+// but look dead to the balancer unless Unanswered is negative.
+package balance
+
+import (
+	"hash/fnv"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Balancer defaults
+const (
+	DefaultMaxHops      = 4
+	DefaultUnanswered   = 3
+	DefaultReplyTimeout = 5 * time.Second
+	DefaultRetryAfter   = 30 * time.Second
+)
+
+// replicas is the number of points each server has on a hash ring.
+const replicas = 64
+
+// Pool is a set of upstream servers, sharing clients by consistent hashing.
+type Pool struct {
+	Name    string
+	Servers []*net.UDPAddr
+	// MACs lists the clients sent to the pool, rather than the default (the
+	// first pool).
+	MACs []net.HardwareAddr
+}
+
+// Balancer forwards requests from relay agents to servers in pools.
+type Balancer struct {
+	// Throttle limits the requests accepted from each relay agent per
+	// second, or is 0 for no limit.  Bursts of up to a second's worth are
+	// accepted.
+	Throttle float64
+	MaxHops  int // DefaultMaxHops if 0
+	// A server is passed over once it hasn't answered Unanswered requests
+	// (DefaultUnanswered if 0, or never if negative) after ReplyTimeout
+	// (DefaultReplyTimeout if 0), until RetryAfter (DefaultRetryAfter if 0)
+	// has passed.  Only DISCOVERs and REQUESTs are expected to be answered,
+	// as servers need not answer INFORMs (dhcp4.Server doesn't).  A server
+	// dropping DISCOVERs, such as with no addresses left, is passed over too,
+	// sending new clients to the others.
+	Unanswered   int
+	ReplyTimeout time.Duration
+	RetryAfter   time.Duration
+	// OnHealth, if set, is called when a server is passed over, or given
+	// another chance.
+	OnHealth func(server *net.UDPAddr, up bool)
+
+	mu      sync.Mutex
+	pools   []*ring
+	byMAC   map[string]*ring
+	health  map[string]*health // By server IP address
+	buckets map[string]*bucket // By relay agent address
+	now     func() time.Time   // For testing
+}
+
+// New returns a Balancer between pools, the first being the default.
+func New(pools ...*Pool) *Balancer {
+	b := &Balancer{health: make(map[string]*health), buckets: make(map[string]*bucket), now: time.Now}
+	b.SetPools(pools...)
+	return b
+}
+
+// SetPools replaces the balancer's pools.  The health of servers remaining
+// is kept.
+func (b *Balancer) SetPools(pools ...*Pool) {
+	rings := make([]*ring, len(pools))
+	byMAC := make(map[string]*ring)
+	for i, p := range pools {
+		rings[i] = newRing(p)
+		for _, mac := range p.MACs {
+			byMAC[mac.String()] = rings[i]
+		}
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pools, b.byMAC = rings, byMAC
+	servers := make(map[string]*health)
+	for _, r := range rings {
+		for _, s := range r.Servers {
+			if h, ok := b.health[s.IP.String()]; ok {
+				servers[s.IP.String()] = h
+			} else {
+				servers[s.IP.String()] = &health{}
+			}
+		}
+	}
+	b.health = servers
+}
+
+// SetThrottle sets Throttle while the balancer is serving.
+func (b *Balancer) SetThrottle(rate float64) {
+	b.mu.Lock()
+	b.Throttle = rate
+	b.mu.Unlock()
+}
+
+// Pools returns the balancer's pools.
+func (b *Balancer) Pools() []*Pool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pools := make([]*Pool, len(b.pools))
+	for i, r := range b.pools {
+		pools[i] = r.Pool
+	}
+	return pools
+}
+
+// ServerStatus is the health of a server.
+type ServerStatus struct {
+	Pool       string
+	Server     *net.UDPAddr
+	Up         bool
+	Unanswered int // Requests since the server last answered
+}
+
+// Status returns the health of every server.
+func (b *Balancer) Status() []ServerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var status []ServerStatus
+	for _, r := range b.pools {
+		for _, s := range r.Servers {
+			h := b.health[s.IP.String()]
+			status = append(status, ServerStatus{Pool: r.Name, Server: s, Up: !h.down, Unanswered: h.unanswered})
+		}
+	}
+	return status
+}
+
+// ring is a pool's hash ring.
+type ring struct {
+	*Pool
+	points []point // In hash order
+}
+
+type point struct {
+	hash   uint64
+	server int
+}
+
+func newRing(p *Pool) *ring {
+	r := &ring{Pool: p}
+	for i, s := range p.Servers {
+		for n := 0; n < replicas; n++ {
+			r.points = append(r.points, point{hash64([]byte(s.String() + "#" + strconv.Itoa(n))), i})
+		}
+	}
+	sort.Sort(points(r.points))
+	return r
+}
+
+type points []point
+
+func (p points) Len() int           { return len(p) }
+func (p points) Less(i, j int) bool { return p[i].hash < p[j].hash }
+func (p points) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+// hash64 returns the FNV-1a hash of b, with the bits mixed (as by MurmurHash3's
+// finaliser) so similar keys, such as consecutive MACs, are spread around the
+// ring.
+func hash64(b []byte) uint64 {
+	h := fnv.New64a()
+	h.Write(b)
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+// lookup returns the server for key: the first up, clockwise from key's
+// point, or if none are, the first.
+func (r *ring) lookup(key []byte, up func(*net.UDPAddr) bool) *net.UDPAddr {
+	if len(r.points) == 0 {
+		return nil
+	}
+	h := hash64(key)
+	start := sort.Search(len(r.points), func(i int) bool { return r.points[i].hash >= h })
+	tried := make(map[int]bool)
+	for n := 0; n < len(r.points) && len(tried) < len(r.Servers); n++ {
+		p := r.points[(start+n)%len(r.points)]
+		if tried[p.server] {
+			continue
+		}
+		tried[p.server] = true
+		if up(r.Servers[p.server]) {
+			return r.Servers[p.server]
+		}
+	}
+	return r.Servers[r.points[start%len(r.points)].server]
+}
+
+// health tracks whether a server answers.
+type health struct {
+	unanswered int       // Requests since the last reply
+	since      time.Time // Of the first unanswered request
+	down       bool
+	downAt     time.Time
+}
+
+// up returns true if server s isn't to be passed over.  b.mu must be held.
+func (b *Balancer) up(s *net.UDPAddr) bool {
+	h := b.health[s.IP.String()]
+	if h == nil || b.Unanswered < 0 {
+		return true
+	}
+	now := b.now()
+	if h.down {
+		if now.Sub(h.downAt) < durationOr(b.RetryAfter, DefaultRetryAfter) {
+			return false
+		}
+		h.down, h.unanswered = false, 0
+		b.notify(s, true)
+		return true
+	}
+	unanswered := b.Unanswered
+	if unanswered == 0 {
+		unanswered = DefaultUnanswered
+	}
+	if h.unanswered >= unanswered && now.Sub(h.since) >= durationOr(b.ReplyTimeout, DefaultReplyTimeout) {
+		h.down, h.downAt = true, now
+		b.notify(s, false)
+		return false
+	}
+	return true
+}
+
+func (b *Balancer) notify(s *net.UDPAddr, up bool) {
+	if b.OnHealth != nil {
+		go b.OnHealth(s, up) // Not under b.mu
+	}
+}
+
+func durationOr(d, def time.Duration) time.Duration {
+	if d > 0 {
+		return d
+	}
+	return def
+}
+
+// bucket is a token bucket throttling a relay agent.
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow returns true if a request from relay agent giaddr is within the
+// throttle.  b.mu must be held.
+func (b *Balancer) allow(giaddr net.IP) bool {
+	if b.Throttle <= 0 {
+		return true
+	}
+	burst := b.Throttle
+	if burst < 1 {
+		burst = 1
+	}
+	now := b.now()
+	k, ok := b.buckets[giaddr.String()]
+	if !ok {
+		k = &bucket{tokens: burst, last: now}
+		b.buckets[giaddr.String()] = k
+	}
+	k.tokens += now.Sub(k.last).Seconds() * b.Throttle
+	if k.tokens > burst {
+		k.tokens = burst
+	}
+	k.last = now
+	if k.tokens < 1 {
+		return false
+	}
+	k.tokens--
+	return true
+}
+
+// clientKey returns what identifies a client: its client identifier, or
+// else its hardware address.
+func clientKey(p dhcp4.Packet, options dhcp4.Options) []byte {
+	if id := options[dhcp4.OptionClientIdentifier]; len(id) > 0 {
+		return id
+	}
+	return p.CHAddr()
+}
+
+// Select returns the server for the client that sent req, or nil if there
+// are no servers.
+func (b *Balancer) Select(req dhcp4.Packet, options dhcp4.Options) *net.UDPAddr {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.selectServer(req, options)
+}
+
+// selectServer is Select, with b.mu held.
+func (b *Balancer) selectServer(req dhcp4.Packet, options dhcp4.Options) *net.UDPAddr {
+	r, ok := b.byMAC[req.CHAddr().String()]
+	if !ok {
+		if len(b.pools) == 0 {
+			return nil
+		}
+		r = b.pools[0]
+	}
+	return r.lookup(clientKey(req, options), b.up)
+}
+
+// Request returns req, from a relay agent, as forwarded, and the server to
+// forward it to, or nil if it's to be dropped: it's not from a relay agent
+// (giaddr is unset), has passed through too many, or is over the relay
+// agent's throttle.
+func (b *Balancer) Request(req dhcp4.Packet, options dhcp4.Options) (dhcp4.Packet, *net.UDPAddr) {
+	giaddr := net.IP(req.GIAddr())
+	maxHops := b.MaxHops
+	if maxHops == 0 {
+		maxHops = DefaultMaxHops
+	}
+	if giaddr.Equal(net.IPv4zero) || int(req.Hops()) >= maxHops {
+		return nil, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.allow(giaddr) {
+		return nil, nil
+	}
+	s := b.selectServer(req, options)
+	if s == nil {
+		return nil, nil
+	}
+	if t := options[dhcp4.OptionDHCPMessageType]; len(t) == 1 {
+		switch dhcp4.MessageType(t[0]) {
+		case dhcp4.Discover, dhcp4.Request:
+			h := b.health[s.IP.String()]
+			if h.unanswered == 0 {
+				h.since = b.now()
+			}
+			h.unanswered++
+		}
+	}
+	p := append(dhcp4.Packet(nil), req...)
+	p.SetHops(req.Hops() + 1)
+	return p, s
+}
+
+// Reply returns the relay agent address to pass res on to, if it's from
+// one of the balancer's servers, from.
+func (b *Balancer) Reply(res dhcp4.Packet, from *net.UDPAddr) *net.UDPAddr {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h := b.health[from.IP.String()]
+	giaddr := net.IP(res.GIAddr())
+	if h == nil || giaddr.Equal(net.IPv4zero) {
+		return nil
+	}
+	if h.down {
+		h.down = false
+		b.notify(from, true)
+	}
+	h.unanswered = 0
+	return &net.UDPAddr{IP: append(net.IP(nil), giaddr...), Port: 67}
+}
+
+// Serve balances requests read from conn, until it fails.
+func (b *Balancer) Serve(conn dhcp4.ServeConn) error {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		p := dhcp4.Packet(buf[:n])
+		if n < 240 || p.HLen() > 16 {
+			continue
+		}
+		var dst *net.UDPAddr
+		switch p.OpCode() {
+		case dhcp4.BootRequest:
+			p, dst = b.Request(p, p.ParseOptions())
+		case dhcp4.BootReply:
+			if from, ok := addr.(*net.UDPAddr); ok {
+				dst = b.Reply(p, from)
+			}
+		}
+		if dst != nil {
+			if _, err := conn.WriteTo(p, dst); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package balance
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// testBalancer returns a Balancer with a clock controlled by the returned
+// func, advancing it by d.
+func testBalancer(pools ...*Pool) (*Balancer, func(d time.Duration)) {
+	b := New(pools...)
+	now := time.Unix(1000000, 0)
+	b.now = func() time.Time { return now }
+	return b, func(d time.Duration) { now = now.Add(d) }
+}
+
+func servers(ips ...byte) []*net.UDPAddr {
+	var s []*net.UDPAddr
+	for _, ip := range ips {
+		s = append(s, &net.UDPAddr{IP: net.IP{10, 0, 0, ip}, Port: 67})
+	}
+	return s
+}
+
+func mac(n int) net.HardwareAddr { return net.HardwareAddr{2, 0, 0, 0, byte(n >> 8), byte(n)} }
+
+func relayed(mt dhcp4.MessageType, chaddr net.HardwareAddr, giaddr net.IP) dhcp4.Packet {
+	p := dhcp4.RequestPacket(mt, chaddr, nil, []byte{1, 2, 3, 4}, false, nil)
+	p.SetGIAddr(giaddr)
+	p.SetHops(1)
+	return p
+}
+
+func TestConsistentHashing(t *testing.T) {
+	b, advance := testBalancer(&Pool{Name: "A", Servers: servers(1, 2, 3, 4)})
+	assign := func() map[string]string {
+		a := make(map[string]string)
+		for n := 0; n < 1000; n++ {
+			p := relayed(dhcp4.Discover, mac(n), net.IP{192, 168, 1, 1})
+			a[mac(n).String()] = b.Select(p, p.ParseOptions()).IP.String()
+		}
+		return a
+	}
+	before := assign()
+	count := make(map[string]int)
+	for _, s := range before {
+		count[s]++
+	}
+	for _, s := range servers(1, 2, 3, 4) {
+		if count[s.IP.String()] < 100 {
+			t.Fatalf("uneven distribution: %v", count)
+		}
+	}
+
+	// Only the clients of a server passed over move
+	h := b.health["10.0.0.1"]
+	h.down, h.downAt = true, b.now()
+	after := assign()
+	for m, s := range before {
+		if s != "10.0.0.1" && after[m] != s || after[m] == "10.0.0.1" {
+			t.Fatalf("client %s moved from %s to %s", m, s, after[m])
+		}
+	}
+	advance(DefaultRetryAfter)
+	if again := assign(); again[mac(0).String()] != before[mac(0).String()] {
+		t.Fatalf("client not returned to server given another chance")
+	}
+
+	// Client identifiers take precedence
+	p1 := dhcp4.RequestPacket(dhcp4.Discover, mac(1), nil, []byte{1}, false, []dhcp4.Option{{Code: dhcp4.OptionClientIdentifier, Value: []byte("id")}})
+	p2 := dhcp4.RequestPacket(dhcp4.Discover, mac(2), nil, []byte{1}, false, []dhcp4.Option{{Code: dhcp4.OptionClientIdentifier, Value: []byte("id")}})
+	if !b.Select(p1, p1.ParseOptions()).IP.Equal(b.Select(p2, p2.ParseOptions()).IP) {
+		t.Fatalf("client identifier ignored")
+	}
+}
+
+func TestPools(t *testing.T) {
+	b, _ := testBalancer(&Pool{Name: "A", Servers: servers(1, 2)}, &Pool{Name: "B", Servers: servers(11), MACs: []net.HardwareAddr{mac(7)}})
+	for n := 0; n < 20; n++ {
+		p := relayed(dhcp4.Discover, mac(n), net.IP{192, 168, 1, 1})
+		_, s := b.Request(p, p.ParseOptions())
+		if (n == 7) != s.IP.Equal(net.IP{10, 0, 0, 11}) {
+			t.Fatalf("%02d: client %s sent to %s", n, mac(n), s)
+		}
+	}
+
+	// Servers staying keep their health
+	b.health["10.0.0.2"].unanswered = 2
+	b.SetPools(&Pool{Name: "A", Servers: servers(2, 3)})
+	if st := b.Status(); len(st) != 2 || st[0].Unanswered != 2 || st[1].Unanswered != 0 {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	p := relayed(dhcp4.Discover, mac(7), net.IP{192, 168, 1, 1})
+	if _, s := b.Request(p, p.ParseOptions()); s.IP.Equal(net.IP{10, 0, 0, 11}) {
+		t.Fatalf("client sent to removed pool")
+	}
+}
+
+func TestRequest(t *testing.T) {
+	b, advance := testBalancer(&Pool{Name: "A", Servers: servers(1)})
+	b.Throttle = 2
+	for i, test := range []struct {
+		advance time.Duration
+		giaddr  net.IP
+		hops    byte
+		ok      bool
+	}{
+		{0, net.IP{192, 168, 1, 1}, 1, true},
+		{0, nil, 0, false}, // Not relayed
+		{0, net.IP{192, 168, 1, 1}, 4, false},
+		{0, net.IP{192, 168, 1, 1}, 1, true},
+		{0, net.IP{192, 168, 1, 1}, 1, false}, // Throttled
+		{0, net.IP{192, 168, 2, 1}, 1, true},  // Another relay
+		{500 * time.Millisecond, net.IP{192, 168, 1, 1}, 1, true},
+		{0, net.IP{192, 168, 1, 1}, 1, false},
+	} {
+		advance(test.advance)
+		p := relayed(dhcp4.Discover, mac(1), net.IPv4zero)
+		if test.giaddr != nil {
+			p.SetGIAddr(test.giaddr)
+		}
+		p.SetHops(test.hops)
+		fwd, s := b.Request(p, p.ParseOptions())
+		if (fwd != nil) != test.ok {
+			t.Fatalf("%02d: test %v %d, unexpected forwarding: %v", i, test.giaddr, test.hops, fwd != nil)
+		}
+		if fwd != nil && (fwd.Hops() != test.hops+1 || !s.IP.Equal(net.IP{10, 0, 0, 1})) {
+			t.Fatalf("%02d: unexpected forward to %s, hops %d", i, s, fwd.Hops())
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	b, advance := testBalancer(&Pool{Name: "A", Servers: servers(1, 2)})
+	health := make(chan bool, 4)
+	b.OnHealth = func(s *net.UDPAddr, up bool) { health <- up }
+	p := relayed(dhcp4.Discover, mac(1), net.IP{192, 168, 1, 1})
+	var first *net.UDPAddr
+	for n := 0; n < DefaultUnanswered; n++ {
+		_, s := b.Request(p, p.ParseOptions())
+		if first != nil && !s.IP.Equal(first.IP) {
+			t.Fatalf("%02d: client moved before timeout", n)
+		}
+		first = s
+	}
+	advance(DefaultReplyTimeout)
+	if _, s := b.Request(p, p.ParseOptions()); s.IP.Equal(first.IP) {
+		t.Fatalf("unanswering server not passed over")
+	}
+	if up := <-health; up {
+		t.Fatalf("expected server down")
+	}
+
+	// Replies restore a server, and are passed on to the relay agent
+	res := dhcp4.ReplyPacket(p, dhcp4.Offer, first.IP, net.IP{192, 168, 1, 10}, time.Hour, nil)
+	if dst := b.Reply(res, first); dst == nil || dst.String() != "192.168.1.1:67" {
+		t.Fatalf("unexpected reply destination: %v", dst)
+	}
+	if up := <-health; !up {
+		t.Fatalf("expected server up")
+	}
+	if _, s := b.Request(p, p.ParseOptions()); !s.IP.Equal(first.IP) {
+		t.Fatalf("client not returned to answering server")
+	}
+	if dst := b.Reply(res, &net.UDPAddr{IP: net.IP{10, 9, 9, 9}, Port: 67}); dst != nil {
+		t.Fatalf("reply from unknown server passed on")
+	}
+}
+
+// Verify that unanswered INFORMs don't count against a server, as
+// dhcp4.Server doesn't answer them.
+func TestInform(t *testing.T) {
+	b, advance := testBalancer(&Pool{Name: "A", Servers: servers(1, 2)})
+	upstream := make(map[string]*dhcp4.Server)
+	for _, s := range servers(1, 2) {
+		upstream[s.IP.String()] = dhcp4.NewServer(s.IP, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+	}
+	p := relayed(dhcp4.Inform, mac(1), net.IP{192, 168, 1, 1})
+	var first *net.UDPAddr
+	for n := 0; n <= DefaultUnanswered; n++ {
+		fwd, s := b.Request(p, p.ParseOptions())
+		if first != nil && !s.IP.Equal(first.IP) {
+			t.Fatalf("%02d: client moved to %s", n, s)
+		}
+		first = s
+		if res := upstream[s.IP.String()].ServeDHCP(fwd, dhcp4.Inform, fwd.ParseOptions()); res != nil {
+			b.Reply(res, s)
+		}
+		advance(DefaultReplyTimeout)
+	}
+	for _, st := range b.Status() {
+		if !st.Up || st.Unanswered != 0 {
+			t.Fatalf("unexpected status: %+v", st)
+		}
+	}
+}
+
+type packet struct {
+	b    []byte
+	addr *net.UDPAddr
+}
+
+// memConn is a ServeConn whose writes to servers are answered by them.
+type memConn struct {
+	servers map[string]*dhcp4.Server
+	in      chan packet
+	relayed chan packet // Written to relay agents
+}
+
+func (c *memConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	p, ok := <-c.in
+	if !ok {
+		return 0, nil, errors.New("closed")
+	}
+	return copy(b, p.b), p.addr, nil
+}
+
+func (c *memConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	p, dst := append(dhcp4.Packet(nil), b...), addr.(*net.UDPAddr)
+	if s, ok := c.servers[dst.IP.String()]; ok {
+		options := p.ParseOptions()
+		if res := s.ServeDHCP(p, dhcp4.MessageType(options[dhcp4.OptionDHCPMessageType][0]), options); res != nil {
+			c.in <- packet{res, dst}
+		}
+	} else {
+		c.relayed <- packet{p, dst}
+	}
+	return len(b), nil
+}
+
+func TestServe(t *testing.T) {
+	c := &memConn{servers: make(map[string]*dhcp4.Server), in: make(chan packet, 4), relayed: make(chan packet, 4)}
+	for _, s := range servers(1, 2) {
+		c.servers[s.IP.String()] = dhcp4.NewServer(s.IP, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+	}
+	b := New(&Pool{Name: "A", Servers: servers(1, 2)})
+	done := make(chan error)
+	go func() { done <- b.Serve(c) }()
+	defer func() {
+		close(c.in)
+		<-done
+	}()
+
+	relay := &net.UDPAddr{IP: net.IP{192, 168, 1, 1}, Port: 67}
+	c.in <- packet{relayed(dhcp4.Discover, mac(1), relay.IP), relay}
+	select {
+	case p := <-c.relayed:
+		if p.addr.String() != relay.String() || !dhcp4.IPInRange(net.IP{192, 168, 1, 10}, net.IP{192, 168, 1, 19}, dhcp4.Packet(p.b).YIAddr()) {
+			t.Fatalf("unexpected reply to %s: %v", p.addr, p.b)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("no reply relayed")
+	}
+	if st := b.Status(); st[0].Unanswered+st[1].Unanswered != 0 {
+		t.Fatalf("answered requests counted: %+v", st)
+	}
+}
@@ -0,0 +1,64 @@
+// Package classify sorts DHCP clients into classes, by match expressions over
+// their requests, so that pools and options can be chosen per class, such
+// as by vendor class, user class, client architecture, relay agent remote
+// id or hardware address vendor (OUI).
+//
+//	c := classify.New(
+//		&classify.Class{Name: "pxe", Test: classify.MustCompile("substring(option[60].text, 0, 9) == 'PXEClient'")},
+//		&classify.Class{Name: "uefi", Test: classify.MustCompile("member('pxe') and option[93].hex == 0x0007")},
+//	)
+//	config := &dhcp4.ServerConfig{Classifier: c, ...}
+package classify
+
+import "github.com/krolaw/dhcp4"
+
+// Class is a named class of clients, matching Test, with options for its
+// members.
+type Class struct {
+	Name  string
+	Test  *Expr // Nil matches no client, for classes assigned by other means
+	Scope dhcp4.OptionScope
+}
+
+// Classifier assigns clients to classes.  It is a dhcp4.Classifier.
+type Classifier struct {
+	classes []*Class
+}
+
+// New returns a Classifier for classes.  Classes are tested in order, so a
+// class may test membership of those before it.
+func New(classes ...*Class) *Classifier {
+	return &Classifier{classes: classes}
+}
+
+// Classify returns the classes the client that sent req belongs to.
+func (c *Classifier) Classify(req dhcp4.Packet, options dhcp4.Options) dhcp4.ClassSet {
+	e := &env{req: req, options: options, classes: make(dhcp4.ClassSet)}
+	for _, class := range c.classes {
+		if class.Test != nil && class.Test.test(e) {
+			e.classes[class.Name] = true
+		}
+	}
+	return e.classes
+}
+
+// Scopes returns the option scopes of the classes in set, in class order.
+func (c *Classifier) Scopes(set dhcp4.ClassSet) []*dhcp4.OptionScope {
+	var scopes []*dhcp4.OptionScope
+	for _, class := range c.classes {
+		if set[class.Name] {
+			scopes = append(scopes, &class.Scope)
+		}
+	}
+	return scopes
+}
+
+// Class returns the class called name, or nil.
+func (c *Classifier) Class(name string) *Class {
+	for _, class := range c.classes {
+		if class.Name == name {
+			return class
+		}
+	}
+	return nil
+}
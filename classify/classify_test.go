@@ -0,0 +1,60 @@
+package classify
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestClassifier(t *testing.T) {
+	c := New(
+		&Class{Name: "pxe", Test: MustCompile("substring(option[60].text, 0, 9) == 'PXEClient'"),
+			Scope: dhcp4.OptionScope{Options: dhcp4.Options{dhcp4.OptionBootFileName: []byte("pxelinux.0")}}},
+		&Class{Name: "uefi", Test: MustCompile("member('pxe') and option[93].hex == 0x0007"),
+			Scope: dhcp4.OptionScope{Options: dhcp4.Options{dhcp4.OptionBootFileName: []byte("bootx64.efi")}}},
+		&Class{Name: "vendor", Test: MustCompile("substring(pkt4.mac, 0, 3) == 0x001122")},
+		&Class{Name: "manual"},
+	)
+	for i, test := range []struct {
+		options []dhcp4.Option
+		classes dhcp4.ClassSet
+		boot    string
+	}{
+		{nil, dhcp4.ClassSet{"vendor": true}, ""},
+		{[]dhcp4.Option{{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00000")}},
+			dhcp4.ClassSet{"pxe": true, "vendor": true}, "pxelinux.0"},
+		{[]dhcp4.Option{
+			{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00007")},
+			{Code: dhcp4.OptionClientArchitecture, Value: []byte{0, 7}},
+		}, dhcp4.ClassSet{"pxe": true, "uefi": true, "vendor": true}, "bootx64.efi"},
+		{[]dhcp4.Option{{Code: dhcp4.OptionClientArchitecture, Value: []byte{0, 7}}}, dhcp4.ClassSet{"vendor": true}, ""},
+	} {
+		req, options := testRequest(test.options)
+		classes := c.Classify(req, options)
+		if !reflect.DeepEqual(classes, test.classes) {
+			t.Fatalf("%02d: test %v, unexpected classes: %v != %v", i, test.options, classes, test.classes)
+		}
+		if boot := string(dhcp4.MergeOptions(c.Scopes(classes)...)[dhcp4.OptionBootFileName]); boot != test.boot {
+			t.Fatalf("%02d: test %v, unexpected boot file: %q != %q", i, test.options, boot, test.boot)
+		}
+	}
+
+	// Pools restricted by class
+	_, n, _ := net.ParseCIDR("10.0.0.0/24")
+	pxe := &dhcp4.Pool{Start: net.IP{10, 0, 0, 100}, Range: 10, Classes: []string{"pxe"}}
+	open := &dhcp4.Pool{Start: net.IP{10, 0, 0, 200}, Range: 10}
+	subnet := &dhcp4.Subnet{Net: *n, Pools: []*dhcp4.Pool{pxe, open}}
+	req, options := testRequest([]dhcp4.Option{{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient")}})
+	if pools := subnet.PermittedPools(c.Classify(req, options)); len(pools) != 2 {
+		t.Fatalf("PXE client, unexpected pools: %v", pools)
+	}
+	req, options = testRequest(nil)
+	if pools := subnet.PermittedPools(c.Classify(req, options)); len(pools) != 1 || pools[0] != open {
+		t.Fatalf("Other client, unexpected pools: %v", pools)
+	}
+	if c.Class("uefi") == nil || c.Class("none") != nil {
+		t.Fatalf("Class lookup failed")
+	}
+}
@@ -0,0 +1,426 @@
+package classify
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// SyntaxError describes an expression that fails to compile.
+type SyntaxError struct {
+	Expr   string
+	Offset int // Byte offset of the error in Expr
+	Msg    string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("classify: %s at offset %d of %q", e.Msg, e.Offset, e.Expr)
+}
+
+// Expr is a compiled match expression.
+type Expr struct {
+	src  string
+	test boolFn
+}
+
+// env is what an expression is evaluated against.
+type env struct {
+	req     dhcp4.Packet
+	options dhcp4.Options
+	classes dhcp4.ClassSet
+}
+
+type boolFn func(*env) bool
+type valueFn func(*env) []byte
+
+// Compile parses an expression, which must be boolean.
+//
+// Values are string literals ('PXEClient'), hex literals (0x0007), options
+// (option[60].text, option[93].hex; .text and .hex are the same bytes),
+// relay agent sub-options (option[82].option[2].hex), packet fields
+// (pkt4.mac, pkt4.htype, pkt4.hlen, pkt4.ciaddr, pkt4.giaddr) and
+// substring(value, start, length), where length may be all.
+//
+// Boolean expressions are value == value, value != value, option[N].exists,
+// member('class') for classes earlier in the Classifier, and their
+// combination with and, or, not and parentheses.
+//
+//	substring(option[60].text, 0, 9) == 'PXEClient' and option[93].hex == 0x0007
+func Compile(s string) (*Expr, error) {
+	p := &parser{src: s}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	test, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %q", t.text)
+	}
+	return &Expr{src: s, test: test}, nil
+}
+
+// MustCompile is like Compile but panics if s doesn't compile.
+func MustCompile(s string) *Expr {
+	e, err := Compile(s)
+	if err != nil {
+		panic(err)
+	}
+	return e
+}
+
+func (e *Expr) String() string { return e.src }
+
+// Match returns true if the request matches the expression.
+func (e *Expr) Match(req dhcp4.Packet, options dhcp4.Options) bool {
+	return e.test(&env{req: req, options: options})
+}
+
+type tokKind byte
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokHex
+	tokInt
+	tokPunct // ( ) [ ] , . == !=
+)
+
+type token struct {
+	kind tokKind
+	text string
+	pos  int
+	b    []byte // Value of string and hex literals
+	n    int    // Value of integers
+}
+
+type parser struct {
+	src    string
+	tokens []token
+	i      int
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return &SyntaxError{Expr: p.src, Offset: t.pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		start := i
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+			continue
+		case c == '\'':
+			end := strings.IndexByte(s[i+1:], '\'')
+			if end < 0 {
+				return &SyntaxError{Expr: s, Offset: i, Msg: "unterminated string"}
+			}
+			text := s[i+1 : i+1+end]
+			i += end + 2
+			p.tokens = append(p.tokens, token{kind: tokString, text: text, pos: start, b: []byte(text)})
+		case c == '0' && i+1 < len(s) && (s[i+1] == 'x' || s[i+1] == 'X'):
+			for i += 2; i < len(s) && isHexDigit(s[i]); i++ {
+			}
+			digits := s[start+2 : i]
+			if len(digits)%2 == 1 {
+				digits = "0" + digits
+			}
+			b, err := hex.DecodeString(digits)
+			if err != nil || len(digits) == 0 {
+				return &SyntaxError{Expr: s, Offset: start, Msg: "bad hex literal"}
+			}
+			p.tokens = append(p.tokens, token{kind: tokHex, text: s[start:i], pos: start, b: b})
+		case c >= '0' && c <= '9':
+			for i++; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+			}
+			n, err := strconv.Atoi(s[start:i])
+			if err != nil {
+				return &SyntaxError{Expr: s, Offset: start, Msg: "bad integer"}
+			}
+			p.tokens = append(p.tokens, token{kind: tokInt, text: s[start:i], pos: start, n: n})
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			for i++; i < len(s) && (s[i] == '_' || s[i] >= 'a' && s[i] <= 'z' || s[i] >= 'A' && s[i] <= 'Z' || s[i] >= '0' && s[i] <= '9'); i++ {
+			}
+			p.tokens = append(p.tokens, token{kind: tokIdent, text: s[start:i], pos: start})
+		case strings.HasPrefix(s[i:], "==") || strings.HasPrefix(s[i:], "!="):
+			i += 2
+			p.tokens = append(p.tokens, token{kind: tokPunct, text: s[start:i], pos: start})
+		case strings.IndexByte("()[],.", c) >= 0:
+			i++
+			p.tokens = append(p.tokens, token{kind: tokPunct, text: s[start:i], pos: start})
+		default:
+			return &SyntaxError{Expr: s, Offset: i, Msg: fmt.Sprintf("unexpected %q", c)}
+		}
+	}
+	p.tokens = append(p.tokens, token{kind: tokEOF, text: "end of expression", pos: len(s)})
+	return nil
+}
+
+func isHexDigit(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F'
+}
+
+func (p *parser) peek() token { return p.tokens[p.i] }
+
+func (p *parser) next() token {
+	t := p.tokens[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it is text.
+func (p *parser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokPunct || t.kind == tokIdent) && t.text == text {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		t := p.peek()
+		return p.errorf(t, "expected %q, found %q", text, t.text)
+	}
+	return nil
+}
+
+func (p *parser) expr() (boolFn, error) {
+	l, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("or") {
+		r, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		l = func(l, r boolFn) boolFn { return func(e *env) bool { return l(e) || r(e) } }(l, r)
+	}
+	return l, nil
+}
+
+func (p *parser) and() (boolFn, error) {
+	l, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept("and") {
+		r, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		l = func(l, r boolFn) boolFn { return func(e *env) bool { return l(e) && r(e) } }(l, r)
+	}
+	return l, nil
+}
+
+func (p *parser) not() (boolFn, error) {
+	if p.accept("not") {
+		f, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(e *env) bool { return !f(e) }, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (boolFn, error) {
+	if p.accept("(") {
+		f, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		return f, p.expect(")")
+	}
+	if p.accept("member") {
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		t := p.next()
+		if t.kind != tokString {
+			return nil, p.errorf(t, "expected class name, found %q", t.text)
+		}
+		name := t.text
+		return func(e *env) bool { return e.classes[name] }, p.expect(")")
+	}
+
+	t := p.peek()
+	l, exists, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if exists != nil {
+		return exists, nil
+	}
+	var negate bool
+	switch op := p.next(); {
+	case op.kind == tokPunct && op.text == "==":
+	case op.kind == tokPunct && op.text == "!=":
+		negate = true
+	default:
+		return nil, p.errorf(t, "expected boolean expression")
+	}
+	r, exists, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if exists != nil {
+		return nil, p.errorf(t, "can't compare boolean")
+	}
+	return func(e *env) bool { return bytes.Equal(l(e), r(e)) != negate }, nil
+}
+
+// value parses a value, or an option's .exists, returned as a boolean.
+func (p *parser) value() (valueFn, boolFn, error) {
+	t := p.next()
+	switch t.kind {
+	case tokString, tokHex:
+		b := t.b
+		return func(*env) []byte { return b }, nil, nil
+	case tokIdent:
+		switch t.text {
+		case "option":
+			return p.option()
+		case "pkt4":
+			return p.packetField()
+		case "substring":
+			f, err := p.substring()
+			return f, nil, err
+		}
+	}
+	return nil, nil, p.errorf(t, "expected value, found %q", t.text)
+}
+
+func (p *parser) optionCode() (int, error) {
+	if err := p.expect("["); err != nil {
+		return 0, err
+	}
+	t := p.next()
+	if t.kind != tokInt || t.n > 255 {
+		return 0, p.errorf(t, "expected option code, found %q", t.text)
+	}
+	return t.n, p.expect("]")
+}
+
+func (p *parser) option() (valueFn, boolFn, error) {
+	code, err := p.optionCode()
+	if err != nil {
+		return nil, nil, err
+	}
+	get := func(e *env) ([]byte, bool) {
+		v, ok := e.options[dhcp4.OptionCode(code)]
+		return v, ok
+	}
+	if code == int(dhcp4.OptionRelayAgentInformation) && p.accept(".") {
+		if !p.accept("option") {
+			p.i-- // Back to the dot, for the accessor
+		} else {
+			sub, err := p.optionCode()
+			if err != nil {
+				return nil, nil, err
+			}
+			get = func(e *env) ([]byte, bool) {
+				return subOption(e.options[dhcp4.OptionRelayAgentInformation], byte(sub))
+			}
+		}
+	}
+	if err := p.expect("."); err != nil {
+		return nil, nil, err
+	}
+	t := p.next()
+	switch t.text {
+	case "text", "hex":
+		return func(e *env) []byte { v, _ := get(e); return v }, nil, nil
+	case "exists":
+		return nil, func(e *env) bool { _, ok := get(e); return ok }, nil
+	}
+	return nil, nil, p.errorf(t, "expected text, hex or exists, found %q", t.text)
+}
+
+// subOption returns relay agent sub-option code of b.
+func subOption(b []byte, code byte) ([]byte, bool) {
+	for len(b) >= 2 && len(b) >= 2+int(b[1]) {
+		if b[0] == code {
+			return b[2 : 2+b[1]], true
+		}
+		b = b[2+b[1]:]
+	}
+	return nil, false
+}
+
+func (p *parser) packetField() (valueFn, boolFn, error) {
+	if err := p.expect("."); err != nil {
+		return nil, nil, err
+	}
+	t := p.next()
+	switch t.text {
+	case "mac":
+		return func(e *env) []byte { return e.req.CHAddr() }, nil, nil
+	case "htype":
+		return func(e *env) []byte { return []byte{e.req.HType()} }, nil, nil
+	case "hlen":
+		return func(e *env) []byte { return []byte{e.req.HLen()} }, nil, nil
+	case "ciaddr":
+		return func(e *env) []byte { return e.req.CIAddr() }, nil, nil
+	case "giaddr":
+		return func(e *env) []byte { return e.req.GIAddr() }, nil, nil
+	}
+	return nil, nil, p.errorf(t, "unknown packet field %q", t.text)
+}
+
+func (p *parser) substring() (valueFn, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	t := p.peek()
+	v, exists, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+	if exists != nil {
+		return nil, p.errorf(t, "substring of boolean")
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	start := p.next()
+	if start.kind != tokInt {
+		return nil, p.errorf(start, "expected start, found %q", start.text)
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	length := p.next()
+	switch {
+	case length.kind == tokInt:
+	case length.kind == tokIdent && length.text == "all":
+		length.n = -1
+	default:
+		return nil, p.errorf(length, "expected length, found %q", length.text)
+	}
+	from, n := start.n, length.n
+	return func(e *env) []byte {
+		b := v(e)
+		if from >= len(b) {
+			return nil
+		}
+		b = b[from:]
+		if n >= 0 && n < len(b) {
+			b = b[:n]
+		}
+		return b
+	}, p.expect(")")
+}
@@ -0,0 +1,89 @@
+package classify
+
+import (
+	"net"
+	"testing"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func testRequest(options []dhcp4.Option) (dhcp4.Packet, dhcp4.Options) {
+	req := dhcp4.RequestPacket(dhcp4.Discover, net.HardwareAddr{0x00, 0x11, 0x22, 3, 4, 5}, nil, []byte{1, 2, 3, 4}, true, options)
+	req.SetGIAddr(net.IP{10, 0, 0, 1})
+	return req, req.ParseOptions()
+}
+
+func TestExpr(t *testing.T) {
+	req, options := testRequest([]dhcp4.Option{
+		{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00007:UNDI:003016")},
+		{Code: dhcp4.OptionUserClass, Value: []byte("lab")},
+		{Code: dhcp4.OptionClientArchitecture, Value: []byte{0, 7}},
+		{Code: dhcp4.OptionRelayAgentInformation, Value: []byte{1, 2, 'e', '0', 2, 3, 0xaa, 0xbb, 0xcc}},
+	})
+	for i, test := range []struct {
+		expr  string
+		match bool
+	}{
+		{"substring(option[60].text, 0, 9) == 'PXEClient'", true},
+		{"substring(option[60].text, 0, 9) == 'HTTPClien'", false},
+		{"substring(option[60].text, 10, 4) == 'Arch'", true},
+		{"substring(option[60].text, 29, all) == '016'", true},
+		{"substring(option[60].text, 100, all) == ''", true},
+		{"option[77].text == 'lab'", true},
+		{"option[77].text != 'lab'", false},
+		{"option[93].hex == 0x0007", true},
+		{"option[93].hex == 0x7", false},
+		{"option[93].exists", true},
+		{"option[12].exists", false},
+		{"not option[12].exists", true},
+		{"option[82].option[2].hex == 0xaabbcc", true},
+		{"option[82].option[1].text == 'e0'", true},
+		{"option[82].option[9].exists", false},
+		{"option[82].exists and option[82].hex == 0x0102", false},
+		{"substring(pkt4.mac, 0, 3) == 0x001122", true},
+		{"pkt4.giaddr == 0x0a000001 and pkt4.htype == 0x01 and pkt4.hlen == 0x06", true},
+		{"pkt4.ciaddr == 0x00000000", true},
+		{"option[77].text == 'x' or option[93].hex == 0x0007", true},
+		{"option[77].text == 'x' or option[93].hex == 0x0006", false},
+		{"not (option[77].text == 'x' or option[93].hex == 0x0006) and option[93].exists", true},
+		{"'a' == 'a' and not 'a' == 'b'", true},
+	} {
+		e, err := Compile(test.expr)
+		if err != nil {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, test.expr, err)
+		}
+		if match := e.Match(req, options); match != test.match {
+			t.Fatalf("%02d: test %q, unexpected match: %v != %v", i, test.expr, match, test.match)
+		}
+	}
+}
+
+func TestExprSyntax(t *testing.T) {
+	for i, test := range []struct {
+		expr   string
+		offset int
+	}{
+		{"", 0},
+		{"option[60].text", 0},
+		{"option[60].text == 'abc", 19},
+		{"option[60].size == 'a'", 11},
+		{"option[600].hex == 0x01", 7},
+		{"option[60].exists == 'a'", 18},
+		{"substring(option[60].text, 0) == 'a'", 28},
+		{"(option[60].exists", 18},
+		{"option[60].exists option[61].exists", 18},
+		{"member(abc)", 7},
+		{"pkt4.foo == 0x00", 5},
+		{"0xzz == 0x00", 0},
+		{"option[60].text == 'a' @", 23},
+	} {
+		_, err := Compile(test.expr)
+		se, ok := err.(*SyntaxError)
+		if !ok {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, test.expr, err)
+		}
+		if se.Offset != test.offset {
+			t.Fatalf("%02d: test %q, unexpected offset: %d != %d (%v)", i, test.expr, se.Offset, test.offset, err)
+		}
+	}
+}
@@ -0,0 +1,601 @@
+// Package client implements a DHCP client, as the state machine of RFC 2131
+// section 4.4.
+//
+//	c := client.New(mac, conn)
+//	c.OnLease = func(l *client.Lease) { ... } // Configure the interface
+//	err := c.Run(ctx)                         // Until ctx is cancelled
+//
+// Transports are provided by package conn, and HandlerConn connects clients
+// to in-process servers.
+package client
+
+import (
+	"context"
+	"crypto/rand"
+	mrand "math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+//go:generate stringer -type=State
+
+// State is a client state (RFC 2131 figure 5).
+type State byte
+
+// Client states
+const (
+	Init State = iota
+	Selecting
+	Requesting
+	Bound
+	Renewing
+	Rebinding
+	InitReboot
+	Rebooting
+)
+
+// Defaults
+const (
+	DefaultRetransmit       = 4 * time.Second  // First retransmission delay
+	DefaultMaxRetransmit    = 64 * time.Second // Retransmission delay limit
+	DefaultAttempts         = 4                // Of each REQUEST before giving up
+	DefaultMinRenewInterval = time.Minute      // Between REQUESTs when renewing and rebinding
+	DefaultDeclineWait      = 10 * time.Second // After a DECLINE, before restarting
+)
+
+// DefaultParams are the options requested by default.
+var DefaultParams = []byte{
+	byte(dhcp4.OptionSubnetMask),
+	byte(dhcp4.OptionClasslessRouteFormat), // Before OptionRouter (RFC 3442)
+	byte(dhcp4.OptionRouter),
+	byte(dhcp4.OptionDomainNameServer),
+	byte(dhcp4.OptionDomainName),
+	byte(dhcp4.OptionDomainSearch),
+	byte(dhcp4.OptionInterfaceMTU),
+	byte(dhcp4.OptionBroadcastAddress),
+	byte(dhcp4.OptionNetworkTimeProtocolServers),
+	byte(dhcp4.OptionIPAddressLeaseTime),
+	byte(dhcp4.OptionRenewalTimeValue),
+	byte(dhcp4.OptionRebindingTimeValue),
+}
+
+// Offer is a server's DHCPOFFER.
+type Offer struct {
+	Packet   dhcp4.Packet
+	Options  dhcp4.Options
+	IP       net.IP // Offered address
+	ServerID net.IP
+}
+
+// Client is a DHCP client for one interface.
+type Client struct {
+	HardwareAddr net.HardwareAddr
+	Conn         Conn
+
+	ClientID []byte         // Option 61, if not nil
+	Hostname string         // Option 12, if set
+	Params   []byte         // Parameter request list, DefaultParams if nil
+	Options  []dhcp4.Option // Sent in every request, such as OptionVendorClassIdentifier
+
+	// NoBroadcast clears the broadcast flag in requests, for transports that
+	// receive unicast replies before the client has an address.
+	NoBroadcast bool
+
+	// OfferWait is how long to collect offers after the first, before
+	// choosing one with SelectOffer.  If zero, the first offer is taken.
+	OfferWait time.Duration
+	// SelectOffer, if set, chooses between offers, returning nil to reject
+	// them all.  By default the first is chosen.
+	SelectOffer func(offers []*Offer) *Offer
+	// Prober, if set, checks offered addresses aren't already in use (such
+	// as with ARP) before they're bound.  Addresses in use are declined.
+	Prober dhcp4.Prober
+
+	// Previous is a lease held before a restart.  If set, Run starts in
+	// INIT-REBOOT, requesting the address again.
+	Previous *Lease
+	// LeaseFile, if set, keeps the lease across restarts: each lease is
+	// saved to it, and unless Previous is set, Run starts in INIT-REBOOT
+	// with the unexpired lease saved there, if any.
+	LeaseFile string
+	// KeepLease stops Run releasing the lease when ctx is done, so it can be
+	// requested again after a restart.
+	KeepLease bool
+
+	Retransmit       time.Duration // Default DefaultRetransmit
+	MaxRetransmit    time.Duration // Default DefaultMaxRetransmit
+	Attempts         int           // Default DefaultAttempts
+	MinRenewInterval time.Duration // Default DefaultMinRenewInterval
+	DeclineWait      time.Duration // Default DefaultDeclineWait
+
+	// OnLease, if set, is called whenever a lease is bound or renewed, and
+	// with nil when the lease is lost or released.
+	OnLease func(l *Lease)
+	// OnState, if set, is called with each state entered.
+	OnState func(s State)
+	// OnSaveError, if set, is called when the lease can't be saved to (or
+	// removed from) LeaseFile.  The lease is still maintained.
+	OnSaveError func(err error)
+
+	mu    sync.Mutex
+	lease *Lease
+	state State
+}
+
+// New returns a client for the interface with hardware address mac, using
+// conn.
+func New(mac net.HardwareAddr, conn Conn) *Client {
+	return &Client{HardwareAddr: mac, Conn: conn}
+}
+
+// Lease returns the current lease, or nil.
+func (c *Client) Lease() *Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lease
+}
+
+// State returns the client's current state.
+func (c *Client) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.state
+}
+
+func (c *Client) setState(s State) {
+	c.mu.Lock()
+	c.state = s
+	c.mu.Unlock()
+	if c.OnState != nil {
+		c.OnState(s)
+	}
+}
+
+// setLease records l as the current lease, saving it to LeaseFile if set.
+func (c *Client) setLease(l *Lease) {
+	c.mu.Lock()
+	c.lease = l
+	c.mu.Unlock()
+	if c.OnLease != nil {
+		c.OnLease(l)
+	}
+	if c.LeaseFile == "" {
+		return
+	}
+	var err error
+	if l == nil {
+		if err = os.Remove(c.LeaseFile); os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = SaveLease(c.LeaseFile, l)
+	}
+	if err != nil && c.OnSaveError != nil {
+		c.OnSaveError(err)
+	}
+}
+
+// reply is a received packet.
+type reply struct {
+	p       dhcp4.Packet
+	options dhcp4.Options
+	t       dhcp4.MessageType
+}
+
+// Run acquires and maintains a lease until ctx is done, then releases it
+// (unless KeepLease is set).
+func (c *Client) Run(ctx context.Context) error {
+	r, end := c.begin(ctx)
+	defer end()
+	r.previous = c.Previous
+	if r.previous == nil && c.LeaseFile != "" {
+		r.previous, _ = LoadLease(c.LeaseFile) // Unreadable leases are forgotten
+	}
+	state := Init
+	if l := r.previous; l != nil && (l.Duration == 0 || time.Now().Before(l.Expiry())) {
+		state = InitReboot
+	}
+	for {
+		c.setState(state)
+		var err error
+		switch state {
+		case Init, Selecting:
+			state, err = r.selecting()
+		case Requesting:
+			state, err = r.requesting()
+		case InitReboot, Rebooting:
+			state, err = r.rebooting()
+		case Bound:
+			state, err = r.bound()
+		case Renewing, Rebinding:
+			state, err = r.renewing(state)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return r.release()
+			}
+			return err
+		}
+	}
+}
+
+// begin starts reading replies for an exchange lasting until ctx is done,
+// returning its run and a func ending it.
+func (c *Client) begin(ctx context.Context) (*run, func()) {
+	replies := make(chan reply, 16)
+	done := make(chan struct{})
+	errc := make(chan error, 1)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errc <- c.read(replies, done)
+	}()
+	return &run{Client: c, ctx: ctx, replies: replies, errc: errc}, func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// read passes packets addressed to the client to replies, until done.
+func (c *Client) read(replies chan<- reply, done <-chan struct{}) error {
+	b := make([]byte, 1500)
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+		c.Conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, _, err := c.Conn.ReadFrom(b)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() {
+				continue
+			}
+			return err
+		}
+		p := dhcp4.Packet(b[:n])
+		if n < 240 || p.OpCode() != dhcp4.BootReply || p.CHAddr().String() != c.HardwareAddr.String() {
+			continue
+		}
+		options := p.ParseOptions()
+		t := options[dhcp4.OptionDHCPMessageType]
+		if len(t) != 1 {
+			continue
+		}
+		p = append(dhcp4.Packet(nil), p...)
+		select {
+		case replies <- reply{p, p.ParseOptions(), dhcp4.MessageType(t[0])}: // Options of the copy
+		case <-done:
+			return nil
+		}
+	}
+}
+
+// run is the state of a call to Run.
+type run struct {
+	*Client
+	ctx      context.Context
+	replies  <-chan reply
+	errc     <-chan error
+	xid      []byte
+	start    time.Time // Of the current exchange
+	offer    *Offer
+	previous *Lease // Requested in INIT-REBOOT
+}
+
+var broadcast = &net.UDPAddr{IP: net.IPv4bcast, Port: 67}
+
+// newXId starts a new exchange.
+func (r *run) newXId() {
+	r.xid = make([]byte, 4)
+	rand.Read(r.xid)
+	r.start = time.Now()
+}
+
+// packet builds a request of type mt from ciaddr, with extra options.
+func (r *run) packet(mt dhcp4.MessageType, ciaddr net.IP, extra ...dhcp4.Option) dhcp4.Packet {
+	var options []dhcp4.Option
+	if r.ClientID != nil {
+		options = append(options, dhcp4.Option{Code: dhcp4.OptionClientIdentifier, Value: r.ClientID})
+	}
+	options = append(options, extra...)
+	if mt == dhcp4.Discover || mt == dhcp4.Request || mt == dhcp4.Inform {
+		if r.Hostname != "" {
+			options = append(options, dhcp4.Option{Code: dhcp4.OptionHostName, Value: []byte(r.Hostname)})
+		}
+		params := r.Params
+		if params == nil {
+			params = DefaultParams
+		}
+		options = append(options,
+			dhcp4.Option{Code: dhcp4.OptionParameterRequestList, Value: params},
+			dhcp4.Option{Code: dhcp4.OptionMaximumDHCPMessageSize, Value: []byte{1500 >> 8, 1500 & 0xff}})
+		options = append(options, r.Options...)
+	}
+	broadcast := !r.NoBroadcast && ciaddr == nil
+	return dhcp4.RequestPacket(mt, r.HardwareAddr, ciaddr, r.xid, broadcast, options)
+}
+
+// retransmitDelay returns the delay before the nth retransmission (from 0),
+// doubling from Retransmit up to MaxRetransmit, randomised by up to a second
+// either way (RFC 2131 section 4.1) or a quarter of the delay, if less.
+func (r *run) retransmitDelay(n int) time.Duration {
+	d, max := r.Retransmit, r.MaxRetransmit
+	if d <= 0 {
+		d = DefaultRetransmit
+	}
+	if max <= 0 {
+		max = DefaultMaxRetransmit
+	}
+	for ; n > 0 && d < max; n-- {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	jitter := time.Second
+	if d/4 < jitter {
+		jitter = d / 4
+	}
+	if jitter > 0 {
+		d += time.Duration(mrand.Int63n(int64(2*jitter))) - jitter
+	}
+	return d
+}
+
+// send writes p to dst, with the secs field updated.
+func (r *run) send(p dhcp4.Packet, dst net.Addr) error {
+	secs := time.Since(r.start) / time.Second
+	if secs > 0xffff {
+		secs = 0xffff
+	}
+	p.SetSecs([]byte{byte(secs >> 8), byte(secs)})
+	_, err := r.Conn.WriteTo(p, dst)
+	return err
+}
+
+// wait returns the next reply to the current exchange accepted by accept,
+// or nil once timeout has passed.  An error is returned if ctx is done or
+// the conn fails.
+func (r *run) wait(timeout time.Duration, accept func(reply) bool) (*reply, error) {
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		case err := <-r.errc:
+			return nil, err
+		case <-t.C:
+			return nil, nil
+		case res := <-r.replies:
+			if string(res.p.XId()) == string(r.xid) && accept(res) {
+				return &res, nil
+			}
+		}
+	}
+}
+
+// sleep waits for d, or returns an error if ctx is done.
+func (r *run) sleep(d time.Duration) error {
+	_, err := r.wait(d, func(reply) bool { return false })
+	return err
+}
+
+// selecting broadcasts DISCOVERs until an acceptable offer is received.
+func (r *run) selecting() (State, error) {
+	r.newXId()
+	discover := r.packet(dhcp4.Discover, nil)
+	for n := 0; ; n++ {
+		if err := r.send(discover, broadcast); err != nil {
+			return Init, err
+		}
+		if n == 0 {
+			r.setState(Selecting)
+		}
+		res, err := r.wait(r.retransmitDelay(n), isOffer)
+		if err != nil {
+			return Init, err
+		}
+		if res == nil {
+			continue
+		}
+		offers := []*Offer{newOffer(res)}
+		if r.OfferWait > 0 {
+			deadline := time.Now().Add(r.OfferWait)
+			for {
+				res, err := r.wait(time.Until(deadline), isOffer)
+				if err != nil {
+					return Init, err
+				}
+				if res == nil {
+					break
+				}
+				offers = append(offers, newOffer(res))
+			}
+		}
+		r.offer = offers[0]
+		if r.SelectOffer != nil {
+			r.offer = r.SelectOffer(offers)
+		}
+		if r.offer != nil {
+			return Requesting, nil
+		}
+	}
+}
+
+// isOffer returns true if res is a usable offer.
+func isOffer(res reply) bool {
+	return res.t == dhcp4.Offer && !res.p.YIAddr().Equal(net.IPv4zero) &&
+		len(res.options[dhcp4.OptionServerIdentifier]) == 4
+}
+
+func newOffer(res *reply) *Offer {
+	return &Offer{
+		Packet:   res.p,
+		Options:  res.options,
+		IP:       append(net.IP(nil), res.p.YIAddr()...),
+		ServerID: net.IP(res.options[dhcp4.OptionServerIdentifier]),
+	}
+}
+
+func (r *run) attempts() int {
+	if r.Attempts > 0 {
+		return r.Attempts
+	}
+	return DefaultAttempts
+}
+
+// requesting requests the chosen offer.
+func (r *run) requesting() (State, error) {
+	req := r.packet(dhcp4.Request, nil,
+		dhcp4.Option{Code: dhcp4.OptionRequestedIPAddress, Value: r.offer.IP.To4()},
+		dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: r.offer.ServerID.To4()})
+	res, err := r.request(req, broadcast, func(res reply) bool {
+		return net.IP(res.options[dhcp4.OptionServerIdentifier]).Equal(r.offer.ServerID)
+	})
+	if err != nil || res == nil {
+		return Init, err
+	}
+	return r.acknowledged(res)
+}
+
+// rebooting requests the previous lease's address again.  If it's not
+// confirmed, the client starts again from INIT.
+func (r *run) rebooting() (State, error) {
+	r.newXId()
+	r.setState(Rebooting)
+	req := r.packet(dhcp4.Request, nil,
+		dhcp4.Option{Code: dhcp4.OptionRequestedIPAddress, Value: r.previous.IP.To4()})
+	res, err := r.request(req, broadcast, func(reply) bool { return true })
+	if err != nil || res == nil {
+		return Init, err
+	}
+	return r.acknowledged(res)
+}
+
+// request sends req to dst until an ACK or NAK is accepted, returning nil
+// if none is or a NAK is received.
+func (r *run) request(req dhcp4.Packet, dst net.Addr, accept func(reply) bool) (*reply, error) {
+	for n := 0; n < r.attempts(); n++ {
+		if err := r.send(req, dst); err != nil {
+			return nil, err
+		}
+		res, err := r.wait(r.retransmitDelay(n), func(res reply) bool {
+			return (res.t == dhcp4.ACK || res.t == dhcp4.NAK) && accept(res)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			if res.t == dhcp4.NAK {
+				return nil, nil
+			}
+			return res, nil
+		}
+	}
+	return nil, nil
+}
+
+// acknowledged binds the lease granted by the ACK res, unless its address
+// is found to be in use, when it's declined.
+func (r *run) acknowledged(res *reply) (State, error) {
+	l := newLease(res.p, res.options, time.Now())
+	if r.Prober != nil && r.Prober.InUse(l.IP) {
+		decline := r.packet(dhcp4.Decline, nil,
+			dhcp4.Option{Code: dhcp4.OptionRequestedIPAddress, Value: l.IP.To4()},
+			dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: l.ServerID.To4()})
+		if err := r.send(decline, broadcast); err != nil {
+			return Init, err
+		}
+		d := r.DeclineWait
+		if d <= 0 {
+			d = DefaultDeclineWait
+		}
+		return Init, r.sleep(d)
+	}
+	r.setLease(l)
+	return Bound, nil
+}
+
+// bound waits until it's time to renew.
+func (r *run) bound() (State, error) {
+	l := r.Lease()
+	if l.Duration == 0 {
+		<-r.ctx.Done() // Never renewed
+		return Bound, r.ctx.Err()
+	}
+	if err := r.sleep(time.Until(l.Acquired.Add(l.T1))); err != nil {
+		return Bound, err
+	}
+	return Renewing, nil
+}
+
+// renewing extends the lease, by unicasting REQUESTs to its server until T2,
+// then broadcasting them (rebinding) until it expires.
+func (r *run) renewing(state State) (State, error) {
+	l := r.Lease()
+	r.newXId()
+	req := r.packet(dhcp4.Request, l.IP)
+	for {
+		dst, end := &net.UDPAddr{IP: l.ServerID, Port: 67}, l.Acquired.Add(l.T2)
+		if state == Rebinding {
+			dst, end = broadcast, l.Expiry()
+		}
+		remaining := time.Until(end)
+		if remaining <= 0 {
+			if state == Rebinding {
+				r.setLease(nil)
+				return Init, nil
+			}
+			state = Rebinding
+			r.setState(state)
+			continue
+		}
+		if err := r.send(req, dst); err != nil {
+			return state, err
+		}
+		// RFC 2131 section 4.4.5: wait half the remaining time, within limits
+		wait, min := remaining/2, r.MinRenewInterval
+		if min <= 0 {
+			min = DefaultMinRenewInterval
+		}
+		if wait < min {
+			wait = min
+		}
+		if wait > remaining {
+			wait = remaining
+		}
+		res, err := r.wait(wait, func(res reply) bool { return res.t == dhcp4.ACK || res.t == dhcp4.NAK })
+		if err != nil {
+			return state, err
+		}
+		if res == nil {
+			continue
+		}
+		if res.t == dhcp4.NAK {
+			r.setLease(nil)
+			return Init, nil
+		}
+		r.setLease(newLease(res.p, res.options, time.Now()))
+		return Bound, nil
+	}
+}
+
+// release gives up the lease, if any.
+func (r *run) release() error {
+	l := r.Lease()
+	if l == nil || r.KeepLease {
+		return nil
+	}
+	r.newXId()
+	p := r.packet(dhcp4.Release, l.IP, dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: l.ServerID.To4()})
+	err := r.send(p, &net.UDPAddr{IP: l.ServerID, Port: 67})
+	r.setLease(nil)
+	return err
+}
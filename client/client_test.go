@@ -0,0 +1,310 @@
+package client
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// testClient returns a client of conn with short timeouts, reporting its
+// leases on the returned channel.
+func testClient(conn Conn) (*Client, chan *Lease) {
+	leases := make(chan *Lease, 16)
+	c := New(net.HardwareAddr{0, 1, 2, 3, 4, 5}, conn)
+	c.Retransmit, c.MaxRetransmit = 50*time.Millisecond, 200*time.Millisecond
+	c.MinRenewInterval, c.DeclineWait = 50*time.Millisecond, 10*time.Millisecond
+	c.OnLease = func(l *Lease) { leases <- l }
+	return c, leases
+}
+
+// start runs c, returning a func stopping it and waiting for Run to return.
+func start(t *testing.T, c *Client) (stop func()) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	return func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Fatalf("Run, unexpected error: %v", err)
+		}
+	}
+}
+
+func nextLease(t *testing.T, leases chan *Lease) *Lease {
+	select {
+	case l := <-leases:
+		return l
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for lease")
+	}
+	return nil
+}
+
+// stateLog records the states a client enters.
+type stateLog struct {
+	mu     sync.Mutex
+	states []State
+}
+
+func (s *stateLog) add(state State) {
+	s.mu.Lock()
+	s.states = append(s.states, state)
+	s.mu.Unlock()
+}
+
+func (s *stateLog) contains(state State) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, st := range s.states {
+		if st == state {
+			return true
+		}
+	}
+	return false
+}
+
+func TestClient(t *testing.T) {
+	serverIP := net.IP{192, 168, 1, 1}
+	s := dhcp4.NewServer(serverIP, net.IP{192, 168, 1, 10}, 10, 2*time.Second, dhcp4.Options{dhcp4.OptionRouter: serverIP}, nil)
+	c, leases := testClient(NewHandlerConn(s))
+	var states stateLog
+	c.OnState = states.add
+	stop := start(t, c)
+
+	l := nextLease(t, leases)
+	if !dhcp4.IPInRange(net.IP{192, 168, 1, 10}, net.IP{192, 168, 1, 19}, l.IP) || !l.ServerID.Equal(serverIP) {
+		t.Fatalf("unexpected lease: %+v", l)
+	}
+	if l.Duration != 2*time.Second || l.T1 != time.Second || l.T2 != 1750*time.Millisecond || !net.IP(l.Options[dhcp4.OptionRouter]).Equal(serverIP) {
+		t.Fatalf("unexpected lease times or options: %+v", l)
+	}
+	if sl, ok := s.Leases().Get(l.IP); !ok || sl.HardwareAddr.String() != c.HardwareAddr.String() {
+		t.Fatalf("lease not recorded by server: %v", sl)
+	}
+
+	// Renewed at T1
+	r := nextLease(t, leases)
+	if r == nil || !r.IP.Equal(l.IP) || !r.Acquired.After(l.Acquired) || !states.contains(Renewing) || states.contains(Rebinding) {
+		t.Fatalf("unexpected renewal: %+v %v", r, states.states)
+	}
+
+	stop()
+	if l := nextLease(t, leases); l != nil {
+		t.Fatalf("Stop, lease not released: %+v", l)
+	}
+	if _, ok := s.Leases().Get(l.IP); ok {
+		t.Fatalf("Stop, lease not released on server")
+	}
+	for i, want := range []State{Init, Selecting, Requesting, Bound, Renewing, Bound} {
+		if states.states[i] != want {
+			t.Fatalf("%02d: unexpected states: %v", i, states.states)
+		}
+	}
+}
+
+func TestClientRebind(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 10, 2*time.Second, nil, nil)
+	conn := NewHandlerConn(s)
+	c, leases := testClient(conn)
+	var states stateLog
+	c.OnState = states.add
+	// The server is unreachable by unicast
+	conn.Drop = func(p dhcp4.Packet) bool { return c.State() == Renewing }
+	stop := start(t, c)
+	defer stop()
+
+	l := nextLease(t, leases)
+	if r := nextLease(t, leases); r == nil || !r.IP.Equal(l.IP) || !states.contains(Rebinding) {
+		t.Fatalf("unexpected rebinding: %+v %v", r, states.states)
+	}
+}
+
+func TestClientExpiry(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 10, 2*time.Second, nil, nil)
+	conn := NewHandlerConn(s)
+	c, leases := testClient(conn)
+	var unreachable int32
+	conn.Drop = func(p dhcp4.Packet) bool { return atomic.LoadInt32(&unreachable) == 1 }
+	stop := start(t, c)
+	defer stop()
+
+	nextLease(t, leases)
+	atomic.StoreInt32(&unreachable, 1)
+	if l := nextLease(t, leases); l != nil {
+		t.Fatalf("expected lease to expire: %+v", l)
+	}
+	if st := c.State(); st != Init && st != Selecting {
+		t.Fatalf("expected restart after expiry: %v", st)
+	}
+}
+
+// stubProber reports the addresses in inUse as taken.
+type stubProber map[string]bool
+
+func (p stubProber) InUse(ip net.IP) bool { return p[ip.String()] }
+
+func TestClientDecline(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 2, time.Hour, nil, nil)
+	c, leases := testClient(NewHandlerConn(s))
+	c.Prober = stubProber{"192.168.1.10": true}
+	stop := start(t, c)
+	defer stop()
+
+	if l := nextLease(t, leases); !l.IP.Equal(net.IP{192, 168, 1, 11}) {
+		t.Fatalf("unexpected lease of %s", l.IP)
+	}
+	if l, ok := s.Leases().Get(net.IP{192, 168, 1, 10}); ok && !l.Quarantined {
+		t.Fatalf("address in use not declined: %+v", l)
+	}
+}
+
+func TestClientSelectOffer(t *testing.T) {
+	s1 := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+	s2 := dhcp4.NewServer(net.IP{192, 168, 1, 2}, net.IP{192, 168, 1, 100}, 10, time.Hour, nil, nil)
+	c, leases := testClient(NewHandlerConn(s1, s2))
+	c.OfferWait = 50 * time.Millisecond
+	var offered int
+	c.SelectOffer = func(offers []*Offer) *Offer {
+		offered = len(offers)
+		for _, o := range offers {
+			if o.ServerID.Equal(net.IP{192, 168, 1, 2}) {
+				return o
+			}
+		}
+		return nil
+	}
+	stop := start(t, c)
+	defer stop()
+
+	l := nextLease(t, leases)
+	if offered != 2 || !l.ServerID.Equal(net.IP{192, 168, 1, 2}) || !dhcp4.IPInRange(net.IP{192, 168, 1, 100}, net.IP{192, 168, 1, 109}, l.IP) {
+		t.Fatalf("unexpected lease from %d offers: %+v", offered, l)
+	}
+}
+
+func TestClientInitReboot(t *testing.T) {
+	serverIP := net.IP{192, 168, 1, 1}
+	for i, test := range []struct {
+		previous net.IP
+		want     net.IP // nil for any new address
+	}{
+		{net.IP{192, 168, 1, 15}, net.IP{192, 168, 1, 15}},
+		{net.IP{10, 0, 0, 5}, nil}, // NAKed
+	} {
+		s := dhcp4.NewServer(serverIP, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+		c, leases := testClient(NewHandlerConn(s))
+		c.Previous = &Lease{IP: test.previous, ServerID: serverIP}
+		var states stateLog
+		c.OnState = states.add
+		stop := start(t, c)
+
+		l := nextLease(t, leases)
+		stop()
+		if test.want != nil && !l.IP.Equal(test.want) || test.want == nil && (l.IP.Equal(test.previous) || !states.contains(Selecting)) {
+			t.Fatalf("%02d: test %s, unexpected lease: %s %v", i, test.previous, l.IP, states.states)
+		}
+		if !states.contains(Rebooting) {
+			t.Fatalf("%02d: test %s, not rebooted: %v", i, test.previous, states.states)
+		}
+	}
+}
+
+func TestClientLeaseFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "lease.json")
+
+	serverIP := net.IP{192, 168, 1, 1}
+	s := dhcp4.NewServer(serverIP, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+	c, leases := testClient(NewHandlerConn(s))
+	c.LeaseFile, c.KeepLease = file, true
+	stop := start(t, c)
+	l := nextLease(t, leases)
+	stop()
+	saved, err := LoadLease(file)
+	if err != nil || !saved.IP.Equal(l.IP) || !saved.ServerID.Equal(serverIP) || !saved.Expiry().Equal(l.Expiry()) {
+		t.Fatalf("unexpected saved lease: %+v %v", saved, err)
+	}
+
+	// Restarted, the client asks for its address back without a server id
+	conn := NewHandlerConn(s)
+	var requested, serverID int32
+	conn.Drop = func(p dhcp4.Packet) bool {
+		if o := p.ParseOptions(); dhcp4.MessageType(o[dhcp4.OptionDHCPMessageType][0]) == dhcp4.Request {
+			if net.IP(o[dhcp4.OptionRequestedIPAddress]).Equal(l.IP) {
+				atomic.StoreInt32(&requested, 1)
+			}
+			if o[dhcp4.OptionServerIdentifier] != nil {
+				atomic.StoreInt32(&serverID, 1)
+			}
+		}
+		return false
+	}
+	c, leases = testClient(conn)
+	c.LeaseFile = file
+	var states stateLog
+	c.OnState = states.add
+	stop = start(t, c)
+	r := nextLease(t, leases)
+	stop()
+	if !r.IP.Equal(l.IP) || states.contains(Selecting) || !states.contains(Rebooting) {
+		t.Fatalf("unexpected lease after restart: %+v %v", r, states.states)
+	}
+	if atomic.LoadInt32(&requested) != 1 || atomic.LoadInt32(&serverID) != 0 {
+		t.Fatalf("unexpected INIT-REBOOT request: requested %d, server id %d", requested, serverID)
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Fatalf("released lease not removed: %v", err)
+	}
+
+	// Unanswered, the client falls back to INIT
+	if err := SaveLease(file, l); err != nil {
+		t.Fatal(err)
+	}
+	conn = NewHandlerConn(s)
+	conn.Drop = func(p dhcp4.Packet) bool {
+		o := p.ParseOptions()
+		return o[dhcp4.OptionRequestedIPAddress] != nil && o[dhcp4.OptionServerIdentifier] == nil
+	}
+	c, leases = testClient(conn)
+	c.LeaseFile = file
+	states = stateLog{}
+	c.OnState = states.add
+	stop = start(t, c)
+	nextLease(t, leases)
+	stop()
+	if !states.contains(Rebooting) || !states.contains(Selecting) {
+		t.Fatalf("expected fallback to INIT: %v", states.states)
+	}
+}
+
+func TestClientLeaseFileError(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+	c, leases := testClient(NewHandlerConn(s))
+	c.LeaseFile = filepath.Join(t.TempDir(), "missing", "lease.json")
+	saveErrs := make(chan error, 1)
+	c.OnSaveError = func(err error) {
+		select {
+		case saveErrs <- err:
+		default:
+		}
+	}
+	stop := start(t, c)
+	l := nextLease(t, leases)
+	if err := <-saveErrs; err == nil {
+		t.Fatalf("expected save error")
+	}
+	// The lease is still held, and released at the end
+	if state := c.State(); state != Bound {
+		t.Fatalf("unexpected state after save error: %s", state)
+	}
+	stop()
+	if _, ok := s.Leases().Get(l.IP); ok {
+		t.Fatalf("lease of %s not released", l.IP)
+	}
+}
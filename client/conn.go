@@ -0,0 +1,117 @@
+package client
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Conn carries a client's packets.  Packets are written to the broadcast
+// address, or unicast to a server, port 67.  A net.PacketConn listening on
+// port 68 satisfies Conn, though it can only be used before the client has
+// an address on hosts that allow sending from 0.0.0.0.
+type Conn interface {
+	ReadFrom(b []byte) (n int, addr net.Addr, err error)
+	WriteTo(b []byte, addr net.Addr) (n int, err error)
+	SetReadDeadline(t time.Time) error
+}
+
+// HandlerConn is an in-memory Conn delivering packets straight to servers'
+// Handlers, for testing clients and servers without a network.  Broadcasts
+// go to every handler; unicast packets only produce replies from the handler
+// whose server identifier matches the destination.
+type HandlerConn struct {
+	Handlers []dhcp4.Handler
+	// Drop, if set, is called with each request and reply, dropping those
+	// for which it returns true.
+	Drop func(p dhcp4.Packet) bool
+
+	replies  chan dhcp4.Packet
+	mu       sync.Mutex
+	deadline time.Time
+}
+
+// NewHandlerConn returns a HandlerConn for handlers.
+func NewHandlerConn(handlers ...dhcp4.Handler) *HandlerConn {
+	return &HandlerConn{Handlers: handlers, replies: make(chan dhcp4.Packet, 64)}
+}
+
+var errTimeout = &timeoutError{}
+
+type timeoutError struct{}
+
+func (*timeoutError) Error() string   { return "client: i/o timeout" }
+func (*timeoutError) Timeout() bool   { return true }
+func (*timeoutError) Temporary() bool { return true }
+
+var errBadPacket = errors.New("client: bad packet")
+
+// WriteTo passes b to the handlers, queueing their replies to be read.
+func (c *HandlerConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	req := dhcp4.Packet(append([]byte(nil), b...))
+	if len(req) < 240 {
+		return 0, errBadPacket
+	}
+	if c.Drop != nil && c.Drop(req) {
+		return len(b), nil
+	}
+	options := req.ParseOptions()
+	t := options[dhcp4.OptionDHCPMessageType]
+	if len(t) != 1 {
+		return 0, errBadPacket
+	}
+	var dst net.IP
+	if u, ok := addr.(*net.UDPAddr); ok && !u.IP.Equal(net.IPv4bcast) {
+		dst = u.IP
+	}
+	for _, h := range c.Handlers {
+		res := h.ServeDHCP(req, dhcp4.MessageType(t[0]), options)
+		if res == nil {
+			continue
+		}
+		res = append(dhcp4.Packet(nil), res...)
+		if dst != nil && !net.IP(res.ParseOptions()[dhcp4.OptionServerIdentifier]).Equal(dst) {
+			continue
+		}
+		if c.Drop != nil && c.Drop(res) {
+			continue
+		}
+		select {
+		case c.replies <- res:
+		default: // Overflowing replies are lost, as on a network
+		}
+	}
+	return len(b), nil
+}
+
+// ReadFrom reads the next reply, from the address of the server that sent
+// it.
+func (c *HandlerConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mu.Lock()
+	deadline := c.deadline
+	c.mu.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case p := <-c.replies:
+		addr := &net.UDPAddr{IP: net.IP(p.ParseOptions()[dhcp4.OptionServerIdentifier]), Port: 67}
+		return copy(b, p), addr, nil
+	case <-timeout:
+		return 0, nil, errTimeout
+	}
+}
+
+// SetReadDeadline sets the deadline for subsequent reads.
+func (c *HandlerConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.mu.Unlock()
+	return nil
+}
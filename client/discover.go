@@ -0,0 +1,45 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Discover broadcasts DHCPDISCOVERs until timeout has passed, returning the
+// offers received, one per server, in the order they arrived.  It never
+// requests an offer, so servers merely hold their offered addresses for a
+// while, and holds no lease state, so mustn't be called while Run is using
+// the same Conn.
+func (c *Client) Discover(ctx context.Context, timeout time.Duration) ([]*Offer, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	r, end := c.begin(ctx)
+	defer end()
+
+	r.newXId()
+	discover := r.packet(dhcp4.Discover, nil)
+	var offers []*Offer
+	servers := make(map[string]bool)
+	for n := 0; ; n++ {
+		if len(offers) == 0 { // Retransmit until answered
+			if err := r.send(discover, broadcast); err != nil {
+				return nil, err
+			}
+		}
+		res, err := r.wait(r.retransmitDelay(n), func(res reply) bool {
+			return isOffer(res) && !servers[string(res.options[dhcp4.OptionServerIdentifier])]
+		})
+		if err == context.DeadlineExceeded {
+			return offers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res != nil {
+			servers[string(res.options[dhcp4.OptionServerIdentifier])] = true
+			offers = append(offers, newOffer(res))
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestDiscover(t *testing.T) {
+	s1 := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+	s2 := dhcp4.NewServer(net.IP{192, 168, 1, 2}, net.IP{192, 168, 1, 100}, 10, time.Hour, nil, nil)
+	conn := NewHandlerConn(s1, s2)
+	var requested bool
+	conn.Drop = func(p dhcp4.Packet) bool {
+		if t := p.ParseOptions()[dhcp4.OptionDHCPMessageType]; len(t) == 1 && dhcp4.MessageType(t[0]) != dhcp4.Discover && p.OpCode() == dhcp4.BootRequest {
+			requested = true
+		}
+		return false
+	}
+	c, _ := testClient(conn)
+	offers, err := c.Discover(context.Background(), 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Discover, unexpected error: %v", err)
+	}
+	if len(offers) != 2 || requested {
+		t.Fatalf("unexpected offers: %d, requested %v", len(offers), requested)
+	}
+	for _, o := range offers {
+		if _, ok := s1.Leases().Get(o.IP); ok {
+			t.Fatalf("offer of %s leased", o.IP)
+		}
+	}
+	if c.Lease() != nil || c.State() != Init {
+		t.Fatalf("Discover changed lease state: %v %v", c.Lease(), c.State())
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// ErrNoReply is returned by Inform if no server answers.
+var ErrNoReply = errors.New("client: no reply")
+
+// Inform asks servers for the configuration of a host with address ip,
+// which it already has (say, statically configured), by sending DHCPINFORMs
+// (RFC 2131 section 3.4) until timeout has passed.  The options of every ACK
+// received are merged, those of earlier ACKs taking precedence, and decoded
+// into the returned lease of ip, whose times are all zero.  ServerID is the
+// first server to answer.  Inform holds no lease state, and mustn't be
+// called while Run is using the same Conn.
+func (c *Client) Inform(ctx context.Context, ip net.IP, timeout time.Duration) (*Lease, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	r, end := c.begin(ctx)
+	defer end()
+
+	r.newXId()
+	inform := r.packet(dhcp4.Inform, ip.To4())
+	var ack dhcp4.Packet
+	options := make(dhcp4.Options)
+	servers := make(map[string]bool)
+	for n := 0; ; n++ {
+		if ack == nil { // Retransmit until answered
+			if err := r.send(inform, broadcast); err != nil {
+				return nil, err
+			}
+		}
+		res, err := r.wait(r.retransmitDelay(n), func(res reply) bool {
+			id := res.options[dhcp4.OptionServerIdentifier]
+			return res.t == dhcp4.ACK && len(id) == 4 && !servers[string(id)]
+		})
+		if err == context.DeadlineExceeded {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if res == nil {
+			continue
+		}
+		servers[string(res.options[dhcp4.OptionServerIdentifier])] = true
+		if ack == nil {
+			ack = res.p
+		}
+		for code, v := range res.options {
+			if _, ok := options[code]; !ok {
+				options[code] = v
+			}
+		}
+	}
+	if ack == nil {
+		return nil, ErrNoReply
+	}
+	// No lease is granted, whatever the servers say
+	for _, code := range []dhcp4.OptionCode{dhcp4.OptionIPAddressLeaseTime, dhcp4.OptionRenewalTimeValue, dhcp4.OptionRebindingTimeValue} {
+		delete(options, code)
+	}
+	l := newLease(ack, options, time.Now())
+	l.IP = append(net.IP(nil), ip.To4()...)
+	return l, nil
+}
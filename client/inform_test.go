@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// informHandler answers DHCPINFORMs with options.
+type informHandler struct {
+	id      net.IP
+	options []dhcp4.Option
+}
+
+func (h informHandler) ServeDHCP(p dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	if msgType != dhcp4.Inform || net.IP(p.CIAddr()).Equal(net.IPv4zero) {
+		return nil
+	}
+	return dhcp4.ReplyPacket(p, dhcp4.ACK, h.id, nil, time.Hour, h.options)
+}
+
+func TestInform(t *testing.T) {
+	s1 := informHandler{net.IP{192, 168, 1, 1}, []dhcp4.Option{
+		{Code: dhcp4.OptionDomainNameServer, Value: []byte{192, 168, 1, 53}},
+	}}
+	s2 := informHandler{net.IP{192, 168, 1, 2}, []dhcp4.Option{
+		{Code: dhcp4.OptionDomainNameServer, Value: []byte{192, 168, 1, 54}},
+		{Code: dhcp4.OptionNetworkTimeProtocolServers, Value: []byte{192, 168, 1, 123}},
+	}}
+	ip := net.IP{192, 168, 1, 50}
+	c, _ := testClient(NewHandlerConn(s1, s2))
+	l, err := c.Inform(context.Background(), ip, 200*time.Millisecond)
+	if err != nil {
+		t.Fatalf("Inform, unexpected error: %v", err)
+	}
+	if !l.IP.Equal(ip) || !l.ServerID.Equal(s1.id) || l.Duration != 0 || l.T1 != 0 || l.T2 != 0 {
+		t.Fatalf("unexpected lease: %+v", l)
+	}
+	if len(l.DNS) != 1 || !l.DNS[0].Equal(net.IP{192, 168, 1, 53}) || len(l.NTP) != 1 || !l.NTP[0].Equal(net.IP{192, 168, 1, 123}) {
+		t.Fatalf("unexpected merged configuration: %+v", l)
+	}
+	if c.Lease() != nil || c.State() != Init {
+		t.Fatalf("Inform changed lease state: %v %v", c.Lease(), c.State())
+	}
+
+	conn := NewHandlerConn(s1)
+	conn.Drop = func(dhcp4.Packet) bool { return true }
+	c, _ = testClient(conn)
+	if _, err := c.Inform(context.Background(), ip, 100*time.Millisecond); err != ErrNoReply {
+		t.Fatalf("unexpected error: %v != %v", err, ErrNoReply)
+	}
+}
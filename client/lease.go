@@ -0,0 +1,242 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Lease is an address leased to the client, with the network configuration
+// decoded from the ACK granting it.
+type Lease struct {
+	IP       net.IP
+	Netmask  net.IPMask // Nil if the server didn't send one
+	ServerID net.IP
+	Acquired time.Time     // When the ACK was received
+	Duration time.Duration // Zero for an infinite lease
+	T1, T2   time.Duration // Renewal and rebinding times, from Acquired
+	Routers  []net.IP
+	DNS      []net.IP
+	Domain   string   // OptionDomainName
+	Search   []string // OptionDomainSearch (RFC 3397)
+	Routes   []Route  // Classless routes (RFC 3442), else a default route via Routers[0]
+	MTU      int      // Zero if not set
+	NTP      []net.IP
+	Options  dhcp4.Options // Options of the ACK
+}
+
+// Route is a route to Dst via Gateway, or on the link if Gateway is nil.
+type Route struct {
+	Dst     net.IPNet
+	Gateway net.IP
+}
+
+// String returns the route as "dst via gateway", or "dst" if on the link,
+// with "default" for 0.0.0.0/0.
+func (r Route) String() string {
+	dst := r.Dst.String()
+	if ones, _ := r.Dst.Mask.Size(); ones == 0 {
+		dst = "default"
+	}
+	if r.Gateway == nil {
+		return dst
+	}
+	return dst + " via " + r.Gateway.String()
+}
+
+// ParseLease returns the lease granted by ack, received at acquired.
+func ParseLease(ack dhcp4.Packet, acquired time.Time) *Lease {
+	return newLease(ack, ack.ParseOptions(), acquired)
+}
+
+// newLease returns the lease granted by ack, received at now.
+func newLease(ack dhcp4.Packet, options dhcp4.Options, now time.Time) *Lease {
+	l := &Lease{
+		IP:       append(net.IP(nil), ack.YIAddr()...),
+		ServerID: append(net.IP(nil), options[dhcp4.OptionServerIdentifier]...),
+		Acquired: now,
+		Options:  make(dhcp4.Options, len(options)),
+	}
+	for code, v := range options {
+		l.Options[code] = append([]byte(nil), v...)
+	}
+	seconds := func(code dhcp4.OptionCode) (time.Duration, bool) {
+		v := options[code]
+		if len(v) != 4 {
+			return 0, false
+		}
+		s := binary.BigEndian.Uint32(v)
+		if s == 0xffffffff {
+			return 0, false
+		}
+		return time.Duration(s) * time.Second, true
+	}
+	l.Duration, _ = seconds(dhcp4.OptionIPAddressLeaseTime)
+	if l.Duration > 0 { // RFC 2131 section 4.4.5 defaults
+		var ok bool
+		if l.T1, ok = seconds(dhcp4.OptionRenewalTimeValue); !ok || l.T1 > l.Duration {
+			l.T1 = l.Duration / 2
+		}
+		if l.T2, ok = seconds(dhcp4.OptionRebindingTimeValue); !ok || l.T2 > l.Duration || l.T2 < l.T1 {
+			l.T2 = l.Duration * 7 / 8
+		}
+	}
+
+	if m := options[dhcp4.OptionSubnetMask]; len(m) == 4 {
+		l.Netmask = append(net.IPMask(nil), m...)
+	}
+	l.Routers = ips(options[dhcp4.OptionRouter])
+	l.DNS = ips(options[dhcp4.OptionDomainNameServer])
+	l.NTP = ips(options[dhcp4.OptionNetworkTimeProtocolServers])
+	l.Domain = strings.TrimRight(string(options[dhcp4.OptionDomainName]), "\x00.")
+	l.Search = domains(options[dhcp4.OptionDomainSearch])
+	if m := options[dhcp4.OptionInterfaceMTU]; len(m) == 2 {
+		l.MTU = int(binary.BigEndian.Uint16(m))
+	}
+	// RFC 3442: classless routes replace the routers option
+	if v, ok := options[dhcp4.OptionClasslessRouteFormat]; ok {
+		l.Routes = classlessRoutes(v)
+	} else if len(l.Routers) > 0 {
+		l.Routes = []Route{{Dst: net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}, Gateway: l.Routers[0]}}
+	}
+	return l
+}
+
+// Expiry returns when the lease expires, or the zero time if it doesn't.
+func (l *Lease) Expiry() time.Time {
+	if l.Duration == 0 {
+		return time.Time{}
+	}
+	return l.Acquired.Add(l.Duration)
+}
+
+// Prefix returns the prefix length of the lease's subnet, or -1 if unknown.
+func (l *Lease) Prefix() int {
+	if l.Netmask == nil {
+		return -1
+	}
+	ones, bits := l.Netmask.Size()
+	if bits == 0 { // Non-canonical
+		return -1
+	}
+	return ones
+}
+
+// Net returns the leased address within its subnet.  Without a netmask, the
+// address's classful mask is assumed.
+func (l *Lease) Net() *net.IPNet {
+	m := l.Netmask
+	if m == nil {
+		m = l.IP.DefaultMask()
+	}
+	return &net.IPNet{IP: l.IP, Mask: m}
+}
+
+// ResolvConf returns the lease's DNS configuration in resolv.conf(5) format.
+func (l *Lease) ResolvConf() []byte {
+	var b bytes.Buffer
+	search := l.Search
+	if len(search) == 0 && l.Domain != "" {
+		search = []string{l.Domain}
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	for _, ip := range l.DNS {
+		fmt.Fprintf(&b, "nameserver %s\n", ip)
+	}
+	return b.Bytes()
+}
+
+// RouteList returns the lease's routes, one per line (see Route.String).
+func (l *Lease) RouteList() string {
+	var b bytes.Buffer
+	for _, r := range l.Routes {
+		b.WriteString(r.String())
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// ips decodes a list of addresses.
+func ips(b []byte) []net.IP {
+	var ips []net.IP
+	for ; len(b) >= 4; b = b[4:] {
+		ips = append(ips, append(net.IP(nil), b[:4]...))
+	}
+	return ips
+}
+
+// classlessRoutes decodes option 121 (RFC 3442), ignoring anything after
+// a malformed route.
+func classlessRoutes(b []byte) []Route {
+	var routes []Route
+	for len(b) > 0 {
+		ones := int(b[0])
+		n := (ones + 7) / 8
+		if ones > 32 || len(b) < 1+n+4 {
+			break
+		}
+		dst := make(net.IP, 4)
+		copy(dst, b[1:1+n])
+		r := Route{Dst: net.IPNet{IP: dst, Mask: net.CIDRMask(ones, 32)}}
+		r.Dst.IP = r.Dst.IP.Mask(r.Dst.Mask)
+		if gw := net.IP(b[1+n : 5+n]); !gw.Equal(net.IPv4zero) {
+			r.Gateway = append(net.IP(nil), gw...)
+		}
+		routes = append(routes, r)
+		b = b[5+n:]
+	}
+	return routes
+}
+
+// domains decodes a list of DNS names, as in option 119 (RFC 3397), which
+// may be compressed with pointers back into the list.
+func domains(b []byte) []string {
+	var names []string
+	for i := 0; i < len(b); {
+		name, next, ok := readName(b, i)
+		if !ok {
+			break
+		}
+		names = append(names, name)
+		i = next
+	}
+	return names
+}
+
+// readName reads the name at b[i:], returning it and the index following it.
+func readName(b []byte, i int) (string, int, bool) {
+	var labels []string
+	next := -1
+	for jumps := 0; i < len(b); {
+		n := int(b[i])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = i + 1
+			}
+			return strings.Join(labels, "."), next, true
+		case n&0xc0 == 0xc0:
+			if i+1 >= len(b) || jumps > len(b) {
+				return "", 0, false
+			}
+			if next < 0 {
+				next = i + 2
+			}
+			i = (n&0x3f)<<8 | int(b[i+1])
+			jumps++
+		case n&0xc0 != 0 || i+1+n > len(b):
+			return "", 0, false
+		default:
+			labels = append(labels, string(b[i+1:i+1+n]))
+			i += 1 + n
+		}
+	}
+	return "", 0, false
+}
@@ -0,0 +1,91 @@
+package client
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestParseLease(t *testing.T) {
+	req := dhcp4.RequestPacket(dhcp4.Request, net.HardwareAddr{0, 1, 2, 3, 4, 5}, nil, []byte{1, 2, 3, 4}, true, nil)
+	search := []byte("\x03eng\x05apple\x03com\x00\x09marketing\xc0\x04") // RFC 3397 example
+	ack := dhcp4.ReplyPacket(req, dhcp4.ACK, net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 20}, time.Hour, []dhcp4.Option{
+		{Code: dhcp4.OptionSubnetMask, Value: []byte{255, 255, 255, 0}},
+		{Code: dhcp4.OptionRouter, Value: []byte{192, 168, 1, 1, 192, 168, 1, 2}},
+		{Code: dhcp4.OptionDomainNameServer, Value: []byte{192, 168, 1, 53, 8, 8, 8, 8}},
+		{Code: dhcp4.OptionDomainName, Value: []byte("example.com")},
+		{Code: dhcp4.OptionDomainSearch, Value: search},
+		{Code: dhcp4.OptionInterfaceMTU, Value: []byte{0x05, 0xdc}},
+		{Code: dhcp4.OptionNetworkTimeProtocolServers, Value: []byte{192, 168, 1, 123}},
+		{Code: dhcp4.OptionRenewalTimeValue, Value: []byte{0, 0, 0x07, 0x08}},
+	})
+	now := time.Now()
+	l := ParseLease(ack, now)
+	if !l.IP.Equal(net.IP{192, 168, 1, 20}) || !l.ServerID.Equal(net.IP{192, 168, 1, 1}) || l.Prefix() != 24 || l.Net().String() != "192.168.1.20/24" {
+		t.Fatalf("unexpected address: %+v", l)
+	}
+	if l.Duration != time.Hour || l.T1 != 1800*time.Second || l.T2 != time.Hour*7/8 || !l.Expiry().Equal(now.Add(time.Hour)) {
+		t.Fatalf("unexpected times: %v %v %v", l.Duration, l.T1, l.T2)
+	}
+	if l.MTU != 1500 || len(l.NTP) != 1 || !l.NTP[0].Equal(net.IP{192, 168, 1, 123}) || len(l.Routers) != 2 || l.Domain != "example.com" {
+		t.Fatalf("unexpected options: %+v", l)
+	}
+	if want := []string{"eng.apple.com", "marketing.apple.com"}; !reflect.DeepEqual(l.Search, want) {
+		t.Fatalf("unexpected search domains: %q != %q", l.Search, want)
+	}
+	if s, want := string(l.ResolvConf()), "search eng.apple.com marketing.apple.com\nnameserver 192.168.1.53\nnameserver 8.8.8.8\n"; s != want {
+		t.Fatalf("unexpected resolv.conf: %q != %q", s, want)
+	}
+	if s, want := l.RouteList(), "default via 192.168.1.1\n"; s != want {
+		t.Fatalf("unexpected routes: %q != %q", s, want)
+	}
+}
+
+func TestClasslessRoutes(t *testing.T) {
+	for i, test := range []struct {
+		routes  []byte
+		routers []byte
+		want    string
+	}{
+		{nil, []byte{10, 0, 0, 1}, "default via 10.0.0.1\n"},
+		{[]byte{}, []byte{10, 0, 0, 1}, ""}, // Classless routes override routers
+		{[]byte{
+			8, 10, 10, 0, 0, 2, // 10.0.0.0/8 via 10.0.0.2
+			24, 192, 168, 5, 0, 0, 0, 0, // On link
+			0, 10, 0, 0, 1, // Default
+			32, 172, 16, 1, // Truncated
+		}, []byte{10, 0, 0, 9}, "10.0.0.0/8 via 10.0.0.2\n192.168.5.0/24\ndefault via 10.0.0.1\n"},
+		{[]byte{33, 1, 2, 3, 4, 5, 0, 0, 0, 0, 0}, nil, ""},
+	} {
+		options := dhcp4.Options{}
+		if test.routes != nil {
+			options[dhcp4.OptionClasslessRouteFormat] = test.routes
+		}
+		if test.routers != nil {
+			options[dhcp4.OptionRouter] = test.routers
+		}
+		l := newLease(dhcp4.NewPacket(dhcp4.BootReply), options, time.Now())
+		if s := l.RouteList(); s != test.want {
+			t.Fatalf("%02d: test %v, unexpected routes: %q != %q", i, test.routes, s, test.want)
+		}
+	}
+}
+
+func TestDomains(t *testing.T) {
+	for i, test := range []struct {
+		b    string
+		want []string
+	}{
+		{"\x07example\x03com\x00", []string{"example.com"}},
+		{"\x01a\x00\x01b\xc0\x00", []string{"a", "b.a"}},
+		{"\x01a\xc0\x00", nil},            // Pointer loop
+		{"\x01a\x00\x05b", []string{"a"}}, // Truncated
+	} {
+		if names := domains([]byte(test.b)); !reflect.DeepEqual(names, test.want) {
+			t.Fatalf("%02d: test %q, unexpected names: %q != %q", i, test.b, names, test.want)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// +build !windows
+
+package main
+
+import (
+	"io"
+	"log/syslog"
+	"os"
+	"syscall"
+)
+
+const daemonEnv = "DHCP4D_DAEMON"
+
+// daemonized returns true if the process was started by daemonize.
+func daemonized() bool { return os.Getenv(daemonEnv) == "1" }
+
+// daemonize starts the command again in a new session, detached from the
+// terminal.
+func daemonize() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	null, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer null.Close()
+	p, err := os.StartProcess(exe, os.Args, &os.ProcAttr{
+		Env:   append(os.Environ(), daemonEnv+"=1"),
+		Files: []*os.File{null, null, null},
+		Sys:   &syscall.SysProcAttr{Setsid: true},
+	})
+	if err != nil {
+		return err
+	}
+	return p.Release()
+}
+
+func syslogWriter() (io.Writer, error) {
+	return syslog.New(syslog.LOG_INFO|syslog.LOG_DAEMON, "dhcp4d")
+}
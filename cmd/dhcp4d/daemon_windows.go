@@ -0,0 +1,16 @@
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+var errNoDaemon = errors.New("dhcp4d: -d isn't supported on windows, run as a service instead")
+
+func daemonized() bool { return false }
+
+func daemonize() error { return errNoDaemon }
+
+func syslogWriter() (io.Writer, error) { return nil, errNoDaemon }
@@ -0,0 +1,169 @@
+// Command dhcp4d is a DHCP server configured by file (see package config).
+//
+//	dhcp4d -config /etc/dhcp4d.conf -i eth0,eth1 -http 127.0.0.1:8067
+//
+// SIGHUP reloads the configuration; SIGINT and SIGTERM shut the server
+// down.  With -http, lease events and pool utilisation are exported at
+// /metrics in the Prometheus text format, and the admin API (see package
+// admin) is served at /admin/, requiring -admin-token if set.
+//
+// -check loads the configuration and exits, reporting any error.  -d runs
+// the server in the background, logging to syslog unless -log is given.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/krolaw/dhcp4/admin"
+	"github.com/krolaw/dhcp4/config"
+	"github.com/krolaw/dhcp4/conn"
+)
+
+var (
+	configFile = flag.String("config", "/etc/dhcp4d.conf", "configuration `file`")
+	interfaces = flag.String("i", "", "comma separated `interfaces` to serve, overriding the configuration")
+	check      = flag.Bool("check", false, "check the configuration and exit")
+	daemon     = flag.Bool("d", false, "run in the background")
+	pidFile    = flag.String("pidfile", "", "write the process id to `file`")
+	logFile    = flag.String("log", "", "log to `file` rather than stderr (or syslog with -d)")
+	verbose    = flag.Bool("v", false, "log every lease event")
+	httpAddr   = flag.String("http", "", "serve metrics and the admin API at `address`")
+	adminToken = flag.String("admin-token", "", "bearer `token` required by the admin API")
+	probe      = flag.Duration("probe", 0, "ping addresses before offering them, waiting `timeout` for replies")
+	quarantine = flag.Duration("quarantine", dhcp4.DefaultQuarantine, "how long to hold back addresses found in use or declined")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	c, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *check {
+		fmt.Printf("%s: OK\n", c.File)
+		return
+	}
+	if *daemon && !daemonized() {
+		if err := daemonize(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if err := setupLog(*logFile, *daemon); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := run(c); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(c *config.Config) error {
+	if *interfaces != "" {
+		c.Interfaces = strings.Split(*interfaces, ",")
+	}
+	if *pidFile != "" {
+		if err := ioutil.WriteFile(*pidFile, []byte(fmt.Sprintln(os.Getpid())), 0644); err != nil {
+			return err
+		}
+		defer os.Remove(*pidFile)
+	}
+
+	s, err := c.NewServer()
+	if err != nil {
+		return err
+	}
+	if j, ok := s.Leases().LeaseStore.(*dhcp4.JournalLeaseStore); ok {
+		defer j.Close()
+	}
+	defer s.Leases().Stop()
+	s.Quarantine = *quarantine
+	if *probe > 0 {
+		s.Prober = conn.NewICMPProber(*probe)
+	}
+
+	metrics := newMetrics(s)
+	s.Leases().Subscribe(func(e dhcp4.LeaseEvent) {
+		metrics.leaseEvent(e)
+		if *verbose {
+			log.Printf("%v %s %s %q", e.Type, e.Lease.IP, e.Lease.HardwareAddr, e.Lease.Hostname)
+		}
+	})
+
+	reloader := config.NewReloader(c, s)
+	reloader.OnReload = func(c *config.Config, invalid []dhcp4.Lease, err error) {
+		if err != nil {
+			log.Printf("reload failed, configuration unchanged: %v", err)
+			return
+		}
+		log.Printf("reloaded %s, %d lease(s) no longer valid", c.File, len(invalid))
+	}
+	defer reloader.Notify(syscall.SIGHUP)()
+
+	if *httpAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		api := admin.New(s)
+		if *adminToken != "" {
+			api.Auth = admin.BearerToken(*adminToken)
+		}
+		api.Mount(mux, "/admin")
+		go func() { log.Fatal(http.ListenAndServe(*httpAddr, mux)) }()
+	}
+
+	done := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		log.Printf("received %v, shutting down", <-sigs)
+		close(done)
+	}()
+
+	if len(c.Interfaces) == 0 {
+		log.Printf("serving on all interfaces")
+	} else {
+		log.Printf("serving on %s", strings.Join(c.Interfaces, ", "))
+	}
+	return c.Serve(s, metrics.handler, done)
+}
+
+// setupLog directs logging to file if set, else syslog when running as a
+// daemon.
+func setupLog(file string, daemon bool) error {
+	switch {
+	case file != "":
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			return err
+		}
+		log.SetOutput(f)
+	case daemon:
+		w, err := syslogWriter()
+		if err != nil {
+			return err
+		}
+		log.SetOutput(w)
+		log.SetFlags(0)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/krolaw/dhcp4/admin"
+)
+
+// metrics counts packets and lease events, serving them with pool
+// utilisation in the Prometheus text format.
+type metrics struct {
+	s *dhcp4.Server
+
+	mu       sync.Mutex
+	requests map[string]uint64 // By message type
+	replies  map[string]uint64 // By message type
+	events   map[string]uint64 // By lease event type
+}
+
+func newMetrics(s *dhcp4.Server) *metrics {
+	return &metrics{
+		s:        s,
+		requests: make(map[string]uint64),
+		replies:  make(map[string]uint64),
+		events:   make(map[string]uint64),
+	}
+}
+
+func (m *metrics) leaseEvent(e dhcp4.LeaseEvent) {
+	m.mu.Lock()
+	m.events[e.Type.String()]++
+	m.mu.Unlock()
+}
+
+// handler wraps h, counting its requests and replies.
+func (m *metrics) handler(h dhcp4.Handler) dhcp4.Handler {
+	return &countingHandler{h, m}
+}
+
+type countingHandler struct {
+	dhcp4.Handler
+	m *metrics
+}
+
+func (h *countingHandler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	res := h.Handler.ServeDHCP(req, msgType, options)
+	h.m.mu.Lock()
+	defer h.m.mu.Unlock()
+	h.m.requests[msgType.String()]++
+	if res != nil {
+		if t := res.ParseOptions()[dhcp4.OptionDHCPMessageType]; len(t) == 1 {
+			h.m.replies[dhcp4.MessageType(t[0]).String()]++
+		}
+	}
+	return res
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.mu.Lock()
+	writeCounters(w, "dhcp4_requests_total", "DHCP requests received, by message type.", m.requests)
+	writeCounters(w, "dhcp4_replies_total", "DHCP replies sent, by message type.", m.replies)
+	writeCounters(w, "dhcp4_lease_events_total", "Lease events, by type.", m.events)
+	m.mu.Unlock()
+
+	pools := admin.Pools(m.s)
+	for _, g := range []struct {
+		name, help string
+		value      func(admin.Pool) int
+	}{
+		{"dhcp4_pool_size", "Addresses in the pool.", func(p admin.Pool) int { return p.Size }},
+		{"dhcp4_pool_leased", "Addresses leased from the pool.", func(p admin.Pool) int { return p.Leased }},
+		{"dhcp4_pool_quarantined", "Pool addresses held back after being found in use.", func(p admin.Pool) int { return p.Quarantined }},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", g.name, g.help, g.name)
+		for _, p := range pools {
+			fmt.Fprintf(w, "%s{subnet=%q,start=%q} %d\n", g.name, p.Subnet, p.Start, g.value(p))
+		}
+	}
+}
+
+// writeCounters writes counters, labelled by type in order.
+func writeCounters(w http.ResponseWriter, name, help string, counters map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	types := make([]string, 0, len(counters))
+	for t := range counters {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	for _, t := range types {
+		fmt.Fprintf(w, "%s{type=%q} %d\n", name, t, counters[t])
+	}
+}
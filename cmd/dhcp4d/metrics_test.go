@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestMetrics(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 4, time.Hour, nil, nil)
+	m := newMetrics(s)
+	s.Leases().Subscribe(m.leaseEvent)
+	h := m.handler(s)
+
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	req := dhcp4.RequestPacket(dhcp4.Discover, mac, nil, []byte{1, 2, 3, 4}, true, nil)
+	offer := h.ServeDHCP(req, dhcp4.Discover, req.ParseOptions())
+	req = dhcp4.RequestPacket(dhcp4.Request, mac, nil, []byte{1, 2, 3, 4}, true, []dhcp4.Option{{Code: dhcp4.OptionRequestedIPAddress, Value: offer.YIAddr()}})
+	h.ServeDHCP(req, dhcp4.Request, req.ParseOptions())
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`dhcp4_requests_total{type="Discover"} 1`,
+		`dhcp4_requests_total{type="Request"} 1`,
+		`dhcp4_replies_total{type="Offer"} 1`,
+		`dhcp4_replies_total{type="ACK"} 1`,
+		`dhcp4_lease_events_total{type="LeaseBound"} 1`,
+		`dhcp4_pool_size{subnet="0.0.0.0/0",start="192.168.1.10"} 4`,
+		`dhcp4_pool_leased{subnet="0.0.0.0/0",start="192.168.1.10"} 1`,
+	} {
+		if !strings.Contains(w.Body.String(), want) {
+			t.Fatalf("metrics missing %q:\n%s", want, w.Body)
+		}
+	}
+}
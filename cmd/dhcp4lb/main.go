@@ -0,0 +1,188 @@
+// Command dhcp4lb is a load balancing relay tier (see package balance),
+// forwarding requests from relay agents to pools of servers.
+//
+//	dhcp4lb -config /etc/dhcp4lb.json
+//
+// The configuration file is JSON, such as:
+//
+//	{
+//		"listen": ":67",
+//		"throttle": 100,
+//		"pools": [
+//			{"name": "stable", "servers": ["10.0.0.1", "10.0.0.2"]},
+//			{"name": "rc", "servers": ["10.0.1.1"], "macs_file": "/etc/dhcp4lb.rc"}
+//		]
+//	}
+//
+// Clients go to the first pool, unless their hardware address is listed by
+// another, in "macs" or a file of one address per line.  SIGHUP reloads the
+// pools, MAC lists and throttle (though not the listening address).
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/krolaw/dhcp4/balance"
+)
+
+var configFile = flag.String("config", "/etc/dhcp4lb.json", "configuration `file`")
+
+// config is the configuration file.
+type config struct {
+	Listen       string  `json:"listen"`
+	Throttle     float64 `json:"throttle"`      // Requests per second from each relay agent
+	MaxHops      int     `json:"max_hops"`      // See balance.Balancer
+	Unanswered   int     `json:"unanswered"`    // See balance.Balancer
+	ReplyTimeout string  `json:"reply_timeout"` // Duration, such as "5s"
+	RetryAfter   string  `json:"retry_after"`
+	Pools        []struct {
+		Name     string   `json:"name"`
+		Servers  []string `json:"servers"`
+		MACs     []string `json:"macs"`
+		MACsFile string   `json:"macs_file"`
+	} `json:"pools"`
+
+	pools                    []*balance.Pool
+	replyTimeout, retryAfter time.Duration
+}
+
+func load(path string) (*config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	c := &config{Listen: ":67"}
+	if err := json.NewDecoder(f).Decode(c); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(c.Pools) == 0 {
+		return nil, fmt.Errorf("%s: no pools", path)
+	}
+	for _, d := range []struct {
+		s string
+		d *time.Duration
+	}{{c.ReplyTimeout, &c.replyTimeout}, {c.RetryAfter, &c.retryAfter}} {
+		if d.s != "" {
+			if *d.d, err = time.ParseDuration(d.s); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+		}
+	}
+	for _, p := range c.Pools {
+		pool := &balance.Pool{Name: p.Name}
+		for _, s := range p.Servers {
+			if _, _, err := net.SplitHostPort(s); err != nil {
+				s = net.JoinHostPort(s, "67")
+			}
+			addr, err := net.ResolveUDPAddr("udp4", s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: pool %s: %v", path, p.Name, err)
+			}
+			pool.Servers = append(pool.Servers, addr)
+		}
+		macs := p.MACs
+		if p.MACsFile != "" {
+			m, err := readLines(p.MACsFile)
+			if err != nil {
+				return nil, err
+			}
+			macs = append(macs, m...)
+		}
+		for _, s := range macs {
+			mac, err := net.ParseMAC(s)
+			if err != nil {
+				return nil, fmt.Errorf("%s: pool %s: %v", path, p.Name, err)
+			}
+			pool.MACs = append(pool.MACs, mac)
+		}
+		c.pools = append(c.pools, pool)
+	}
+	if len(c.pools[0].Servers) == 0 {
+		return nil, errors.New(path + ": no servers in default pool")
+	}
+	return c, nil
+}
+
+// readLines returns the lines of a file, ignoring blanks and # comments.
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if l := strings.TrimSpace(s.Text()); l != "" && !strings.HasPrefix(l, "#") {
+			lines = append(lines, l)
+		}
+	}
+	return lines, s.Err()
+}
+
+// configure applies c's pools and throttle to b.
+func configure(b *balance.Balancer, c *config) {
+	b.SetPools(c.pools...)
+	b.SetThrottle(c.Throttle)
+	for _, p := range c.pools {
+		log.Printf("pool %s: %d servers, %d clients listed", p.Name, len(p.Servers), len(p.MACs))
+	}
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	c, err := load(*configFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	b := balance.New()
+	b.MaxHops, b.Unanswered = c.MaxHops, c.Unanswered
+	b.ReplyTimeout, b.RetryAfter = c.replyTimeout, c.retryAfter
+	b.OnHealth = func(s *net.UDPAddr, up bool) {
+		if up {
+			log.Printf("server %s: retrying", s)
+		} else {
+			log.Printf("server %s: not answering", s)
+		}
+	}
+	configure(b, c)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			c, err := load(*configFile)
+			if err != nil {
+				log.Printf("reload: %v", err)
+				continue
+			}
+			configure(b, c)
+		}
+	}()
+
+	conn, err := net.ListenPacket("udp4", c.Listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(b.Serve(conn))
+}
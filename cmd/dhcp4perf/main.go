@@ -0,0 +1,105 @@
+// Command dhcp4perf measures the performance of a DHCP server, by
+// simulating many clients acquiring and renewing leases (see package
+// loadgen).
+//
+//	dhcp4perf -server 10.0.0.1 -clients 10000 -renewals 1 -rate 500
+//
+// Requests are relayed, with giaddr set to -giaddr, or the local address
+// used to reach the server by default.  The server must lease addresses on
+// that subnet.  Replies to relayed requests are sent to port 67, so -local
+// usually needs to be bound there; servers replying to the requests' source
+// address, such as those of package dhcp4, work with any port.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/krolaw/dhcp4/loadgen"
+)
+
+var (
+	server      = flag.String("server", "", "server `address`, port 67 if not given")
+	local       = flag.String("local", ":67", "local `address` to send from and receive replies at")
+	giaddr      = flag.String("giaddr", "", "relay agent `address` sent, by default the local address reaching the server")
+	clients     = flag.Int("clients", 1000, "number of clients to simulate")
+	renewals    = flag.Int("renewals", 1, "renewals by each client once bound")
+	rate        = flag.Float64("rate", 0, "target exchanges per second, or 0 for no limit")
+	concurrency = flag.Int("concurrency", loadgen.DefaultConcurrency, "maximum clients exchanging at once")
+	timeout     = flag.Duration("timeout", loadgen.DefaultTimeout, "how long to wait for each reply")
+	jsonOut     = flag.Bool("json", false, "print the report as JSON")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s -server address [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *server == "" || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	r, err := run()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *jsonOut {
+		b, _ := json.MarshalIndent(r, "", "  ")
+		fmt.Printf("%s\n", b)
+	} else {
+		fmt.Print(r)
+	}
+}
+
+func run() (*loadgen.Report, error) {
+	addr := *server
+	if !strings.Contains(addr, ":") {
+		addr = net.JoinHostPort(addr, "67")
+	}
+	c := loadgen.Config{Clients: *clients, Renewals: *renewals, Rate: *rate,
+		Concurrency: *concurrency, Timeout: *timeout}
+	if *giaddr != "" {
+		if c.GIAddr = net.ParseIP(*giaddr).To4(); c.GIAddr == nil {
+			return nil, fmt.Errorf("bad giaddr %q", *giaddr)
+		}
+	} else {
+		ip, err := localIP(addr)
+		if err != nil {
+			return nil, err
+		}
+		c.GIAddr = ip
+	}
+
+	t, err := loadgen.ListenUDP(*local, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer t.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt)
+	go func() {
+		<-sig
+		cancel() // Report what's been done so far
+	}()
+	return loadgen.Run(ctx, c, t)
+}
+
+// localIP returns the local address used to reach addr.
+func localIP(addr string) (net.IP, error) {
+	conn, err := net.Dial("udp4", addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP.To4(), nil
+}
@@ -0,0 +1,9 @@
+// +build linux
+
+package main
+
+import "github.com/krolaw/dhcp4/conn"
+
+// openConn returns a conn for interface ifname, which needn't have an
+// address.
+func openConn(ifname string) (clientConn, error) { return conn.NewClientConn(ifname) }
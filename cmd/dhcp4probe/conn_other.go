@@ -0,0 +1,9 @@
+// +build !linux
+
+package main
+
+import "errors"
+
+func openConn(ifname string) (clientConn, error) {
+	return nil, errors.New("dhcp4probe: only supported on linux")
+}
@@ -0,0 +1,160 @@
+// Command dhcp4probe lists the DHCP servers on a link, by broadcasting a
+// DHCPDISCOVER and printing every offer received.
+//
+//	dhcp4probe -i eth0 -timeout 5s -json
+//
+// It never sends a DHCPREQUEST, so nothing is leased and it is safe to run on
+// production networks, though servers hold the addresses they offer for a
+// short while.  -mac sets the client hardware address (chaddr) sent, to see
+// what another client would be offered, and -vendor and -prl the vendor
+// class (option 60) and parameter request list (option 55).  It exits with
+// status 1 if no server answers.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/krolaw/dhcp4/client"
+)
+
+var (
+	iface   = flag.String("i", "", "`interface` to probe")
+	mac     = flag.String("mac", "", "client hardware `address`, rather than the interface's")
+	vendor  = flag.String("vendor", "", "vendor class `identifier` (option 60)")
+	prl     = flag.String("prl", "", "comma separated option `codes` to request, rather than the defaults")
+	timeout = flag.Duration("timeout", 5*time.Second, "how long to wait for offers")
+	jsonOut = flag.Bool("json", false, "print offers as JSON")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s -i interface [flags]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *iface == "" || flag.NArg() > 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	offers, err := probe()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *jsonOut {
+		b, _ := json.MarshalIndent(offers, "", "  ")
+		fmt.Printf("%s\n", b)
+	} else {
+		for _, o := range offers {
+			o.print()
+		}
+	}
+	if len(offers) == 0 {
+		fmt.Fprintln(os.Stderr, "no offers")
+		os.Exit(1)
+	}
+}
+
+// clientConn is a client.Conn that can be closed.
+type clientConn interface {
+	client.Conn
+	io.Closer
+}
+
+// probe sends the DISCOVER described by the flags.
+func probe() ([]*offer, error) {
+	ifi, err := net.InterfaceByName(*iface)
+	if err != nil {
+		return nil, err
+	}
+	chaddr := ifi.HardwareAddr
+	if *mac != "" {
+		if chaddr, err = net.ParseMAC(*mac); err != nil {
+			return nil, err
+		}
+	}
+	conn, err := openConn(*iface)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	c := client.New(chaddr, conn)
+	if *vendor != "" {
+		c.Options = append(c.Options, dhcp4.Option{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte(*vendor)})
+	}
+	if *prl != "" {
+		if c.Params, err = parsePRL(*prl); err != nil {
+			return nil, err
+		}
+	}
+	offers, err := c.Discover(context.Background(), *timeout)
+	if err != nil {
+		return nil, err
+	}
+	views := make([]*offer, len(offers))
+	for i, o := range offers {
+		views[i] = newOffer(o)
+	}
+	return views, nil
+}
+
+// parsePRL parses a comma separated list of option codes.
+func parsePRL(s string) ([]byte, error) {
+	var prl []byte
+	for _, f := range strings.Split(s, ",") {
+		n, err := strconv.ParseUint(strings.TrimSpace(f), 10, 8)
+		if err != nil || n == 0 || n == 255 {
+			return nil, fmt.Errorf("bad option code %q", f)
+		}
+		prl = append(prl, byte(n))
+	}
+	return prl, nil
+}
+
+// offer is an Offer as printed.
+type offer struct {
+	Server     string   `json:"server"`
+	Address    string   `json:"address"`
+	NextServer string   `json:"next_server,omitempty"` // siaddr
+	BootFile   string   `json:"boot_file,omitempty"`
+	Options    []option `json:"options"`
+}
+
+type option struct {
+	Code  dhcp4.OptionCode `json:"code"`
+	Name  string           `json:"name"`
+	Value string           `json:"value"`
+}
+
+func newOffer(o *client.Offer) *offer {
+	v := &offer{Server: o.ServerID.String(), Address: o.IP.String(), Options: decodeOptions(o.Packet)}
+	if siaddr := net.IP(o.Packet.SIAddr()); !siaddr.Equal(net.IPv4zero) {
+		v.NextServer = siaddr.String()
+	}
+	v.BootFile = string(o.Packet.File())
+	return v
+}
+
+func (o *offer) print() {
+	fmt.Printf("%s offered %s\n", o.Server, o.Address)
+	if o.NextServer != "" {
+		fmt.Printf("  next server: %s\n", o.NextServer)
+	}
+	if o.BootFile != "" {
+		fmt.Printf("  boot file: %s\n", o.BootFile)
+	}
+	for _, opt := range o.Options {
+		fmt.Printf("  %3d %s: %s\n", opt.Code, opt.Name, opt.Value)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/krolaw/dhcp4/client"
+)
+
+// How option values are decoded.
+type optionType byte
+
+const (
+	typeBytes       optionType = iota // Hex
+	typeIPs                           // One or more addresses
+	typeString                        // Text
+	typeUint8                         // Number
+	typeUint16                        // Number
+	typeSeconds                       // Duration, as uint32 seconds
+	typeInt32                         // Number
+	typeBool                          // true or false
+	typeMessageType                   // DHCP message type
+	typeDomains                       // Option 119
+	typeRoutes                        // Option 121
+)
+
+var optionTypes = map[dhcp4.OptionCode]optionType{
+	dhcp4.OptionSubnetMask:                                 typeIPs,
+	dhcp4.OptionTimeOffset:                                 typeInt32,
+	dhcp4.OptionRouter:                                     typeIPs,
+	dhcp4.OptionTimeServer:                                 typeIPs,
+	dhcp4.OptionNameServer:                                 typeIPs,
+	dhcp4.OptionDomainNameServer:                           typeIPs,
+	dhcp4.OptionLogServer:                                  typeIPs,
+	dhcp4.OptionLPRServer:                                  typeIPs,
+	dhcp4.OptionHostName:                                   typeString,
+	dhcp4.OptionBootFileSize:                               typeUint16,
+	dhcp4.OptionDomainName:                                 typeString,
+	dhcp4.OptionRootPath:                                   typeString,
+	dhcp4.OptionIPForwardingEnableDisable:                  typeBool,
+	dhcp4.OptionDefaultIPTimeToLive:                        typeUint8,
+	dhcp4.OptionInterfaceMTU:                               typeUint16,
+	dhcp4.OptionBroadcastAddress:                           typeIPs,
+	dhcp4.OptionStaticRoute:                                typeIPs,
+	dhcp4.OptionARPCacheTimeout:                            typeSeconds,
+	dhcp4.OptionTCPDefaultTTL:                              typeUint8,
+	dhcp4.OptionNetworkInformationServiceDomain:            typeString,
+	dhcp4.OptionNetworkInformationServers:                  typeIPs,
+	dhcp4.OptionNetworkTimeProtocolServers:                 typeIPs,
+	dhcp4.OptionNetBIOSOverTCPIPNameServer:                 typeIPs,
+	dhcp4.OptionNetBIOSOverTCPIPDatagramDistributionServer: typeIPs,
+	dhcp4.OptionNetBIOSOverTCPIPNodeType:                   typeUint8,
+	dhcp4.OptionIPAddressLeaseTime:                         typeSeconds,
+	dhcp4.OptionDHCPMessageType:                            typeMessageType,
+	dhcp4.OptionServerIdentifier:                           typeIPs,
+	dhcp4.OptionMessage:                                    typeString,
+	dhcp4.OptionMaximumDHCPMessageSize:                     typeUint16,
+	dhcp4.OptionRenewalTimeValue:                           typeSeconds,
+	dhcp4.OptionRebindingTimeValue:                         typeSeconds,
+	dhcp4.OptionVendorClassIdentifier:                      typeString,
+	dhcp4.OptionTFTPServerName:                             typeString,
+	dhcp4.OptionBootFileName:                               typeString,
+	dhcp4.OptionTZPOSIXString:                              typeString,
+	dhcp4.OptionTZDatabaseString:                           typeString,
+	dhcp4.OptionDomainSearch:                               typeDomains,
+	dhcp4.OptionClasslessRouteFormat:                       typeRoutes,
+	dhcp4.OptionPxelinuxConfigfile:                         typeString,
+	dhcp4.OptionPxelinuxPathprefix:                         typeString,
+	dhcp4.OptionPxelinuxReboottime:                         typeSeconds,
+}
+
+// decodeOptions returns the options of p, in code order.
+func decodeOptions(p dhcp4.Packet) []option {
+	options := p.ParseOptions()
+	codes := make([]int, 0, len(options))
+	for code := range options {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	l := client.ParseLease(p, time.Now()) // For the types it decodes
+	opts := make([]option, len(codes))
+	for i, c := range codes {
+		code := dhcp4.OptionCode(c)
+		name := strings.TrimPrefix(code.String(), "Option")
+		if strings.HasPrefix(name, "Code(") {
+			name = ""
+		}
+		opts[i] = option{Code: code, Name: name, Value: formatOption(code, options[code], l)}
+	}
+	return opts
+}
+
+// formatOption returns option code's value v as text.  l is the lease
+// decoded from the packet holding it.
+func formatOption(code dhcp4.OptionCode, v []byte, l *client.Lease) string {
+	hex := fmt.Sprintf("0x%x", v)
+	switch t := optionTypes[code]; t {
+	case typeIPs:
+		if len(v) == 0 || len(v)%4 != 0 {
+			return hex
+		}
+		var ips []string
+		for ; len(v) > 0; v = v[4:] {
+			ips = append(ips, net.IP(v[:4]).String())
+		}
+		return strings.Join(ips, ", ")
+	case typeString:
+		return strconv.Quote(strings.TrimRight(string(v), "\x00"))
+	case typeUint8, typeBool, typeMessageType:
+		if len(v) != 1 {
+			return hex
+		}
+		switch t {
+		case typeBool:
+			return strconv.FormatBool(v[0] != 0)
+		case typeMessageType:
+			return dhcp4.MessageType(v[0]).String()
+		}
+		return strconv.Itoa(int(v[0]))
+	case typeUint16:
+		if len(v) != 2 {
+			return hex
+		}
+		return strconv.Itoa(int(binary.BigEndian.Uint16(v)))
+	case typeSeconds:
+		if len(v) != 4 {
+			return hex
+		}
+		s := binary.BigEndian.Uint32(v)
+		if s == 0xffffffff {
+			return "infinite"
+		}
+		return (time.Duration(s) * time.Second).String()
+	case typeInt32:
+		if len(v) != 4 {
+			return hex
+		}
+		return strconv.Itoa(int(int32(binary.BigEndian.Uint32(v))))
+	case typeDomains:
+		return strings.Join(l.Search, ", ")
+	case typeRoutes:
+		routes := make([]string, len(l.Routes))
+		for i, r := range l.Routes {
+			routes[i] = r.String()
+		}
+		return strings.Join(routes, ", ")
+	}
+	return hex
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestDecodeOptions(t *testing.T) {
+	req := dhcp4.RequestPacket(dhcp4.Discover, net.HardwareAddr{0, 1, 2, 3, 4, 5}, nil, []byte{1, 2, 3, 4}, true, nil)
+	offer := dhcp4.ReplyPacket(req, dhcp4.Offer, net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, time.Hour, []dhcp4.Option{
+		{Code: dhcp4.OptionSubnetMask, Value: []byte{255, 255, 255, 0}},
+		{Code: dhcp4.OptionDomainNameServer, Value: []byte{192, 168, 1, 53, 8, 8, 8, 8}},
+		{Code: dhcp4.OptionDomainName, Value: []byte("example.com")},
+		{Code: dhcp4.OptionInterfaceMTU, Value: []byte{0x05, 0xdc}},
+		{Code: dhcp4.OptionDomainSearch, Value: []byte("\x03eng\x05apple\x03com\x00\x09marketing\xc0\x04")},
+		{Code: dhcp4.OptionClasslessRouteFormat, Value: []byte{8, 10, 192, 168, 1, 2, 0, 192, 168, 1, 1}},
+		{Code: dhcp4.OptionRouter, Value: []byte{192, 168, 1}}, // Malformed
+		{Code: 224, Value: []byte{0xca, 0xfe}},
+	})
+	opts := decodeOptions(offer)
+	for i, want := range []option{
+		{dhcp4.OptionSubnetMask, "SubnetMask", "255.255.255.0"},
+		{dhcp4.OptionRouter, "Router", "0xc0a801"},
+		{dhcp4.OptionDomainNameServer, "DomainNameServer", "192.168.1.53, 8.8.8.8"},
+		{dhcp4.OptionDomainName, "DomainName", `"example.com"`},
+		{dhcp4.OptionInterfaceMTU, "InterfaceMTU", "1500"},
+		{dhcp4.OptionIPAddressLeaseTime, "IPAddressLeaseTime", "1h0m0s"},
+		{dhcp4.OptionDHCPMessageType, "DHCPMessageType", "Offer"},
+		{dhcp4.OptionServerIdentifier, "ServerIdentifier", "192.168.1.1"},
+		{dhcp4.OptionDomainSearch, "DomainSearch", "eng.apple.com, marketing.apple.com"},
+		{dhcp4.OptionClasslessRouteFormat, "ClasslessRouteFormat", "10.0.0.0/8 via 192.168.1.2, default via 192.168.1.1"},
+		{224, "", "0xcafe"},
+	} {
+		if i >= len(opts) || opts[i] != want {
+			t.Fatalf("%02d: unexpected options: %v, want %v", i, opts, want)
+		}
+	}
+}
+
+func TestParsePRL(t *testing.T) {
+	for i, test := range []struct {
+		s    string
+		want string
+		ok   bool
+	}{
+		{"1,3, 6", "\x01\x03\x06", true},
+		{"1,x", "", false},
+		{"256", "", false},
+		{"0", "", false},
+	} {
+		prl, err := parsePRL(test.s)
+		if (err == nil) != test.ok || string(prl) != test.want {
+			t.Fatalf("%02d: test %q, unexpected result: %v %v", i, test.s, prl, err)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Command dhcp4relay is a DHCP relay agent (see package relay), passing
+// requests from clients on the given interfaces to servers, and their
+// replies back.
+//
+//	dhcp4relay -i eth1,eth2 10.0.0.1 10.0.0.2
+//
+// Requests are sent to every server given, port 67 unless another is given.
+// Option 82 identifies the interface a request came from by name (circuit
+// id) and hardware address (remote id), unless -no-agent-info is set.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/krolaw/dhcp4/relay"
+)
+
+var (
+	interfaces  = flag.String("i", "", "comma separated client facing `interfaces`")
+	listen      = flag.String("listen", ":67", "`address` to listen on")
+	maxHops     = flag.Int("max-hops", relay.DefaultMaxHops, "drop requests that have passed through this many relays")
+	noAgentInfo = flag.Bool("no-agent-info", false, "don't add relay agent information (option 82)")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s -i interfaces [flags] server...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *interfaces == "" || flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	var servers []*net.UDPAddr
+	for _, s := range flag.Args() {
+		if _, _, err := net.SplitHostPort(s); err != nil {
+			s = net.JoinHostPort(s, "67")
+		}
+		addr, err := net.ResolveUDPAddr("udp4", s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		servers = append(servers, addr)
+	}
+	r := relay.New(servers)
+	r.MaxHops, r.NoAgentInfo = *maxHops, *noAgentInfo
+	for _, name := range strings.Split(*interfaces, ",") {
+		i, err := relay.NewInterface(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		r.Interfaces = append(r.Interfaces, i)
+		log.Printf("relaying from %s (%s)", i.Name, i.Addr)
+	}
+
+	conn, err := relay.ListenUDP(*listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Fatal(r.Serve(conn))
+}
@@ -0,0 +1,550 @@
+// Package config loads dhcp4 server configuration from a file, so that a
+// server can be deployed without writing Go.
+//
+// The format is a sequence of statements, each ended by a semicolon or a
+// block of statements in braces.  Words are separated by spaces or commas,
+// may be quoted with double quotes, and # starts a comment:
+//
+//	server-id 192.168.1.1;
+//	interface eth0;
+//	lease-time 12h;
+//	lease-file /var/lib/dhcp4/leases.journal;
+//	option domain-name-server 8.8.8.8, 8.8.4.4;
+//
+//	class pxe {
+//		match "substring(option[60].text, 0, 9) == 'PXEClient'";
+//		option bootfile-name "pxelinux.0";
+//		force-send bootfile-name;
+//	}
+//
+//	shared-network floor1 {
+//		subnet 10.0.1.0/24 {
+//			option router 10.0.1.1;
+//			pool 10.0.1.100 10.0.1.199;
+//		}
+//		subnet 10.0.2.0/24 {
+//			option router 10.0.2.1;
+//			pool 10.0.2.100 10.0.2.199 {
+//				allow-class pxe;
+//			}
+//		}
+//	}
+//
+//	subnet 192.168.1.0/24 {
+//		option router 192.168.1.1;
+//		option subnet-mask 255.255.255.0;
+//		pool 192.168.1.10 192.168.1.200;
+//	}
+//
+//	host printer {
+//		hardware-address 00:11:22:33:44:55;
+//		fixed-address 192.168.1.5;
+//		hostname "printer";
+//	}
+//
+// Options are named after their dhcp4.OptionCode constants without the
+// Option prefix, ignoring case and dashes, or given by number.  An
+// ip-address-lease-time option sets the lease time of the addresses its scope
+// applies to.  Class match expressions are described in package classify.
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+	"github.com/krolaw/dhcp4/classify"
+	"github.com/krolaw/dhcp4/conn"
+)
+
+// DefaultLeaseTime is the lease time when none is configured.
+const DefaultLeaseTime = 12 * time.Hour
+
+// Config is a loaded configuration.
+type Config struct {
+	File       string
+	Interfaces []string // Interfaces to serve, all if empty
+	LeaseFile  string   // Lease journal, or empty to keep leases in memory
+	Server     *dhcp4.ServerConfig
+}
+
+// Load reads the configuration file path.
+func Load(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, path)
+}
+
+// Parse reads a configuration from r, which is named file in errors.
+func Parse(r io.Reader, file string) (*Config, error) {
+	stmts, err := parse(r, file)
+	if err != nil {
+		return nil, err
+	}
+	l := &loader{
+		file:   file,
+		config: &Config{File: file, Server: &dhcp4.ServerConfig{LeaseTime: DefaultLeaseTime}},
+		hosts:  make(map[string]bool),
+	}
+	if err := l.load(stmts); err != nil {
+		return nil, err
+	}
+	return l.config, nil
+}
+
+type loader struct {
+	file     string
+	config   *Config
+	networks []*dhcp4.SharedNetwork
+	subnets  []*dhcp4.Subnet // Not in a shared network
+	all      []*dhcp4.Subnet
+	classes  []*classify.Class
+	hosts    map[string]bool // Reserved addresses and clients
+}
+
+func (l *loader) errorf(line int, format string, args ...interface{}) error {
+	return &Error{File: l.file, Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// expect checks s has n arguments, and a block if block is true.
+func (l *loader) expect(s *statement, n int, block bool) error {
+	if len(s.args()) != n {
+		return l.errorf(s.line, "%s expects %d argument(s)", s.keyword(), n)
+	}
+	if block != (s.block != nil) {
+		if block {
+			return l.errorf(s.line, "%s expects a block", s.keyword())
+		}
+		return l.errorf(s.line, "unexpected block after %s", s.keyword())
+	}
+	return nil
+}
+
+func (l *loader) load(stmts []*statement) error {
+	c := l.config
+	for _, s := range stmts {
+		var err error
+		switch s.keyword() {
+		case "server-id":
+			if err = l.expect(s, 1, false); err == nil {
+				c.Server.ServerID, err = l.ip(s.args()[0])
+			}
+		case "interface":
+			if len(s.args()) == 0 || s.block != nil {
+				return l.errorf(s.line, "interface expects interface names")
+			}
+			c.Interfaces = append(c.Interfaces, texts(s.args())...)
+		case "lease-time":
+			if err = l.expect(s, 1, false); err == nil {
+				c.Server.LeaseTime, err = l.duration(s.args()[0])
+			}
+		case "lease-file":
+			if err = l.expect(s, 1, false); err == nil {
+				c.LeaseFile = s.args()[0].text
+			}
+		case "class":
+			err = l.class(s)
+		case "shared-network":
+			err = l.sharedNetwork(s)
+		case "subnet":
+			var sn *dhcp4.Subnet
+			if sn, err = l.subnet(s); err == nil {
+				l.subnets = append(l.subnets, sn)
+			}
+		case "host":
+			err = l.host(s)
+		default:
+			err = l.scopeStatement(s, &c.Server.Global)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.Server.ServerID == nil {
+		return l.errorf(1, "missing server-id")
+	}
+	if len(l.all) == 0 {
+		return l.errorf(1, "no subnets")
+	}
+	c.Server.Subnets = dhcp4.NewSubnetSelector(l.networks, l.subnets...)
+	if len(l.classes) > 0 {
+		c.Server.Classifier = classify.New(l.classes...)
+	}
+	return nil
+}
+
+// scopeStatement loads option and force-send statements into scope.
+func (l *loader) scopeStatement(s *statement, scope *dhcp4.OptionScope) error {
+	switch s.keyword() {
+	case "option":
+		if len(s.args()) < 2 || s.block != nil {
+			return l.errorf(s.line, "option expects a name and value")
+		}
+		code, ok := optionCode(s.args()[0].text)
+		if !ok {
+			return l.errorf(s.line, "unknown option %q", s.args()[0].text)
+		}
+		v, err := optionValue(code, s.args()[1:])
+		if err != nil {
+			return l.errorf(s.line, "option %s: %v", s.args()[0].text, err)
+		}
+		if scope.Options == nil {
+			scope.Options = make(dhcp4.Options)
+		}
+		scope.Options[code] = v
+	case "force-send":
+		if len(s.args()) == 0 || s.block != nil {
+			return l.errorf(s.line, "force-send expects option names")
+		}
+		for _, w := range s.args() {
+			code, ok := optionCode(w.text)
+			if !ok {
+				return l.errorf(s.line, "unknown option %q", w.text)
+			}
+			scope.ForceSend = append(scope.ForceSend, code)
+		}
+	default:
+		return l.errorf(s.line, "unknown statement %q", s.keyword())
+	}
+	return nil
+}
+
+func (l *loader) class(s *statement) error {
+	if err := l.expect(s, 1, true); err != nil {
+		return err
+	}
+	class := &classify.Class{Name: s.args()[0].text}
+	for _, prev := range l.classes {
+		if prev.Name == class.Name {
+			return l.errorf(s.line, "class %q already defined", class.Name)
+		}
+	}
+	for _, b := range s.block {
+		if b.keyword() != "match" {
+			if err := l.scopeStatement(b, &class.Scope); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := l.expect(b, 1, false); err != nil {
+			return err
+		}
+		e, err := classify.Compile(b.args()[0].text)
+		if err != nil {
+			return l.errorf(b.line, "%v", err)
+		}
+		class.Test = e
+	}
+	l.classes = append(l.classes, class)
+	return nil
+}
+
+func (l *loader) sharedNetwork(s *statement) error {
+	if err := l.expect(s, 1, true); err != nil {
+		return err
+	}
+	n := &dhcp4.SharedNetwork{Name: s.args()[0].text}
+	for _, b := range s.block {
+		if b.keyword() != "subnet" {
+			if err := l.scopeStatement(b, &n.Scope); err != nil {
+				return err
+			}
+			continue
+		}
+		sn, err := l.subnet(b)
+		if err != nil {
+			return err
+		}
+		n.Subnets = append(n.Subnets, sn)
+	}
+	if len(n.Subnets) == 0 {
+		return l.errorf(s.line, "shared-network %s has no subnets", n.Name)
+	}
+	l.networks = append(l.networks, n)
+	return nil
+}
+
+func (l *loader) subnet(s *statement) (*dhcp4.Subnet, error) {
+	if err := l.expect(s, 1, true); err != nil {
+		return nil, err
+	}
+	_, ipNet, err := net.ParseCIDR(s.args()[0].text)
+	if err != nil || ipNet.IP.To4() == nil {
+		return nil, l.errorf(s.line, "bad subnet %q", s.args()[0].text)
+	}
+	ipNet.IP = ipNet.IP.To4()
+	for _, other := range l.all {
+		if other.Net.Contains(ipNet.IP) || ipNet.Contains(other.Net.IP) {
+			return nil, l.errorf(s.line, "subnet %s overlaps %s", ipNet, &other.Net)
+		}
+	}
+	sn := &dhcp4.Subnet{Net: *ipNet}
+	for _, b := range s.block {
+		if b.keyword() != "pool" {
+			if err := l.scopeStatement(b, &sn.Scope); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		p, err := l.pool(b, sn)
+		if err != nil {
+			return nil, err
+		}
+		sn.Pools = append(sn.Pools, p)
+	}
+	l.all = append(l.all, sn)
+	return sn, nil
+}
+
+func (l *loader) pool(s *statement, sn *dhcp4.Subnet) (*dhcp4.Pool, error) {
+	if len(s.args()) != 2 {
+		return nil, l.errorf(s.line, "pool expects start and end addresses")
+	}
+	start, err := l.ip(s.args()[0])
+	if err != nil {
+		return nil, err
+	}
+	end, err := l.ip(s.args()[1])
+	if err != nil {
+		return nil, err
+	}
+	if !sn.Contains(start) || !sn.Contains(end) {
+		return nil, l.errorf(s.line, "pool %s-%s outside subnet %s", start, end, &sn.Net)
+	}
+	if dhcp4.IPLess(end, start) {
+		return nil, l.errorf(s.line, "pool %s-%s ends before it starts", start, end)
+	}
+	p := &dhcp4.Pool{Start: start, Range: dhcp4.IPRange(start, end)}
+	for _, other := range sn.Pools {
+		if p.Contains(other.Start) || other.Contains(start) {
+			return nil, l.errorf(s.line, "pool %s-%s overlaps another", start, end)
+		}
+	}
+	for _, b := range s.block {
+		if b.keyword() != "allow-class" {
+			if err := l.scopeStatement(b, &p.Scope); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(b.args()) == 0 || b.block != nil {
+			return nil, l.errorf(b.line, "allow-class expects class names")
+		}
+		for _, w := range b.args() {
+			if !l.classDefined(w.text) {
+				return nil, l.errorf(b.line, "unknown class %q", w.text)
+			}
+			p.Classes = append(p.Classes, w.text)
+		}
+	}
+	return p, nil
+}
+
+func (l *loader) classDefined(name string) bool {
+	for _, c := range l.classes {
+		if c.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *loader) host(s *statement) error {
+	if err := l.expect(s, 1, true); err != nil {
+		return err
+	}
+	r := &dhcp4.Reservation{Name: s.args()[0].text}
+	for _, b := range s.block {
+		var err error
+		switch b.keyword() {
+		case "hardware-address":
+			if err = l.expect(b, 1, false); err == nil {
+				if r.HardwareAddr, err = net.ParseMAC(b.args()[0].text); err != nil {
+					err = l.errorf(b.line, "bad hardware address %q", b.args()[0].text)
+				}
+			}
+		case "client-id":
+			if err = l.expect(b, 1, false); err == nil {
+				r.ClientID, err = l.bytes(b.args()[0])
+			}
+		case "fixed-address":
+			if err = l.expect(b, 1, false); err == nil {
+				r.IP, err = l.ip(b.args()[0])
+			}
+		case "hostname":
+			if err = l.expect(b, 1, false); err == nil {
+				r.Hostname = b.args()[0].text
+			}
+		default:
+			err = l.scopeStatement(b, &r.Scope)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if r.HardwareAddr == nil && r.ClientID == nil {
+		return l.errorf(s.line, "host %s needs a hardware-address or client-id", r.Name)
+	}
+	for _, key := range []string{"mac:" + r.HardwareAddr.String(), "id:" + hex.EncodeToString(r.ClientID), "ip:" + r.IP.String()} {
+		if key == "mac:" || key == "id:" || key == "ip:<nil>" {
+			continue
+		}
+		if l.hosts[key] {
+			return l.errorf(s.line, "host %s duplicates another host's %s", r.Name, key[:strings.IndexByte(key, ':')])
+		}
+		l.hosts[key] = true
+	}
+	if r.IP != nil {
+		onSubnet := false
+		for _, sn := range l.all {
+			onSubnet = onSubnet || sn.Contains(r.IP)
+		}
+		if !onSubnet {
+			return l.errorf(s.line, "host %s fixed-address %s isn't on a subnet", r.Name, r.IP)
+		}
+	}
+	l.config.Server.Reservations = append(l.config.Server.Reservations, r)
+	return nil
+}
+
+func (l *loader) ip(w word) (net.IP, error) {
+	ip := net.ParseIP(w.text).To4()
+	if ip == nil {
+		return nil, l.errorf(w.line, "bad IPv4 address %q", w.text)
+	}
+	return ip, nil
+}
+
+// duration parses a Go duration, or a number of seconds.
+func (l *loader) duration(w word) (time.Duration, error) {
+	if n, err := strconv.Atoi(w.text); err == nil && n > 0 {
+		return time.Duration(n) * time.Second, nil
+	}
+	d, err := time.ParseDuration(w.text)
+	if err != nil || d <= 0 {
+		return 0, l.errorf(w.line, "bad duration %q", w.text)
+	}
+	return d, nil
+}
+
+// bytes parses a quoted string, or hex with optional colons.
+func (l *loader) bytes(w word) ([]byte, error) {
+	if w.quoted {
+		return []byte(w.text), nil
+	}
+	b, err := hex.DecodeString(strings.Replace(strings.TrimPrefix(w.text, "0x"), ":", "", -1))
+	if err != nil || len(b) == 0 {
+		return nil, l.errorf(w.line, "bad hex %q", w.text)
+	}
+	return b, nil
+}
+
+// NewServer returns a server for the configuration, keeping leases in its
+// lease file, if any.  The lease store (s.Leases().LeaseStore) should be
+// closed when the server is done with.
+func (c *Config) NewServer() (*dhcp4.Server, error) {
+	var leases dhcp4.LeaseStore
+	if c.LeaseFile != "" {
+		j, err := dhcp4.OpenJournalLeaseStore(c.LeaseFile)
+		if err != nil {
+			return nil, err
+		}
+		leases = j
+	}
+	return dhcp4.NewConfiguredServer(c.Server, leases), nil
+}
+
+var errNoAddress = fmt.Errorf("config: interface has no IPv4 address")
+
+// ListenAndServe serves s on the configured interfaces, or on all of them if
+// none are configured, until it fails.
+func (c *Config) ListenAndServe(s *dhcp4.Server) error { return c.Serve(s, nil, nil) }
+
+// Serve is like ListenAndServe, but returns nil once done is closed.  If
+// wrap is not nil, each interface's handler is wrapped with it, so
+// middleware (such as logging) can be added.
+func (c *Config) Serve(s *dhcp4.Server, wrap func(dhcp4.Handler) dhcp4.Handler, done <-chan struct{}) error {
+	if wrap == nil {
+		wrap = func(h dhcp4.Handler) dhcp4.Handler { return h }
+	}
+	var l interface {
+		dhcp4.ServeConn
+		Close() error
+	}
+	var h dhcp4.Handler
+	if len(c.Interfaces) == 0 {
+		pc, err := net.ListenPacket("udp4", ":67")
+		if err != nil {
+			return err
+		}
+		l, h = pc, wrap(s)
+	} else {
+		handlers := make(map[int]dhcp4.Handler)
+		for _, name := range c.Interfaces {
+			local, err := interfaceIP(name)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			iface, _ := net.InterfaceByName(name)
+			handlers[iface.Index] = wrap(s.Interface(local))
+		}
+		ifc, err := conn.NewUDP4InterfacesListener(":67", c.Interfaces...)
+		if err != nil {
+			return err
+		}
+		l, h = ifc, ifHandler{ifc, handlers}
+	}
+	defer l.Close()
+	errs := make(chan error, 1)
+	go func() { errs <- dhcp4.Serve(l, h) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-done:
+		return nil
+	}
+}
+
+// ifHandler passes requests to the handler of the interface they were
+// received on.  Serve handles each request between reading it and reading
+// the next, so the conn's interface is the request's.
+type ifHandler struct {
+	conn     interface{ IfIndex() int }
+	handlers map[int]dhcp4.Handler
+}
+
+func (h ifHandler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	if i, ok := h.handlers[h.conn.IfIndex()]; ok {
+		return i.ServeDHCP(req, msgType, options)
+	}
+	return nil
+}
+
+// interfaceIP returns the first IPv4 address of interface name.
+func interfaceIP(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			return n.IP.To4(), nil
+		}
+	}
+	return nil, errNoAddress
+}
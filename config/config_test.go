@@ -0,0 +1,166 @@
+package config
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestLoad(t *testing.T) {
+	c, err := Load("testdata/dhcp4.conf")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	s := c.Server
+	if !s.ServerID.Equal(net.IP{192, 168, 1, 1}) || s.LeaseTime != 2*time.Hour || len(c.Interfaces) != 1 || c.Interfaces[0] != "eth0" {
+		t.Fatalf("Unexpected settings: %v %v %v", s.ServerID, s.LeaseTime, c.Interfaces)
+	}
+	if !bytes.Equal(s.Global.Options[dhcp4.OptionDomainNameServer], []byte{8, 8, 8, 8, 8, 8, 4, 4}) ||
+		string(s.Global.Options[dhcp4.OptionDomainName]) != "example.com" {
+		t.Fatalf("Unexpected global options: %v", s.Global.Options)
+	}
+	if len(s.Reservations) != 2 || !s.Reservations[0].IP.Equal(net.IP{192, 168, 1, 5}) ||
+		!bytes.Equal(s.Reservations[1].ClientID, []byte{1, 0, 0x11, 0x22, 0x33, 0x44, 0x66}) {
+		t.Fatalf("Unexpected reservations: %v", s.Reservations)
+	}
+
+	relayed := func(giaddr net.IP, mac net.HardwareAddr, options []dhcp4.Option) dhcp4.Packet {
+		req := dhcp4.RequestPacket(dhcp4.Discover, mac, nil, []byte{1, 2, 3, 4}, false, options)
+		req.SetGIAddr(giaddr)
+		return req
+	}
+	for i, test := range []struct {
+		req     dhcp4.Packet
+		yiaddr  string // Prefix
+		options dhcp4.Options
+	}{
+		{relayed(nil, net.HardwareAddr{0, 1, 2, 3, 4, 5}, nil), "192.168.1.", dhcp4.Options{
+			dhcp4.OptionRouter:               {192, 168, 1, 1},
+			dhcp4.OptionClasslessRouteFormat: {8, 10, 192, 168, 1, 254, 0, 192, 168, 1, 1},
+			dhcp4.OptionInterfaceMTU:         {5, 220},
+			dhcp4.OptionIPAddressLeaseTime:   {0, 0, 14, 16},
+		}},
+		{relayed(nil, net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}, nil), "192.168.1.5", dhcp4.Options{
+			dhcp4.OptionHostName: []byte("printer"),
+			43:                   {1, 2, 0xab},
+		}},
+		{relayed(net.IP{10, 0, 2, 1}, net.HardwareAddr{0, 1, 2, 3, 4, 6}, nil), "10.0.1.10", dhcp4.Options{
+			dhcp4.OptionRouter:       {10, 0, 1, 1},
+			dhcp4.OptionDomainSearch: []byte("\x07example\x03com\x00\x04corp\x07example\x03com\x00"),
+		}},
+		{relayed(net.IP{10, 0, 1, 1}, net.HardwareAddr{0, 1, 2, 3, 4, 7}, []dhcp4.Option{
+			{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00000")},
+			{Code: dhcp4.OptionParameterRequestList, Value: []byte{byte(dhcp4.OptionRouter)}},
+		}), "10.0.", dhcp4.Options{
+			dhcp4.OptionBootFileName: []byte("pxelinux.0"),
+		}},
+		{relayed(net.IP{172, 16, 0, 1}, net.HardwareAddr{0, 1, 2, 3, 4, 8}, nil), "", nil},
+	} {
+		srv, err := c.NewServer()
+		if err != nil {
+			t.Fatalf("NewServer: %v", err)
+		}
+		res := srv.ServeDHCP(test.req, dhcp4.Discover, test.req.ParseOptions())
+		if test.yiaddr == "" {
+			if res != nil {
+				t.Fatalf("%02d: unexpected offer of %s", i, res.YIAddr())
+			}
+			continue
+		}
+		if res == nil || !strings.HasPrefix(res.YIAddr().String(), test.yiaddr) {
+			t.Fatalf("%02d: unexpected offer: %v", i, res)
+		}
+		options := res.ParseOptions()
+		for code, v := range test.options {
+			if !bytes.Equal(options[code], v) {
+				t.Fatalf("%02d: option %v, unexpected value: %v != %v", i, code, options[code], v)
+			}
+		}
+		if n := countOption(res, dhcp4.OptionIPAddressLeaseTime); n != 1 {
+			t.Fatalf("%02d: unexpected lease time options: %d", i, n)
+		}
+
+		// The lease is recorded for the time offered
+		yiaddr := append(net.IP(nil), res.YIAddr()...)
+		req := dhcp4.RequestPacket(dhcp4.Request, test.req.CHAddr(), nil, []byte{1, 2, 3, 5}, false, []dhcp4.Option{
+			{Code: dhcp4.OptionServerIdentifier, Value: s.ServerID},
+			{Code: dhcp4.OptionRequestedIPAddress, Value: yiaddr},
+		})
+		req.SetGIAddr(test.req.GIAddr())
+		for code, v := range test.req.ParseOptions() {
+			if code != dhcp4.OptionDHCPMessageType {
+				req.AddOption(code, v)
+			}
+		}
+		srv.ServeDHCP(req, dhcp4.Request, req.ParseOptions())
+		l, ok := srv.Leases().Get(yiaddr)
+		if want := time.Duration(binary.BigEndian.Uint32(options[dhcp4.OptionIPAddressLeaseTime])) * time.Second; !ok || l.Expiry.Sub(l.Start) != want {
+			t.Fatalf("%02d: unexpected lease: %v, expected %v", i, l, want)
+		}
+	}
+}
+
+// countOption returns how many times code appears in p.
+func countOption(p dhcp4.Packet, code dhcp4.OptionCode) int {
+	n := 0
+	for opts := p.Options(); len(opts) >= 2 && dhcp4.OptionCode(opts[0]) != dhcp4.End; {
+		if dhcp4.OptionCode(opts[0]) == dhcp4.Pad {
+			opts = opts[1:]
+			continue
+		}
+		if dhcp4.OptionCode(opts[0]) == code {
+			n++
+		}
+		if len(opts) < 2+int(opts[1]) {
+			break
+		}
+		opts = opts[2+opts[1]:]
+	}
+	return n
+}
+
+func TestLoadErrors(t *testing.T) {
+	for i, test := range []struct {
+		config string
+		line   int
+		msg    string
+	}{
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {\n pool 10.0.0.10 10.0.0.20;\n}\nbogus 1;", 5, `unknown statement "bogus"`},
+		{"server-id 10.0.0.1\nsubnet 10.0.0.0/24 {}", 1, "server-id expects 1 argument(s)"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {}\nlease-file x", 3, "missing ; after lease-file"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {\n pool 10.0.0.10 10.0.0.20;\n", 2, "unclosed block"},
+		{"server-id 10.0.0.1;\n}", 2, "unexpected }"},
+		{"server-id 10.0.0.1;\noption domain-name \"abc;\n", 2, "unterminated string"},
+		{"server-id 10.0.0.300;", 1, `bad IPv4 address "10.0.0.300"`},
+		{"server-id 10.0.0.1;\nlease-time soon;", 2, `bad duration "soon"`},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {\n pool 10.0.1.10 10.0.1.20;\n}", 3, "pool 10.0.1.10-10.0.1.20 outside subnet 10.0.0.0/24"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {\n pool 10.0.0.20 10.0.0.10;\n}", 3, "pool 10.0.0.20-10.0.0.10 ends before it starts"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {\n pool 10.0.0.10 10.0.0.20;\n pool 10.0.0.15 10.0.0.30;\n}", 4, "pool 10.0.0.15-10.0.0.30 overlaps another"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {}\nsubnet 10.0.0.0/16 {}", 3, "subnet 10.0.0.0/16 overlaps 10.0.0.0/24"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {\n pool 10.0.0.10 10.0.0.20 { allow-class pxe; }\n}", 3, `unknown class "pxe"`},
+		{"server-id 10.0.0.1;\noption no-such-option 1;", 2, `unknown option "no-such-option"`},
+		{"server-id 10.0.0.1;\noption router 10.0.0;", 2, `option router: bad IPv4 address "10.0.0"`},
+		{"server-id 10.0.0.1;\noption interface-mtu 70000;", 2, `option interface-mtu: number "70000" out of range`},
+		{"server-id 10.0.0.1;\noption subnet-mask 255.0.0.0 255.0.0.0;", 2, "option subnet-mask: expected one address"},
+		{"server-id 10.0.0.1;\nclass a {\n match \"option[60].text ==\";\n}", 3, "classify: expected value"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {}\nhost a {\n fixed-address 10.0.0.5;\n}", 3, "host a needs a hardware-address or client-id"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {}\nhost a {\n hardware-address 00:01:02:03:04:05;\n}\nhost b {\n hardware-address 00:01:02:03:04:05;\n}", 6, "host b duplicates another host's mac"},
+		{"server-id 10.0.0.1;\nsubnet 10.0.0.0/24 {}\nhost a {\n hardware-address 00:01:02:03:04:05;\n fixed-address 10.0.1.5;\n}", 3, "host a fixed-address 10.0.1.5 isn't on a subnet"},
+		{"subnet 10.0.0.0/24 {}", 1, "missing server-id"},
+		{"server-id 10.0.0.1;", 1, "no subnets"},
+	} {
+		_, err := Parse(strings.NewReader(test.config), "test.conf")
+		e, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, test.config, err)
+		}
+		if e.File != "test.conf" || e.Line != test.line || !strings.HasPrefix(e.Msg, test.msg) {
+			t.Fatalf("%02d: test %q, unexpected error: %v != %d: %s", i, test.config, err, test.line, test.msg)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// How option values are written in configuration files.
+type optionType byte
+
+const (
+	typeBytes   optionType = iota // "string" or 0x hex
+	typeIP                        // 10.0.0.1
+	typeIPs                       // 10.0.0.1, 10.0.0.2
+	typeString                    // "string"
+	typeUint8                     // 64
+	typeUint16                    // 1500
+	typeUint32                    // 3600, or a duration such as 1h
+	typeInt32                     // -3600, or a duration
+	typeBool                      // true or false
+	typeDomains                   // example.com, corp.example.com
+	typeRoutes                    // 10.0.0.0/8 10.0.1.1, 0.0.0.0/0 10.0.1.1
+)
+
+var optionTypes = map[dhcp4.OptionCode]optionType{
+	dhcp4.OptionSubnetMask:                                 typeIP,
+	dhcp4.OptionTimeOffset:                                 typeInt32,
+	dhcp4.OptionRouter:                                     typeIPs,
+	dhcp4.OptionTimeServer:                                 typeIPs,
+	dhcp4.OptionNameServer:                                 typeIPs,
+	dhcp4.OptionDomainNameServer:                           typeIPs,
+	dhcp4.OptionLogServer:                                  typeIPs,
+	dhcp4.OptionCookieServer:                               typeIPs,
+	dhcp4.OptionLPRServer:                                  typeIPs,
+	dhcp4.OptionImpressServer:                              typeIPs,
+	dhcp4.OptionResourceLocationServer:                     typeIPs,
+	dhcp4.OptionHostName:                                   typeString,
+	dhcp4.OptionBootFileSize:                               typeUint16,
+	dhcp4.OptionMeritDumpFile:                              typeString,
+	dhcp4.OptionDomainName:                                 typeString,
+	dhcp4.OptionSwapServer:                                 typeIP,
+	dhcp4.OptionRootPath:                                   typeString,
+	dhcp4.OptionExtensionsPath:                             typeString,
+	dhcp4.OptionIPForwardingEnableDisable:                  typeBool,
+	dhcp4.OptionNonLocalSourceRoutingEnableDisable:         typeBool,
+	dhcp4.OptionMaximumDatagramReassemblySize:              typeUint16,
+	dhcp4.OptionDefaultIPTimeToLive:                        typeUint8,
+	dhcp4.OptionPathMTUAgingTimeout:                        typeUint32,
+	dhcp4.OptionInterfaceMTU:                               typeUint16,
+	dhcp4.OptionAllSubnetsAreLocal:                         typeBool,
+	dhcp4.OptionBroadcastAddress:                           typeIP,
+	dhcp4.OptionPerformMaskDiscovery:                       typeBool,
+	dhcp4.OptionMaskSupplier:                               typeBool,
+	dhcp4.OptionPerformRouterDiscovery:                     typeBool,
+	dhcp4.OptionRouterSolicitationAddress:                  typeIP,
+	dhcp4.OptionTrailerEncapsulation:                       typeBool,
+	dhcp4.OptionARPCacheTimeout:                            typeUint32,
+	dhcp4.OptionEthernetEncapsulation:                      typeBool,
+	dhcp4.OptionTCPDefaultTTL:                              typeUint8,
+	dhcp4.OptionTCPKeepaliveInterval:                       typeUint32,
+	dhcp4.OptionTCPKeepaliveGarbage:                        typeBool,
+	dhcp4.OptionNetworkInformationServiceDomain:            typeString,
+	dhcp4.OptionNetworkInformationServers:                  typeIPs,
+	dhcp4.OptionNetworkTimeProtocolServers:                 typeIPs,
+	dhcp4.OptionNetBIOSOverTCPIPNameServer:                 typeIPs,
+	dhcp4.OptionNetBIOSOverTCPIPDatagramDistributionServer: typeIPs,
+	dhcp4.OptionNetBIOSOverTCPIPNodeType:                   typeUint8,
+	dhcp4.OptionNetBIOSOverTCPIPScope:                      typeString,
+	dhcp4.OptionXWindowSystemFontServer:                    typeIPs,
+	dhcp4.OptionXWindowSystemDisplayManager:                typeIPs,
+	dhcp4.OptionSimpleMailTransportProtocol:                typeIPs,
+	dhcp4.OptionPostOfficeProtocolServer:                   typeIPs,
+	dhcp4.OptionNetworkNewsTransportProtocol:               typeIPs,
+	dhcp4.OptionDefaultWorldWideWebServer:                  typeIPs,
+	dhcp4.OptionDefaultFingerServer:                        typeIPs,
+	dhcp4.OptionDefaultInternetRelayChatServer:             typeIPs,
+	dhcp4.OptionStreetTalkServer:                           typeIPs,
+	dhcp4.OptionStreetTalkDirectoryAssistance:              typeIPs,
+	dhcp4.OptionIPAddressLeaseTime:                         typeUint32,
+	dhcp4.OptionServerIdentifier:                           typeIP,
+	dhcp4.OptionMessage:                                    typeString,
+	dhcp4.OptionMaximumDHCPMessageSize:                     typeUint16,
+	dhcp4.OptionRenewalTimeValue:                           typeUint32,
+	dhcp4.OptionRebindingTimeValue:                         typeUint32,
+	dhcp4.OptionVendorClassIdentifier:                      typeString,
+	dhcp4.OptionTFTPServerName:                             typeString,
+	dhcp4.OptionBootFileName:                               typeString,
+	dhcp4.OptionTZPOSIXString:                              typeString,
+	dhcp4.OptionTZDatabaseString:                           typeString,
+	dhcp4.OptionDomainSearch:                               typeDomains,
+	dhcp4.OptionClasslessRouteFormat:                       typeRoutes,
+	dhcp4.OptionPxelinuxConfigfile:                         typeString,
+	dhcp4.OptionPxelinuxPathprefix:                         typeString,
+	dhcp4.OptionPxelinuxReboottime:                         typeUint32,
+}
+
+// optionNames maps lower case option names, being OptionCode constant names
+// without the Option prefix (such as domainnameserver), to their codes.
+var optionNames = func() map[string]dhcp4.OptionCode {
+	names := make(map[string]dhcp4.OptionCode)
+	for c := 1; c < 255; c++ {
+		code := dhcp4.OptionCode(c)
+		if name := code.String(); strings.HasPrefix(name, "Option") {
+			names[strings.ToLower(strings.TrimPrefix(name, "Option"))] = code
+		}
+	}
+	return names
+}()
+
+// optionCode returns the code of an option given by name, ignoring case and
+// dashes (so domain-name-server is DomainNameServer), or number.
+func optionCode(name string) (dhcp4.OptionCode, bool) {
+	if n, err := strconv.Atoi(name); err == nil {
+		return dhcp4.OptionCode(n), n > 0 && n < 255
+	}
+	code, ok := optionNames[strings.ToLower(strings.Replace(name, "-", "", -1))]
+	return code, ok
+}
+
+// optionValue encodes the value of option code from words.
+func optionValue(code dhcp4.OptionCode, words []word) ([]byte, error) {
+	if len(words) == 0 {
+		return nil, fmt.Errorf("missing value")
+	}
+	t := optionTypes[code]
+	single := func() (string, error) {
+		if len(words) != 1 {
+			return "", fmt.Errorf("expected one value")
+		}
+		return words[0].text, nil
+	}
+	switch t {
+	case typeIP, typeIPs:
+		if t == typeIP && len(words) != 1 {
+			return nil, fmt.Errorf("expected one address")
+		}
+		var ips []net.IP
+		for _, w := range words {
+			ip := net.ParseIP(w.text).To4()
+			if ip == nil {
+				return nil, fmt.Errorf("bad IPv4 address %q", w.text)
+			}
+			ips = append(ips, ip)
+		}
+		return dhcp4.JoinIPs(ips), nil
+	case typeString:
+		return []byte(strings.Join(texts(words), " ")), nil
+	case typeUint8, typeUint16, typeUint32, typeInt32:
+		s, err := single()
+		if err != nil {
+			return nil, err
+		}
+		return integer(t, s)
+	case typeBool:
+		s, err := single()
+		if err != nil {
+			return nil, err
+		}
+		switch s {
+		case "true", "on":
+			return []byte{1}, nil
+		case "false", "off":
+			return []byte{0}, nil
+		}
+		return nil, fmt.Errorf("bad boolean %q", s)
+	case typeDomains:
+		var b []byte
+		for _, w := range words {
+			for _, label := range strings.Split(strings.TrimSuffix(w.text, "."), ".") {
+				if len(label) == 0 || len(label) > 63 {
+					return nil, fmt.Errorf("bad domain name %q", w.text)
+				}
+				b = append(append(b, byte(len(label))), label...)
+			}
+			b = append(b, 0)
+		}
+		return b, nil
+	case typeRoutes:
+		if len(words)%2 != 0 {
+			return nil, fmt.Errorf("expected destination and router pairs")
+		}
+		var b []byte
+		for i := 0; i < len(words); i += 2 {
+			_, dst, err := net.ParseCIDR(words[i].text)
+			router := net.ParseIP(words[i+1].text).To4()
+			if err != nil || dst.IP.To4() == nil || router == nil {
+				return nil, fmt.Errorf("bad route %s %s", words[i].text, words[i+1].text)
+			}
+			ones, _ := dst.Mask.Size()
+			b = append(append(append(b, byte(ones)), dst.IP.To4()[:(ones+7)/8]...), router...)
+		}
+		return b, nil
+	}
+	// Untyped: a string, or hex
+	s, err := single()
+	if err != nil {
+		return nil, err
+	}
+	if !words[0].quoted && strings.HasPrefix(s, "0x") {
+		b, err := hex.DecodeString(strings.Replace(s[2:], ":", "", -1))
+		if err != nil {
+			return nil, fmt.Errorf("bad hex %q", s)
+		}
+		return b, nil
+	}
+	return []byte(s), nil
+}
+
+func texts(words []word) []string {
+	s := make([]string, len(words))
+	for i, w := range words {
+		s[i] = w.text
+	}
+	return s
+}
+
+// integer encodes s, an integer or a duration (in seconds), as t.
+func integer(t optionType, s string) ([]byte, error) {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil && (t == typeUint32 || t == typeInt32) {
+		var d time.Duration
+		if d, err = time.ParseDuration(s); err == nil {
+			n = int64(d / time.Second)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("bad number %q", s)
+	}
+	switch {
+	case t == typeUint8 && n >= 0 && n <= 0xff:
+		return []byte{byte(n)}, nil
+	case t == typeUint16 && n >= 0 && n <= 0xffff:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(n))
+		return b, nil
+	case t == typeUint32 && n >= 0 && n <= 0xffffffff,
+		t == typeInt32 && n >= -0x80000000 && n <= 0x7fffffff:
+		b := make([]byte, 4)
+		binary.BigEndian.PutUint32(b, uint32(n))
+		return b, nil
+	}
+	return nil, fmt.Errorf("number %q out of range", s)
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Error is a configuration error, at a line of a file.
+type Error struct {
+	File string
+	Line int
+	Msg  string
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg) }
+
+// word is a word of a statement, as written or quoted.
+type word struct {
+	text   string
+	quoted bool
+	line   int
+}
+
+// statement is a sequence of words, ended by a semicolon or a block of
+// statements.
+type statement struct {
+	line  int
+	words []word
+	block []*statement // Nil unless the statement has a block
+}
+
+func (s *statement) keyword() string { return s.words[0].text }
+
+// args returns the words after the keyword.
+func (s *statement) args() []word { return s.words[1:] }
+
+type parser struct {
+	file  string
+	r     *bufio.Reader
+	line  int
+	words []word
+}
+
+// parse reads the statements of r, which is named file in errors.
+func parse(r io.Reader, file string) ([]*statement, error) {
+	p := &parser{file: file, r: bufio.NewReader(r), line: 1}
+	stmts, end, err := p.statements()
+	if err != nil {
+		return nil, err
+	}
+	if end == '}' {
+		return nil, p.errorf(p.line, "unexpected }")
+	}
+	return stmts, nil
+}
+
+func (p *parser) errorf(line int, format string, args ...interface{}) error {
+	return &Error{File: p.file, Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// statements reads statements until the end of a block or file, returning
+// which ended them: '}' or 0.
+func (p *parser) statements() ([]*statement, byte, error) {
+	var stmts []*statement
+	var words []word
+	for {
+		w, delim, err := p.word()
+		if err != nil {
+			return nil, 0, err
+		}
+		if w != nil {
+			words = append(words, *w)
+			continue
+		}
+		switch delim {
+		case ';':
+			if len(words) == 0 {
+				return nil, 0, p.errorf(p.line, "empty statement")
+			}
+			stmts = append(stmts, &statement{line: words[0].line, words: words})
+			words = nil
+		case '{':
+			if len(words) == 0 {
+				return nil, 0, p.errorf(p.line, "block without statement")
+			}
+			line := words[0].line
+			block, end, err := p.statements()
+			if err != nil {
+				return nil, 0, err
+			}
+			if end != '}' {
+				return nil, 0, p.errorf(line, "unclosed block")
+			}
+			if block == nil {
+				block = []*statement{}
+			}
+			stmts = append(stmts, &statement{line: line, words: words, block: block})
+			words = nil
+		case '}', 0:
+			if len(words) > 0 {
+				return nil, 0, p.errorf(words[0].line, "missing ; after %s", words[0].text)
+			}
+			return stmts, delim, nil
+		}
+	}
+}
+
+// word reads the next word, or if there isn't one, the delimiter ; { or }
+// that follows, or 0 at the end of input.  Commas separate words like
+// spaces, and # starts a comment.
+func (p *parser) word() (*word, byte, error) {
+	for {
+		c, err := p.r.ReadByte()
+		if err == io.EOF {
+			return nil, 0, nil
+		} else if err != nil {
+			return nil, 0, err
+		}
+		switch {
+		case c == '\n':
+			p.line++
+		case c == ' ' || c == '\t' || c == '\r' || c == ',':
+		case c == '#':
+			if _, err := p.r.ReadString('\n'); err == io.EOF {
+				return nil, 0, nil
+			} else if err != nil {
+				return nil, 0, err
+			}
+			p.line++
+		case c == ';' || c == '{' || c == '}':
+			return nil, c, nil
+		case c == '"':
+			return p.quoted()
+		default:
+			var b strings.Builder
+			b.WriteByte(c)
+			for {
+				c, err := p.r.ReadByte()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					return nil, 0, err
+				}
+				if strings.IndexByte(" \t\r\n,;{}#\"", c) >= 0 {
+					p.r.UnreadByte()
+					break
+				}
+				b.WriteByte(c)
+			}
+			return &word{text: b.String(), line: p.line}, 0, nil
+		}
+	}
+}
+
+// quoted reads a quoted string, after its opening quote.  Backslash escapes
+// the next character.
+func (p *parser) quoted() (*word, byte, error) {
+	line := p.line
+	var b strings.Builder
+	for {
+		c, err := p.r.ReadByte()
+		if err == io.EOF {
+			return nil, 0, p.errorf(line, "unterminated string")
+		} else if err != nil {
+			return nil, 0, err
+		}
+		switch c {
+		case '"':
+			return &word{text: b.String(), quoted: true, line: line}, 0, nil
+		case '\\':
+			if c, err = p.r.ReadByte(); err != nil {
+				return nil, 0, p.errorf(line, "unterminated string")
+			}
+		case '\n':
+			p.line++
+		}
+		b.WriteByte(c)
+	}
+}
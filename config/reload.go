@@ -0,0 +1,67 @@
+package config
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Reloader reloads a server's configuration from its file, on demand or on
+// a signal (typically SIGHUP).  Interface and lease file changes only take
+// effect on restart.
+type Reloader struct {
+	File   string
+	Server *dhcp4.Server
+	// OnReload, if set, is called after each reload attempt with the new
+	// configuration and the leases it invalidated, or the error.
+	OnReload func(c *Config, invalid []dhcp4.Lease, err error)
+
+	mu sync.Mutex
+}
+
+// NewReloader returns a Reloader of s from c's file.
+func NewReloader(c *Config, s *dhcp4.Server) *Reloader {
+	return &Reloader{File: c.File, Server: s}
+}
+
+// Reload loads the file and applies it to the server.  If the file doesn't
+// load, the server's configuration is unchanged.
+func (r *Reloader) Reload() (*Config, []dhcp4.Lease, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, err := Load(r.File)
+	var invalid []dhcp4.Lease
+	if err == nil {
+		invalid = r.Server.SetConfig(c.Server)
+	}
+	if r.OnReload != nil {
+		r.OnReload(c, invalid, err)
+	}
+	return c, invalid, err
+}
+
+// Notify reloads whenever one of sigs is received, until stop is called.
+func (r *Reloader) Notify(sigs ...os.Signal) (stop func()) {
+	ch := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(ch, sigs...)
+	go func() {
+		for {
+			select {
+			case <-ch:
+				r.Reload()
+			case <-done:
+				return
+			}
+		}
+	}()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(done)
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+const reloadConfig = `server-id 10.0.0.1;
+subnet 10.0.0.0/24 {
+	pool 10.0.0.10 10.0.0.19;
+}
+`
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dhcp4.conf")
+	if err := ioutil.WriteFile(path, []byte(reloadConfig), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	s, err := c.NewServer()
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+
+	// Two clients lease, and a third has an offer outstanding
+	mac := func(i byte) net.HardwareAddr { return net.HardwareAddr{0, 1, 2, 3, 4, i} }
+	leased := make([]net.IP, 2)
+	for i := range leased {
+		req := dhcp4.RequestPacket(dhcp4.Discover, mac(byte(i)), nil, []byte{1, 2, 3, 4}, true, nil)
+		offer := s.ServeDHCP(req, dhcp4.Discover, req.ParseOptions())
+		leased[i] = append(net.IP(nil), offer.YIAddr()...)
+		req = dhcp4.RequestPacket(dhcp4.Request, mac(byte(i)), nil, []byte{1, 2, 3, 4}, true, []dhcp4.Option{
+			{Code: dhcp4.OptionRequestedIPAddress, Value: leased[i]},
+		})
+		s.ServeDHCP(req, dhcp4.Request, req.ParseOptions())
+	}
+	req := dhcp4.RequestPacket(dhcp4.Discover, mac(9), nil, []byte{1, 2, 3, 4}, true, nil)
+	offered := append(net.IP(nil), s.ServeDHCP(req, dhcp4.Discover, req.ParseOptions()).YIAddr()...)
+
+	// The new configuration reserves the first client's address for another
+	// host, and a bad configuration is ignored
+	r := NewReloader(c, s)
+	var reloads int
+	r.OnReload = func(*Config, []dhcp4.Lease, error) { reloads++ }
+	if err := ioutil.WriteFile(path, []byte("server-id 10.0.0.1;\nbogus;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := r.Reload(); err == nil {
+		t.Fatalf("Reload of bad configuration, expected error")
+	}
+	if s.Config() != c.Server {
+		t.Fatalf("Reload of bad configuration changed the server")
+	}
+	next := reloadConfig + "host other {\n\thardware-address 00:01:02:03:04:ff;\n\tfixed-address " + leased[0].String() + ";\n}\n"
+	if err := ioutil.WriteFile(path, []byte(next), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "windows" {
+		r.Reload()
+	} else {
+		stop := r.Notify(syscall.SIGHUP)
+		defer stop()
+		p, _ := os.FindProcess(os.Getpid())
+		p.Signal(syscall.SIGHUP)
+	}
+	for deadline := time.Now().Add(5 * time.Second); reloadCount(r, &reloads) < 2; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for reload")
+		}
+	}
+	_, invalid, err := r.Reload()
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if len(invalid) != 1 || !invalid[0].IP.Equal(leased[0]) {
+		t.Fatalf("Unexpected invalid leases: %v", invalid)
+	}
+
+	// The invalidated lease is NAKed on renewal, the other renews, and the
+	// outstanding offer is still honoured
+	for i, test := range []struct {
+		mac net.HardwareAddr
+		ip  net.IP
+		mt  dhcp4.MessageType
+	}{
+		{mac(0), leased[0], dhcp4.NAK},
+		{mac(1), leased[1], dhcp4.ACK},
+		{mac(9), offered, dhcp4.ACK},
+	} {
+		req := dhcp4.RequestPacket(dhcp4.Request, test.mac, nil, []byte{1, 2, 3, 4}, true, []dhcp4.Option{
+			{Code: dhcp4.OptionRequestedIPAddress, Value: test.ip},
+		})
+		res := s.ServeDHCP(req, dhcp4.Request, req.ParseOptions())
+		if mt := dhcp4.MessageType(res.ParseOptions()[dhcp4.OptionDHCPMessageType][0]); mt != test.mt {
+			t.Fatalf("%02d: test %s, unexpected reply: %v != %v", i, test.mac, mt, test.mt)
+		}
+	}
+}
+
+func reloadCount(r *Reloader, n *int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return *n
+}
@@ -0,0 +1,40 @@
+package dhcp4
+
+import (
+	"net"
+	"time"
+)
+
+// Prober checks whether an address is already in use, before it is offered
+// to a client.  dhcp4/conn provides ICMP echo (ping) and ARP probers.
+//
+// InUse should return false if it can't tell, such as when a probe can't be
+// sent, so that a broken prober doesn't stop addresses being leased.
+type Prober interface {
+	InUse(ip net.IP) bool
+}
+
+// DefaultQuarantine is how long a Server keeps an address from clients after
+// finding it in use or having it declined, if Server.Quarantine is zero.
+const DefaultQuarantine = 24 * time.Hour
+
+// Attempts made to find an address not in use, before giving up on a
+// Discover.
+const maxProbes = 4
+
+func (s *Server) quarantine(ip net.IP) {
+	d := s.Quarantine
+	if d == 0 {
+		d = DefaultQuarantine
+	}
+	s.leases.Quarantine(ip, d)
+}
+
+// inUse probes ip, quarantining it if some host answers.
+func (s *Server) inUse(ip net.IP) bool {
+	if s.Prober == nil || !s.Prober.InUse(ip) {
+		return false
+	}
+	s.quarantine(ip)
+	return true
+}
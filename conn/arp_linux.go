@@ -0,0 +1,86 @@
+// +build linux
+
+package conn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"time"
+)
+
+const ethPArp = 0x0806 // ETH_P_ARP
+
+// ARPProber checks whether an address is in use on an interface's link by
+// sending an ARP probe (RFC 5227) for it.  Unlike ping, hosts can't ignore
+// ARP, but only hosts on the same link can be detected.  It satisfies
+// dhcp4.Prober, and requires CAP_NET_RAW.
+type ARPProber struct {
+	iface   *net.Interface
+	Timeout time.Duration // How long to wait for a reply
+}
+
+// NewARPProber returns an ARPProber for interfaceName, waiting timeout for
+// replies.
+func NewARPProber(interfaceName string, timeout time.Duration) (*ARPProber, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	return &ARPProber{iface: iface, Timeout: timeout}, nil
+}
+
+// InUse returns true if any host claimed ip within the timeout.  If the probe
+// can't be sent, false is returned.
+func (p *ARPProber) InUse(ip net.IP) bool {
+	ip = ip.To4()
+	s, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(ethPArp)))
+	if err != nil {
+		return false
+	}
+	defer syscall.Close(s)
+	if err := syscall.Bind(s, &syscall.SockaddrLinklayer{Protocol: htons(ethPArp), Ifindex: p.iface.Index}); err != nil {
+		return false
+	}
+
+	// ARP probe: request from sender IP 0.0.0.0, so no host updates its cache
+	probe := make([]byte, 28)
+	binary.BigEndian.PutUint16(probe[0:], 1)      // Hardware type: ethernet
+	binary.BigEndian.PutUint16(probe[2:], 0x0800) // Protocol type: IPv4
+	probe[4], probe[5] = 6, 4                     // Address lengths
+	binary.BigEndian.PutUint16(probe[6:], 1)      // Operation: request
+	copy(probe[8:14], p.iface.HardwareAddr)
+	copy(probe[24:28], ip)
+	to := &syscall.SockaddrLinklayer{Protocol: htons(ethPArp), Ifindex: p.iface.Index, Halen: 6}
+	copy(to.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err := syscall.Sendto(s, probe, 0, to); err != nil {
+		return false
+	}
+
+	deadline := time.Now().Add(p.Timeout)
+	buffer := make([]byte, 1500)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return false
+		}
+		tv := syscall.NsecToTimeval(remaining.Nanoseconds())
+		if err := syscall.SetsockoptTimeval(s, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return false
+		}
+		n, _, err := syscall.Recvfrom(s, buffer, 0)
+		if err == syscall.EINTR {
+			continue
+		} else if err != nil {
+			return false // Timeout
+		}
+		// Any ARP packet with ip as sender address, from a host other than
+		// us, shows the address is in use.
+		if n >= 28 && bytes.Equal(buffer[14:18], ip) && !bytes.Equal(buffer[8:14], p.iface.HardwareAddr) {
+			return true
+		}
+	}
+}
+
+func htons(v uint16) uint16 { return v<<8 | v>>8 }
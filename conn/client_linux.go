@@ -0,0 +1,297 @@
+// +build linux
+
+package conn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const ethPIP = 0x0800 // ETH_P_IP
+
+// ClientConn is a DHCP client transport for an interface that may not have
+// an address.  Until the client is bound, packets are sent from 0.0.0.0:68
+// to the link broadcast address through an AF_PACKET socket, which only
+// receives replies matching the transaction id and hardware address of the
+// last packet sent, as filtered by a BPF program.  Once the client sends a
+// packet with its leased address in ciaddr (renewing), a UDP socket bound to
+// that address is used instead, until the client again sends without one.
+// It satisfies client.Conn, and requires CAP_NET_RAW.
+type ClientConn struct {
+	iface *net.Interface
+	fd    int
+
+	mu       sync.Mutex
+	deadline time.Time
+	xid      []byte // Of the last packet sent
+	chaddr   []byte
+	udp      net.PacketConn // When bound
+	bound    net.IP
+}
+
+// NewClientConn returns a ClientConn for interfaceName.
+func NewClientConn(interfaceName string) (*ClientConn, error) {
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	fd, err := syscall.Socket(syscall.AF_PACKET, syscall.SOCK_DGRAM, int(htons(ethPIP)))
+	if err != nil {
+		return nil, err
+	}
+	// Drop everything until the first packet sets the filter
+	if err := syscall.AttachLsf(fd, []syscall.SockFilter{*syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0)}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	if err := syscall.Bind(fd, &syscall.SockaddrLinklayer{Protocol: htons(ethPIP), Ifindex: iface.Index}); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	return &ClientConn{iface: iface, fd: fd}, nil
+}
+
+// clientFilter returns a BPF program accepting unfragmented UDP packets to
+// port 68 carrying BOOTP messages with xid and chaddr.  Offsets are from the
+// IP header, as SOCK_DGRAM packet sockets have no link header.
+func clientFilter(xid, chaddr []byte) []syscall.SockFilter {
+	mac := make([]byte, 6)
+	copy(mac, chaddr)
+	const drop = 14 // Index of the final instruction
+	toDrop := func(i int) int { return drop - i - 1 }
+	prog := []*syscall.SockFilter{
+		syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_B|syscall.BPF_ABS, 9), // IP protocol
+		syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, syscall.IPPROTO_UDP, 0, toDrop(1)),
+		syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_ABS, 6), // Fragment offset
+		syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JSET|syscall.BPF_K, 0x1fff, toDrop(3), 0),
+		syscall.LsfStmt(syscall.BPF_LDX|syscall.BPF_B|syscall.BPF_MSH, 0), // X = IP header length
+		syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, 2),  // UDP destination port
+		syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, 68, 0, toDrop(6)),
+		syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_IND, 8+4), // xid
+		syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint32(xid)), 0, toDrop(8)),
+		syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_IND, 8+28), // chaddr[0:4]
+		syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint32(mac)), 0, toDrop(10)),
+		syscall.LsfStmt(syscall.BPF_LD|syscall.BPF_H|syscall.BPF_IND, 8+32), // chaddr[4:6]
+		syscall.LsfJump(syscall.BPF_JMP|syscall.BPF_JEQ|syscall.BPF_K, int(binary.BigEndian.Uint16(mac[4:])), 0, toDrop(12)),
+		syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0xffff),
+		syscall.LsfStmt(syscall.BPF_RET|syscall.BPF_K, 0),
+	}
+	f := make([]syscall.SockFilter, len(prog))
+	for i, ins := range prog {
+		f[i] = *ins
+	}
+	return f
+}
+
+// timeoutError is returned by reads past the deadline.
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "conn: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errShortPacket = errors.New("conn: packet too short for DHCP")
+
+// WriteTo sends the DHCP message b to addr, which should be port 67 of a
+// server or the broadcast address.
+func (c *ClientConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if len(b) < 240 {
+		return 0, errShortPacket
+	}
+	ciaddr := net.IP(b[12:16])
+	c.mu.Lock()
+	if !bytes.Equal(b[4:8], c.xid) || !bytes.Equal(b[28:28+6], c.chaddr) {
+		c.xid, c.chaddr = append([]byte(nil), b[4:8]...), append([]byte(nil), b[28:28+6]...)
+		if err := syscall.AttachLsf(c.fd, clientFilter(c.xid, c.chaddr)); err != nil {
+			c.mu.Unlock()
+			return 0, err
+		}
+	}
+	udp, err := c.bind(ciaddr)
+	c.mu.Unlock()
+	if err != nil {
+		return 0, err
+	}
+	if udp != nil {
+		return udp.WriteTo(b, addr)
+	}
+
+	dst := net.IPv4bcast
+	port := 67
+	if u, ok := addr.(*net.UDPAddr); ok {
+		dst, port = u.IP.To4(), u.Port
+	}
+	packet := udp4Packet(net.IPv4zero.To4(), dst, 68, port, b)
+	to := &syscall.SockaddrLinklayer{Protocol: htons(ethPIP), Ifindex: c.iface.Index, Halen: 6}
+	copy(to.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	if err := syscall.Sendto(c.fd, packet, 0, to); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// bind switches to a UDP socket bound to ciaddr, or back to the packet
+// socket if ciaddr is 0.0.0.0, returning the UDP socket if any.
+func (c *ClientConn) bind(ciaddr net.IP) (net.PacketConn, error) {
+	if ciaddr.Equal(net.IPv4zero) {
+		if c.udp != nil {
+			c.udp.Close()
+			c.udp, c.bound = nil, nil
+		}
+		return nil, nil
+	}
+	if c.udp != nil && c.bound.Equal(ciaddr) {
+		return c.udp, nil
+	}
+	if c.udp != nil {
+		c.udp.Close()
+	}
+	udp, err := NewUDP4BoundListener(c.iface.Name, net.JoinHostPort(ciaddr.String(), "68"))
+	if err != nil {
+		c.udp, c.bound = nil, nil
+		return nil, err
+	}
+	c.udp, c.bound = udp, append(net.IP(nil), ciaddr...)
+	if !c.deadline.IsZero() {
+		udp.SetReadDeadline(c.deadline)
+	}
+	return udp, nil
+}
+
+// ReadFrom reads a reply to the last packet sent.
+func (c *ClientConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		c.mu.Lock()
+		udp, deadline := c.udp, c.deadline
+		c.mu.Unlock()
+		if udp == nil {
+			return c.readRaw(b, deadline)
+		}
+		n, addr, err := udp.ReadFrom(b)
+		c.mu.Lock()
+		switched := c.udp != udp
+		c.mu.Unlock()
+		if err == nil || !switched {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *ClientConn) readRaw(b []byte, deadline time.Time) (int, net.Addr, error) {
+	buffer := make([]byte, 1500+20+8)
+	for {
+		var tv syscall.Timeval
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return 0, nil, timeoutError{}
+			}
+			tv = syscall.NsecToTimeval(remaining.Nanoseconds())
+		}
+		if err := syscall.SetsockoptTimeval(c.fd, syscall.SOL_SOCKET, syscall.SO_RCVTIMEO, &tv); err != nil {
+			return 0, nil, err
+		}
+		n, _, err := syscall.Recvfrom(c.fd, buffer, 0)
+		if err == syscall.EINTR {
+			continue
+		} else if err == syscall.EAGAIN || err == syscall.EWOULDBLOCK {
+			return 0, nil, timeoutError{}
+		} else if err != nil {
+			return 0, nil, err
+		}
+		src, srcPort, payload, ok := parseUDP4(buffer[:n])
+		c.mu.Lock()
+		match := ok && len(payload) >= 240 && bytes.Equal(payload[4:8], c.xid) && bytes.Equal(payload[28:28+6], c.chaddr)
+		c.mu.Unlock()
+		if match { // Packets queued before the filter changed may not
+			return copy(b, payload), &net.UDPAddr{IP: src, Port: srcPort}, nil
+		}
+	}
+}
+
+// SetReadDeadline sets the deadline for subsequent reads.
+func (c *ClientConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deadline = t
+	if c.udp != nil {
+		return c.udp.SetReadDeadline(t)
+	}
+	return nil
+}
+
+// Close closes the sockets.
+func (c *ClientConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.udp != nil {
+		c.udp.Close()
+		c.udp = nil
+	}
+	return syscall.Close(c.fd)
+}
+
+// udp4Packet returns an IPv4 packet carrying payload in a UDP datagram.
+func udp4Packet(src, dst net.IP, srcPort, dstPort int, payload []byte) []byte {
+	p := make([]byte, 20+8+len(payload))
+	p[0] = 0x45 // Version 4, 5 word header
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	p[8] = 64 // TTL
+	p[9] = syscall.IPPROTO_UDP
+	copy(p[12:16], src)
+	copy(p[16:20], dst)
+	binary.BigEndian.PutUint16(p[10:], ^checksum(p[:20], 0))
+
+	u := p[20:]
+	binary.BigEndian.PutUint16(u[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(u[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(u[4:], uint16(8+len(payload)))
+	copy(u[8:], payload)
+	// Pseudo header: addresses, protocol and UDP length
+	pseudo := append(append(append([]byte(nil), src...), dst...), 0, syscall.IPPROTO_UDP, u[4], u[5])
+	sum := ^checksum(u, checksum(pseudo, 0))
+	if sum == 0 {
+		sum = 0xffff
+	}
+	binary.BigEndian.PutUint16(u[6:], sum)
+	return p
+}
+
+// checksum adds b to the ones' complement sum.
+func checksum(b []byte, sum uint16) uint16 {
+	s := uint32(sum)
+	for i := 0; i+1 < len(b); i += 2 {
+		s += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		s += uint32(b[len(b)-1]) << 8
+	}
+	for s > 0xffff {
+		s = s>>16 + s&0xffff
+	}
+	return uint16(s)
+}
+
+// parseUDP4 returns the source and payload of the UDP datagram in IPv4
+// packet p.
+func parseUDP4(p []byte) (src net.IP, srcPort int, payload []byte, ok bool) {
+	if len(p) < 20 || p[0]>>4 != 4 {
+		return nil, 0, nil, false
+	}
+	ihl := int(p[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(p[2:]))
+	if ihl < 20 || total > len(p) || total < ihl+8 || p[9] != syscall.IPPROTO_UDP {
+		return nil, 0, nil, false
+	}
+	u := p[ihl:total]
+	length := int(binary.BigEndian.Uint16(u[4:]))
+	if length < 8 || length > len(u) {
+		return nil, 0, nil, false
+	}
+	return net.IP(append([]byte(nil), p[12:16]...)), int(binary.BigEndian.Uint16(u[0:])), u[8:length], true
+}
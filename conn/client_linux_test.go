@@ -0,0 +1,188 @@
+// +build linux
+
+package conn
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"syscall"
+	"testing"
+)
+
+// runFilter runs BPF program f over packet p, returning how many bytes it
+// accepts.  Only the instructions clientFilter uses are supported.
+func runFilter(t *testing.T, f []syscall.SockFilter, p []byte) uint32 {
+	var a, x uint32
+	load := func(off uint32, size int) (uint32, bool) {
+		if int(off)+size > len(p) {
+			return 0, false
+		}
+		switch size {
+		case 1:
+			return uint32(p[off]), true
+		case 2:
+			return uint32(binary.BigEndian.Uint16(p[off:])), true
+		}
+		return binary.BigEndian.Uint32(p[off:]), true
+	}
+	sizes := map[uint16]int{syscall.BPF_B: 1, syscall.BPF_H: 2, syscall.BPF_W: 4}
+	for pc := 0; pc < len(f); pc++ {
+		ins := f[pc]
+		switch class := ins.Code & 0x07; {
+		case class == syscall.BPF_RET:
+			return ins.K
+		case class == syscall.BPF_LDX && ins.Code&0xe0 == syscall.BPF_MSH:
+			v, ok := load(ins.K, 1)
+			if !ok {
+				return 0
+			}
+			x = (v & 0x0f) * 4
+		case class == syscall.BPF_LD:
+			off := ins.K
+			if ins.Code&0xe0 == syscall.BPF_IND {
+				off += x
+			}
+			v, ok := load(off, sizes[ins.Code&0x18])
+			if !ok {
+				return 0
+			}
+			a = v
+		case class == syscall.BPF_JMP:
+			var cond bool
+			switch ins.Code & 0xf0 {
+			case syscall.BPF_JEQ:
+				cond = a == ins.K
+			case syscall.BPF_JSET:
+				cond = a&ins.K != 0
+			default:
+				t.Fatalf("%d: unsupported jump %#x", pc, ins.Code)
+			}
+			if cond {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		default:
+			t.Fatalf("%d: unsupported instruction %#x", pc, ins.Code)
+		}
+	}
+	t.Fatalf("program ran off the end")
+	return 0
+}
+
+func TestClientFilter(t *testing.T) {
+	xid := []byte{0xde, 0xad, 0xbe, 0xef}
+	mac := []byte{0, 1, 2, 3, 4, 5}
+	f := clientFilter(xid, mac)
+
+	// Every jump not to the next instruction drops the packet
+	drop := len(f) - 1
+	if f[drop].Code != syscall.BPF_RET|syscall.BPF_K || f[drop].K != 0 {
+		t.Fatalf("last instruction doesn't drop: %v", f[drop])
+	}
+	for i, ins := range f {
+		if ins.Code&0x07 != syscall.BPF_JMP {
+			continue
+		}
+		for _, off := range []uint8{ins.Jt, ins.Jf} {
+			if off != 0 && i+1+int(off) != drop {
+				t.Fatalf("%02d: jump to %d, not the drop at %d", i, i+1+int(off), drop)
+			}
+		}
+	}
+
+	bootp := func(xid, mac []byte) []byte {
+		b := make([]byte, 240)
+		b[0] = 2
+		copy(b[4:8], xid)
+		copy(b[28:], mac)
+		return b
+	}
+	src, dst := net.IP{10, 0, 0, 1}, net.IPv4bcast.To4()
+	fragment := udp4Packet(src, dst, 67, 68, bootp(xid, mac))
+	fragment[7] = 1 // Offset
+	tcp := udp4Packet(src, dst, 67, 68, bootp(xid, mac))
+	tcp[9] = syscall.IPPROTO_TCP
+	options := append([]byte{0x46}, udp4Packet(src, dst, 67, 68, bootp(xid, mac))[1:]...)
+	options = append(append(options[:20:20], 1, 1, 1, 1), options[20:]...) // 4 bytes of NOPs
+	binary.BigEndian.PutUint16(options[2:], uint16(len(options)))
+	for i, test := range []struct {
+		p      []byte
+		accept bool
+	}{
+		{udp4Packet(src, dst, 67, 68, bootp(xid, mac)), true},
+		{options, true},
+		{udp4Packet(src, dst, 67, 67, bootp(xid, mac)), false},
+		{udp4Packet(src, dst, 67, 68, bootp([]byte{1, 2, 3, 4}, mac)), false},
+		{udp4Packet(src, dst, 67, 68, bootp(xid, []byte{0, 1, 2, 3, 9, 5})), false},
+		{udp4Packet(src, dst, 67, 68, bootp(xid, []byte{0, 1, 2, 3, 4, 9})), false},
+		{fragment, false},
+		{tcp, false},
+		{udp4Packet(src, dst, 67, 68, nil), false}, // Too short
+	} {
+		if accept := runFilter(t, f, test.p) != 0; accept != test.accept {
+			t.Fatalf("%02d: unexpected accept: %v != %v", i, accept, test.accept)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	// An IPv4 header, with its checksum (0xb861) zeroed
+	header := []byte{
+		0x45, 0x00, 0x00, 0x73, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11,
+		0x00, 0x00, 0xc0, 0xa8, 0x00, 0x01, 0xc0, 0xa8, 0x00, 0xc7,
+	}
+	if sum := ^checksum(header, 0); sum != 0xb861 {
+		t.Fatalf("unexpected checksum: %#04x != 0xb861", sum)
+	}
+	if sum := checksum([]byte{0x01}, 0); sum != 0x0100 { // Odd lengths pad with zero
+		t.Fatalf("unexpected odd length checksum: %#04x", sum)
+	}
+	if sum := checksum([]byte{0xff, 0xff, 0x00, 0x02}, 0); sum != 0x0002 { // End around carry
+		t.Fatalf("unexpected carried checksum: %#04x", sum)
+	}
+}
+
+func TestUDP4Packet(t *testing.T) {
+	for i, test := range []struct {
+		src, dst         net.IP
+		srcPort, dstPort int
+		payload          []byte
+	}{
+		{net.IPv4zero.To4(), net.IPv4bcast.To4(), 68, 67, bytes.Repeat([]byte{0xab}, 300)},
+		{net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 67, 68, []byte{1, 2, 3}}, // Odd length
+		{net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 2}, 4011, 68, nil},
+	} {
+		p := udp4Packet(test.src, test.dst, test.srcPort, test.dstPort, test.payload)
+		if sum := checksum(p[:20], 0); sum != 0xffff {
+			t.Fatalf("%02d: bad IP header checksum: %#04x", i, sum)
+		}
+		u := p[20:]
+		pseudo := append(append(append([]byte(nil), test.src...), test.dst...), 0, syscall.IPPROTO_UDP, u[4], u[5])
+		if sum := checksum(u, checksum(pseudo, 0)); sum != 0xffff {
+			t.Fatalf("%02d: bad UDP checksum: %#04x", i, sum)
+		}
+		src, port, payload, ok := parseUDP4(p)
+		if !ok || !src.Equal(test.src) || port != test.srcPort || !bytes.Equal(payload, test.payload) {
+			t.Fatalf("%02d: round trip, unexpected result: %v %s %d %v", i, ok, src, port, payload)
+		}
+	}
+
+	good := udp4Packet(net.IP{10, 0, 0, 1}, net.IP{10, 0, 0, 2}, 67, 68, []byte{1, 2, 3, 4})
+	damage := func(f func(p []byte) []byte) []byte { return f(append([]byte(nil), good...)) }
+	for i, p := range [][]byte{
+		good[:19],
+		damage(func(p []byte) []byte { p[0] = 0x65; return p }),                                        // IPv6
+		damage(func(p []byte) []byte { p[0] = 0x44; return p }),                                        // Header too short
+		damage(func(p []byte) []byte { p[9] = syscall.IPPROTO_TCP; return p }),                         // Not UDP
+		damage(func(p []byte) []byte { return p[:len(p)-1] }),                                          // Truncated
+		damage(func(p []byte) []byte { binary.BigEndian.PutUint16(p[2:], 24); return p }),              // No room for UDP
+		damage(func(p []byte) []byte { binary.BigEndian.PutUint16(p[24:], 7); return p }),              // UDP length too short
+		damage(func(p []byte) []byte { binary.BigEndian.PutUint16(p[24:], uint16(len(p))); return p }), // UDP length too long
+	} {
+		if _, _, _, ok := parseUDP4(p); ok {
+			t.Fatalf("%02d: damaged packet parsed", i)
+		}
+	}
+}
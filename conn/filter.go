@@ -59,3 +59,56 @@ func (s *serveIfConn) Close() error { return s.conn.Close() }
 func NewServeIf(ifIndex int, p *ipv4.PacketConn) *serveIfConn {
 	return &serveIfConn{ifIndex: ifIndex, conn: p}
 }
+
+// NewUDP4InterfacesListener creates a listener on all interfaces, filtering
+// packets not received by one of interfaceNames.  Unlike a listener per
+// interface, it needs only the one socket, so several interfaces may be
+// served on the same port.  IfIndex returns the interface the last packet
+// read was received on.
+func NewUDP4InterfacesListener(laddr string, interfaceNames ...string) (c *serveIfsConn, e error) {
+	ifIndexes := make(map[int]bool)
+	for _, name := range interfaceNames {
+		iface, err := net.InterfaceByName(name)
+		if err != nil {
+			return nil, err
+		}
+		ifIndexes[iface.Index] = true
+	}
+	l, err := net.ListenPacket("udp4", laddr)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if e != nil {
+			l.Close()
+		}
+	}()
+	p := ipv4.NewPacketConn(l)
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return nil, err
+	}
+	return &serveIfsConn{ifIndexes: ifIndexes, serveIfConn: serveIfConn{conn: p}}, nil
+}
+
+type serveIfsConn struct {
+	ifIndexes map[int]bool
+	serveIfConn
+}
+
+func (s *serveIfsConn) ReadFrom(b []byte) (n int, addr net.Addr, err error) {
+	for { // Filter all other interfaces
+		n, s.cm, addr, err = s.conn.ReadFrom(b)
+		if err != nil || s.cm != nil && s.ifIndexes[s.cm.IfIndex] {
+			break
+		}
+	}
+	return
+}
+
+// IfIndex returns the index of the interface the last packet was read from.
+func (s *serveIfsConn) IfIndex() int {
+	if s.cm == nil {
+		return 0
+	}
+	return s.cm.IfIndex
+}
@@ -0,0 +1,82 @@
+package conn
+
+import (
+	"math/rand"
+	"net"
+	"os"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// ICMPProber checks whether an address is in use by sending it an ICMP echo
+// request (ping), like ISC dhcpd's ping-check.  It satisfies dhcp4.Prober.
+//
+// A raw ICMP socket is used if permitted, otherwise an unprivileged ICMP
+// datagram socket (see net.ipv4.ping_group_range on linux).
+type ICMPProber struct {
+	Timeout time.Duration // How long to wait for a reply
+}
+
+// NewICMPProber returns an ICMPProber waiting timeout for replies.
+func NewICMPProber(timeout time.Duration) *ICMPProber {
+	return &ICMPProber{Timeout: timeout}
+}
+
+// InUse returns true if ip answered a ping within the timeout.  If no ICMP
+// socket can be opened, false is returned.
+func (p *ICMPProber) InUse(ip net.IP) bool {
+	network, dst := "ip4:icmp", net.Addr(&net.IPAddr{IP: ip})
+	c, err := icmp.ListenPacket(network, "0.0.0.0")
+	if err != nil {
+		network, dst = "udp4", &net.UDPAddr{IP: ip}
+		if c, err = icmp.ListenPacket(network, "0.0.0.0"); err != nil {
+			return false
+		}
+	}
+	defer c.Close()
+
+	id, seq := os.Getpid()&0xffff, rand.Intn(0xffff)
+	msg := icmp.Message{
+		Type: ipv4.ICMPTypeEcho,
+		Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("dhcp4 conflict check")},
+	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return false
+	}
+	if _, err := c.WriteTo(b, dst); err != nil {
+		return false
+	}
+
+	c.SetReadDeadline(time.Now().Add(p.Timeout))
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := c.ReadFrom(buffer)
+		if err != nil {
+			return false // Timeout
+		}
+		if !addrIP(from).Equal(ip) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(1, buffer[:n]) // 1 = ICMP for IPv4
+		if err != nil || reply.Type != ipv4.ICMPTypeEchoReply {
+			continue
+		}
+		// Datagram sockets have their ID rewritten by the kernel
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.Seq == seq && (network == "udp4" || echo.ID == id) {
+			return true
+		}
+	}
+}
+
+func addrIP(a net.Addr) net.IP {
+	switch a := a.(type) {
+	case *net.IPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
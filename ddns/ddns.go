@@ -0,0 +1,557 @@
+// Package ddns keeps DNS in step with DHCP leases, sending dynamic updates
+// (RFC 2136) signed with TSIG (RFC 8945) as leases are bound and released.
+//
+// Forward (A) records are guarded by DHCID records (RFC 4701), so that a
+// name is never taken from another client (RFC 4703).  Names are taken from
+// the client's FQDN option (RFC 4702), or else its host name, with the
+// configured domain appended.
+//
+//	u := ddns.New("192.168.1.2:53", "example.com.", &ddns.Key{Name: "dhcp.", Secret: secret})
+//	u.ReverseZone = "1.168.192.in-addr.arpa."
+//	defer u.Close()
+//	u.Watch(server.Leases())
+//	dhcp4.ListenAndServe(u.Handler(server))
+package ddns
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dhcp4 "github.com/krolaw/dhcp4"
+)
+
+// FQDN option flags (RFC 4702 section 2.1)
+const (
+	FlagS = 0x01 // Server should perform the A update
+	FlagO = 0x02 // Server overrode the client's S flag
+	FlagE = 0x04 // Name is in DNS wire format
+	FlagN = 0x08 // Server should perform no updates
+)
+
+// Update defaults
+const (
+	DefaultTTL      = time.Hour // For leases without an expiry
+	DefaultTimeout  = 5 * time.Second
+	DefaultMinRetry = time.Second
+	DefaultMaxRetry = 5 * time.Minute
+	DefaultAttempts = 10
+)
+
+// ErrConflict is reported when the name is held by another client.
+var ErrConflict = errors.New("ddns: name in use by another client")
+
+// RcodeError is a DNS server's refusal of an update.
+type RcodeError int
+
+func (e RcodeError) Error() string {
+	names := map[RcodeError]string{1: "FORMERR", 2: "SERVFAIL", 3: "NXDOMAIN", 4: "NOTIMP",
+		5: "REFUSED", 6: "YXDOMAIN", 7: "YXRRSET", 8: "NXRRSET", 9: "NOTAUTH", 10: "NOTZONE"}
+	if name, ok := names[e]; ok {
+		return "ddns: server responded " + name
+	}
+	return "ddns: server responded rcode " + strconv.Itoa(int(e))
+}
+
+// Updater sends DNS updates for lease events.  Updates are queued and sent
+// in the background, being retried with exponential backoff until they
+// succeed, are superseded by a later event for the same lease, or run out
+// of attempts.  Fields must not be changed once updates have been queued.
+type Updater struct {
+	Server      string // DNS server, host:port
+	Zone        string // Forward zone, e.g. "example.com."
+	ReverseZone string // Reverse zone, e.g. "1.168.192.in-addr.arpa.", or "" for no PTR updates
+	Domain      string // Appended to partial names, defaults to Zone
+	Key         *Key   // Signs updates if not nil
+
+	TTL      time.Duration // Record TTL, zero for a third of the lease time (RFC 4702)
+	Timeout  time.Duration // For each attempt
+	MinRetry time.Duration // First retry delay, doubling with each attempt
+	MaxRetry time.Duration // Retry delay limit
+	Attempts int           // Before giving up
+
+	// Override has the server update A records even for clients that asked
+	// to do so themselves.
+	Override bool
+
+	// OnUpdate, if set, is called as each event's updates complete or are
+	// abandoned.
+	OnUpdate func(e dhcp4.LeaseEvent, err error)
+
+	start  sync.Once
+	mu     sync.Mutex
+	cond   *sync.Cond
+	queue  []string        // Keys of pending jobs, in order
+	jobs   map[string]*job // Pending jobs by key
+	latest map[string]*job // Most recent job by key, until it completes
+	timers map[*job]*time.Timer
+	closed bool
+	done   chan struct{}
+}
+
+type job struct {
+	key     string
+	e       dhcp4.LeaseEvent
+	attempt int
+}
+
+// New returns an Updater for zone, sending updates to server signed with
+// key.
+func New(server, zone string, key *Key) *Updater {
+	return &Updater{Server: server, Zone: zone, Key: key}
+}
+
+// Watch queues updates for the events of m.  The returned func stops
+// watching.
+func (u *Updater) Watch(m *dhcp4.LeaseManager) (cancel func()) {
+	return m.Subscribe(u.Update)
+}
+
+// Update queues the updates for e, replacing any still pending for the same
+// lease.  Offers don't change DNS, so are ignored.
+func (u *Updater) Update(e dhcp4.LeaseEvent) {
+	switch e.Type {
+	case dhcp4.LeaseBound, dhcp4.LeaseRenewed, dhcp4.LeaseReleased, dhcp4.LeaseDeclined, dhcp4.LeaseExpired:
+	default:
+		return
+	}
+	u.start.Do(u.init)
+	j := &job{key: leaseKey(e.Lease), e: e}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.closed {
+		return
+	}
+	if old := u.latest[j.key]; old != nil {
+		if t := u.timers[old]; t != nil {
+			t.Stop()
+			delete(u.timers, old)
+		}
+	}
+	u.latest[j.key] = j
+	if _, ok := u.jobs[j.key]; !ok {
+		u.queue = append(u.queue, j.key)
+	}
+	u.jobs[j.key] = j
+	u.cond.Signal()
+}
+
+// Close abandons pending updates, waiting for any in progress.
+func (u *Updater) Close() error {
+	u.start.Do(u.init)
+	u.mu.Lock()
+	if !u.closed {
+		u.closed = true
+		for _, t := range u.timers {
+			t.Stop()
+		}
+		u.cond.Signal()
+	}
+	u.mu.Unlock()
+	<-u.done
+	return nil
+}
+
+// leaseKey identifies a client's lease of an address, so that a lease's
+// events replace one another, but not those of the address's previous client.
+func leaseKey(l dhcp4.Lease) string {
+	return l.IP.String() + "/" + l.HardwareAddr.String() + "/" + string(l.ClientID)
+}
+
+func (u *Updater) init() {
+	u.cond = sync.NewCond(&u.mu)
+	u.jobs = make(map[string]*job)
+	u.latest = make(map[string]*job)
+	u.timers = make(map[*job]*time.Timer)
+	u.done = make(chan struct{})
+	go u.run()
+}
+
+func (u *Updater) run() {
+	defer close(u.done)
+	for {
+		u.mu.Lock()
+		for len(u.queue) == 0 && !u.closed {
+			u.cond.Wait()
+		}
+		if u.closed {
+			u.mu.Unlock()
+			return
+		}
+		key := u.queue[0]
+		u.queue = u.queue[1:]
+		j := u.jobs[key]
+		delete(u.jobs, key)
+		u.mu.Unlock()
+
+		err := u.process(j.e)
+		j.attempt++
+
+		u.mu.Lock()
+		if u.latest[key] != j || u.closed {
+			u.mu.Unlock() // Superseded
+			continue
+		}
+		if err == nil || err == ErrConflict || j.attempt >= u.attempts() {
+			delete(u.latest, key)
+			u.mu.Unlock()
+			if u.OnUpdate != nil {
+				u.OnUpdate(j.e, err)
+			}
+			continue
+		}
+		u.timers[j] = time.AfterFunc(u.retryDelay(j.attempt), func() { u.retry(j) })
+		u.mu.Unlock()
+	}
+}
+
+func (u *Updater) retry(j *job) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	delete(u.timers, j)
+	if u.closed || u.latest[j.key] != j {
+		return
+	}
+	u.queue = append(u.queue, j.key)
+	u.jobs[j.key] = j
+	u.cond.Signal()
+}
+
+func (u *Updater) attempts() int {
+	if u.Attempts > 0 {
+		return u.Attempts
+	}
+	return DefaultAttempts
+}
+
+func (u *Updater) retryDelay(attempt int) time.Duration {
+	d, max := u.MinRetry, u.MaxRetry
+	if d <= 0 {
+		d = DefaultMinRetry
+	}
+	if max <= 0 {
+		max = DefaultMaxRetry
+	}
+	for i := 1; i < attempt && d < max; i++ {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// process sends the updates for e.
+func (u *Updater) process(e dhcp4.LeaseEvent) error {
+	l := e.Lease
+	name, forward, reverse := u.plan(l.ClientFQDN, l.Hostname)
+	if name == "" {
+		return nil
+	}
+	ptr := u.reverseName(l.IP)
+	if ptr == "" {
+		reverse = false
+	}
+	add := e.Type == dhcp4.LeaseBound || e.Type == dhcp4.LeaseRenewed
+	if forward {
+		var err error
+		if add {
+			err = u.addForward(name, l, u.ttl(e))
+		} else {
+			err = u.removeForward(name, l)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if reverse {
+		if add {
+			return u.addReverse(ptr, name, u.ttl(e))
+		}
+		return u.removeReverse(ptr, name)
+	}
+	return nil
+}
+
+func (u *Updater) ttl(e dhcp4.LeaseEvent) uint32 {
+	d := u.TTL
+	if d <= 0 {
+		d = DefaultTTL
+		if !e.Lease.Expiry.IsZero() {
+			d = e.Lease.Expiry.Sub(e.Time) / 3
+		}
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+	return uint32(d / time.Second)
+}
+
+// addForward adds name's A record, as RFC 4703 section 5.3.1.
+func (u *Updater) addForward(name string, l dhcp4.Lease, ttl uint32) error {
+	a := rr{Name: name, Type: typeA, Class: classIN, TTL: ttl, Data: l.IP.To4()}
+	dhcid := rr{Name: name, Type: typeDHCID, Class: classIN, TTL: ttl, Data: DHCID(l, name)}
+	err := u.send(u.Zone,
+		[]rr{{Name: name, Type: typeANY, Class: classNONE}}, // Name not in use
+		[]rr{a, dhcid})
+	if err != RcodeError(rcodeYXDomain) {
+		return err
+	}
+	// The name exists, so only take it over if it's ours
+	err = u.send(u.Zone,
+		[]rr{{Name: name, Type: typeDHCID, Class: classIN, Data: dhcid.Data}},
+		[]rr{{Name: name, Type: typeA, Class: classANY}, a})
+	if err == RcodeError(rcodeNXRRSet) {
+		return ErrConflict
+	}
+	return err
+}
+
+// removeForward removes name's records if they're still ours, as RFC 4703
+// section 5.5.
+func (u *Updater) removeForward(name string, l dhcp4.Lease) error {
+	err := u.send(u.Zone,
+		[]rr{{Name: name, Type: typeDHCID, Class: classIN, Data: DHCID(l, name)}},
+		[]rr{{Name: name, Type: typeA, Class: classANY}, {Name: name, Type: typeDHCID, Class: classANY}})
+	if err == RcodeError(rcodeNXRRSet) || err == RcodeError(rcodeNXDomain) {
+		return nil // Gone, or taken over by another client
+	}
+	return err
+}
+
+func (u *Updater) addReverse(ptr, name string, ttl uint32) error {
+	return u.send(u.ReverseZone, nil, []rr{
+		{Name: ptr, Type: typePTR, Class: classANY},
+		{Name: ptr, Type: typePTR, Class: classIN, TTL: ttl, Data: appendName(nil, name)},
+	})
+}
+
+// removeReverse removes only the PTR record for name, leaving any added
+// since for the address's next client.
+func (u *Updater) removeReverse(ptr, name string) error {
+	return u.send(u.ReverseZone, nil, []rr{
+		{Name: ptr, Type: typePTR, Class: classNONE, Data: appendName(nil, name)},
+	})
+}
+
+// reverseName returns the PTR name for ip, or "" if it's outside
+// ReverseZone.
+func (u *Updater) reverseName(ip net.IP) string {
+	ip = ip.To4()
+	if ip == nil || u.ReverseZone == "" {
+		return ""
+	}
+	name := fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip[3], ip[2], ip[1], ip[0])
+	if !inZone(name, u.ReverseZone) {
+		return ""
+	}
+	return name
+}
+
+// send sends an update of zone, returning nil once the server accepts it.
+func (u *Updater) send(zone string, prereq, update []rr) error {
+	var id [2]byte
+	rand.Read(id[:])
+	m := &message{
+		ID:     binary.BigEndian.Uint16(id[:]),
+		Opcode: opcodeUpdate,
+		Zone:   []rr{{Name: fqdn(zone), Type: typeSOA, Class: classIN}},
+		Prereq: prereq,
+		Update: update,
+	}
+	b, mac := m.marshal(), []byte(nil)
+	if u.Key != nil {
+		var err error
+		if b, mac, err = u.Key.sign(m, nil, time.Now(), 0); err != nil {
+			return err
+		}
+	}
+
+	c, err := net.Dial("udp", u.Server)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	timeout := u.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	c.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.Write(b); err != nil {
+		return err
+	}
+	buf := make([]byte, 4096)
+	for {
+		n, err := c.Read(buf)
+		if err != nil {
+			return err
+		}
+		res, err := parseMessage(buf[:n])
+		if err != nil || !res.Response || res.ID != m.ID {
+			continue // Not our response
+		}
+		if u.Key != nil {
+			t, err := u.Key.verify(res, buf[:n], mac, time.Now())
+			if err != nil {
+				return err
+			}
+			if t.Error != 0 {
+				return errBadTSIG
+			}
+		}
+		if res.Rcode != rcodeSuccess {
+			return RcodeError(res.Rcode)
+		}
+		return nil
+	}
+}
+
+// DHCID returns the DHCID RDATA identifying l's client as the owner of name
+// (RFC 4701), using the client identifier if there is one, or else the
+// hardware address.
+func DHCID(l dhcp4.Lease, name string) []byte {
+	var id []byte
+	b := []byte{0, 0, 1} // Identifier type, digest type SHA-256
+	if len(l.ClientID) > 0 {
+		b[1] = 1
+		id = l.ClientID
+	} else {
+		id = append([]byte{1}, l.HardwareAddr...) // Ethernet
+	}
+	h := sha256.New()
+	h.Write(id)
+	h.Write(canonicalName(name))
+	return h.Sum(b)
+}
+
+// plan returns the FQDN to register for a client sending the FQDN option
+// fqdnOpt and host name hostname, and whether the server is to update the
+// forward and reverse records.  name is "" if there's nothing to update.
+func (u *Updater) plan(fqdnOpt []byte, hostname string) (name string, forward, reverse bool) {
+	flags := byte(FlagS)
+	if len(fqdnOpt) >= 3 {
+		flags = fqdnOpt[0]
+		name = u.qualify(decodeName(fqdnOpt))
+	}
+	if name == "" && hostname != "" {
+		name = u.qualify(strings.SplitN(hostname, ".", 2)[0])
+	}
+	if name == "" || flags&FlagN != 0 {
+		return "", false, false
+	}
+	return name, flags&FlagS != 0 || u.Override, true
+}
+
+// decodeName returns the name in the FQDN option b, with a trailing dot if
+// it's fully qualified.
+func decodeName(b []byte) string {
+	if b[0]&FlagE == 0 {
+		return string(b[3:])
+	}
+	var labels []string
+	for b = b[3:]; len(b) > 0; {
+		n := int(b[0])
+		if n == 0 {
+			return strings.Join(labels, ".") + "."
+		}
+		if n > 63 || 1+n > len(b) {
+			return ""
+		}
+		labels = append(labels, string(b[1:1+n]))
+		b = b[1+n:]
+	}
+	return strings.Join(labels, ".")
+}
+
+// qualify returns name, with Domain appended if it's partial, or "" if it's
+// invalid or outside Zone.
+func (u *Updater) qualify(name string) string {
+	if name == "" || name == "." {
+		return ""
+	}
+	if !strings.HasSuffix(name, ".") {
+		domain := u.Domain
+		if domain == "" {
+			domain = u.Zone
+		}
+		name += "." + fqdn(domain)
+	}
+	if len(name) > 254 || !inZone(name, u.Zone) {
+		return ""
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if !validLabel(label) {
+			return ""
+		}
+	}
+	return name
+}
+
+// validLabel reports whether label is a valid host name label (RFC 1123).
+func validLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// inZone reports whether name is zone or a name within it.
+func inZone(name, zone string) bool {
+	name, zone = strings.ToLower(fqdn(name)), strings.ToLower(fqdn(zone))
+	return zone == "." || name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// Handler returns a Handler answering clients that send the FQDN option
+// with the option in Offers and ACKs, telling them which updates the server
+// performs (RFC 4702 section 3.3).
+func (u *Updater) Handler(h dhcp4.Handler) dhcp4.Handler {
+	return &fqdnHandler{Handler: h, u: u}
+}
+
+type fqdnHandler struct {
+	dhcp4.Handler
+	u *Updater
+}
+
+func (h *fqdnHandler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	res := h.Handler.ServeDHCP(req, msgType, options)
+	opt := options[dhcp4.OptionClientFQDN]
+	if res == nil || len(opt) < 3 {
+		return res
+	}
+	if mt := res.ParseOptions()[dhcp4.OptionDHCPMessageType]; len(mt) != 1 ||
+		dhcp4.MessageType(mt[0]) != dhcp4.Offer && dhcp4.MessageType(mt[0]) != dhcp4.ACK {
+		return res
+	}
+	name, forward, _ := h.u.plan(opt, "")
+	flags := opt[0] & (FlagE | FlagN)
+	if forward {
+		flags |= FlagS
+		if opt[0]&FlagS == 0 {
+			flags |= FlagO
+		}
+	}
+	v := []byte{flags, 255, 255}
+	if name != "" {
+		if flags&FlagE != 0 {
+			v = appendName(v, name)
+		} else {
+			v = append(v, strings.TrimSuffix(name, ".")...)
+		}
+	}
+	res.AddOption(dhcp4.OptionClientFQDN, v)
+	res.PadToMinSize()
+	return res
+}
@@ -0,0 +1,386 @@
+package ddns
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	dhcp4 "github.com/krolaw/dhcp4"
+)
+
+// testServer is a minimal DNS server accepting updates to a single zone
+// (RFC 2136), with TSIG.
+type testServer struct {
+	conn net.PacketConn
+	key  *Key
+
+	mu      sync.Mutex
+	drop    int                 // Requests to ignore
+	records map[string][][]byte // name/type -> RDATAs
+}
+
+func newTestServer(t *testing.T, key *Key) *testServer {
+	c, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{conn: c, key: key, records: make(map[string][][]byte)}
+	go s.serve()
+	return s
+}
+
+func (s *testServer) Addr() string { return s.conn.LocalAddr().String() }
+func (s *testServer) Close()       { s.conn.Close() }
+
+func rrKey(name string, t uint16) string { return strings.ToLower(name) + "/" + typeName(t) }
+
+func typeName(t uint16) string {
+	switch t {
+	case typeA:
+		return "A"
+	case typePTR:
+		return "PTR"
+	case typeDHCID:
+		return "DHCID"
+	}
+	return "?"
+}
+
+// get returns the RDATAs of name's records of type t.
+func (s *testServer) get(name string, t uint16) [][]byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.records[rrKey(name, t)]
+}
+
+func (s *testServer) serve() {
+	buf := make([]byte, 4096)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		if res := s.handle(buf[:n]); res != nil {
+			s.conn.WriteTo(res, addr)
+		}
+	}
+}
+
+func (s *testServer) handle(b []byte) []byte {
+	m, err := parseMessage(b)
+	if err != nil || m.Response || m.Opcode != opcodeUpdate {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.drop > 0 {
+		s.drop--
+		return nil
+	}
+	res := &message{ID: m.ID, Response: true, Opcode: opcodeUpdate, Zone: m.Zone}
+	var mac []byte
+	if s.key != nil {
+		t, err := s.key.verify(m, b, nil, time.Now())
+		if err != nil {
+			return nil
+		}
+		if t.Error != 0 {
+			res.Rcode = rcodeNotAuth
+			out, _, _ := s.key.sign(res, nil, time.Now(), t.Error)
+			return out
+		}
+		mac = t.MAC
+	}
+	if res.Rcode = s.prereqs(m.Prereq); res.Rcode == rcodeSuccess {
+		s.apply(m.Update)
+	}
+	if s.key == nil {
+		return res.marshal()
+	}
+	out, _, _ := s.key.sign(res, mac, time.Now(), 0)
+	return out
+}
+
+func (s *testServer) inUse(name string) bool {
+	for k, v := range s.records {
+		if strings.HasPrefix(k, strings.ToLower(name)+"/") && len(v) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *testServer) prereqs(prereqs []rr) byte {
+	for _, p := range prereqs {
+		switch {
+		case p.Class == classANY && p.Type == typeANY:
+			if !s.inUse(p.Name) {
+				return rcodeNXDomain
+			}
+		case p.Class == classNONE && p.Type == typeANY:
+			if s.inUse(p.Name) {
+				return rcodeYXDomain
+			}
+		case p.Class == classIN:
+			found := false
+			for _, d := range s.records[rrKey(p.Name, p.Type)] {
+				found = found || bytes.Equal(d, p.Data)
+			}
+			if !found {
+				return rcodeNXRRSet
+			}
+		default:
+			return 1 // FORMERR
+		}
+	}
+	return rcodeSuccess
+}
+
+func (s *testServer) apply(updates []rr) {
+	for _, u := range updates {
+		k := rrKey(u.Name, u.Type)
+		switch u.Class {
+		case classIN:
+			s.records[k] = append(s.records[k], append([]byte(nil), u.Data...))
+		case classANY:
+			delete(s.records, k)
+		case classNONE:
+			var kept [][]byte
+			for _, d := range s.records[k] {
+				if !bytes.Equal(d, u.Data) {
+					kept = append(kept, d)
+				}
+			}
+			s.records[k] = kept
+		}
+	}
+}
+
+func TestDHCID(t *testing.T) {
+	// RFC 4701 section 3.6
+	want := "AAABxLmlskllE0MVjd57zHcWmEH3pCQ6VytcKD//7es/deY="
+	if got := base64.StdEncoding.EncodeToString(DHCID(dhcp4.Lease{HardwareAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6}}, "client.example.com.")); got != want {
+		t.Fatalf("unexpected DHCID: %v != %v", got, want)
+	}
+	// Client identifiers take precedence, and the name is case insensitive
+	l := dhcp4.Lease{HardwareAddr: net.HardwareAddr{1, 2, 3, 4, 5, 6}, ClientID: []byte{1, 7, 8, 9, 10, 11, 12}}
+	if a, b := DHCID(l, "chi6.example.com."), DHCID(l, "CHI6.Example.com."); !bytes.Equal(a, b) || !bytes.Equal(a[:3], []byte{0, 1, 1}) || len(a) != 35 {
+		t.Fatalf("unexpected client identifier DHCID: %v %v", a, b)
+	}
+}
+
+func TestPlan(t *testing.T) {
+	u := New("", "example.com.", nil)
+	for i, test := range []struct {
+		fqdn             []byte
+		hostname         string
+		name             string
+		forward, reverse bool
+	}{
+		{nil, "host", "host.example.com.", true, true},
+		{nil, "host.other.org", "host.example.com.", true, true},
+		{nil, "bad_name", "", false, false},
+		{nil, "", "", false, false},
+		{[]byte{FlagS, 0, 0, 'p', 'c'}, "host", "pc.example.com.", true, true},
+		{[]byte{0, 0, 0, 'p', 'c'}, "", "pc.example.com.", false, true},
+		{[]byte{FlagN, 0, 0, 'p', 'c'}, "", "", false, false},
+		{[]byte{FlagE | FlagS, 0, 0, 2, 'p', 'c', 3, 'l', 'a', 'b'}, "", "pc.lab.example.com.", true, true},
+		{[]byte{FlagE | FlagS, 0, 0, 2, 'p', 'c', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}, "", "pc.example.com.", true, true},
+		{[]byte{FlagS, 0, 0}, "host", "host.example.com.", true, true},
+		{[]byte{FlagS, 0, 0, 'p', 'c', '.', 'o', 't', 'h', 'e', 'r', '.'}, "", "", false, false},
+	} {
+		name, forward, reverse := u.plan(test.fqdn, test.hostname)
+		if name != test.name || forward != test.forward || reverse != test.reverse {
+			t.Fatalf("%02d: test %q, unexpected plan: %v %v %v != %v %v %v", i, test.fqdn, name, forward, reverse, test.name, test.forward, test.reverse)
+		}
+	}
+	u.Override = true
+	if _, forward, _ := u.plan([]byte{0, 0, 0, 'p', 'c'}, ""); !forward {
+		t.Fatalf("Override, expected forward update")
+	}
+}
+
+// newTestUpdater returns an Updater for s, reporting results on the
+// returned channel.
+func newTestUpdater(s *testServer, key *Key) (*Updater, chan error) {
+	results := make(chan error, 10)
+	u := New(s.Addr(), "example.com.", key)
+	u.ReverseZone = "168.192.in-addr.arpa."
+	u.Timeout, u.MinRetry = 100*time.Millisecond, 10*time.Millisecond
+	u.OnUpdate = func(e dhcp4.LeaseEvent, err error) { results <- err }
+	return u, results
+}
+
+func result(t *testing.T, results chan error) error {
+	select {
+	case err := <-results:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for update")
+	}
+	return nil
+}
+
+func TestUpdater(t *testing.T) {
+	key := &Key{Name: "dhcp.", Secret: []byte("secret")}
+	s := newTestServer(t, key)
+	defer s.Close()
+	u, results := newTestUpdater(s, key)
+	defer u.Close()
+
+	now := time.Now()
+	lease := dhcp4.Lease{IP: net.IP{192, 168, 1, 10}, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}, Hostname: "host", Expiry: now.Add(time.Hour)}
+	ptr := "10.1.168.192.in-addr.arpa."
+
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseBound, Lease: lease, Time: now})
+	if err := result(t, results); err != nil {
+		t.Fatalf("Bound, unexpected error: %v", err)
+	}
+	if a := s.get("host.example.com.", typeA); len(a) != 1 || !net.IP(a[0]).Equal(lease.IP) {
+		t.Fatalf("Bound, unexpected A records: %v", a)
+	}
+	if d := s.get("host.example.com.", typeDHCID); len(d) != 1 || !bytes.Equal(d[0], DHCID(lease, "host.example.com.")) {
+		t.Fatalf("Bound, unexpected DHCID records: %v", d)
+	}
+	if p := s.get(ptr, typePTR); len(p) != 1 || !bytes.Equal(p[0], appendName(nil, "host.example.com.")) {
+		t.Fatalf("Bound, unexpected PTR records: %v", p)
+	}
+
+	// Another client can't take the name
+	other := dhcp4.Lease{IP: net.IP{192, 168, 1, 11}, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 6}, Hostname: "host"}
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseBound, Lease: other, Time: now})
+	if err := result(t, results); err != ErrConflict {
+		t.Fatalf("Bound other, expected conflict: %v", err)
+	}
+	if a := s.get("host.example.com.", typeA); len(a) != 1 || !net.IP(a[0]).Equal(lease.IP) {
+		t.Fatalf("Bound other, unexpected A records: %v", a)
+	}
+	// Nor remove it
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseReleased, Lease: other, Time: now})
+	if err := result(t, results); err != nil || len(s.get("host.example.com.", typeA)) != 1 {
+		t.Fatalf("Released other, unexpected removal: %v", err)
+	}
+
+	// But the owner can update it
+	lease.IP = net.IP{192, 168, 1, 12}
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseRenewed, Lease: lease, Time: now})
+	if err := result(t, results); err != nil {
+		t.Fatalf("Renewed, unexpected error: %v", err)
+	}
+	if a := s.get("host.example.com.", typeA); len(a) != 1 || !net.IP(a[0]).Equal(lease.IP) {
+		t.Fatalf("Renewed, unexpected A records: %v", a)
+	}
+
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseExpired, Lease: lease, Time: now})
+	if err := result(t, results); err != nil {
+		t.Fatalf("Expired, unexpected error: %v", err)
+	}
+	if s.get("host.example.com.", typeA) != nil || s.get("host.example.com.", typeDHCID) != nil || len(s.get("12.1.168.192.in-addr.arpa.", typePTR)) != 0 {
+		t.Fatalf("Expired, records not removed: %v", s.records)
+	}
+
+	// Clients doing their own A updates only get PTRs
+	lease.ClientFQDN = []byte{0, 0, 0, 'p', 'c'}
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseBound, Lease: lease, Time: now})
+	if err := result(t, results); err != nil {
+		t.Fatalf("Bound without S, unexpected error: %v", err)
+	}
+	if s.get("pc.example.com.", typeA) != nil || len(s.get("12.1.168.192.in-addr.arpa.", typePTR)) != 1 {
+		t.Fatalf("Bound without S, unexpected records: %v", s.records)
+	}
+}
+
+func TestUpdaterRetry(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	s.mu.Lock()
+	s.drop = 2
+	s.mu.Unlock()
+	u, results := newTestUpdater(s, nil)
+	u.Attempts = 3
+	defer u.Close()
+
+	lease := dhcp4.Lease{IP: net.IP{192, 168, 1, 10}, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}, Hostname: "host"}
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseBound, Lease: lease, Time: time.Now()})
+	if err := result(t, results); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(s.get("host.example.com.", typeA)) != 1 {
+		t.Fatalf("A record not added: %v", s.records)
+	}
+
+	// Updates are abandoned after the last attempt
+	s.mu.Lock()
+	s.drop = 3
+	s.mu.Unlock()
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseReleased, Lease: lease, Time: time.Now()})
+	if err := result(t, results); err == nil {
+		t.Fatalf("expected timeout error")
+	}
+}
+
+func TestUpdaterBadKey(t *testing.T) {
+	s := newTestServer(t, &Key{Name: "dhcp.", Secret: []byte("secret")})
+	defer s.Close()
+	u, results := newTestUpdater(s, &Key{Name: "dhcp.", Secret: []byte("wrong")})
+	u.Attempts = 1
+	defer u.Close()
+
+	u.Update(dhcp4.LeaseEvent{Type: dhcp4.LeaseBound, Lease: dhcp4.Lease{IP: net.IP{192, 168, 1, 10}, Hostname: "host"}, Time: time.Now()})
+	if err := result(t, results); err != errBadTSIG {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	s := newTestServer(t, nil)
+	defer s.Close()
+	u, results := newTestUpdater(s, nil)
+	defer u.Close()
+	server := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+	u.Watch(server.Leases())
+	h := u.Handler(server)
+
+	for i, test := range []struct {
+		fqdn []byte
+		want []byte
+	}{
+		{[]byte{FlagE | FlagS, 0, 0, 2, 'p', 'c', 0}, nil},
+		{[]byte{FlagS, 0, 0, 'p', 'c'}, []byte{FlagS, 255, 255, 'p', 'c', '.', 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm'}},
+		{[]byte{FlagE, 0, 0, 2, 'p', 'c'}, []byte{FlagE, 255, 255, 2, 'p', 'c', 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0}},
+		{[]byte{FlagN, 0, 0, 'p', 'c'}, []byte{FlagN, 255, 255}},
+	} {
+		req := dhcp4.RequestPacket(dhcp4.Discover, net.HardwareAddr{0, 1, 2, 3, 4, byte(i)}, nil, []byte{1, 2, 3, 4}, true, []dhcp4.Option{{Code: dhcp4.OptionClientFQDN, Value: test.fqdn}})
+		res := h.ServeDHCP(req, dhcp4.Discover, req.ParseOptions())
+		if res == nil {
+			t.Fatalf("%02d: test %q, no offer", i, test.fqdn)
+		}
+		if got := res.ParseOptions()[dhcp4.OptionClientFQDN]; test.want != nil && !bytes.Equal(got, test.want) {
+			t.Fatalf("%02d: test %q, unexpected FQDN option: %v != %v", i, test.fqdn, got, test.want)
+		}
+	}
+
+	// Binding the lease updates DNS
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 9}
+	fqdn := []byte{FlagE | FlagS, 0, 0, 6, 'l', 'a', 'p', 't', 'o', 'p'}
+	req := dhcp4.RequestPacket(dhcp4.Discover, mac, nil, []byte{1, 2, 3, 4}, true, nil)
+	ip := append(net.IP(nil), h.ServeDHCP(req, dhcp4.Discover, req.ParseOptions()).YIAddr()...)
+	req = dhcp4.RequestPacket(dhcp4.Request, mac, nil, []byte{1, 2, 3, 4}, true, []dhcp4.Option{
+		{Code: dhcp4.OptionRequestedIPAddress, Value: ip},
+		{Code: dhcp4.OptionClientFQDN, Value: fqdn},
+	})
+	res := h.ServeDHCP(req, dhcp4.Request, req.ParseOptions())
+	if opts := res.ParseOptions(); dhcp4.MessageType(opts[dhcp4.OptionDHCPMessageType][0]) != dhcp4.ACK || opts[dhcp4.OptionClientFQDN][0] != FlagE|FlagS {
+		t.Fatalf("Request, expected ACK with FQDN: %v", opts)
+	}
+	if err := result(t, results); err != nil {
+		t.Fatalf("Request, unexpected update error: %v", err)
+	}
+	if a := s.get("laptop.example.com.", typeA); len(a) != 1 || !net.IP(a[0]).Equal(ip) {
+		t.Fatalf("Request, unexpected A records: %v", a)
+	}
+}
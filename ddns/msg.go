@@ -0,0 +1,197 @@
+package ddns
+
+import (
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// DNS constants used in updates
+const (
+	typeA     = 1
+	typeSOA   = 6
+	typePTR   = 12
+	typeDHCID = 49
+	typeTSIG  = 250
+	typeANY   = 255
+
+	classIN   = 1
+	classNONE = 254
+	classANY  = 255
+
+	opcodeUpdate = 5
+)
+
+// Response codes
+const (
+	rcodeSuccess  = 0
+	rcodeNXDomain = 3
+	rcodeYXDomain = 6
+	rcodeNXRRSet  = 8
+	rcodeNotAuth  = 9
+)
+
+// rr is a resource record.  Sections of an update reuse the fields with
+// special meanings (RFC 2136 section 2.4 and 2.5).
+type rr struct {
+	Name  string // Fully qualified, with trailing dot
+	Type  uint16
+	Class uint16
+	TTL   uint32
+	Data  []byte
+}
+
+// message is a DNS UPDATE message: the question section holds the zone,
+// answers the prerequisites, and authorities the updates.
+type message struct {
+	ID       uint16
+	Response bool
+	Opcode   byte
+	Rcode    byte
+	Zone     []rr // Zone section (question): Name, Type and Class only
+	Prereq   []rr
+	Update   []rr
+	Extra    []rr
+
+	tsigStart int // Offset of a trailing TSIG record in a parsed message
+}
+
+var errMalformed = errors.New("ddns: malformed DNS message")
+
+// appendName appends name in uncompressed wire format.
+func appendName(b []byte, name string) []byte {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			b = append(append(b, byte(len(label))), label...)
+		}
+	}
+	return append(b, 0)
+}
+
+// canonicalName returns name in lower case wire format, as used by DHCID
+// and TSIG digests.
+func canonicalName(name string) []byte {
+	return appendName(nil, strings.ToLower(name))
+}
+
+func appendUint16(b []byte, v uint16) []byte {
+	return append(b, byte(v>>8), byte(v))
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func appendRR(b []byte, r rr) []byte {
+	b = appendName(b, r.Name)
+	b = appendUint16(appendUint16(b, r.Type), r.Class)
+	b = appendUint32(b, r.TTL)
+	return append(appendUint16(b, uint16(len(r.Data))), r.Data...)
+}
+
+// marshal encodes m.
+func (m *message) marshal() []byte {
+	b := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(b, m.ID)
+	b[2] = m.Opcode << 3
+	if m.Response {
+		b[2] |= 0x80
+	}
+	b[3] = m.Rcode & 0x0f
+	for i, n := range []int{len(m.Zone), len(m.Prereq), len(m.Update), len(m.Extra)} {
+		binary.BigEndian.PutUint16(b[4+2*i:], uint16(n))
+	}
+	for _, z := range m.Zone {
+		b = appendUint16(appendUint16(appendName(b, z.Name), z.Type), z.Class)
+	}
+	for _, section := range [][]rr{m.Prereq, m.Update, m.Extra} {
+		for _, r := range section {
+			b = appendRR(b, r)
+		}
+	}
+	return b
+}
+
+// readName reads a possibly compressed name at b[off:], returning it and
+// the offset after it.
+func readName(b []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(b) {
+			return "", 0, errMalformed
+		}
+		n := int(b[off])
+		switch {
+		case n == 0:
+			off++
+			if next < 0 {
+				next = off
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(b) || jumps > 16 {
+				return "", 0, errMalformed
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(b[off:]) & 0x3fff)
+			jumps++
+		default:
+			if off+1+n > len(b) {
+				return "", 0, errMalformed
+			}
+			labels = append(labels, string(b[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// parseMessage decodes b.
+func parseMessage(b []byte) (*message, error) {
+	if len(b) < 12 {
+		return nil, errMalformed
+	}
+	m := &message{
+		ID:       binary.BigEndian.Uint16(b),
+		Response: b[2]&0x80 != 0,
+		Opcode:   (b[2] >> 3) & 0x0f,
+		Rcode:    b[3] & 0x0f,
+	}
+	off := 12
+	for i := 0; i < int(binary.BigEndian.Uint16(b[4:])); i++ {
+		name, n, err := readName(b, off)
+		if err != nil || n+4 > len(b) {
+			return nil, errMalformed
+		}
+		m.Zone = append(m.Zone, rr{Name: name, Type: binary.BigEndian.Uint16(b[n:]), Class: binary.BigEndian.Uint16(b[n+2:])})
+		off = n + 4
+	}
+	for i, section := range []*[]rr{&m.Prereq, &m.Update, &m.Extra} {
+		for j := 0; j < int(binary.BigEndian.Uint16(b[6+2*i:])); j++ {
+			start := off
+			name, n, err := readName(b, off)
+			if err != nil || n+10 > len(b) {
+				return nil, errMalformed
+			}
+			size := int(binary.BigEndian.Uint16(b[n+8:]))
+			if n+10+size > len(b) {
+				return nil, errMalformed
+			}
+			*section = append(*section, rr{
+				Name:  name,
+				Type:  binary.BigEndian.Uint16(b[n:]),
+				Class: binary.BigEndian.Uint16(b[n+2:]),
+				TTL:   binary.BigEndian.Uint32(b[n+4:]),
+				Data:  b[n+10 : n+10+size],
+			})
+			off = n + 10 + size
+			if section == &m.Extra && (*section)[j].Type == typeTSIG {
+				m.tsigStart = start
+			}
+		}
+	}
+	return m, nil
+}
@@ -0,0 +1,197 @@
+package ddns
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"strings"
+	"time"
+)
+
+// TSIG algorithm names (RFC 8945 section 6)
+const (
+	HMACMD5    = "hmac-md5.sig-alg.reg.int."
+	HMACSHA1   = "hmac-sha1."
+	HMACSHA256 = "hmac-sha256."
+	HMACSHA512 = "hmac-sha512."
+)
+
+// TSIG error codes
+const (
+	tsigBadSig  = 16
+	tsigBadKey  = 17
+	tsigBadTime = 18
+)
+
+const tsigFudge = 300 // Seconds
+
+// Key is a TSIG key shared with the DNS server.
+type Key struct {
+	Name      string // e.g. "dhcp-key."
+	Algorithm string // Defaults to HMACSHA256
+	Secret    []byte
+}
+
+var (
+	errNoTSIG     = errors.New("ddns: response not signed")
+	errBadTSIG    = errors.New("ddns: bad TSIG signature")
+	errBadTSIGAlg = errors.New("ddns: unsupported TSIG algorithm")
+)
+
+func (k *Key) algorithm() string {
+	if k.Algorithm == "" {
+		return HMACSHA256
+	}
+	return fqdn(strings.ToLower(k.Algorithm))
+}
+
+func (k *Key) hash() (func() hash.Hash, error) {
+	switch k.algorithm() {
+	case HMACMD5:
+		return md5.New, nil
+	case HMACSHA1:
+		return sha1.New, nil
+	case HMACSHA256:
+		return sha256.New, nil
+	case HMACSHA512:
+		return sha512.New, nil
+	}
+	return nil, errBadTSIGAlg
+}
+
+// tsigVariables holds the TSIG fields covered by the MAC.
+type tsigVariables struct {
+	Algorithm string
+	Time      time.Time
+	Fudge     uint16
+	Error     uint16
+	Other     []byte
+}
+
+// mac computes the TSIG MAC of msg (the wire message without its TSIG
+// record), as for RFC 8945 section 4.3.
+func (k *Key) mac(requestMAC, msg []byte, v tsigVariables) ([]byte, error) {
+	h, err := k.hash()
+	if err != nil {
+		return nil, err
+	}
+	m := hmac.New(h, k.Secret)
+	if requestMAC != nil {
+		m.Write(appendUint16(nil, uint16(len(requestMAC))))
+		m.Write(requestMAC)
+	}
+	m.Write(msg)
+	b := canonicalName(k.Name)
+	b = appendUint32(appendUint16(b, classANY), 0)
+	b = append(b, canonicalName(v.Algorithm)...)
+	b = appendTime(b, v.Time)
+	b = appendUint16(appendUint16(b, v.Fudge), v.Error)
+	b = append(appendUint16(b, uint16(len(v.Other))), v.Other...)
+	m.Write(b)
+	return m.Sum(nil), nil
+}
+
+func appendTime(b []byte, t time.Time) []byte {
+	s := uint64(t.Unix())
+	return appendUint32(appendUint16(b, uint16(s>>32)), uint32(s))
+}
+
+// sign encodes m with a TSIG record appended, returning the message and its
+// MAC.  requestMAC is the MAC of the request being answered, if any.
+func (k *Key) sign(m *message, requestMAC []byte, now time.Time, tsigErr uint16) ([]byte, []byte, error) {
+	msg := m.marshal()
+	v := tsigVariables{Algorithm: k.algorithm(), Time: now, Fudge: tsigFudge, Error: tsigErr}
+	mac, err := k.mac(requestMAC, msg, v)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tsigErr == tsigBadSig || tsigErr == tsigBadKey {
+		mac = nil
+	}
+	rdata := appendName(nil, v.Algorithm)
+	rdata = appendUint16(appendTime(rdata, v.Time), v.Fudge)
+	rdata = append(appendUint16(rdata, uint16(len(mac))), mac...)
+	rdata = appendUint16(appendUint16(rdata, m.ID), v.Error)
+	rdata = appendUint16(rdata, 0)
+	msg = appendRR(msg, rr{Name: fqdn(k.Name), Type: typeTSIG, Class: classANY, Data: rdata})
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(m.Extra)+1))
+	return msg, mac, nil
+}
+
+// tsigRecord is a decoded TSIG record.
+type tsigRecord struct {
+	tsigVariables
+	Key        string
+	MAC        []byte
+	OriginalID uint16
+}
+
+func parseTSIG(r rr) (*tsigRecord, error) {
+	alg, n, err := readName(r.Data, 0)
+	if err != nil || n+10 > len(r.Data) {
+		return nil, errMalformed
+	}
+	d := r.Data[n:]
+	t := &tsigRecord{Key: r.Name}
+	t.Algorithm = alg
+	t.Time = time.Unix(int64(uint64(binary.BigEndian.Uint16(d))<<32|uint64(binary.BigEndian.Uint32(d[2:]))), 0)
+	t.Fudge = binary.BigEndian.Uint16(d[6:])
+	size := int(binary.BigEndian.Uint16(d[8:]))
+	if 10+size+6 > len(d) {
+		return nil, errMalformed
+	}
+	t.MAC = d[10 : 10+size]
+	d = d[10+size:]
+	t.OriginalID = binary.BigEndian.Uint16(d)
+	t.Error = binary.BigEndian.Uint16(d[2:])
+	size = int(binary.BigEndian.Uint16(d[4:]))
+	if 6+size > len(d) {
+		return nil, errMalformed
+	}
+	t.Other = d[6 : 6+size]
+	return t, nil
+}
+
+// verify checks the TSIG record of the parsed message m, whose wire form is
+// b, returning the record.  The record's Error is set to the TSIG error to
+// report, if any, rather than an error being returned.
+func (k *Key) verify(m *message, b []byte, requestMAC []byte, now time.Time) (*tsigRecord, error) {
+	if len(m.Extra) == 0 || m.Extra[len(m.Extra)-1].Type != typeTSIG {
+		return nil, errNoTSIG
+	}
+	t, err := parseTSIG(m.Extra[len(m.Extra)-1])
+	if err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(t.Key, fqdn(k.Name)) || !strings.EqualFold(t.Algorithm, k.algorithm()) {
+		t.Error = tsigBadKey
+		return t, nil
+	}
+	msg := append([]byte(nil), b[:m.tsigStart]...)
+	binary.BigEndian.PutUint16(msg, t.OriginalID)
+	binary.BigEndian.PutUint16(msg[10:], uint16(len(m.Extra)-1))
+	mac, err := k.mac(requestMAC, msg, t.tsigVariables)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case !hmac.Equal(mac, t.MAC):
+		t.Error = tsigBadSig
+	case now.Sub(t.Time) > time.Duration(t.Fudge)*time.Second || t.Time.Sub(now) > time.Duration(t.Fudge)*time.Second:
+		t.Error = tsigBadTime
+	}
+	return t, nil
+}
+
+// fqdn returns name with a trailing dot.
+func fqdn(name string) string {
+	if strings.HasSuffix(name, ".") {
+		return name
+	}
+	return name + "."
+}
@@ -0,0 +1,204 @@
+package failover
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
+
+//go:generate stringer -type=MessageType,BindingStatus,State -output=types_string.go
+
+type MessageType byte
+
+// Failover message types
+const (
+	PoolReq    MessageType = 1
+	PoolResp   MessageType = 2
+	BndUpd     MessageType = 3
+	BndAck     MessageType = 4
+	Connect    MessageType = 5
+	ConnectAck MessageType = 6
+	UpdReqAll  MessageType = 7
+	UpdDone    MessageType = 8
+	UpdReq     MessageType = 9
+	StateMsg   MessageType = 10
+	Contact    MessageType = 11
+	Disconnect MessageType = 12
+)
+
+type OptionCode uint16
+
+// Failover options
+const (
+	OptionAddressesTransferred    OptionCode = 1
+	OptionAssignedIPAddress       OptionCode = 2
+	OptionBindingStatus           OptionCode = 3
+	OptionClientIdentifier        OptionCode = 4
+	OptionClientHardwareAddress   OptionCode = 5
+	OptionClientLastTransaction   OptionCode = 6
+	OptionClientReplyOptions      OptionCode = 7
+	OptionClientRequestOptions    OptionCode = 8
+	OptionHashBucketAssignment    OptionCode = 11
+	OptionLeaseExpirationTime     OptionCode = 13
+	OptionMaxUnackedBndUpd        OptionCode = 14
+	OptionMCLT                    OptionCode = 15
+	OptionMessage                 OptionCode = 16
+	OptionPotentialExpirationTime OptionCode = 18
+	OptionReceiveTimer            OptionCode = 19
+	OptionProtocolVersion         OptionCode = 20
+	OptionRejectReason            OptionCode = 21
+	OptionRelationshipName        OptionCode = 22
+	OptionServerFlags             OptionCode = 23
+	OptionServerState             OptionCode = 24
+	OptionStartTimeOfState        OptionCode = 25
+)
+
+type BindingStatus byte
+
+// Binding states
+const (
+	Free      BindingStatus = 1
+	Active    BindingStatus = 2
+	Expired   BindingStatus = 3
+	Released  BindingStatus = 4
+	Abandoned BindingStatus = 5
+	Reset     BindingStatus = 6
+	Backup    BindingStatus = 7
+)
+
+type State byte
+
+// Server states.  Only those needed without shared storage are implemented.
+// A server without bindings it can vouch for (as after a restart) requests
+// them all from its partner, in place of RECOVER, then waits out the MCLT in
+// RecoverWait before leasing new addresses, as clients may still hold leases
+// it granted that the partner never learnt of.
+const (
+	Startup                   State = 1
+	Normal                    State = 2
+	CommunicationsInterrupted State = 3
+	PartnerDown               State = 4
+	Shutdown                  State = 8
+	RecoverWait               State = 254
+)
+
+const protocolVersion = 1
+
+// Message header: length(2) type(1) payload offset(1) time(4) xid(4)
+const headerLen = 12
+
+// Message is a failover protocol message.
+type Message struct {
+	Type    MessageType
+	Time    time.Time // Sender's time
+	XId     uint32
+	Options map[OptionCode][]byte
+}
+
+func newMessage(t MessageType, xid uint32) *Message {
+	return &Message{Type: t, XId: xid, Options: make(map[OptionCode][]byte)}
+}
+
+// Marshal encodes m.  Options are written in code order.
+func (m *Message) Marshal() []byte {
+	b := make([]byte, headerLen, 128)
+	b[2] = byte(m.Type)
+	b[3] = headerLen
+	binary.BigEndian.PutUint32(b[4:], uint32(m.Time.Unix()))
+	binary.BigEndian.PutUint32(b[8:], m.XId)
+	for code := 0; code < 256; code++ {
+		v, ok := m.Options[OptionCode(code)]
+		if !ok {
+			continue
+		}
+		var opt [4]byte
+		binary.BigEndian.PutUint16(opt[:], uint16(code))
+		binary.BigEndian.PutUint16(opt[2:], uint16(len(v)))
+		b = append(append(b, opt[:]...), v...)
+	}
+	binary.BigEndian.PutUint16(b, uint16(len(b)))
+	return b
+}
+
+var errShortMessage = errors.New("failover: malformed message")
+
+// ReadMessage reads a message from r.
+func ReadMessage(r io.Reader) (*Message, error) {
+	var header [headerLen]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint16(header[:]))
+	offset := int(header[3])
+	if size < headerLen || offset < headerLen || offset > size {
+		return nil, errShortMessage
+	}
+	body := make([]byte, size-headerLen)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	m := newMessage(MessageType(header[2]), binary.BigEndian.Uint32(header[8:]))
+	m.Time = time.Unix(int64(binary.BigEndian.Uint32(header[4:])), 0)
+	opts := body[offset-headerLen:]
+	for len(opts) >= 4 {
+		code := OptionCode(binary.BigEndian.Uint16(opts))
+		n := int(binary.BigEndian.Uint16(opts[2:]))
+		if len(opts) < 4+n {
+			return nil, errShortMessage
+		}
+		m.Options[code] = opts[4 : 4+n]
+		opts = opts[4+n:]
+	}
+	if len(opts) != 0 {
+		return nil, errShortMessage
+	}
+	return m, nil
+}
+
+// Option accessors; missing or short options read as zero values.
+
+func (m *Message) setUint32(code OptionCode, v uint32) {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+	m.Options[code] = b
+}
+
+func (m *Message) uint32(code OptionCode) uint32 {
+	if v := m.Options[code]; len(v) == 4 {
+		return binary.BigEndian.Uint32(v)
+	}
+	return 0
+}
+
+func (m *Message) setTime(code OptionCode, t time.Time) {
+	if t.IsZero() {
+		m.setUint32(code, 0xffffffff) // Infinite
+		return
+	}
+	m.setUint32(code, uint32(t.Unix()))
+}
+
+func (m *Message) time(code OptionCode) time.Time {
+	switch v := m.uint32(code); v {
+	case 0, 0xffffffff:
+		return time.Time{}
+	default:
+		return time.Unix(int64(v), 0)
+	}
+}
+
+func (m *Message) byte(code OptionCode) byte {
+	if v := m.Options[code]; len(v) == 1 {
+		return v[0]
+	}
+	return 0
+}
+
+func (m *Message) ip(code OptionCode) net.IP {
+	if v := m.Options[code]; len(v) == 4 {
+		return net.IP(append([]byte(nil), v...))
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package failover
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	m := newMessage(BndUpd, 42)
+	m.Time = time.Unix(1500000000, 0)
+	m.Options[OptionAssignedIPAddress] = []byte{192, 168, 1, 10}
+	m.Options[OptionBindingStatus] = []byte{byte(Active)}
+	m.Options[OptionClientHardwareAddress] = []byte{1, 0, 1, 2, 3, 4, 5}
+	m.setTime(OptionLeaseExpirationTime, time.Unix(1500003600, 0))
+	m.setTime(OptionPotentialExpirationTime, time.Time{})
+
+	b := m.Marshal()
+	got, err := ReadMessage(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("Round trip, unexpected message: %+v != %+v", got, m)
+	}
+	if e := got.time(OptionLeaseExpirationTime); !e.Equal(time.Unix(1500003600, 0)) {
+		t.Fatalf("Lease expiration, unexpected time: %v", e)
+	}
+	if e := got.time(OptionPotentialExpirationTime); !e.IsZero() {
+		t.Fatalf("Infinite expiration, unexpected time: %v", e)
+	}
+	if s := BindingStatus(got.byte(OptionBindingStatus)); s != Active {
+		t.Fatalf("Binding status, unexpected: %v", s)
+	}
+
+	for i, n := range []int{1, headerLen - 1, headerLen + 2, len(b) - 1} {
+		if _, err := ReadMessage(bytes.NewReader(b[:n])); err == nil {
+			t.Fatalf("%02d: truncated to %d, expected error", i, n)
+		}
+	}
+	bad := append([]byte(nil), b...)
+	bad[len(b)-6] = 0xff // Option length past end of message
+	if _, err := ReadMessage(bytes.NewReader(bad)); err != errShortMessage {
+		t.Fatalf("Bad option length, unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,705 @@
+// Package failover implements the DHCP failover protocol
+// (draft-ietf-dhc-failover-12, as used by ISC dhcpd) between two dhcp4
+// servers, providing high availability without shared storage.
+//
+// The two servers, a primary and a secondary, keep each other informed of
+// every lease with binding updates over TCP, and split the free addresses of
+// their pool between them.  While in contact, each answers only the clients
+// whose RFC 3074 load balancing hash falls in its half of the hash buckets.
+// If contact is lost, each answers all clients from its own free addresses,
+// with lease times limited by the Maximum Client Lead Time (MCLT), until the
+// partner returns or is declared down.
+//
+// A Peer is attached to a dhcp4.Server by setting it as the server's Policy,
+// and wrapping the server with Peer.Handler:
+//
+//	s := dhcp4.NewServer(...)
+//	p := failover.New(cfg, s.Leases())
+//	s.Policy = p
+//	go p.DialAndServe("primary:647") // Or p.Serve(listener) on the primary
+//	dhcp4.Serve(conn, p.Handler(s))
+package failover
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Role of a server in a failover relationship.
+type Role byte
+
+const (
+	Primary   Role = 1
+	Secondary Role = 2
+)
+
+// Config holds the settings of one side of a failover relationship.
+type Config struct {
+	Role Role
+	Name string // Relationship name, which must match the partner's
+	// Maximum Client Lead Time: how far a lease granted to a client may run
+	// beyond what the partner knows of.  Defaults to an hour.
+	MCLT time.Duration
+	// Split is the number of hash buckets (of 256) served by the primary;
+	// the secondary serves the rest.  Defaults to 128.
+	Split int
+	// ResponseDelay is how long without hearing from the partner before
+	// contact is considered lost.  Defaults to a minute.
+	ResponseDelay time.Duration
+	// AutoPartnerDown, if non zero, is how long to wait after losing contact
+	// before assuming the partner is down.  Otherwise an operator must call
+	// Peer.SetPartnerDown.
+	AutoPartnerDown time.Duration
+	// The pool shared by the two servers.
+	Start net.IP
+	Range int
+}
+
+// How often the secondary asks the primary to rebalance the pool.
+const balanceInterval = time.Minute
+
+// How long the secondary waits between connection attempts.
+const redialDelay = time.Second
+
+// Peer is one side of a failover relationship.  It is a dhcp4.LeasePolicy.
+type Peer struct {
+	cfg       Config
+	clock     dhcp4.Clock
+	leases    *dhcp4.LeaseManager
+	buckets   dhcp4.HashBuckets // Hash buckets served while in contact
+	cancelSub func()
+
+	mu         sync.Mutex
+	state      State
+	stateStart time.Time
+	stateTimer dhcp4.Timer
+	everSynced bool                 // Ever received a full update
+	backup     map[string]bool      // Free addresses owned by the secondary
+	acked      map[string]time.Time // Potential expiry acked by the partner
+	dirty      map[string]bool      // Addresses with changes not yet acked
+	sent       map[uint32]sentUpdate
+	xid        uint32
+	conn       net.Conn
+	writeMu    sync.Mutex
+	closed     bool
+	listener   net.Listener
+}
+
+type sentUpdate struct {
+	ip        string
+	potential time.Time
+}
+
+var errClosed = errors.New("failover: peer closed")
+
+// New returns a Peer for leases, starting in the startup state.
+func New(cfg Config, leases *dhcp4.LeaseManager) *Peer {
+	return newPeer(cfg, leases, realClock{})
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                                  { return time.Now() }
+func (realClock) AfterFunc(d time.Duration, f func()) dhcp4.Timer { return time.AfterFunc(d, f) }
+
+// newPeer returns a Peer whose state timers and lease times follow clock.
+func newPeer(cfg Config, leases *dhcp4.LeaseManager, clock dhcp4.Clock) *Peer {
+	if cfg.MCLT == 0 {
+		cfg.MCLT = time.Hour
+	}
+	if cfg.Split == 0 {
+		cfg.Split = 128
+	}
+	if cfg.ResponseDelay == 0 {
+		cfg.ResponseDelay = time.Minute
+	}
+	p := &Peer{
+		cfg:    cfg,
+		clock:  clock,
+		leases: leases,
+		backup: make(map[string]bool),
+		acked:  make(map[string]time.Time),
+		dirty:  make(map[string]bool),
+		sent:   make(map[uint32]sentUpdate),
+	}
+	if cfg.Role == Primary {
+		p.buckets = dhcp4.BucketRange(0, cfg.Split-1)
+	} else {
+		p.buckets = dhcp4.BucketRange(cfg.Split, 255)
+	}
+	p.mu.Lock()
+	p.setState(Startup)
+	p.mu.Unlock()
+	p.cancelSub = leases.Subscribe(p.leaseEvent)
+	return p
+}
+
+// State returns the peer's current failover state.
+func (p *Peer) State() State {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.state
+}
+
+// SetPartnerDown declares the partner down, allowing this server to lease
+// all of the pool once the MCLT has passed.  Only use this when certain the
+// partner isn't serving clients.
+func (p *Peer) SetPartnerDown() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.conn == nil {
+		p.setState(PartnerDown)
+	}
+}
+
+// setState changes state, arming any timer for leaving it.  p.mu must be
+// held.
+func (p *Peer) setState(s State) {
+	if p.state == s {
+		return
+	}
+	p.state, p.stateStart = s, p.clock.Now()
+	if p.stateTimer != nil {
+		p.stateTimer.Stop()
+		p.stateTimer = nil
+	}
+	switch {
+	case s == Startup:
+		p.stateTimer = p.clock.AfterFunc(p.cfg.ResponseDelay, func() { p.timeout(Startup, CommunicationsInterrupted) })
+	case s == RecoverWait:
+		p.stateTimer = p.clock.AfterFunc(p.cfg.MCLT, p.recovered)
+	case s == CommunicationsInterrupted && p.cfg.AutoPartnerDown > 0:
+		p.stateTimer = p.clock.AfterFunc(p.cfg.AutoPartnerDown, func() { p.timeout(CommunicationsInterrupted, PartnerDown) })
+	}
+	if p.conn != nil {
+		go p.send(p.stateMessage())
+	}
+}
+
+func (p *Peer) timeout(from, to State) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state == from && !p.closed {
+		p.setState(to)
+	}
+}
+
+// recovered ends RecoverWait, once the MCLT has passed.
+func (p *Peer) recovered() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.state != RecoverWait || p.closed {
+		return
+	}
+	if p.conn != nil {
+		p.setState(Normal)
+	} else {
+		p.setState(CommunicationsInterrupted)
+	}
+}
+
+// Close disconnects from the partner and stops the peer.
+func (p *Peer) Close() error {
+	p.mu.Lock()
+	p.closed = true
+	if p.stateTimer != nil {
+		p.stateTimer.Stop()
+	}
+	c, l := p.conn, p.listener
+	p.mu.Unlock()
+	p.cancelSub()
+	if c != nil {
+		p.send(newMessage(Disconnect, 0))
+		c.Close()
+	}
+	if l != nil {
+		l.Close()
+	}
+	return nil
+}
+
+// Serve accepts connections from the secondary on l, serving one at a time,
+// until the peer is closed.
+func (p *Peer) Serve(l net.Listener) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return errClosed
+	}
+	p.listener = l
+	p.mu.Unlock()
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if p.isClosed() {
+				return errClosed
+			}
+			return err
+		}
+		p.mu.Lock()
+		busy := p.conn != nil
+		p.mu.Unlock()
+		if busy {
+			c.Close()
+			continue
+		}
+		go p.serveConn(c, false)
+	}
+}
+
+// DialAndServe connects to the primary at addr, reconnecting whenever the
+// connection is lost, until the peer is closed.
+func (p *Peer) DialAndServe(addr string) error {
+	for !p.isClosed() {
+		c, err := net.DialTimeout("tcp", addr, p.cfg.ResponseDelay)
+		if err == nil {
+			p.serveConn(c, true)
+		}
+		time.Sleep(redialDelay)
+	}
+	return errClosed
+}
+
+func (p *Peer) isClosed() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closed
+}
+
+func (p *Peer) nextXId() uint32 {
+	p.xid++
+	return p.xid
+}
+
+func (p *Peer) send(m *Message) error {
+	p.mu.Lock()
+	c := p.conn
+	p.mu.Unlock()
+	if c == nil {
+		return errClosed
+	}
+	m.Time = p.clock.Now()
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	c.SetWriteDeadline(time.Now().Add(p.cfg.ResponseDelay))
+	_, err := c.Write(m.Marshal())
+	return err
+}
+
+// serveConn runs the protocol over c until it fails.
+func (p *Peer) serveConn(c net.Conn, initiator bool) {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(p.cfg.ResponseDelay))
+	if initiator {
+		m := newMessage(Connect, 0)
+		m.Options[OptionRelationshipName] = []byte(p.cfg.Name)
+		m.setUint32(OptionMCLT, uint32(p.cfg.MCLT/time.Second))
+		m.Options[OptionHashBucketAssignment] = p.buckets[:]
+		m.Options[OptionProtocolVersion] = []byte{protocolVersion}
+		m.setUint32(OptionReceiveTimer, uint32(p.cfg.ResponseDelay/time.Second))
+		m.Time = p.clock.Now()
+		if _, err := c.Write(m.Marshal()); err != nil {
+			return
+		}
+		if ack, err := ReadMessage(c); err != nil || ack.Type != ConnectAck || ack.byte(OptionRejectReason) != 0 {
+			return
+		}
+	} else {
+		m, err := ReadMessage(c)
+		if err != nil || m.Type != Connect {
+			return
+		}
+		ack := newMessage(ConnectAck, m.XId)
+		if string(m.Options[OptionRelationshipName]) != p.cfg.Name {
+			ack.Options[OptionRejectReason] = []byte{rejectInvalidPeer}
+			ack.Options[OptionMessage] = []byte("unknown relationship name")
+		}
+		ack.Time = p.clock.Now()
+		if _, err := c.Write(ack.Marshal()); err != nil || ack.byte(OptionRejectReason) != 0 {
+			return
+		}
+	}
+	c.SetDeadline(time.Time{})
+
+	p.mu.Lock()
+	if p.closed || p.conn != nil {
+		p.mu.Unlock()
+		return
+	}
+	p.conn = c
+	req := newMessage(UpdReq, p.nextXId())
+	if !p.everSynced {
+		req.Type = UpdReqAll
+	}
+	state := p.stateMessage()
+	p.mu.Unlock()
+
+	stop := make(chan struct{})
+	defer func() {
+		close(stop)
+		p.mu.Lock()
+		p.conn = nil
+		p.sent = make(map[uint32]sentUpdate)
+		if p.state == Normal || p.state == Startup {
+			p.setState(CommunicationsInterrupted)
+		}
+		p.mu.Unlock()
+	}()
+	go p.contact(stop)
+
+	if p.send(state) != nil || p.send(req) != nil {
+		return
+	}
+	for {
+		c.SetReadDeadline(time.Now().Add(p.cfg.ResponseDelay))
+		m, err := ReadMessage(c)
+		if err != nil || m.Type == Disconnect {
+			return
+		}
+		if p.handle(m) != nil {
+			return
+		}
+	}
+}
+
+// contact sends CONTACT messages often enough for the partner not to time
+// out, until stop is closed.
+func (p *Peer) contact(stop chan struct{}) {
+	t := time.NewTicker(p.cfg.ResponseDelay / 3)
+	defer t.Stop()
+	balance := time.NewTicker(balanceInterval)
+	defer balance.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-t.C:
+			p.send(newMessage(Contact, 0))
+		case <-balance.C:
+			if p.cfg.Role == Secondary && p.State() == Normal {
+				p.send(newMessage(PoolReq, 0))
+			}
+		}
+	}
+}
+
+// stateMessage returns a STATE message.  p.mu must be held.
+func (p *Peer) stateMessage() *Message {
+	m := newMessage(StateMsg, p.nextXId())
+	m.Options[OptionServerState] = []byte{byte(p.state)}
+	m.setTime(OptionStartTimeOfState, p.stateStart)
+	return m
+}
+
+// Reject reasons
+const (
+	rejectInvalidPeer      = 2
+	rejectOutdatedBinding  = 8
+	rejectIllegalIPAddress = 1
+)
+
+func (p *Peer) handle(m *Message) error {
+	switch m.Type {
+	case UpdReq, UpdReqAll:
+		p.sendUpdates(m.Type == UpdReqAll)
+		return p.send(newMessage(UpdDone, m.XId))
+
+	case UpdDone:
+		p.mu.Lock()
+		switch {
+		case !p.everSynced:
+			p.everSynced = true
+			p.setState(RecoverWait)
+		case p.state != RecoverWait: // Which only the MCLT ends
+			p.setState(Normal)
+		}
+		p.mu.Unlock()
+		if p.cfg.Role == Secondary {
+			return p.send(newMessage(PoolReq, 0))
+		}
+
+	case BndUpd:
+		return p.send(p.applyUpdate(m))
+
+	case BndAck:
+		p.mu.Lock()
+		if u, ok := p.sent[m.XId]; ok {
+			delete(p.sent, m.XId)
+			if m.byte(OptionRejectReason) == 0 {
+				delete(p.dirty, u.ip)
+				p.acked[u.ip] = u.potential
+			}
+		}
+		p.mu.Unlock()
+
+	case PoolReq:
+		if p.cfg.Role == Primary {
+			n := p.balance()
+			resp := newMessage(PoolResp, m.XId)
+			resp.setUint32(OptionAddressesTransferred, uint32(n))
+			return p.send(resp)
+		}
+	}
+	return nil
+}
+
+// leaseEvent marks the address changed, and sends a binding update if
+// connected to the partner.  Expiry isn't sent, as the partner expires its
+// copy of the binding itself; echoing it would race that, and each expiry
+// would bounce between the peers.
+func (p *Peer) leaseEvent(e dhcp4.LeaseEvent) {
+	if e.Type == dhcp4.LeaseOffered || e.Type == dhcp4.LeaseExpired {
+		return
+	}
+	ip := e.Lease.IP.String()
+	p.mu.Lock()
+	p.dirty[ip] = true
+	delete(p.backup, ip) // Leased addresses leave the free pool
+	var m *Message
+	if p.conn != nil {
+		m = p.bindingUpdate(e.Lease, statusOf(e.Type))
+	}
+	p.mu.Unlock()
+	if m != nil {
+		go p.send(m) // Don't hold up the DHCP server
+	}
+}
+
+func statusOf(t dhcp4.LeaseEventType) BindingStatus {
+	switch t {
+	case dhcp4.LeaseReleased:
+		return Released
+	case dhcp4.LeaseExpired:
+		return Expired
+	case dhcp4.LeaseDeclined:
+		return Abandoned
+	}
+	return Active
+}
+
+// leaseStatus returns the binding status describing l.
+func leaseStatus(l dhcp4.Lease, now time.Time) BindingStatus {
+	switch {
+	case l.Quarantined:
+		return Abandoned
+	case l.Expired(now):
+		return Expired
+	}
+	return Active
+}
+
+// bindingUpdate returns a BNDUPD for l, recording it as sent.  p.mu must be
+// held.
+func (p *Peer) bindingUpdate(l dhcp4.Lease, status BindingStatus) *Message {
+	m := newMessage(BndUpd, p.nextXId())
+	m.Options[OptionAssignedIPAddress] = l.IP.To4()
+	m.Options[OptionBindingStatus] = []byte{byte(status)}
+	if len(l.HardwareAddr) > 0 {
+		m.Options[OptionClientHardwareAddress] = append([]byte{1}, l.HardwareAddr...) // Ethernet
+	}
+	if len(l.ClientID) > 0 {
+		m.Options[OptionClientIdentifier] = l.ClientID
+	}
+	if l.Hostname != "" {
+		m.Options[OptionClientRequestOptions] = append([]byte{byte(dhcp4.OptionHostName), byte(len(l.Hostname))}, l.Hostname...)
+	}
+	m.setTime(OptionClientLastTransaction, l.Start)
+	m.setTime(OptionLeaseExpirationTime, l.Expiry)
+	potential := l.Expiry
+	if status == Active && !potential.IsZero() {
+		potential = potential.Add(p.cfg.MCLT)
+	}
+	m.setTime(OptionPotentialExpirationTime, potential)
+	p.sent[m.XId] = sentUpdate{ip: l.IP.String(), potential: potential}
+	return m
+}
+
+// sendUpdates sends a BNDUPD for every changed address, or all of them.
+func (p *Peer) sendUpdates(all bool) {
+	now := p.clock.Now()
+	var msgs []*Message
+	p.mu.Lock()
+	if all {
+		p.leases.Iterate(func(l dhcp4.Lease) bool {
+			msgs = append(msgs, p.bindingUpdate(l, leaseStatus(l, now)))
+			return true
+		})
+		if p.cfg.Role == Primary {
+			for ip := range p.backup {
+				msgs = append(msgs, p.bindingUpdate(dhcp4.Lease{IP: net.ParseIP(ip)}, Backup))
+			}
+		}
+	} else {
+		for ip := range p.dirty {
+			if l, ok := p.leases.Get(net.ParseIP(ip)); ok {
+				msgs = append(msgs, p.bindingUpdate(l, leaseStatus(l, now)))
+			} else {
+				msgs = append(msgs, p.bindingUpdate(dhcp4.Lease{IP: net.ParseIP(ip), Expiry: now}, Released))
+			}
+		}
+	}
+	p.mu.Unlock()
+	for _, m := range msgs {
+		if p.send(m) != nil {
+			return
+		}
+	}
+}
+
+// applyUpdate applies a BNDUPD from the partner, returning the BNDACK.
+func (p *Peer) applyUpdate(m *Message) *Message {
+	ack := newMessage(BndAck, m.XId)
+	ip := m.ip(OptionAssignedIPAddress)
+	ack.Options[OptionAssignedIPAddress] = ip
+	if ip == nil || (p.cfg.Range > 0 && !dhcp4.IPInRange(p.cfg.Start, dhcp4.IPAdd(p.cfg.Start, p.cfg.Range-1), ip)) {
+		ack.Options[OptionRejectReason] = []byte{rejectIllegalIPAddress}
+		return ack
+	}
+	l := dhcp4.Lease{
+		IP:       ip,
+		ClientID: m.Options[OptionClientIdentifier],
+		Start:    m.time(OptionClientLastTransaction),
+		Expiry:   m.time(OptionLeaseExpirationTime),
+	}
+	if hw := m.Options[OptionClientHardwareAddress]; len(hw) > 1 {
+		l.HardwareAddr = net.HardwareAddr(hw[1:])
+	}
+	if ro := m.Options[OptionClientRequestOptions]; len(ro) > 2 && dhcp4.OptionCode(ro[0]) == dhcp4.OptionHostName && len(ro) >= 2+int(ro[1]) {
+		l.Hostname = string(ro[2 : 2+ro[1]])
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := ip.String()
+	status := BindingStatus(m.byte(OptionBindingStatus))
+	switch status {
+	case Backup:
+		p.backup[key] = true
+		return ack
+	case Free:
+		delete(p.backup, key)
+		return ack
+	}
+
+	// When both servers changed the binding while out of contact, the most
+	// recent transaction wins.
+	if old, ok := p.leases.Get(ip); ok && p.dirty[key] && old.Start.Truncate(time.Second).After(l.Start) &&
+		!(bytes.Equal(old.HardwareAddr, l.HardwareAddr) && bytes.Equal(old.ClientID, l.ClientID)) {
+		ack.Options[OptionRejectReason] = []byte{rejectOutdatedBinding}
+		return ack
+	}
+	delete(p.dirty, key)
+	delete(p.backup, key)
+	switch status {
+	case Active:
+		p.leases.Put(l)
+	case Abandoned:
+		p.leases.Quarantine(ip, dhcp4.DefaultQuarantine)
+	case Expired: // Keep the client, for its return
+		if old, ok := p.leases.Get(ip); ok && old.Expiry.Truncate(time.Second).Equal(l.Expiry) &&
+			bytes.Equal(old.HardwareAddr, l.HardwareAddr) && bytes.Equal(old.ClientID, l.ClientID) {
+			break // Our copy expires by itself
+		}
+		p.leases.Put(l)
+	default:
+		p.leases.Delete(ip)
+	}
+	return ack
+}
+
+// balance gives the secondary half of the free addresses, returning how many
+// were transferred.
+func (p *Peer) balance() int {
+	now := p.clock.Now()
+	p.mu.Lock()
+	var free []string
+	owned := 0
+	for i := 0; i < p.cfg.Range; i++ {
+		ip := dhcp4.IPAdd(p.cfg.Start, i)
+		if l, ok := p.leases.Get(ip); ok && !l.Expired(now) {
+			continue
+		}
+		if p.backup[ip.String()] {
+			owned++
+		} else {
+			free = append(free, ip.String())
+		}
+	}
+	var msgs []*Message
+	for target := (owned + len(free)) / 2; owned < target; owned++ {
+		ip := free[len(free)-1] // Give away from the top of the range
+		free = free[:len(free)-1]
+		p.backup[ip] = true
+		msgs = append(msgs, p.bindingUpdate(dhcp4.Lease{IP: net.ParseIP(ip)}, Backup))
+	}
+	p.mu.Unlock()
+	for _, m := range msgs {
+		p.send(m)
+	}
+	return len(msgs)
+}
+
+// Allocatable returns true if this server may lease ip to a new client: the
+// primary leases free addresses, the secondary its backup addresses.  Once
+// the partner has been down for the MCLT, either may lease any address.
+// Neither leases new addresses in RecoverWait.
+func (p *Peer) Allocatable(ip net.IP) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch p.state {
+	case Startup, Shutdown, RecoverWait:
+		return false
+	case PartnerDown:
+		if p.clock.Now().Sub(p.stateStart) >= p.cfg.MCLT {
+			return true
+		}
+	}
+	return p.backup[ip.String()] == (p.cfg.Role == Secondary)
+}
+
+// LeaseTime limits lease times so that no client's lease runs more than the
+// MCLT beyond the expiry time the partner has acknowledged.
+func (p *Peer) LeaseTime(ip net.IP, d time.Duration) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := p.clock.Now()
+	limit := now.Add(p.cfg.MCLT)
+	if acked := p.acked[ip.String()]; acked.After(limit) {
+		limit = acked
+	}
+	if max := limit.Sub(now); d > max {
+		return max
+	}
+	return d
+}
+
+// Handler returns a Handler that passes requests to h that this server
+// should answer.  While in contact with the partner, or waiting out the MCLT
+// after a full update, clients are answered by the server owning their hash
+// bucket (see dhcp4.LoadBalancer); requests naming a server identifier go
+// to h, which ignores those for other servers.  Otherwise all requests are
+// answered, except during startup.
+func (p *Peer) Handler(h dhcp4.Handler) dhcp4.Handler {
+	return &handler{p: p, h: h, lb: dhcp4.NewLoadBalancer(h, nil, p.buckets)}
+}
+
+type handler struct {
+	p  *Peer
+	h  dhcp4.Handler
+	lb *dhcp4.LoadBalancer
+}
+
+func (h *handler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	switch h.p.State() {
+	case Startup, Shutdown:
+		return nil
+	case Normal, RecoverWait:
+		return h.lb.ServeDHCP(req, msgType, options)
+	}
+	return h.h.ServeDHCP(req, msgType, options)
+}
@@ -0,0 +1,333 @@
+package failover
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// fakeClock is a dhcp4.Clock whose time only moves when told to.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(1400000000, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) dhcp4.Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves time forward by d, one second at a time, firing due timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	for end := c.Now().Add(d); c.Now().Before(end); {
+		c.mu.Lock()
+		c.now = c.now.Add(time.Second)
+		var due []*fakeTimer
+		pending := c.timers[:0]
+		for _, t := range c.timers {
+			if t.stopped {
+				continue
+			}
+			if !t.when.After(c.now) {
+				due = append(due, t)
+			} else {
+				pending = append(pending, t)
+			}
+		}
+		c.timers = pending
+		c.mu.Unlock()
+		for _, t := range due {
+			t.f()
+		}
+	}
+}
+
+type testServer struct {
+	ip      net.IP
+	server  *dhcp4.Server
+	peer    *Peer
+	handler dhcp4.Handler
+}
+
+var testStart = net.IP{192, 168, 1, 10}
+
+const testRange = 20
+
+func newTestServer(role Role, ip net.IP, mclt time.Duration, clock *fakeClock) *testServer {
+	leases := dhcp4.NewLeaseManager(dhcp4.NewMemoryLeaseStore(), clock)
+	s := dhcp4.NewServer(ip, testStart, testRange, time.Hour, dhcp4.Options{}, leases)
+	p := newPeer(Config{
+		Role:          role,
+		Name:          "test",
+		MCLT:          mclt,
+		ResponseDelay: 300 * time.Millisecond,
+		Start:         testStart,
+		Range:         testRange,
+	}, s.Leases(), clock)
+	s.Policy = p
+	return &testServer{ip: ip, server: s, peer: p, handler: p.Handler(s)}
+}
+
+// startTestPair returns a primary and secondary in contact over loopback,
+// both waiting out the MCLT after their first full update.
+func startTestPair(t *testing.T, mclt time.Duration, clock *fakeClock) (*testServer, *testServer, net.Listener) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	primary := newTestServer(Primary, net.IP{192, 168, 1, 1}, mclt, clock)
+	secondary := newTestServer(Secondary, net.IP{192, 168, 1, 2}, mclt, clock)
+	go primary.peer.Serve(l)
+	go secondary.peer.DialAndServe(l.Addr().String())
+	waitFor(t, "recover wait", func() bool {
+		return primary.peer.State() == RecoverWait && secondary.peer.State() == RecoverWait
+	})
+	return primary, secondary, l
+}
+
+// newTestPair returns a primary and secondary in contact over loopback, in
+// the normal state.
+func newTestPair(t *testing.T, mclt time.Duration, clock *fakeClock) (*testServer, *testServer, net.Listener) {
+	primary, secondary, l := startTestPair(t, mclt, clock)
+	clock.Advance(mclt)
+	waitFor(t, "normal", func() bool {
+		return primary.peer.State() == Normal && secondary.peer.State() == Normal
+	})
+	return primary, secondary, l
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	for deadline := time.Now().Add(5 * time.Second); !cond(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", what)
+		}
+	}
+}
+
+func exchange(h dhcp4.Handler, mt dhcp4.MessageType, chAddr net.HardwareAddr, options []dhcp4.Option) dhcp4.Packet {
+	req := dhcp4.RequestPacket(mt, chAddr, nil, []byte{1, 2, 3, 4}, true, options)
+	return h.ServeDHCP(req, mt, req.ParseOptions())
+}
+
+// lease gets chAddr a lease from whichever server answers, returning it.
+func lease(t *testing.T, chAddr net.HardwareAddr, servers ...*testServer) (*testServer, net.IP) {
+	for _, s := range servers {
+		offer := exchange(s.handler, dhcp4.Discover, chAddr, nil)
+		if offer == nil {
+			continue
+		}
+		ip := append(net.IP(nil), offer.YIAddr()...)
+		ack := exchange(s.handler, dhcp4.Request, chAddr, []dhcp4.Option{
+			{Code: dhcp4.OptionServerIdentifier, Value: s.ip},
+			{Code: dhcp4.OptionRequestedIPAddress, Value: ip},
+		})
+		if ack == nil || dhcp4.MessageType(ack.ParseOptions()[dhcp4.OptionDHCPMessageType][0]) != dhcp4.ACK {
+			t.Fatalf("%s: Request, expected ACK", chAddr)
+		}
+		return s, ip
+	}
+	t.Fatalf("%s: Discover, no server answered", chAddr)
+	return nil, nil
+}
+
+func backupCount(p *Peer) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.backup)
+}
+
+func TestPeer(t *testing.T) {
+	primary, secondary, l := newTestPair(t, time.Hour, newFakeClock())
+	defer primary.peer.Close()
+	defer secondary.peer.Close()
+
+	// The secondary is given half the free addresses
+	waitFor(t, "pool balance", func() bool { return backupCount(secondary.peer) == testRange/2 })
+	if n := backupCount(primary.peer); n != testRange/2 {
+		t.Fatalf("Primary, unexpected backup count: %d", n)
+	}
+
+	// Exactly one server answers each client, and the lease reaches the other
+	answered := map[*testServer]int{}
+	owners := map[string]*testServer{}
+	for i := 0; i < 8; i++ {
+		mac := net.HardwareAddr{0, 1, 2, 3, 4, byte(i)}
+		s, ip := lease(t, mac, primary, secondary)
+		answered[s]++
+		owners[mac.String()] = s
+		other := primary
+		if s == primary {
+			other = secondary
+		}
+		if offer := exchange(other.handler, dhcp4.Discover, mac, nil); offer != nil {
+			t.Fatalf("%02d: both servers answered %s", i, mac)
+		}
+		waitFor(t, "binding update", func() bool {
+			l, ok := other.server.Leases().Get(ip)
+			return ok && l.HardwareAddr.String() == mac.String()
+		})
+		// Lease times are limited to the MCLT beyond what the partner acked
+		waitFor(t, "binding ack", func() bool { return s.peer.LeaseTime(ip, 24*time.Hour) > time.Hour })
+		if d := s.peer.LeaseTime(ip, 24*time.Hour); d > 2*time.Hour {
+			t.Fatalf("%02d: unexpected lease time after ack: %v", i, d)
+		}
+	}
+	if answered[primary] == 0 || answered[secondary] == 0 {
+		t.Fatalf("Unexpected load balance: %v", answered)
+	}
+	if d := primary.peer.LeaseTime(dhcp4.IPAdd(testStart, testRange-1), 24*time.Hour); d > time.Hour {
+		t.Fatalf("Unacked address, unexpected lease time: %v", d)
+	}
+
+	// Release reaches the partner
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 0}
+	owner := owners[mac.String()]
+	l0, _ := owner.server.Leases().GetByHardwareAddr(mac)
+	ip := l0.IP
+	exchange(owner.handler, dhcp4.Release, mac, []dhcp4.Option{{Code: dhcp4.OptionServerIdentifier, Value: owner.ip}})
+	waitFor(t, "release", func() bool {
+		_, p := primary.server.Leases().Get(ip)
+		_, s := secondary.server.Leases().Get(ip)
+		return !p && !s
+	})
+
+	// Losing contact, each answers all clients from its own addresses
+	l.Close()
+	primary.peer.mu.Lock()
+	primary.peer.conn.Close()
+	primary.peer.mu.Unlock()
+	waitFor(t, "communications interrupted", func() bool {
+		return primary.peer.State() == CommunicationsInterrupted && secondary.peer.State() == CommunicationsInterrupted
+	})
+	mac = net.HardwareAddr{0, 1, 2, 3, 5, 0}
+	for _, s := range []*testServer{primary, secondary} {
+		s.peer.mu.Lock()
+		backup := make(map[string]bool)
+		for ip := range s.peer.backup {
+			backup[ip] = true
+		}
+		s.peer.mu.Unlock()
+		_, ip := lease(t, mac, s)
+		if backup[ip.String()] != (s == secondary) {
+			t.Fatalf("%s: leased address from partner's pool: %s", s.ip, ip)
+		}
+	}
+}
+
+func TestRecoverWait(t *testing.T) {
+	clock := newFakeClock()
+	mclt := time.Minute
+	primary, secondary, _ := startTestPair(t, mclt, clock)
+	defer primary.peer.Close()
+	defer secondary.peer.Close()
+	waitFor(t, "pool balance", func() bool { return backupCount(secondary.peer) == testRange/2 })
+
+	// Neither leases new addresses until the MCLT has passed
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	for _, s := range []*testServer{primary, secondary} {
+		if offer := exchange(s.handler, dhcp4.Discover, mac, nil); offer != nil {
+			t.Fatalf("%s: offered %s in recover wait", s.ip, offer.YIAddr())
+		}
+	}
+	clock.Advance(mclt - time.Second)
+	if state := primary.peer.State(); state != RecoverWait {
+		t.Fatalf("Left recover wait before the MCLT: %s", state)
+	}
+	clock.Advance(time.Second)
+	waitFor(t, "normal", func() bool {
+		return primary.peer.State() == Normal && secondary.peer.State() == Normal
+	})
+	lease(t, mac, primary, secondary)
+}
+
+func TestPartnerDown(t *testing.T) {
+	mclt := time.Minute
+	clock := newFakeClock()
+	primary, secondary, l := newTestPair(t, mclt, clock)
+	defer primary.peer.Close()
+	waitFor(t, "pool balance", func() bool { return backupCount(primary.peer) == testRange/2 })
+	l.Close()
+	secondary.peer.Close()
+	waitFor(t, "communications interrupted", func() bool { return primary.peer.State() == CommunicationsInterrupted })
+
+	backup := dhcp4.IPAdd(testStart, testRange-1)
+	if primary.peer.Allocatable(backup) {
+		t.Fatalf("Communications interrupted, backup address allocatable")
+	}
+	primary.peer.SetPartnerDown()
+	if s := primary.peer.State(); s != PartnerDown {
+		t.Fatalf("Unexpected state: %v", s)
+	}
+	if primary.peer.Allocatable(backup) {
+		t.Fatalf("Partner down within MCLT, backup address allocatable")
+	}
+	clock.Advance(mclt)
+	if !primary.peer.Allocatable(backup) {
+		t.Fatalf("Partner down after MCLT, backup address not allocatable")
+	}
+}
+
+func TestPeerExpiry(t *testing.T) {
+	clock := newFakeClock()
+	primary, secondary, _ := newTestPair(t, time.Hour, clock)
+	defer primary.peer.Close()
+	defer secondary.peer.Close()
+
+	var mu sync.Mutex
+	expired := map[*testServer]int{}
+	for _, s := range []*testServer{primary, secondary} {
+		s := s
+		s.server.Leases().Subscribe(func(e dhcp4.LeaseEvent) {
+			if e.Type == dhcp4.LeaseExpired {
+				mu.Lock()
+				expired[s]++
+				mu.Unlock()
+			}
+		})
+	}
+
+	// Each peer expires a lease once, without the expiries bouncing
+	now := clock.Now()
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	primary.server.Leases().Bind(dhcp4.Lease{IP: testStart, HardwareAddr: mac, Start: now, Expiry: now.Add(time.Second)})
+	waitFor(t, "binding update", func() bool {
+		_, ok := secondary.server.Leases().Get(testStart)
+		return ok
+	})
+	clock.Advance(2 * time.Second)
+	time.Sleep(100 * time.Millisecond) // For any binding updates to arrive
+	clock.Advance(2 * time.Second)
+	time.Sleep(100 * time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	if expired[primary] != 1 || expired[secondary] != 1 {
+		t.Fatalf("Unexpected expiries: primary %d, secondary %d", expired[primary], expired[secondary])
+	}
+}
@@ -0,0 +1,456 @@
+package dhcp4
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// How long an offered address is held for the client it was offered to.
+const offerHold = time.Minute
+
+// Server is a Handler that leases addresses from the pools of its subnets,
+// recording each lease in a LeaseStore.
+type Server struct {
+	plan   atomic.Value  // *plan, replaced by SetConfig
+	leases *LeaseManager // Where leases are kept
+	mu     sync.Mutex    // Serialises choosing addresses and recording them
+
+	// Prober, if set, is used to check an address isn't already in use
+	// before offering it to a new client.  As probing happens while handling
+	// the Discover, a Prober with a short timeout is recommended.
+	Prober Prober
+	// Quarantine is how long to keep an address from clients after finding
+	// it in use, or having it declined.  Zero means DefaultQuarantine.
+	Quarantine time.Duration
+	// Policy, if set, constrains which addresses are allocated and for how
+	// long, such as for a failover partner.
+	Policy LeasePolicy
+}
+
+// LeasePolicy constrains a Server's leasing decisions on behalf of another
+// component, such as a failover peer.
+type LeasePolicy interface {
+	// Allocatable returns true if ip may be leased to a client that doesn't
+	// already hold it.
+	Allocatable(ip net.IP) bool
+	// LeaseTime returns how long ip may be leased for, at most d.
+	LeaseTime(ip net.IP, d time.Duration) time.Duration
+}
+
+// NewServer returns a Server identifying itself as ip, that leases the
+// leaseRange addresses starting at start for leaseDuration, along with
+// options, to all clients.  If leases is nil, leases are kept in memory only;
+// to keep leases across restarts use a JournalLeaseStore.  Unless leases is
+// already a LeaseManager, it is wrapped in one using the system clock.
+func NewServer(ip, start net.IP, leaseRange int, leaseDuration time.Duration, options Options, leases LeaseStore) *Server {
+	all := net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	return NewConfiguredServer(&ServerConfig{
+		ServerID:  ip,
+		LeaseTime: leaseDuration,
+		Global:    OptionScope{Options: options},
+		Subnets:   NewSubnetSelector(nil, &Subnet{Net: all, Pools: []*Pool{{Start: start.To4(), Range: leaseRange}}}),
+	}, leases)
+}
+
+// NewConfiguredServer returns a Server serving config, keeping leases as for
+// NewServer.
+func NewConfiguredServer(config *ServerConfig, leases LeaseStore) *Server {
+	if leases == nil {
+		leases = NewMemoryLeaseStore()
+	}
+	m, ok := leases.(*LeaseManager)
+	if !ok {
+		m = NewLeaseManager(leases, nil)
+	}
+	s := &Server{leases: m}
+	s.plan.Store(newPlan(config))
+	return s
+}
+
+// Config returns the server's configuration.
+func (s *Server) Config() *ServerConfig { return s.currentPlan().ServerConfig }
+
+func (s *Server) currentPlan() *plan { return s.plan.Load().(*plan) }
+
+// Leases returns the server's lease manager, for access to its leases and
+// lease events.
+func (s *Server) Leases() *LeaseManager { return s.leases }
+
+// Interface returns a Handler for requests received on the interface with
+// address local, which selects the subnet of clients that aren't relayed.
+// The Server itself uses its server identifier.
+func (s *Server) Interface(local net.IP) Handler {
+	return &interfaceHandler{s, local}
+}
+
+type interfaceHandler struct {
+	s     *Server
+	local net.IP
+}
+
+func (h *interfaceHandler) ServeDHCP(p Packet, msgType MessageType, options Options) Packet {
+	return h.s.serve(p, msgType, options, h.local)
+}
+
+func (s *Server) ServeDHCP(p Packet, msgType MessageType, options Options) Packet {
+	return s.serve(p, msgType, options, nil)
+}
+
+// request is a client's request, with the configuration that applies to it.
+type request struct {
+	*plan
+	p       Packet
+	options Options
+	subnet  *Subnet
+	pools   []*Pool // Pools on the client's link it may lease from
+	classes ClassSet
+	host    *Reservation
+}
+
+// request returns p with its configuration, or nil if it's from a subnet the
+// server doesn't serve.  local defaults to the server identifier.  The
+// configuration is fixed for the request, even if replaced meanwhile.
+func (s *Server) request(p Packet, options Options, local net.IP) *request {
+	pl := s.currentPlan()
+	if local == nil {
+		local = pl.ServerID
+	}
+	subnet := pl.Subnets.Select(p, options, local)
+	if subnet == nil {
+		return nil
+	}
+	r := &request{plan: pl, p: p, options: options, subnet: subnet,
+		classes: pl.classify(p, options), host: pl.reservation(p, options)}
+	for _, sn := range subnet.Network.Subnets {
+		r.pools = append(r.pools, sn.PermittedPools(r.classes)...)
+	}
+	return r
+}
+
+func (s *Server) serve(p Packet, msgType MessageType, options Options, local net.IP) Packet {
+	r := s.request(p, options, local)
+	if r == nil {
+		return nil
+	}
+	switch msgType {
+
+	case Discover:
+		ip := s.offer(r)
+		if ip == nil {
+			return nil
+		}
+		return ReplyPacket(p, Offer, r.ServerID, ip, s.leaseTime(r, ip), r.replyOptions(ip))
+
+	case Request:
+		if server, ok := options[OptionServerIdentifier]; ok && !net.IP(server).Equal(r.ServerID) {
+			return nil // Message not for this dhcp server
+		}
+		reqIP := net.IP(options[OptionRequestedIPAddress])
+		if reqIP == nil {
+			reqIP = net.IP(p.CIAddr())
+		}
+
+		if len(reqIP) == 4 && !reqIP.Equal(net.IPv4zero) && r.inRange(reqIP) {
+			if d, ok, err := s.bind(r, reqIP); ok {
+				if err == nil { // Don't ACK what can't be recorded
+					return ReplyPacket(p, ACK, r.ServerID, reqIP, d, r.replyOptions(reqIP))
+				}
+				return nil
+			}
+		}
+		return ReplyPacket(p, NAK, r.ServerID, nil, 0, nil)
+
+	case Release:
+		if l, ok := s.clientLease(p, options); ok {
+			s.leases.Release(l.IP)
+		}
+
+	case Decline:
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		l, ok := s.clientLease(p, options)
+		if reqIP := net.IP(options[OptionRequestedIPAddress]); len(reqIP) == 4 && !reqIP.Equal(l.IP) {
+			l, ok = Lease{IP: reqIP}, r.inRange(reqIP) && s.available(r, reqIP)
+		}
+		if ok {
+			s.leases.Decline(l.IP)
+			s.quarantine(l.IP)
+		}
+	}
+	return nil
+}
+
+// offer chooses an address for the client that made r, and holds it for
+// the client, returning nil if there's none.  Choosing and holding are done
+// together, so concurrent requests can't be offered the same address.
+func (s *Server) offer(r *request) net.IP {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ip := s.clientIP(r)
+	for i := 0; ip == nil; i++ { // New addresses are checked first
+		if i == maxProbes {
+			return nil
+		}
+		if ip = s.freeIP(r); ip == nil {
+			return nil
+		}
+		if s.inUse(ip) {
+			ip = nil
+		}
+	}
+	s.leases.Offer(s.newLease(r, ip, offerHold))
+	return ip
+}
+
+// bind leases ip to the client that made r, if it may have it, returning
+// the lease time and any error recording the lease.
+func (s *Server) bind(r *request, ip net.IP) (time.Duration, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.available(r, ip) || !s.allocatable(r, ip) {
+		return 0, false, nil
+	}
+	d := s.leaseTime(r, ip)
+	return d, true, s.leases.Bind(s.newLease(r, ip, d))
+}
+
+// newLease returns a lease of ip for d to the client that made r.
+func (s *Server) newLease(r *request, ip net.IP, d time.Duration) Lease {
+	now := s.leases.clock.Now()
+	hostname := string(r.options[OptionHostName])
+	if r.host != nil && r.host.Hostname != "" {
+		hostname = r.host.Hostname
+	}
+	return Lease{
+		IP:           append(net.IP(nil), ip...),
+		HardwareAddr: append(net.HardwareAddr(nil), r.p.CHAddr()...),
+		ClientID:     append([]byte(nil), r.options[OptionClientIdentifier]...),
+		Hostname:     hostname,
+		ClientFQDN:   append([]byte(nil), r.options[OptionClientFQDN]...),
+		Start:        now,
+		Expiry:       now.Add(d),
+	}
+}
+
+// available returns true if ip may be leased to the client that made r: it
+// is neither leased nor offered to another client, nor reserved for one.
+func (s *Server) available(r *request, ip net.IP) bool {
+	if l, ok := s.leases.Get(ip); ok && !l.Expired(s.leases.clock.Now()) && !sameClient(l, r.p, r.options) {
+		return false
+	}
+	if o, ok := s.leases.Offered(ip); ok && !sameClient(o, r.p, r.options) {
+		return false
+	}
+	if h, ok := r.reserved[ip.String()]; ok && h != r.host {
+		return false
+	}
+	return true
+}
+
+// allocatable returns true if policy permits leasing ip to the client that
+// made r.  Clients may always keep addresses they hold, and have those
+// reserved for them.
+func (s *Server) allocatable(r *request, ip net.IP) bool {
+	if s.Policy == nil || r.reservedFor(ip) {
+		return true
+	}
+	if l, ok := s.leases.Get(ip); ok && !l.Expired(s.leases.clock.Now()) && sameClient(l, r.p, r.options) {
+		return true
+	}
+	return s.Policy.Allocatable(ip)
+}
+
+func (s *Server) leaseTime(r *request, ip net.IP) time.Duration {
+	d := r.leaseTime(ip)
+	if s.Policy == nil {
+		return d
+	}
+	return s.Policy.LeaseTime(ip, d)
+}
+
+// clientLease returns the lease held by the client that sent p, preferring
+// its client identifier over its hardware address.
+func (s *Server) clientLease(p Packet, options Options) (Lease, bool) {
+	if id := options[OptionClientIdentifier]; len(id) > 0 {
+		if l, ok := s.leases.GetByClientID(id); ok {
+			return l, true
+		}
+	}
+	if l, ok := s.leases.GetByHardwareAddr(p.CHAddr()); ok && sameClient(l, p, options) {
+		return l, true
+	}
+	return Lease{}, false
+}
+
+// clientIP returns the address reserved for, or previously leased or
+// offered to the client, if any.
+func (s *Server) clientIP(r *request) net.IP {
+	if r.host != nil && r.host.IP != nil && r.onLink(r.host.IP) && s.available(r, r.host.IP) {
+		return r.host.IP
+	}
+	if l, ok := s.clientLease(r.p, r.options); ok && r.inRange(l.IP) && s.available(r, l.IP) {
+		return l.IP
+	}
+	if o, ok := s.leases.offeredTo(r.p, r.options); ok && r.inRange(o.IP) && s.available(r, o.IP) {
+		return o.IP
+	}
+	return nil
+}
+
+// reservedFor returns true if ip is reserved for the client that made r.
+func (r *request) reservedFor(ip net.IP) bool {
+	return r.host != nil && r.host.IP.Equal(ip)
+}
+
+// onLink returns true if ip is on one of the subnets of the client's
+// network, so it can use it there.
+func (r *request) onLink(ip net.IP) bool {
+	for _, sn := range r.subnet.Network.Subnets {
+		if sn.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// inRange returns true if ip may be leased to the client that made r: it is
+// reserved for it and on its network, or in one of its pools.
+func (r *request) inRange(ip net.IP) bool {
+	if r.reservedFor(ip) && r.onLink(ip) {
+		return true
+	}
+	for _, p := range r.pools {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Options set by the server itself, rather than from scopes.
+var serverOptions = map[OptionCode]bool{
+	OptionDHCPMessageType:    true,
+	OptionServerIdentifier:   true,
+	OptionIPAddressLeaseTime: true, // Sets the lease time instead
+}
+
+// replyOptions returns the options for leasing ip to the client that made
+// r, from the scopes that apply, ordered by its parameter request list.
+func (r *request) replyOptions(ip net.IP) []Option {
+	var opts []Option
+	for _, o := range ResolveOptions(r.options[OptionParameterRequestList], r.scopes(ip)...) {
+		if !serverOptions[o.Code] {
+			opts = append(opts, o)
+		}
+	}
+	return opts
+}
+
+// leaseTime returns how long ip is leased to the client that made r: the
+// ip-address-lease-time of the scopes that apply, or the server's lease
+// time.
+func (r *request) leaseTime(ip net.IP) time.Duration {
+	if v := MergeOptions(r.scopes(ip)...)[OptionIPAddressLeaseTime]; len(v) == 4 {
+		return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	return r.LeaseTime
+}
+
+// scopes returns the option scopes that apply to leasing ip to the client
+// that made r, in increasing precedence.
+func (r *request) scopes(ip net.IP) []*OptionScope {
+	subnet := r.subnet
+	for _, sn := range subnet.Network.Subnets {
+		if sn.Contains(ip) {
+			subnet = sn
+			break
+		}
+	}
+	scopes := append([]*OptionScope{&r.Global}, subnet.Scopes(subnet.Pool(ip))...)
+	if r.Classifier != nil {
+		scopes = append(scopes, r.Classifier.Scopes(r.classes)...)
+	}
+	if r.host != nil {
+		if r.host.Hostname != "" {
+			scopes = append(scopes, &OptionScope{Options: Options{OptionHostName: []byte(r.host.Hostname)}})
+		}
+		scopes = append(scopes, &r.host.Scope)
+	}
+	return scopes
+}
+
+func (s *Server) freeIP(r *request) net.IP {
+	now := s.leases.clock.Now()
+	total := 0
+	for _, p := range r.pools {
+		total += p.Range
+	}
+	if total == 0 {
+		return nil
+	}
+	b := rand.Intn(total) // Try random first
+	for _, v := range [][]int{[]int{b, total}, []int{0, b}} {
+		for i := v[0]; i < v[1]; i++ {
+			ip := poolAddress(r.pools, i)
+			if l, ok := s.leases.Get(ip); ok && !l.Expired(now) {
+				continue
+			}
+			if _, ok := r.reserved[ip.String()]; ok {
+				continue
+			}
+			if s.Policy != nil && !s.Policy.Allocatable(ip) {
+				continue
+			}
+			if _, ok := s.leases.Offered(ip); !ok {
+				return ip
+			}
+		}
+	}
+	return nil
+}
+
+// poolAddress returns the i'th address of pools, taken in turn.
+func poolAddress(pools []*Pool, i int) net.IP {
+	for _, p := range pools {
+		if i < p.Range {
+			return IPAdd(p.Start, i)
+		}
+		i -= p.Range
+	}
+	return nil
+}
+
+// sameClient returns true if lease l belongs to the client that sent p.
+// Client identifiers take precedence over hardware addresses, when present.
+func sameClient(l Lease, p Packet, options Options) bool {
+	if id := options[OptionClientIdentifier]; len(id) > 0 && len(l.ClientID) > 0 {
+		return bytes.Equal(id, l.ClientID)
+	}
+	return bytes.Equal(l.HardwareAddr, p.CHAddr())
+}
+
+// SetConfig replaces the server's configuration.  Requests being handled
+// keep the configuration they started with, and outstanding offers are
+// unaffected.
+//
+// Unexpired leases no longer valid under config (their address is outside
+// every pool, or reserved for another client) are returned.  They are kept,
+// so their addresses aren't leased to others, but their clients are NAKed
+// when they next renew.
+func (s *Server) SetConfig(config *ServerConfig) (invalid []Lease) {
+	p := newPlan(config)
+	s.plan.Store(p)
+	now := s.leases.clock.Now()
+	s.leases.Iterate(func(l Lease) bool {
+		if !l.Quarantined && !l.Expired(now) && !p.valid(l) {
+			invalid = append(invalid, l)
+		}
+		return true
+	})
+	return invalid
+}
@@ -0,0 +1,227 @@
+package dhcp4
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// exchange sends a request of type mt from chAddr to h, returning the reply
+// and its parsed options.
+func exchange(h Handler, mt MessageType, chAddr net.HardwareAddr, options []Option) (Packet, Options) {
+	req := RequestPacket(mt, chAddr, nil, []byte{1, 2, 3, 4}, true, options)
+	res := h.ServeDHCP(req, mt, req.ParseOptions())
+	if res == nil {
+		return nil, nil
+	}
+	return res, res.ParseOptions()
+}
+
+func TestServer(t *testing.T) {
+	serverIP := net.IP{192, 168, 1, 1}
+	s := NewServer(serverIP, net.IP{192, 168, 1, 10}, 2, time.Hour, Options{OptionSubnetMask: []byte{255, 255, 255, 0}}, nil)
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	var events []LeaseEventType
+	s.Leases().Subscribe(func(e LeaseEvent) { events = append(events, e.Type) })
+
+	offer, opts := exchange(s, Discover, mac, nil)
+	if offer == nil || MessageType(opts[OptionDHCPMessageType][0]) != Offer {
+		t.Fatalf("Discover, expected Offer: %v", opts)
+	}
+	yiaddr := append(net.IP(nil), offer.YIAddr()...)
+	if !IPInRange(net.IP{192, 168, 1, 10}, net.IP{192, 168, 1, 11}, yiaddr) {
+		t.Fatalf("Offer, address out of range: %s", yiaddr)
+	}
+
+	_, opts = exchange(s, Request, mac, []Option{
+		{OptionServerIdentifier, serverIP},
+		{OptionRequestedIPAddress, yiaddr},
+	})
+	if MessageType(opts[OptionDHCPMessageType][0]) != ACK {
+		t.Fatalf("Request, expected ACK: %v", opts)
+	}
+	if l, ok := s.Leases().Get(yiaddr); !ok || l.HardwareAddr.String() != mac.String() {
+		t.Fatalf("Request, lease not recorded: %v", l)
+	}
+
+	// Rediscovery returns the same address
+	if offer, _ = exchange(s, Discover, mac, nil); !offer.YIAddr().Equal(yiaddr) {
+		t.Fatalf("Rediscover, unexpected address: %s != %s", offer.YIAddr(), yiaddr)
+	}
+
+	// Another client can't take the address
+	other := net.HardwareAddr{0, 1, 2, 3, 4, 6}
+	if _, opts = exchange(s, Request, other, []Option{{OptionRequestedIPAddress, yiaddr}}); MessageType(opts[OptionDHCPMessageType][0]) != NAK {
+		t.Fatalf("Request for taken address, expected NAK: %v", opts)
+	}
+
+	// Requests for other servers are ignored
+	if res, _ := exchange(s, Request, other, []Option{{OptionServerIdentifier, []byte{192, 168, 1, 2}}}); res != nil {
+		t.Fatalf("Request for other server, unexpected reply")
+	}
+
+	exchange(s, Release, mac, nil)
+	if _, ok := s.Leases().Get(yiaddr); ok {
+		t.Fatalf("Release, lease not removed")
+	}
+
+	want := []LeaseEventType{LeaseOffered, LeaseBound, LeaseOffered, LeaseReleased}
+	if len(events) != len(want) {
+		t.Fatalf("unexpected events: %v != %v", events, want)
+	}
+	for i := range want {
+		if events[i] != want[i] {
+			t.Fatalf("unexpected events: %v != %v", events, want)
+		}
+	}
+}
+
+// Verify that clients served concurrently are never given the same address.
+func TestServerConcurrent(t *testing.T) {
+	const clients = 64
+	serverIP := net.IP{192, 168, 1, 1}
+	s := NewServer(serverIP, net.IP{192, 168, 1, 10}, clients, time.Hour, nil, nil)
+	s.Prober = slowProber{} // Widen the window between choosing and offering
+	var wg sync.WaitGroup
+	acked := make([]net.IP, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			mac := net.HardwareAddr{0, 1, 2, 3, 4, byte(i)}
+			offer, _ := exchange(s, Discover, mac, nil)
+			if offer == nil {
+				return
+			}
+			ack, opts := exchange(s, Request, mac, []Option{
+				{OptionServerIdentifier, serverIP},
+				{OptionRequestedIPAddress, offer.YIAddr()},
+			})
+			if ack != nil && MessageType(opts[OptionDHCPMessageType][0]) == ACK {
+				acked[i] = append(net.IP(nil), ack.YIAddr()...)
+			}
+		}(i)
+	}
+	wg.Wait()
+	seen := make(map[string]int)
+	for i, ip := range acked {
+		if ip == nil {
+			t.Fatalf("%02d: no lease", i)
+		}
+		if j, ok := seen[ip.String()]; ok {
+			t.Fatalf("%02d: %s also leased to %02d", i, ip, j)
+		}
+		seen[ip.String()] = i
+	}
+}
+
+// slowProber takes a while to find nothing.
+type slowProber struct{}
+
+func (slowProber) InUse(ip net.IP) bool {
+	time.Sleep(time.Millisecond)
+	return false
+}
+
+// stubProber reports the addresses in inUse as taken.
+type stubProber struct {
+	inUse  map[string]bool
+	probed []string
+}
+
+func (p *stubProber) InUse(ip net.IP) bool {
+	p.probed = append(p.probed, ip.String())
+	return p.inUse[ip.String()]
+}
+
+func TestServerConflict(t *testing.T) {
+	serverIP := net.IP{192, 168, 1, 1}
+	clock := newFakeClock()
+	s := NewServer(serverIP, net.IP{192, 168, 1, 10}, 2, time.Hour, nil, NewLeaseManager(NewMemoryLeaseStore(), clock))
+	prober := &stubProber{inUse: map[string]bool{"192.168.1.10": true}}
+	s.Prober, s.Quarantine = prober, time.Hour
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+
+	// The address in use is never offered
+	for i := 0; i < 3; i++ {
+		offer, _ := exchange(s, Discover, mac, nil)
+		if offer == nil || !offer.YIAddr().Equal(net.IP{192, 168, 1, 11}) {
+			t.Fatalf("Discover %d, expected offer of 192.168.1.11: %v", i, offer)
+		}
+	}
+	// Another client causes it to be probed and quarantined
+	if offer, _ := exchange(s, Discover, net.HardwareAddr{0, 1, 2, 3, 4, 6}, nil); offer != nil {
+		t.Fatalf("Discover, unexpected offer of %s", offer.YIAddr())
+	}
+	if l, ok := s.Leases().Get(net.IP{192, 168, 1, 10}); !ok || !l.Quarantined {
+		t.Fatalf("address in use not quarantined: %v", l)
+	}
+
+	// Declining the only other address leaves nothing to offer
+	exchange(s, Request, mac, []Option{{OptionRequestedIPAddress, []byte{192, 168, 1, 11}}})
+	exchange(s, Decline, mac, []Option{{OptionRequestedIPAddress, []byte{192, 168, 1, 11}}})
+	if l, ok := s.Leases().Get(net.IP{192, 168, 1, 11}); !ok || !l.Quarantined {
+		t.Fatalf("declined address not quarantined: %v", l)
+	}
+	if offer, _ := exchange(s, Discover, mac, nil); offer != nil {
+		t.Fatalf("Discover, unexpected offer of quarantined address %s", offer.YIAddr())
+	}
+
+	// Once the quarantine ends, the addresses are available again
+	prober.inUse = nil
+	clock.Advance(time.Hour + time.Second)
+	if _, ok := s.Leases().Get(net.IP{192, 168, 1, 11}); ok {
+		t.Fatalf("quarantine not lifted")
+	}
+	if offer, _ := exchange(s, Discover, mac, nil); offer == nil {
+		t.Fatalf("Discover, no offer after quarantine")
+	}
+}
+
+func TestConfiguredServer(t *testing.T) {
+	serverIP := net.IP{192, 168, 1, 1}
+	_, n, _ := net.ParseCIDR("192.168.1.0/24")
+	_, n10, _ := net.ParseCIDR("10.0.0.0/24")
+	host := &Reservation{HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}, IP: net.IP{192, 168, 1, 10}, Hostname: "fixed"}
+	s := NewConfiguredServer(&ServerConfig{
+		ServerID:  serverIP,
+		LeaseTime: time.Hour,
+		Subnets: NewSubnetSelector(nil,
+			&Subnet{Net: *n, Pools: []*Pool{{Start: net.IP{192, 168, 1, 10}, Range: 2}}},
+			&Subnet{Net: *n10, Pools: []*Pool{{Start: net.IP{10, 0, 0, 10}, Range: 2}}}),
+		Reservations: []*Reservation{host},
+	}, nil)
+
+	// The reserved address is never leased to others
+	other := net.HardwareAddr{0, 1, 2, 3, 4, 6}
+	for i := 0; i < 3; i++ {
+		if offer, _ := exchange(s, Discover, other, nil); offer == nil || !offer.YIAddr().Equal(net.IP{192, 168, 1, 11}) {
+			t.Fatalf("%02d: Discover, expected offer of 192.168.1.11: %v", i, offer)
+		}
+	}
+	if _, opts := exchange(s, Request, other, []Option{{OptionRequestedIPAddress, host.IP}}); MessageType(opts[OptionDHCPMessageType][0]) != NAK {
+		t.Fatalf("Request for reserved address, expected NAK: %v", opts)
+	}
+
+	offer, opts := exchange(s, Discover, host.HardwareAddr, nil)
+	if offer == nil || !offer.YIAddr().Equal(host.IP) || string(opts[OptionHostName]) != "fixed" {
+		t.Fatalf("Discover, expected offer of reservation: %v %v", offer, opts)
+	}
+
+	// Unrelayed requests received on an unserved subnet's interface are ignored
+	req := RequestPacket(Discover, host.HardwareAddr, nil, []byte{1, 2, 3, 4}, true, nil)
+	if res := s.Interface(net.IP{172, 16, 0, 1}).ServeDHCP(req, Discover, req.ParseOptions()); res != nil {
+		t.Fatalf("Discover on other subnet, unexpected offer of %s", res.YIAddr())
+	}
+
+	// A reserved host on another network isn't given its off-link address
+	moved := s.Interface(net.IP{10, 0, 0, 1})
+	if res := moved.ServeDHCP(req, Discover, req.ParseOptions()); res == nil || !n10.Contains(res.YIAddr()) {
+		t.Fatalf("Discover on reserved host's other network, unexpected offer: %v", res)
+	}
+	req = RequestPacket(Request, host.HardwareAddr, nil, []byte{1, 2, 3, 5}, true, []Option{{OptionRequestedIPAddress, host.IP}})
+	if res := moved.ServeDHCP(req, Request, req.ParseOptions()); res == nil || MessageType(res.ParseOptions()[OptionDHCPMessageType][0]) != NAK {
+		t.Fatalf("Request for off-link reservation, expected NAK: %v", res)
+	}
+}
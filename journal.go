@@ -0,0 +1,278 @@
+package dhcp4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Journal record operations
+const (
+	journalPut    byte = 1
+	journalDelete byte = 2
+)
+
+// Compaction happens once this many records beyond twice the live lease count
+// have been appended.
+const journalCompactSlack = 1024
+
+// JournalLeaseStore is a LeaseStore kept in memory and persisted to an
+// append-only journal file.  Every change is appended and fsynced before
+// Put or Delete return, so a lease acknowledged to a client survives a crash.
+// The journal is periodically compacted by rewriting it as a snapshot of the
+// live leases.
+//
+// Each record is framed as a 4 byte length, a 4 byte CRC32 (IEEE) of the
+// payload, and the payload: an operation byte followed by the JSON encoded
+// Lease.  On open, the journal is replayed up to the first truncated or
+// corrupt record, and anything after it is discarded.
+type JournalLeaseStore struct {
+	*memoryLeaseStore
+	mu      sync.Mutex // Serialises writes to f
+	path    string
+	f       *os.File
+	records int   // Records in the journal since the last compaction
+	failed  error // Set if a failed write couldn't be undone
+
+	compactErr   error // Of the last automatic compaction, if it failed
+	compactRetry int   // Records before which it isn't retried
+}
+
+// OpenJournalLeaseStore opens (creating if necessary) the lease journal at
+// path and replays it.
+func OpenJournalLeaseStore(path string) (*JournalLeaseStore, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s := &JournalLeaseStore{memoryLeaseStore: newMemoryLeaseStore(), path: path, f: f}
+	good, err := s.replay(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	// Drop a torn or corrupt tail, so new records follow the last good one.
+	if err := f.Truncate(good); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(good, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay applies journal records from r, returning the offset just past the
+// last good record.
+func (s *JournalLeaseStore) replay(r io.Reader) (good int64, err error) {
+	br := bufio.NewReader(r)
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(br, header[:]); err != nil {
+			return good, nil // EOF or torn header
+		}
+		size := binary.BigEndian.Uint32(header[:4])
+		if size < 1 || size > 1<<20 {
+			return good, nil
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return good, nil
+		}
+		if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:]) {
+			return good, nil
+		}
+		var l Lease
+		if err := json.Unmarshal(payload[1:], &l); err != nil {
+			return good, nil
+		}
+		switch payload[0] {
+		case journalPut:
+			s.memoryLeaseStore.put(l)
+		case journalDelete:
+			s.memoryLeaseStore.remove(l.IP.String())
+		default:
+			return good, nil
+		}
+		good += int64(len(header) + len(payload))
+		s.records++
+	}
+}
+
+func encodeJournalRecord(op byte, l Lease) ([]byte, error) {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return nil, err
+	}
+	rec := make([]byte, 9, 9+len(data))
+	binary.BigEndian.PutUint32(rec[:4], uint32(1+len(data)))
+	rec[8] = op
+	rec = append(rec, data...)
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(rec[8:]))
+	return rec, nil
+}
+
+// append writes and syncs a record; the caller then applies it in memory and
+// calls maybeCompact.  A record that fails to be written is cut off again, as
+// replay would stop at it, losing every record after.  If that fails too, the
+// store refuses further changes.
+func (s *JournalLeaseStore) append(op byte, l Lease) error {
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	if s.failed != nil {
+		return s.failed
+	}
+	rec, err := encodeJournalRecord(op, l)
+	if err != nil {
+		return err
+	}
+	off, err := s.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err = s.f.Write(rec); err == nil {
+		err = s.f.Sync()
+	}
+	if err != nil {
+		s.rollback(off, err)
+		return err
+	}
+	s.records++
+	return nil
+}
+
+// rollback truncates the journal to off after a failed write, marking the
+// store failed with err if it can't.
+func (s *JournalLeaseStore) rollback(off int64, err error) {
+	if s.f.Truncate(off) != nil {
+		s.failed = err
+		return
+	}
+	if _, e := s.f.Seek(off, io.SeekStart); e != nil {
+		s.failed = err
+	}
+}
+
+// maybeCompact compacts the journal once enough records have been appended.
+// The record triggering it is already safe, so a failure doesn't fail the
+// write: it's recorded for CompactErr, and compaction retried after another
+// journalCompactSlack records.
+func (s *JournalLeaseStore) maybeCompact() {
+	if s.records <= 2*s.memoryLeaseStore.len()+journalCompactSlack || s.records < s.compactRetry {
+		return
+	}
+	if s.compactErr = s.compact(); s.compactErr != nil {
+		s.compactRetry = s.records + journalCompactSlack
+	}
+}
+
+// CompactErr returns the error of the last automatic compaction, or nil if
+// it succeeded.
+func (s *JournalLeaseStore) CompactErr() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compactErr
+}
+
+// Put records l in the journal and then in memory.
+func (s *JournalLeaseStore) Put(l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.append(journalPut, l); err != nil {
+		return err
+	}
+	s.memoryLeaseStore.Put(l)
+	s.maybeCompact()
+	return nil
+}
+
+// Delete records the removal of ip's lease in the journal and then in memory.
+func (s *JournalLeaseStore) Delete(ip net.IP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.memoryLeaseStore.Get(ip); !ok {
+		return nil
+	}
+	if err := s.append(journalDelete, Lease{IP: ip}); err != nil {
+		return err
+	}
+	s.memoryLeaseStore.Delete(ip)
+	s.maybeCompact()
+	return nil
+}
+
+// Compact rewrites the journal as a snapshot of the current leases.
+func (s *JournalLeaseStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	return s.compact()
+}
+
+func (s *JournalLeaseStore) compact() error {
+	tmp := s.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	records := 0
+	s.memoryLeaseStore.Iterate(func(l Lease) bool {
+		var rec []byte
+		if rec, err = encodeJournalRecord(journalPut, l); err == nil {
+			_, err = w.Write(rec)
+		}
+		records++
+		return err == nil
+	})
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+	s.f.Close()
+	s.f, s.records = f, records
+	return nil
+}
+
+// syncDir makes a rename durable, where the OS supports it.
+func syncDir(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+// Close closes the journal file.  The store must not be modified afterwards.
+func (s *JournalLeaseStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
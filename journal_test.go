@@ -0,0 +1,208 @@
+package dhcp4
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJournalLeaseStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s, err := OpenJournalLeaseStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testLeaseStore(t, s)
+	s.Close()
+
+	// Leases must survive reopening
+	if s, err = OpenJournalLeaseStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if _, ok := s.Get(testLeases[0].IP); !ok {
+		t.Fatalf("lease %s lost on reopen", testLeases[0].IP)
+	}
+	if _, ok := s.Get(testLeases[1].IP); ok {
+		t.Fatalf("deleted lease %s resurrected on reopen", testLeases[1].IP)
+	}
+}
+
+// Verify that a journal with a damaged tail recovers every record before the
+// damage, and that new records are appended after the last good one.
+func TestJournalRecovery(t *testing.T) {
+	var tests = []struct {
+		description string
+		damage      func(data []byte, last int) []byte // last is the offset of the final record
+	}{
+		{
+			description: "truncated payload",
+			damage:      func(data []byte, last int) []byte { return data[:len(data)-3] },
+		},
+		{
+			description: "truncated header",
+			damage:      func(data []byte, last int) []byte { return data[:last+5] },
+		},
+		{
+			description: "corrupt payload",
+			damage: func(data []byte, last int) []byte {
+				data[len(data)-2] ^= 0xff
+				return data
+			},
+		},
+		{
+			description: "garbage length",
+			damage: func(data []byte, last int) []byte {
+				data[last] = 0xff
+				return data
+			},
+		},
+	}
+
+	for i, tt := range tests {
+		path := filepath.Join(t.TempDir(), "leases")
+		s, err := OpenJournalLeaseStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Put(testLeases[0])
+		fi, _ := os.Stat(path)
+		last := int(fi.Size())
+		s.Put(testLeases[1])
+		s.Close()
+
+		data, _ := os.ReadFile(path)
+		os.WriteFile(path, tt.damage(data, last), 0644)
+
+		if s, err = OpenJournalLeaseStore(path); err != nil {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, tt.description, err)
+		}
+		if _, ok := s.Get(testLeases[0].IP); !ok {
+			t.Fatalf("%02d: test %q, good record lost", i, tt.description)
+		}
+		if _, ok := s.Get(testLeases[1].IP); ok {
+			t.Fatalf("%02d: test %q, damaged record replayed", i, tt.description)
+		}
+		if fi, _ := os.Stat(path); int(fi.Size()) != last {
+			t.Fatalf("%02d: test %q, damaged tail not truncated: %d != %d", i, tt.description, fi.Size(), last)
+		}
+
+		// Appending after recovery must produce a readable journal
+		s.Put(testLeases[1])
+		s.Close()
+		if s, err = OpenJournalLeaseStore(path); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := s.Get(testLeases[1].IP); !ok {
+			t.Fatalf("%02d: test %q, record appended after recovery lost", i, tt.description)
+		}
+		s.Close()
+	}
+}
+
+func TestJournalCompact(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s, err := OpenJournalLeaseStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ { // Churn a single lease
+		s.Put(testLeases[0])
+	}
+	s.Put(testLeases[1])
+	s.Delete(testLeases[1].IP)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if s.records != 1 {
+		t.Fatalf("unexpected records after compaction: %d != 1", s.records)
+	}
+	s.Put(testLeases[1])
+	s.Close()
+
+	if s, err = OpenJournalLeaseStore(path); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, l := range testLeases {
+		if got, ok := s.Get(l.IP); !ok || !leaseEqual(l, got) {
+			t.Fatalf("Get(%s) after compaction, unexpected result: %v != %v", l.IP, l, got)
+		}
+	}
+}
+
+// Verify that a failed write is cut off, so records after it aren't lost, and
+// that the store refuses changes if it can't be.
+func TestJournalWriteFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s, err := OpenJournalLeaseStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.Put(testLeases[0])
+
+	// A torn record is truncated
+	fi, _ := s.f.Stat()
+	s.f.Write([]byte{0, 0, 0, 9, 1, 2})
+	s.rollback(fi.Size(), os.ErrInvalid)
+	if s.failed != nil {
+		t.Fatalf("rollback failed: %v", s.failed)
+	}
+	if err := s.Put(testLeases[1]); err != nil {
+		t.Fatal(err)
+	}
+	r, err := OpenJournalLeaseStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ok := r.Get(testLeases[1].IP)
+	r.Close()
+	if !ok {
+		t.Fatalf("record after torn write lost")
+	}
+
+	// One that can't be truncated fails the store
+	w := s.f
+	if s.f, err = os.Open(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Put(testLeases[0]); err == nil {
+		t.Fatalf("Put to read only journal, expected error")
+	}
+	s.f.Close()
+	s.f = w
+	if err := s.Delete(testLeases[0].IP); err == nil || s.failed == nil {
+		t.Fatalf("Delete after failed write, expected error")
+	}
+}
+
+// Verify that a failed compaction doesn't fail the write that triggered it,
+// and is retried later.
+func TestJournalCompactFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "leases")
+	s, err := OpenJournalLeaseStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := os.Mkdir(path+".tmp", 0755); err != nil { // Compaction can't create it
+		t.Fatal(err)
+	}
+	for i := 0; i <= journalCompactSlack+2; i++ {
+		if err := s.Put(testLeases[0]); err != nil {
+			t.Fatalf("%02d: Put, unexpected error: %v", i, err)
+		}
+	}
+	if s.CompactErr() == nil {
+		t.Fatalf("expected compaction error")
+	}
+
+	os.Remove(path + ".tmp")
+	for i := 0; i <= journalCompactSlack; i++ {
+		s.Put(testLeases[0])
+	}
+	if err := s.CompactErr(); err != nil || s.records > journalCompactSlack {
+		t.Fatalf("compaction not retried: %v, %d records", err, s.records)
+	}
+}
@@ -0,0 +1,151 @@
+package dhcp4
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Lease records the binding of an IP address to a client.
+type Lease struct {
+	IP           net.IP
+	HardwareAddr net.HardwareAddr // Client's CHAddr
+	ClientID     []byte           // Option 61, if sent by the client
+	Hostname     string           // Option 12, if sent by the client
+	ClientFQDN   []byte           // Option 81, if sent by the client
+	Start        time.Time        // When the lease was granted
+	Expiry       time.Time        // When the lease expires, zero for never
+	// Quarantined leases hold an address found to be in use by an unknown
+	// host (abandoned, in ISC terms), keeping it from clients until Expiry.
+	Quarantined bool
+}
+
+// Expired returns true if the lease has expired at time now.
+func (l Lease) Expired(now time.Time) bool {
+	return !l.Expiry.IsZero() && !l.Expiry.After(now)
+}
+
+// LeaseStore is the interface for keeping track of leases.  Leases are keyed
+// by IP, and may also be looked up by the client's hardware address or client
+// identifier.  Expired leases are returned like any other, it is up to the
+// caller to check Lease.Expired.
+type LeaseStore interface {
+	Get(ip net.IP) (Lease, bool)
+	GetByHardwareAddr(mac net.HardwareAddr) (Lease, bool)
+	GetByClientID(id []byte) (Lease, bool)
+	Put(l Lease) error      // Adds or replaces the lease for l.IP
+	Delete(ip net.IP) error // Removes the lease for ip, if any
+	// Iterate calls fn for each lease, in no particular order, until fn
+	// returns false.
+	Iterate(fn func(Lease) bool)
+}
+
+// NewMemoryLeaseStore returns a LeaseStore that keeps leases in memory only.
+func NewMemoryLeaseStore() LeaseStore { return newMemoryLeaseStore() }
+
+type memoryLeaseStore struct {
+	mu    sync.RWMutex
+	byIP  map[string]Lease
+	byMAC map[string]string // HardwareAddr -> IP
+	byCID map[string]string // ClientID -> IP
+}
+
+func newMemoryLeaseStore() *memoryLeaseStore {
+	return &memoryLeaseStore{
+		byIP:  make(map[string]Lease),
+		byMAC: make(map[string]string),
+		byCID: make(map[string]string),
+	}
+}
+
+func (s *memoryLeaseStore) Get(ip net.IP) (Lease, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	l, ok := s.byIP[ip.String()]
+	return l, ok
+}
+
+func (s *memoryLeaseStore) GetByHardwareAddr(mac net.HardwareAddr) (Lease, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lookup(s.byMAC, string(mac))
+}
+
+func (s *memoryLeaseStore) GetByClientID(id []byte) (Lease, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lookup(s.byCID, string(id))
+}
+
+func (s *memoryLeaseStore) lookup(index map[string]string, key string) (Lease, bool) {
+	if key == "" {
+		return Lease{}, false
+	}
+	ip, ok := index[key]
+	if !ok {
+		return Lease{}, false
+	}
+	l, ok := s.byIP[ip]
+	return l, ok
+}
+
+func (s *memoryLeaseStore) Put(l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(l)
+	return nil
+}
+
+func (s *memoryLeaseStore) put(l Lease) {
+	ip := l.IP.String()
+	s.remove(ip)
+	s.byIP[ip] = l
+	if len(l.HardwareAddr) > 0 {
+		s.byMAC[string(l.HardwareAddr)] = ip
+	}
+	if len(l.ClientID) > 0 {
+		s.byCID[string(l.ClientID)] = ip
+	}
+}
+
+func (s *memoryLeaseStore) Delete(ip net.IP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.remove(ip.String())
+	return nil
+}
+
+func (s *memoryLeaseStore) remove(ip string) {
+	l, ok := s.byIP[ip]
+	if !ok {
+		return
+	}
+	delete(s.byIP, ip)
+	// Only remove index entries still pointing at this lease
+	if k := string(l.HardwareAddr); s.byMAC[k] == ip {
+		delete(s.byMAC, k)
+	}
+	if k := string(l.ClientID); s.byCID[k] == ip {
+		delete(s.byCID, k)
+	}
+}
+
+func (s *memoryLeaseStore) Iterate(fn func(Lease) bool) {
+	s.mu.RLock()
+	leases := make([]Lease, 0, len(s.byIP))
+	for _, l := range s.byIP {
+		leases = append(leases, l)
+	}
+	s.mu.RUnlock()
+	for _, l := range leases { // fn may safely call back into the store
+		if !fn(l) {
+			return
+		}
+	}
+}
+
+func (s *memoryLeaseStore) len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byIP)
+}
@@ -0,0 +1,89 @@
+package dhcp4
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+var testLeases = []Lease{
+	{
+		IP:           net.IP{192, 168, 1, 10},
+		HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		ClientID:     []byte{1, 0, 1, 2, 3, 4, 5},
+		Hostname:     "alpha",
+		Start:        time.Unix(1400000000, 0),
+		Expiry:       time.Unix(1400007200, 0),
+	},
+	{
+		IP:           net.IP{192, 168, 1, 11},
+		HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		Start:        time.Unix(1400000000, 0),
+		Expiry:       time.Unix(1400007200, 0),
+	},
+}
+
+func TestMemoryLeaseStore(t *testing.T) {
+	testLeaseStore(t, NewMemoryLeaseStore())
+}
+
+// testLeaseStore exercises the LeaseStore interface on an empty store.
+func testLeaseStore(t *testing.T, s LeaseStore) {
+	for _, l := range testLeases {
+		if err := s.Put(l); err != nil {
+			t.Fatalf("Put(%s), unexpected error: %v", l.IP, err)
+		}
+	}
+	for i, want := range testLeases {
+		if got, ok := s.Get(want.IP); !ok || !leaseEqual(want, got) {
+			t.Fatalf("%02d: Get(%s), unexpected result: %v != %v", i, want.IP, want, got)
+		}
+		if got, ok := s.GetByHardwareAddr(want.HardwareAddr); !ok || !leaseEqual(want, got) {
+			t.Fatalf("%02d: GetByHardwareAddr(%s), unexpected result: %v != %v", i, want.HardwareAddr, want, got)
+		}
+	}
+	if got, ok := s.GetByClientID(testLeases[0].ClientID); !ok || !leaseEqual(testLeases[0], got) {
+		t.Fatalf("GetByClientID, unexpected result: %v != %v", testLeases[0], got)
+	}
+	if _, ok := s.GetByClientID(nil); ok {
+		t.Fatalf("GetByClientID(nil), unexpected lease")
+	}
+
+	// Moving a client to a new address must drop the old address' indexes
+	moved := testLeases[1]
+	moved.IP = net.IP{192, 168, 1, 12}
+	s.Delete(testLeases[1].IP)
+	s.Put(moved)
+	if got, ok := s.GetByHardwareAddr(moved.HardwareAddr); !ok || !got.IP.Equal(moved.IP) {
+		t.Fatalf("GetByHardwareAddr after move, unexpected result: %v", got)
+	}
+	if _, ok := s.Get(testLeases[1].IP); ok {
+		t.Fatalf("Get(%s), deleted lease still present", testLeases[1].IP)
+	}
+
+	count := 0
+	s.Iterate(func(Lease) bool { count++; return true })
+	if count != 2 {
+		t.Fatalf("Iterate, unexpected count: %d != 2", count)
+	}
+}
+
+func TestLeaseExpired(t *testing.T) {
+	l := testLeases[0]
+	if l.Expired(l.Start) {
+		t.Fatalf("lease expired at start")
+	}
+	if !l.Expired(l.Expiry) {
+		t.Fatalf("lease not expired at expiry")
+	}
+	if l.Expiry = (time.Time{}); l.Expired(time.Now()) {
+		t.Fatalf("lease without expiry expired")
+	}
+}
+
+func leaseEqual(a, b Lease) bool {
+	return a.IP.Equal(b.IP) && bytes.Equal(a.HardwareAddr, b.HardwareAddr) &&
+		bytes.Equal(a.ClientID, b.ClientID) && a.Hostname == b.Hostname &&
+		a.Start.Equal(b.Start) && a.Expiry.Equal(b.Expiry)
+}
@@ -0,0 +1,98 @@
+package leasefile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+const dnsmasqFormat = "dnsmasq.leases"
+
+// ReadDnsmasq parses a dnsmasq lease file from r, putting each IPv4 lease
+// into store.  Each line holds the expiry time (0 for never), hardware
+// address, IP, hostname and client identifier, with "*" for unknown values.
+// dnsmasq doesn't record when a lease started, so imported leases have a
+// zero Start.  DHCPv6 entries are skipped.
+func ReadDnsmasq(r io.Reader, store dhcp4.LeaseStore) error {
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		errorf := func(format string, a ...interface{}) error {
+			return &ParseError{Format: dnsmasqFormat, Line: line, Err: fmt.Sprintf(format, a...)}
+		}
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0] == "duid" {
+			continue
+		}
+		if len(fields) != 5 {
+			return errorf("expected 5 fields, found %d", len(fields))
+		}
+		var l dhcp4.Lease
+		ip := net.ParseIP(fields[2])
+		if ip == nil {
+			return errorf("bad address %q", fields[2])
+		}
+		if l.IP = ip.To4(); l.IP == nil {
+			continue // DHCPv6
+		}
+		expiry, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return errorf("bad expiry %q", fields[0])
+		}
+		if expiry != 0 {
+			l.Expiry = time.Unix(expiry, 0)
+		}
+		if hw := fields[1]; hw != "*" {
+			// Non ethernet addresses are prefixed by their hardware type, e.g. "06-"
+			if i := strings.IndexByte(hw, '-'); i == 2 {
+				hw = hw[3:]
+			}
+			if l.HardwareAddr, err = parseHex(hw); err != nil {
+				return errorf("bad hardware address %q", fields[1])
+			}
+		}
+		if fields[3] != "*" {
+			l.Hostname = fields[3]
+		}
+		if id := fields[4]; id != "*" {
+			if l.ClientID, err = parseHex(id); err != nil {
+				return errorf("bad client id %q", id)
+			}
+		}
+		if err := store.Put(l); err != nil {
+			return err
+		}
+	}
+	return s.Err()
+}
+
+// WriteDnsmasq writes every lease in store to w in dnsmasq lease file format.
+// dnsmasq itself discards any that have expired when it loads the file.
+// dnsmasq has no notion of quarantined addresses, so they are omitted.
+func WriteDnsmasq(w io.Writer, store dhcp4.LeaseStore) error {
+	bw := bufio.NewWriter(w)
+	for _, l := range sortedLeases(store) {
+		if l.Quarantined {
+			continue
+		}
+		var expiry int64
+		if !l.Expiry.IsZero() {
+			expiry = l.Expiry.Unix()
+		}
+		fmt.Fprintf(bw, "%d %s %s %s %s\n", expiry, orStar(formatHex(l.HardwareAddr)),
+			l.IP, orStar(l.Hostname), orStar(formatHex(l.ClientID)))
+	}
+	return bw.Flush()
+}
+
+func orStar(s string) string {
+	if s == "" {
+		return "*"
+	}
+	return s
+}
@@ -0,0 +1,81 @@
+package leasefile
+
+import (
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestReadDnsmasq(t *testing.T) {
+	f, err := os.Open("testdata/dnsmasq.leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	store := dhcp4.NewMemoryLeaseStore()
+	if err := ReadDnsmasq(f, store); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []dhcp4.Lease{
+		{
+			IP:           net.IP{192, 168, 1, 101},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0x4c, 0xa2, 0x3f},
+			ClientID:     []byte{1, 0x08, 0x00, 0x27, 0x4c, 0xa2, 0x3f},
+			Hostname:     "DESKTOP-1",
+			Expiry:       time.Unix(1575453600, 0),
+		},
+		{ // Infinite lease
+			IP:           net.IP{192, 168, 1, 102},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0, 0, 2},
+			Hostname:     "printer",
+		},
+		{ // Hardware type prefixed
+			IP:           net.IP{192, 168, 1, 103},
+			HardwareAddr: net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+			ClientID:     []byte{0xff, 0, 0x11, 0x22, 0x33, 0, 1, 0, 1, 0x25, 0xf4, 0xea, 0x22, 0x08, 0, 0x27, 0xae, 0xc9, 0xcd},
+			Expiry:       time.Unix(1575450000, 0),
+		},
+		// DHCPv6 lease skipped
+	}
+	assertLeases(t, "dnsmasq.leases", tests, store)
+}
+
+func TestDnsmasqRoundTrip(t *testing.T) {
+	testRoundTrip(t, "testdata/dnsmasq.leases", ReadDnsmasq, WriteDnsmasq)
+}
+
+func TestReadDnsmasqErrors(t *testing.T) {
+	var tests = []struct {
+		description string
+		data        string
+		line        int
+	}{
+		{
+			description: "missing field",
+			data:        "1575450000 08:00:27:00:00:02 192.168.1.102 printer\n",
+			line:        1,
+		},
+		{
+			description: "bad expiry",
+			data:        "\nsoon 08:00:27:00:00:02 192.168.1.102 printer *\n",
+			line:        2,
+		},
+		{
+			description: "bad address",
+			data:        "0 08:00:27:00:00:02 192.168.1 printer *\n",
+			line:        1,
+		},
+	}
+
+	for i, tt := range tests {
+		err := ReadDnsmasq(strings.NewReader(tt.data), dhcp4.NewMemoryLeaseStore())
+		if pe, ok := err.(*ParseError); !ok || pe.Line != tt.line {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, tt.description, err)
+		}
+	}
+}
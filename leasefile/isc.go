@@ -0,0 +1,347 @@
+package leasefile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+const iscFormat = "dhcpd.leases"
+
+// iscTime is the layout of ISC dhcpd's default (UTC) lease times, which are
+// preceded by the day of the week.
+const iscTime = "2006/01/02 15:04:05"
+
+// ReadISC parses an ISC dhcpd.leases file from r, putting each lease into
+// store.  As dhcpd appends to its lease file, later entries for an address
+// replace earlier ones.  Leases in any binding state are imported, as dhcpd
+// uses the hardware address of free leases to give clients back their old
+// address; those not active carry their (past) end time.  Abandoned leases
+// are imported as quarantined.
+func ReadISC(r io.Reader, store dhcp4.LeaseStore) error {
+	t := &iscTokenizer{r: bufio.NewReader(r), line: 1}
+	for {
+		tok, err := t.next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if tok != "lease" {
+			if err := t.skipStatement(tok); err != nil {
+				return err
+			}
+			continue
+		}
+		l, err := t.lease()
+		if err != nil {
+			return err
+		}
+		if err := store.Put(l); err != nil {
+			return err
+		}
+	}
+}
+
+// WriteISC writes every lease in store to w in dhcpd.leases format.
+func WriteISC(w io.Writer, store dhcp4.LeaseStore) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "# The format of this file is documented in the dhcpd.leases(5) manual page.\n")
+	fmt.Fprintf(bw, "# Written by github.com/krolaw/dhcp4\n\n")
+	now := time.Now()
+	for _, l := range sortedLeases(store) {
+		fmt.Fprintf(bw, "lease %s {\n", l.IP)
+		if !l.Start.IsZero() {
+			fmt.Fprintf(bw, "  starts %s;\n", iscFormatTime(l.Start))
+			fmt.Fprintf(bw, "  cltt %s;\n", iscFormatTime(l.Start))
+		}
+		fmt.Fprintf(bw, "  ends %s;\n", iscFormatTime(l.Expiry))
+		if l.Quarantined {
+			fmt.Fprintf(bw, "  binding state abandoned;\n")
+		} else if l.Expired(now) {
+			fmt.Fprintf(bw, "  binding state free;\n")
+		} else {
+			fmt.Fprintf(bw, "  binding state active;\n  next binding state free;\n")
+		}
+		if len(l.HardwareAddr) > 0 {
+			fmt.Fprintf(bw, "  hardware ethernet %s;\n", l.HardwareAddr)
+		}
+		if len(l.ClientID) > 0 {
+			fmt.Fprintf(bw, "  uid %s;\n", iscQuote(string(l.ClientID)))
+		}
+		if l.Hostname != "" {
+			fmt.Fprintf(bw, "  client-hostname %s;\n", iscQuote(l.Hostname))
+		}
+		fmt.Fprintf(bw, "}\n")
+	}
+	return bw.Flush()
+}
+
+func iscFormatTime(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+	t = t.UTC()
+	return strconv.Itoa(int(t.Weekday())) + " " + t.Format(iscTime)
+}
+
+// iscQuote quotes s as dhcpd does, escaping unprintable bytes in octal.
+func iscQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < ' ' || c > '~':
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// iscTokenizer splits a dhcpd.leases file into words, quoted strings (which
+// are returned with their quotes, unescaped), and the punctuation { } ;
+type iscTokenizer struct {
+	r    *bufio.Reader
+	line int
+}
+
+func (t *iscTokenizer) errorf(format string, a ...interface{}) error {
+	return &ParseError{Format: iscFormat, Line: t.line, Err: fmt.Sprintf(format, a...)}
+}
+
+func (t *iscTokenizer) next() (string, error) {
+	for {
+		c, err := t.r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case c == '\n':
+			t.line++
+		case c == ' ' || c == '\t' || c == '\r':
+		case c == '#':
+			if _, err := t.r.ReadString('\n'); err != nil {
+				return "", err
+			}
+			t.line++
+		case c == '{' || c == '}' || c == ';':
+			return string(c), nil
+		case c == '"':
+			return t.quoted()
+		default:
+			word := []byte{c}
+			for {
+				c, err := t.r.ReadByte()
+				if err == io.EOF {
+					return string(word), nil
+				} else if err != nil {
+					return "", err
+				}
+				if strings.IndexByte(" \t\r\n{};\"#", c) >= 0 {
+					t.r.UnreadByte()
+					return string(word), nil
+				}
+				word = append(word, c)
+			}
+		}
+	}
+}
+
+func (t *iscTokenizer) quoted() (string, error) {
+	s := []byte{'"'}
+	for {
+		c, err := t.r.ReadByte()
+		if err != nil {
+			return "", t.errorf("unterminated string")
+		}
+		switch c {
+		case '"':
+			return string(append(s, '"')), nil
+		case '\n':
+			t.line++
+		case '\\':
+			if c, err = t.r.ReadByte(); err != nil {
+				return "", t.errorf("unterminated string")
+			}
+			if c >= '0' && c <= '7' {
+				oct := []byte{c}
+				for len(oct) < 3 {
+					d, err := t.r.ReadByte()
+					if err != nil {
+						break
+					}
+					if d < '0' || d > '7' {
+						t.r.UnreadByte()
+						break
+					}
+					oct = append(oct, d)
+				}
+				n, _ := strconv.ParseUint(string(oct), 8, 8)
+				c = byte(n)
+			} else if c == 'n' {
+				c = '\n'
+			} else if c == 't' {
+				c = '\t'
+			}
+		}
+		s = append(s, c)
+	}
+}
+
+// statement returns the tokens up to the terminating semicolon.
+func (t *iscTokenizer) statement(first string) ([]string, error) {
+	toks := []string{first}
+	for {
+		tok, err := t.next()
+		if err != nil {
+			return nil, t.errorf("unterminated statement %q", first)
+		}
+		switch tok {
+		case ";":
+			return toks, nil
+		case "{", "}":
+			return nil, t.errorf("unexpected %q in statement %q", tok, first)
+		}
+		toks = append(toks, tok)
+	}
+}
+
+// skipStatement skips a statement or block that isn't understood, such as
+// "on expiry { ... }" or "failover peer ... state { ... }".
+func (t *iscTokenizer) skipStatement(first string) error {
+	tok := first
+	for depth := 0; ; {
+		switch tok {
+		case "{":
+			depth++
+		case "}":
+			if depth--; depth < 0 {
+				return t.errorf("unexpected \"}\"")
+			}
+			if depth == 0 {
+				return nil
+			}
+		case ";":
+			if depth == 0 {
+				return nil
+			}
+		}
+		var err error
+		if tok, err = t.next(); err != nil {
+			return t.errorf("unterminated statement %q", first)
+		}
+	}
+}
+
+func (t *iscTokenizer) lease() (l dhcp4.Lease, err error) {
+	addr, err := t.next()
+	if err != nil {
+		return l, t.errorf("missing lease address")
+	}
+	if l.IP = net.ParseIP(addr).To4(); l.IP == nil {
+		return l, t.errorf("bad lease address %q", addr)
+	}
+	if tok, _ := t.next(); tok != "{" {
+		return l, t.errorf("expected \"{\" after lease %s", addr)
+	}
+	for {
+		tok, err := t.next()
+		if err != nil {
+			return l, t.errorf("unterminated lease %s", addr)
+		}
+		switch tok {
+		case "}":
+			return l, nil
+		case "starts", "ends", "binding", "hardware", "uid", "client-hostname":
+		default:
+			if err := t.skipStatement(tok); err != nil {
+				return l, err
+			}
+			continue
+		}
+		s, err := t.statement(tok)
+		if err != nil {
+			return l, err
+		}
+		switch s[0] {
+		case "starts":
+			l.Start, err = t.time(s[1:])
+		case "ends":
+			l.Expiry, err = t.time(s[1:])
+		case "binding":
+			if len(s) != 3 || s[1] != "state" {
+				return l, t.errorf("bad binding statement")
+			}
+			l.Quarantined = s[2] == "abandoned"
+		case "hardware":
+			if len(s) != 3 {
+				return l, t.errorf("bad hardware statement")
+			}
+			if l.HardwareAddr, err = net.ParseMAC(s[2]); err != nil {
+				err = t.errorf("bad hardware address %q", s[2])
+			}
+		case "uid":
+			if len(s) != 2 {
+				return l, t.errorf("bad uid statement")
+			}
+			l.ClientID, err = t.data(s[1])
+		case "client-hostname":
+			if len(s) != 2 || !strings.HasPrefix(s[1], `"`) {
+				return l, t.errorf("bad client-hostname statement")
+			}
+			l.Hostname = s[1][1 : len(s[1])-1]
+		}
+		if err != nil {
+			return l, err
+		}
+	}
+}
+
+// time parses "never", "epoch <seconds>" or "<weekday> yyyy/mm/dd hh:mm:ss".
+func (t *iscTokenizer) time(s []string) (time.Time, error) {
+	switch {
+	case len(s) == 1 && s[0] == "never":
+		return time.Time{}, nil
+	case len(s) == 2 && s[0] == "epoch":
+		secs, err := strconv.ParseInt(s[1], 10, 64)
+		if err != nil {
+			return time.Time{}, t.errorf("bad epoch time %q", s[1])
+		}
+		return time.Unix(secs, 0), nil
+	case len(s) == 3:
+		tm, err := time.Parse(iscTime, s[1]+" "+s[2])
+		if err != nil {
+			return tm, t.errorf("bad time %q", strings.Join(s, " "))
+		}
+		return tm, nil
+	}
+	return time.Time{}, t.errorf("bad time %q", strings.Join(s, " "))
+}
+
+// data parses a quoted string or colon separated hex bytes.
+func (t *iscTokenizer) data(s string) ([]byte, error) {
+	if strings.HasPrefix(s, `"`) {
+		return []byte(s[1 : len(s)-1]), nil
+	}
+	var b []byte
+	for _, h := range strings.Split(s, ":") {
+		n, err := strconv.ParseUint(h, 16, 8)
+		if err != nil {
+			return nil, t.errorf("bad data %q", s)
+		}
+		b = append(b, byte(n))
+	}
+	return b, nil
+}
@@ -0,0 +1,140 @@
+package leasefile
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestReadISC(t *testing.T) {
+	f, err := os.Open("testdata/dhcpd.leases")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	store := dhcp4.NewMemoryLeaseStore()
+	if err := ReadISC(f, store); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []dhcp4.Lease{
+		{ // Second entry for the address replaces the first
+			IP:           net.IP{192, 168, 56, 101},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0x4c, 0xa2, 0x3f},
+			ClientID:     []byte{1, 8, 0, '\'', 'L', 0242, '?'},
+			Hostname:     "DESKTOP-1",
+			Start:        time.Date(2019, 12, 4, 9, 17, 41, 0, time.UTC),
+			Expiry:       time.Date(2019, 12, 4, 9, 27, 41, 0, time.UTC),
+		},
+		{
+			IP:           net.IP{192, 168, 56, 102},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0, 0, 2},
+			ClientID:     []byte{1, 8, 0, 0x27, 0, 0, 2},
+			Start:        time.Date(2019, 12, 4, 8, 1, 2, 0, time.UTC),
+			Expiry:       time.Date(2019, 12, 4, 8, 11, 2, 0, time.UTC),
+		},
+		{
+			IP:           net.IP{192, 168, 56, 103},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0, 0, 3},
+			Hostname:     `printer "lab"`,
+			Start:        time.Unix(1575450000, 0),
+		},
+		{
+			IP:          net.IP{192, 168, 56, 104},
+			Start:       time.Date(2019, 12, 4, 7, 0, 0, 0, time.UTC),
+			Expiry:      time.Date(2019, 12, 5, 7, 0, 0, 0, time.UTC),
+			Quarantined: true,
+		},
+	}
+	assertLeases(t, "dhcpd.leases", tests, store)
+}
+
+func TestISCRoundTrip(t *testing.T) {
+	testRoundTrip(t, "testdata/dhcpd.leases", ReadISC, WriteISC)
+}
+
+// testRoundTrip reads file, writes the leases out and reads them back again,
+// checking nothing was lost.
+func testRoundTrip(t *testing.T, file string, read func(io.Reader, dhcp4.LeaseStore) error, write func(io.Writer, dhcp4.LeaseStore) error) {
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	want := dhcp4.NewMemoryLeaseStore()
+	if err := read(f, want); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := write(&buf, want); err != nil {
+		t.Fatal(err)
+	}
+	got := dhcp4.NewMemoryLeaseStore()
+	if err := read(&buf, got); err != nil {
+		t.Fatal(err)
+	}
+	var leases []dhcp4.Lease
+	want.Iterate(func(l dhcp4.Lease) bool { leases = append(leases, l); return true })
+	assertLeases(t, file+" round trip", leases, got)
+}
+
+func TestReadISCErrors(t *testing.T) {
+	var tests = []struct {
+		description string
+		data        string
+		line        int
+	}{
+		{
+			description: "bad address",
+			data:        "\nlease 192.168.1 {\n}\n",
+			line:        2,
+		},
+		{
+			description: "bad hardware address",
+			data:        "lease 192.168.1.1 {\n  hardware ethernet 00:11;\n}\n",
+			line:        2,
+		},
+		{
+			description: "bad time",
+			data:        "lease 192.168.1.1 {\n  starts 3 2019/13/04 09:12:41;\n}\n",
+			line:        2,
+		},
+		{
+			description: "unterminated lease",
+			data:        "lease 192.168.1.1 {\n  starts 3 2019/12/04 09:12:41;\n",
+			line:        3,
+		},
+	}
+
+	for i, tt := range tests {
+		err := ReadISC(bytes.NewBufferString(tt.data), dhcp4.NewMemoryLeaseStore())
+		if pe, ok := err.(*ParseError); !ok || pe.Line != tt.line {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, tt.description, err)
+		}
+	}
+}
+
+// assertLeases checks that store holds exactly the leases in want.
+func assertLeases(t *testing.T, description string, want []dhcp4.Lease, store dhcp4.LeaseStore) {
+	count := 0
+	store.Iterate(func(dhcp4.Lease) bool { count++; return true })
+	if count != len(want) {
+		t.Fatalf("test %q, unexpected lease count: %d != %d", description, count, len(want))
+	}
+	for i, w := range want {
+		got, ok := store.Get(w.IP)
+		if !ok {
+			t.Fatalf("%02d: test %q, missing lease %s", i, description, w.IP)
+		}
+		if !got.IP.Equal(w.IP) || !bytes.Equal(got.HardwareAddr, w.HardwareAddr) ||
+			!bytes.Equal(got.ClientID, w.ClientID) || got.Hostname != w.Hostname ||
+			!got.Start.Equal(w.Start) || !got.Expiry.Equal(w.Expiry) || got.Quarantined != w.Quarantined {
+			t.Fatalf("%02d: test %q, unexpected lease: %v != %v", i, description, got, w)
+		}
+	}
+}
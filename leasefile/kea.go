@@ -0,0 +1,184 @@
+package leasefile
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+const keaFormat = "kea-leases4.csv"
+
+// Header written by WriteKea, matching Kea 2.x memfile.
+const keaHeader = "address,hwaddr,client_id,valid_lifetime,expire,subnet_id,fqdn_fwd,fqdn_rev,hostname,state,user_context,pool_id"
+
+// Kea lease states
+const (
+	keaStateDefault   = 0
+	keaStateDeclined  = 1
+	keaStateReclaimed = 2
+)
+
+// keaInfinite is Kea's infinite valid lifetime.
+const keaInfinite = 0xffffffff
+
+// Kea needs a lifetime for each lease, but leases imported from formats
+// lacking a start time (such as dnsmasq's) don't have one.
+const keaDefaultLifetime = 24 * time.Hour
+
+// ReadKea parses a Kea memfile lease file (kea-leases4.csv) from r, putting
+// each lease into store.  Columns are located by the header, so files from
+// older Kea versions, lacking the later columns, are accepted.  As the
+// memfile is appended to, later rows for an address replace earlier ones, and
+// rows with a zero valid lifetime (Kea's record of a deleted lease) remove
+// it.  Declined addresses are imported as quarantined.
+func ReadKea(r io.Reader, store dhcp4.LeaseStore) error {
+	s := bufio.NewScanner(r)
+	line := 0
+	errorf := func(format string, a ...interface{}) error {
+		return &ParseError{Format: keaFormat, Line: line, Err: fmt.Sprintf(format, a...)}
+	}
+	cols := map[string]int{}
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		if text == "" {
+			continue
+		}
+		fields := strings.Split(text, ",")
+		if len(cols) == 0 {
+			for i, name := range fields {
+				cols[name] = i
+			}
+			for _, name := range []string{"address", "hwaddr", "client_id", "valid_lifetime", "expire"} {
+				if _, ok := cols[name]; !ok {
+					return errorf("header missing column %q", name)
+				}
+			}
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := cols[name]; ok && i < len(fields) {
+				return fields[i]
+			}
+			return ""
+		}
+
+		var l dhcp4.Lease
+		if l.IP = net.ParseIP(field("address")).To4(); l.IP == nil {
+			return errorf("bad address %q", field("address"))
+		}
+		lifetime, err := strconv.ParseUint(field("valid_lifetime"), 10, 32)
+		if err != nil {
+			return errorf("bad valid_lifetime %q", field("valid_lifetime"))
+		}
+		if lifetime == 0 {
+			if err := store.Delete(l.IP); err != nil {
+				return err
+			}
+			continue
+		}
+		expire, err := strconv.ParseInt(field("expire"), 10, 64)
+		if err != nil {
+			return errorf("bad expire %q", field("expire"))
+		}
+		if state := field("state"); state != "" {
+			if n, err := strconv.Atoi(state); err != nil {
+				return errorf("bad state %q", state)
+			} else if n == keaStateDeclined {
+				l.Quarantined = true
+			}
+		}
+		if hw := field("hwaddr"); hw != "" {
+			if l.HardwareAddr, err = parseHex(hw); err != nil {
+				return errorf("bad hwaddr %q", hw)
+			}
+		}
+		if id := field("client_id"); id != "" {
+			if l.ClientID, err = parseHex(id); err != nil {
+				return errorf("bad client_id %q", id)
+			}
+		}
+		l.Hostname = keaUnescape(field("hostname"))
+		l.Start = time.Unix(expire-int64(lifetime), 0)
+		if lifetime != keaInfinite {
+			l.Expiry = time.Unix(expire, 0)
+		}
+		if err := store.Put(l); err != nil {
+			return err
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	if len(cols) == 0 {
+		return errorf("missing header")
+	}
+	return nil
+}
+
+// WriteKea writes every lease in store to w as a Kea memfile lease file.
+// subnetID returns the Kea subnet-id for a leased address; if nil, all leases
+// are placed in subnet 1.
+func WriteKea(w io.Writer, store dhcp4.LeaseStore, subnetID func(ip net.IP) uint32) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintln(bw, keaHeader)
+	now := time.Now()
+	for _, l := range sortedLeases(store) {
+		id := uint32(1)
+		if subnetID != nil {
+			id = subnetID(l.IP)
+		}
+		var lifetime, expire int64
+		switch {
+		case l.Expiry.IsZero():
+			lifetime, expire = keaInfinite, now.Unix()
+			if !l.Start.IsZero() {
+				expire = l.Start.Unix()
+			}
+			expire += lifetime
+		case l.Start.IsZero():
+			lifetime = int64(keaDefaultLifetime / time.Second)
+			expire = l.Expiry.Unix()
+		default:
+			lifetime = int64(l.Expiry.Sub(l.Start) / time.Second)
+			if lifetime < 1 {
+				lifetime = 1 // Zero would mark the lease deleted
+			}
+			expire = l.Expiry.Unix()
+		}
+		state := keaStateDefault
+		if l.Quarantined {
+			state = keaStateDeclined
+		} else if l.Expired(now) {
+			state = keaStateReclaimed
+		}
+		fmt.Fprintf(bw, "%s,%s,%s,%d,%d,%d,0,0,%s,%d,,0\n", l.IP, formatHex(l.HardwareAddr),
+			formatHex(l.ClientID), lifetime, expire, id, keaEscape(l.Hostname), state)
+	}
+	return bw.Flush()
+}
+
+// Kea escapes commas in text fields, to keep its CSV unquoted.
+func keaEscape(s string) string   { return strings.Replace(s, ",", "&#x2c", -1) }
+func keaUnescape(s string) string { return strings.Replace(s, "&#x2c", ",", -1) }
+
+// parseHex parses colon separated hex bytes, such as "01:0a:ff".
+func parseHex(s string) ([]byte, error) {
+	return hex.DecodeString(strings.Replace(s, ":", "", -1))
+}
+
+// formatHex formats b as colon separated hex bytes.
+func formatHex(b []byte) string {
+	parts := make([]string, len(b))
+	for i, v := range b {
+		parts[i] = fmt.Sprintf("%02x", v)
+	}
+	return strings.Join(parts, ":")
+}
@@ -0,0 +1,110 @@
+package leasefile
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+func TestReadKea(t *testing.T) {
+	f, err := os.Open("testdata/kea-leases4.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	store := dhcp4.NewMemoryLeaseStore()
+	if err := ReadKea(f, store); err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []dhcp4.Lease{
+		{ // Renewal row replaces the first
+			IP:           net.IP{192, 0, 2, 10},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0x4c, 0xa2, 0x3f},
+			ClientID:     []byte{1, 0x08, 0x00, 0x27, 0x4c, 0xa2, 0x3f},
+			Hostname:     "desktop-1.example.com",
+			Start:        time.Unix(1575453600, 0),
+			Expiry:       time.Unix(1575457200, 0),
+		},
+		{
+			IP:           net.IP{192, 0, 2, 11},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0, 0, 2},
+			Hostname:     "web, server",
+			Start:        time.Unix(1575446400, 0),
+			Expiry:       time.Unix(1575450000, 0),
+		},
+		{
+			IP:          net.IP{192, 0, 2, 12},
+			Start:       time.Unix(1575446400, 0),
+			Expiry:      time.Unix(1575450000, 0),
+			Quarantined: true,
+		},
+		// 192.0.2.13 deleted
+		{
+			IP:           net.IP{192, 0, 2, 14},
+			HardwareAddr: net.HardwareAddr{0x08, 0x00, 0x27, 0, 0, 5},
+			Hostname:     "printer",
+			Start:        time.Unix(1575450000, 0),
+		},
+	}
+	assertLeases(t, "kea-leases4.csv", tests, store)
+}
+
+func TestKeaRoundTrip(t *testing.T) {
+	testRoundTrip(t, "testdata/kea-leases4.csv", ReadKea, func(w io.Writer, s dhcp4.LeaseStore) error {
+		return WriteKea(w, s, nil)
+	})
+}
+
+func TestWriteKeaSubnetID(t *testing.T) {
+	store := dhcp4.NewMemoryLeaseStore()
+	store.Put(dhcp4.Lease{IP: net.IP{10, 0, 0, 1}, Start: time.Unix(1575450000, 0), Expiry: time.Unix(1575453600, 0)})
+	var buf bytes.Buffer
+	WriteKea(&buf, store, func(net.IP) uint32 { return 7 })
+	want := keaHeader + "\n10.0.0.1,,,3600,1575453600,7,0,0,,2,,0\n"
+	if got := buf.String(); got != want {
+		t.Fatalf("WriteKea, unexpected result: %q != %q", got, want)
+	}
+}
+
+func TestReadKeaErrors(t *testing.T) {
+	var tests = []struct {
+		description string
+		data        string
+		line        int
+	}{
+		{
+			description: "missing header",
+			data:        "",
+			line:        0,
+		},
+		{
+			description: "header lacks columns",
+			data:        "address,hwaddr\n",
+			line:        1,
+		},
+		{
+			description: "bad address",
+			data:        keaHeader + "\n192.0.2,,,3600,1575450000,1,0,0,,0,,0\n",
+			line:        2,
+		},
+		{
+			description: "bad hwaddr",
+			data:        keaHeader + "\n\n192.0.2.1,zz,,3600,1575450000,1,0,0,,0,,0\n",
+			line:        3,
+		},
+	}
+
+	for i, tt := range tests {
+		err := ReadKea(strings.NewReader(tt.data), dhcp4.NewMemoryLeaseStore())
+		if pe, ok := err.(*ParseError); !ok || pe.Line != tt.line {
+			t.Fatalf("%02d: test %q, unexpected error: %v", i, tt.description, err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+// Package leasefile reads and writes the lease databases of other DHCP
+// servers, so that leases can be carried to and from a dhcp4.LeaseStore when
+// migrating between servers.
+package leasefile
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// sortedLeases returns the leases in store ordered by IP.
+func sortedLeases(store dhcp4.LeaseStore) []dhcp4.Lease {
+	var leases []dhcp4.Lease
+	store.Iterate(func(l dhcp4.Lease) bool {
+		leases = append(leases, l)
+		return true
+	})
+	sort.Slice(leases, func(i, j int) bool { return dhcp4.IPLess(leases[i].IP, leases[j].IP) })
+	return leases
+}
+
+// ParseError reports a malformed lease file entry.
+type ParseError struct {
+	Format string // Lease file format, e.g. "dhcpd.leases"
+	Line   int
+	Err    string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s: line %d: %s", e.Format, e.Line, e.Err)
+}
@@ -0,0 +1,372 @@
+package dhcp4
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+//go:generate stringer -type=LeaseEventType
+
+// Lease lifecycle events
+const (
+	LeaseOffered  LeaseEventType = 1 // Address offered to a client
+	LeaseBound    LeaseEventType = 2 // Address leased to a new client
+	LeaseRenewed  LeaseEventType = 3 // Lease extended for its existing client
+	LeaseReleased LeaseEventType = 4 // Client gave up the lease
+	LeaseDeclined LeaseEventType = 5 // Client found the address in use
+	LeaseExpired  LeaseEventType = 6 // Lease reached its expiry time
+)
+
+type LeaseEventType byte
+
+// A LeaseEvent describes a change to a lease.
+type LeaseEvent struct {
+	Type  LeaseEventType
+	Lease Lease
+	Time  time.Time
+}
+
+// Clock provides the time to a LeaseManager, allowing tests to control it.
+type Clock interface {
+	Now() time.Time
+	AfterFunc(d time.Duration, f func()) Timer
+}
+
+// Timer is a pending Clock.AfterFunc call.
+type Timer interface {
+	Stop() bool
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                            { return time.Now() }
+func (realClock) AfterFunc(d time.Duration, f func()) Timer { return time.AfterFunc(d, f) }
+
+// Timer wheel granularity and size.  Expiries further than a revolution away
+// simply stay in their slot until their time comes around.
+const (
+	wheelTick  = time.Second
+	wheelSlots = 3600
+)
+
+// LeaseManager wraps a LeaseStore, tracking the expiry of its leases on a
+// timer wheel and publishing LeaseEvents to subscribers.  It is itself a
+// LeaseStore, so may be used wherever one is expected; the plain LeaseStore
+// methods change leases without publishing events, while Offer, Bind,
+// Release and Decline publish the appropriate event.
+//
+// Subscribers learn of every change as it happens, so components such as DNS
+// updaters, auditing and metrics can react to leases without polling.
+type LeaseManager struct {
+	LeaseStore
+	clock Clock
+
+	mu        sync.Mutex
+	wheel     [wheelSlots]map[string]time.Time // Slot -> IP -> expiry
+	slot      map[string]int                   // IP -> slot
+	offers    map[string]Lease                 // IP -> offer, held until expiry
+	cursor    int64                            // Last tick processed
+	timer     Timer
+	nextSub   int
+	callbacks map[int]func(LeaseEvent)
+}
+
+// NewLeaseManager returns a LeaseManager for store.  If clock is nil, the
+// system clock is used.  The manager schedules the expiry of every unexpired
+// lease already in store.
+func NewLeaseManager(store LeaseStore, clock Clock) *LeaseManager {
+	if clock == nil {
+		clock = realClock{}
+	}
+	m := &LeaseManager{
+		LeaseStore: store,
+		clock:      clock,
+		slot:       make(map[string]int),
+		offers:     make(map[string]Lease),
+		callbacks:  make(map[int]func(LeaseEvent)),
+	}
+	now := clock.Now()
+	m.cursor = now.UnixNano() / int64(wheelTick)
+	m.mu.Lock()
+	store.Iterate(func(l Lease) bool {
+		if !l.Expiry.IsZero() && !l.Expired(now) {
+			m.schedule(l.IP, l.Expiry)
+		}
+		return true
+	})
+	m.mu.Unlock()
+	return m
+}
+
+// Subscribe registers fn to be called with each event.  fn is called
+// synchronously by whatever changed the lease (often the DHCP server), so it
+// must not block for long.  The returned func cancels the subscription.
+func (m *LeaseManager) Subscribe(fn func(LeaseEvent)) (cancel func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id := m.nextSub
+	m.nextSub++
+	m.callbacks[id] = fn
+	return func() {
+		m.mu.Lock()
+		delete(m.callbacks, id)
+		m.mu.Unlock()
+	}
+}
+
+// Events returns a channel receiving each event, buffered to size.  Events
+// are dropped, rather than holding up the server, while the buffer is full.
+// The returned func cancels the subscription and closes the channel.
+func (m *LeaseManager) Events(size int) (events <-chan LeaseEvent, cancel func()) {
+	ch := make(chan LeaseEvent, size)
+	var once sync.Once
+	var mu sync.Mutex // Prevents sending on a closed channel
+	closed := false
+	unsubscribe := m.Subscribe(func(e LeaseEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if closed {
+			return
+		}
+		select {
+		case ch <- e:
+		default:
+		}
+	})
+	return ch, func() {
+		once.Do(func() {
+			unsubscribe()
+			mu.Lock()
+			closed = true
+			close(ch)
+			mu.Unlock()
+		})
+	}
+}
+
+func (m *LeaseManager) publish(t LeaseEventType, l Lease) {
+	e := LeaseEvent{Type: t, Lease: l, Time: m.clock.Now()}
+	m.mu.Lock()
+	callbacks := make([]func(LeaseEvent), 0, len(m.callbacks))
+	for _, fn := range m.callbacks {
+		callbacks = append(callbacks, fn)
+	}
+	m.mu.Unlock()
+	for _, fn := range callbacks {
+		fn(e)
+	}
+}
+
+// Offer holds l.IP for the client in l until l.Expiry, so it isn't offered to
+// anyone else, and publishes LeaseOffered.  The offer isn't stored in the
+// LeaseStore.
+func (m *LeaseManager) Offer(l Lease) {
+	m.mu.Lock()
+	ip := l.IP.String()
+	m.offers[ip] = l
+	m.reschedule(l.IP)
+	m.mu.Unlock()
+	m.publish(LeaseOffered, l)
+}
+
+// Offered returns the outstanding offer of ip, if any.
+func (m *LeaseManager) Offered(ip net.IP) (Lease, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.offers[ip.String()]
+	if ok && l.Expired(m.clock.Now()) {
+		return Lease{}, false
+	}
+	return l, ok
+}
+
+// offeredTo returns the outstanding offer to the client that sent p, if any.
+func (m *LeaseManager) offeredTo(p Packet, options Options) (Lease, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	for _, o := range m.offers {
+		if !o.Expired(now) && sameClient(o, p, options) {
+			return o, true
+		}
+	}
+	return Lease{}, false
+}
+
+// Bind stores l, publishing LeaseRenewed if it extends an unexpired lease
+// held by the same client, otherwise LeaseBound.
+func (m *LeaseManager) Bind(l Lease) error {
+	t := LeaseBound
+	if old, ok := m.LeaseStore.Get(l.IP); ok && !old.Expired(m.clock.Now()) &&
+		bytes.Equal(old.HardwareAddr, l.HardwareAddr) && bytes.Equal(old.ClientID, l.ClientID) {
+		t = LeaseRenewed
+	}
+	if err := m.Put(l); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.offers, l.IP.String())
+	m.mu.Unlock()
+	m.publish(t, l)
+	return nil
+}
+
+// Release removes ip's lease, publishing LeaseReleased.
+func (m *LeaseManager) Release(ip net.IP) error { return m.remove(ip, LeaseReleased) }
+
+// Decline removes ip's lease, publishing LeaseDeclined.
+func (m *LeaseManager) Decline(ip net.IP) error { return m.remove(ip, LeaseDeclined) }
+
+// Quarantine keeps ip from being leased for d, by storing a Quarantined
+// lease of it.  When the quarantine ends, the lease is deleted without an
+// event being published.
+func (m *LeaseManager) Quarantine(ip net.IP, d time.Duration) error {
+	now := m.clock.Now()
+	return m.Put(Lease{IP: append(net.IP(nil), ip...), Start: now, Expiry: now.Add(d), Quarantined: true})
+}
+
+func (m *LeaseManager) remove(ip net.IP, t LeaseEventType) error {
+	l, ok := m.LeaseStore.Get(ip)
+	if !ok {
+		m.mu.Lock()
+		l, ok = m.offers[ip.String()]
+		m.mu.Unlock()
+		if !ok {
+			return nil
+		}
+	}
+	if err := m.Delete(ip); err != nil {
+		return err
+	}
+	m.publish(t, l)
+	return nil
+}
+
+// Put stores l and schedules its expiry, without publishing an event.  A
+// lease that has already expired isn't scheduled, so never expires while
+// managed.
+func (m *LeaseManager) Put(l Lease) error {
+	if err := m.LeaseStore.Put(l); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	m.reschedule(l.IP)
+	m.mu.Unlock()
+	return nil
+}
+
+// Delete removes ip's lease and any offer of it, without publishing an event.
+func (m *LeaseManager) Delete(ip net.IP) error {
+	if err := m.LeaseStore.Delete(ip); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	delete(m.offers, ip.String())
+	m.unschedule(ip)
+	m.mu.Unlock()
+	return nil
+}
+
+// Stop cancels the expiry timer.  No further LeaseExpired events are
+// published, unless leases are changed again.
+func (m *LeaseManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.timer != nil {
+		m.timer.Stop()
+		m.timer = nil
+	}
+}
+
+// schedule places ip in the wheel slot for expiry.  m.mu must be held.
+func (m *LeaseManager) schedule(ip net.IP, expiry time.Time) {
+	tick := (expiry.UnixNano() + int64(wheelTick) - 1) / int64(wheelTick) // Round up
+	if tick <= m.cursor {
+		tick = m.cursor + 1
+	}
+	key := ip.String()
+	n := int(tick % wheelSlots)
+	if m.wheel[n] == nil {
+		m.wheel[n] = make(map[string]time.Time)
+	}
+	m.wheel[n][key] = expiry
+	m.slot[key] = n
+	if m.timer == nil {
+		m.timer = m.clock.AfterFunc(wheelTick, m.advance)
+	}
+}
+
+// reschedule places ip in the wheel at the earlier of the unexpired expiries
+// of its lease and offer, if any.  m.mu must be held.
+func (m *LeaseManager) reschedule(ip net.IP) {
+	m.unschedule(ip)
+	now := m.clock.Now()
+	var next time.Time
+	if o, ok := m.offers[ip.String()]; ok && !o.Expiry.IsZero() && !o.Expired(now) {
+		next = o.Expiry
+	}
+	if l, ok := m.LeaseStore.Get(ip); ok && !l.Expiry.IsZero() && !l.Expired(now) &&
+		(next.IsZero() || l.Expiry.Before(next)) {
+		next = l.Expiry
+	}
+	if !next.IsZero() {
+		m.schedule(ip, next)
+	}
+}
+
+// unschedule removes ip from the wheel.  m.mu must be held.
+func (m *LeaseManager) unschedule(ip net.IP) {
+	key := ip.String()
+	if n, ok := m.slot[key]; ok {
+		delete(m.wheel[n], key)
+		delete(m.slot, key)
+	}
+}
+
+// advance processes the wheel slots up to the current time, publishing
+// LeaseExpired for each lease that has expired.
+func (m *LeaseManager) advance() {
+	m.mu.Lock()
+	now := m.clock.Now()
+	current := now.UnixNano() / int64(wheelTick)
+	expired := make(map[string]time.Time)
+	for t := m.cursor + 1; t <= current && t <= m.cursor+wheelSlots; t++ {
+		n := int(t % wheelSlots)
+		for key, expiry := range m.wheel[n] {
+			if !expiry.After(now) {
+				delete(m.wheel[n], key)
+				delete(m.slot, key)
+				expired[key] = expiry
+			}
+		}
+	}
+	if current > m.cursor {
+		m.cursor = current
+	}
+	m.timer = nil
+	var events []Lease
+	for key, expiry := range expired {
+		ip := net.ParseIP(key)
+		if o, ok := m.offers[key]; ok && o.Expired(now) {
+			delete(m.offers, key) // Offers lapse silently
+		}
+		// Ignore lapsed offers of addresses whose lease expired long ago
+		if l, ok := m.LeaseStore.Get(ip); ok && l.Expiry.Equal(expiry) {
+			if l.Quarantined {
+				m.LeaseStore.Delete(l.IP) // Quarantines end silently
+			} else {
+				events = append(events, l)
+			}
+		}
+		m.reschedule(ip) // The lease or offer yet to expire, if any
+	}
+	if m.timer == nil && len(m.slot) > 0 {
+		m.timer = m.clock.AfterFunc(wheelTick, m.advance)
+	}
+	m.mu.Unlock()
+	for _, l := range events {
+		m.publish(LeaseExpired, l)
+	}
+}
@@ -0,0 +1,218 @@
+package dhcp4
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a Clock whose time only moves when told to.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+}
+
+type fakeTimer struct {
+	when    time.Time
+	f       func()
+	stopped bool
+}
+
+func (t *fakeTimer) Stop() bool {
+	wasActive := !t.stopped
+	t.stopped = true
+	return wasActive
+}
+
+func newFakeClock() *fakeClock { return &fakeClock{now: time.Unix(1400000000, 0)} }
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) AfterFunc(d time.Duration, f func()) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t := &fakeTimer{when: c.now.Add(d), f: f}
+	c.timers = append(c.timers, t)
+	return t
+}
+
+// Advance moves time forward by d, one second at a time, firing due timers.
+func (c *fakeClock) Advance(d time.Duration) {
+	for end := c.Now().Add(d); c.Now().Before(end); {
+		c.mu.Lock()
+		c.now = c.now.Add(time.Second)
+		var due []*fakeTimer
+		pending := c.timers[:0]
+		for _, t := range c.timers {
+			if t.stopped {
+				continue
+			}
+			if !t.when.After(c.now) {
+				due = append(due, t)
+			} else {
+				pending = append(pending, t)
+			}
+		}
+		c.timers = pending
+		c.mu.Unlock()
+		for _, t := range due {
+			t.f()
+		}
+	}
+}
+
+func TestLeaseManagerEvents(t *testing.T) {
+	clock := newFakeClock()
+	m := NewLeaseManager(NewMemoryLeaseStore(), clock)
+	var events []LeaseEvent
+	cancel := m.Subscribe(func(e LeaseEvent) { events = append(events, e) })
+
+	now := clock.Now()
+	l := Lease{IP: net.IP{10, 0, 0, 1}, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}, Start: now, Expiry: now.Add(time.Minute)}
+	m.Offer(l)
+	if _, ok := m.Offered(l.IP); !ok {
+		t.Fatalf("Offered(%s), offer missing", l.IP)
+	}
+	m.Bind(l)
+	l.Expiry = now.Add(2 * time.Minute)
+	m.Bind(l)
+	other := Lease{IP: net.IP{10, 0, 0, 2}, HardwareAddr: net.HardwareAddr{0, 1, 2, 3, 4, 6}, Start: now, Expiry: now.Add(time.Hour)}
+	m.Bind(other)
+	m.Release(other.IP)
+	m.Bind(other)
+	m.Decline(other.IP)
+	m.Release(other.IP) // Nothing left to release
+
+	clock.Advance(90 * time.Second)
+	if len(events) != 7 {
+		t.Fatalf("unexpected events before expiry: %v", events)
+	}
+	clock.Advance(time.Minute)
+
+	want := []LeaseEventType{LeaseOffered, LeaseBound, LeaseRenewed, LeaseBound, LeaseReleased, LeaseBound, LeaseDeclined, LeaseExpired}
+	if len(events) != len(want) {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	for i, e := range events {
+		if e.Type != want[i] {
+			t.Fatalf("%02d: unexpected event: %s != %s", i, e.Type, want[i])
+		}
+	}
+	if e := events[7]; !e.Lease.IP.Equal(l.IP) || e.Time.Before(l.Expiry) {
+		t.Fatalf("unexpected expiry event: %v", e)
+	}
+	// Expired leases are kept, so the client can have its address back
+	if _, ok := m.Get(l.IP); !ok {
+		t.Fatalf("expired lease removed from store")
+	}
+
+	cancel()
+	m.Bind(l)
+	if len(events) != len(want) {
+		t.Fatalf("event delivered after cancel")
+	}
+}
+
+func TestLeaseManagerRescheduled(t *testing.T) {
+	clock := newFakeClock()
+	store := NewMemoryLeaseStore()
+	now := clock.Now()
+	store.Put(Lease{IP: net.IP{10, 0, 0, 1}, Expiry: now.Add(time.Minute)})
+	store.Put(Lease{IP: net.IP{10, 0, 0, 2}, Expiry: now.Add(-time.Minute)}) // Already expired
+	store.Put(Lease{IP: net.IP{10, 0, 0, 3}})                                // Never expires
+	// Beyond one revolution of the wheel
+	store.Put(Lease{IP: net.IP{10, 0, 0, 4}, Expiry: now.Add(wheelSlots*wheelTick + 5*time.Second)})
+
+	m := NewLeaseManager(store, clock)
+	events, cancel := m.Events(10)
+	defer cancel()
+
+	clock.Advance(2 * time.Minute)
+	if e := <-events; e.Type != LeaseExpired || !e.Lease.IP.Equal(net.IP{10, 0, 0, 1}) {
+		t.Fatalf("unexpected event: %v", e)
+	}
+	clock.Advance(wheelSlots * wheelTick)
+	select {
+	case e := <-events:
+		if !e.Lease.IP.Equal(net.IP{10, 0, 0, 4}) {
+			t.Fatalf("unexpected event: %v", e)
+		}
+	default:
+		t.Fatalf("lease beyond a revolution didn't expire")
+	}
+	select {
+	case e := <-events:
+		t.Fatalf("unexpected event: %v", e)
+	default:
+	}
+}
+
+func TestLeaseManagerOfferLapses(t *testing.T) {
+	clock := newFakeClock()
+	m := NewLeaseManager(NewMemoryLeaseStore(), clock)
+	var events []LeaseEvent
+	m.Subscribe(func(e LeaseEvent) { events = append(events, e) })
+
+	ip := net.IP{10, 0, 0, 1}
+	m.Offer(Lease{IP: ip, Expiry: clock.Now().Add(time.Minute)})
+	clock.Advance(2 * time.Minute)
+	if _, ok := m.Offered(ip); ok {
+		t.Fatalf("offer of %s didn't lapse", ip)
+	}
+	if len(events) != 1 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+func TestLeaseManagerPutExpired(t *testing.T) {
+	clock := newFakeClock()
+	m := NewLeaseManager(NewMemoryLeaseStore(), clock)
+	var events []LeaseEvent
+	m.Subscribe(func(e LeaseEvent) { events = append(events, e) })
+
+	// Imported leases that have already expired never expire while managed
+	m.Put(Lease{IP: net.IP{10, 0, 0, 1}, Expiry: clock.Now().Add(-time.Minute)})
+	m.Put(Lease{IP: net.IP{10, 0, 0, 2}, Expiry: clock.Now()})
+	clock.Advance(time.Minute)
+	if len(events) != 0 {
+		t.Fatalf("unexpected events: %v", events)
+	}
+}
+
+func TestLeaseManagerOfferOfLease(t *testing.T) {
+	var tests = []struct {
+		lease, offer time.Duration // Expiries
+	}{
+		{30 * time.Second, 2 * time.Minute},
+		{10 * time.Minute, time.Minute},
+	}
+	for i, test := range tests {
+		clock := newFakeClock()
+		m := NewLeaseManager(NewMemoryLeaseStore(), clock)
+		var events []LeaseEvent
+		m.Subscribe(func(e LeaseEvent) {
+			if e.Type == LeaseExpired {
+				events = append(events, e)
+			}
+		})
+		ip := net.IP{10, 0, 0, 1}
+		m.Put(Lease{IP: ip, Expiry: clock.Now().Add(test.lease)})
+		m.Offer(Lease{IP: ip, Expiry: clock.Now().Add(test.offer)})
+		clock.Advance(test.lease + test.offer)
+		m.mu.Lock()
+		offers := len(m.offers)
+		m.mu.Unlock()
+		if offers != 0 {
+			t.Fatalf("%02d: test %v, offer not removed on lapsing", i, test)
+		}
+		if len(events) != 1 {
+			t.Fatalf("%02d: test %v, unexpected expiries: %v", i, test, events)
+		}
+	}
+}
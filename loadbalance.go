@@ -0,0 +1,117 @@
+package dhcp4
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// HashBuckets is an RFC 3074 hash bucket assignment: bit n (least significant
+// bit first) of the 256 bit map is set if the server serves bucket n.
+type HashBuckets [32]byte
+
+// BucketRange returns the assignment of buckets from to to inclusive.
+func BucketRange(from, to int) (h HashBuckets) {
+	for b := from; b <= to && b < 256; b++ {
+		h.Set(byte(b))
+	}
+	return h
+}
+
+// Contains returns true if bucket b is assigned.
+func (h *HashBuckets) Contains(b byte) bool { return h[b/8]&(1<<(b%8)) != 0 }
+
+// Set assigns bucket b.
+func (h *HashBuckets) Set(b byte) { h[b/8] |= 1 << (b % 8) }
+
+// LoadBalancer is a Handler that passes to Handler only those requests that
+// this server should answer when sharing clients with other servers, per RFC
+// 3074.  Each client is hashed, by client identifier if it sent one,
+// otherwise by hardware address, into one of 256 buckets, which are divided
+// between the servers.
+//
+// Requests naming a server identifier are answered only by that server,
+// regardless of bucket.
+type LoadBalancer struct {
+	Handler  Handler
+	ServerID net.IP      // This server's identifier, nil to pass all
+	Buckets  HashBuckets // Buckets this server serves
+	// MaxSecs, if non zero, has clients that have been trying for at least
+	// this many seconds (the secs field) answered regardless of bucket, in
+	// case the server owning their bucket is down.
+	MaxSecs uint16
+}
+
+// NewLoadBalancer returns a LoadBalancer for h, identified as serverID,
+// serving buckets.
+func NewLoadBalancer(h Handler, serverID net.IP, buckets HashBuckets) *LoadBalancer {
+	return &LoadBalancer{Handler: h, ServerID: serverID, Buckets: buckets}
+}
+
+func (lb *LoadBalancer) ServeDHCP(req Packet, msgType MessageType, options Options) Packet {
+	if lb.Serves(req, msgType, options) {
+		return lb.Handler.ServeDHCP(req, msgType, options)
+	}
+	return nil
+}
+
+// Serves returns true if this server should answer req.
+func (lb *LoadBalancer) Serves(req Packet, msgType MessageType, options Options) bool {
+	if server, ok := options[OptionServerIdentifier]; ok {
+		return lb.ServerID == nil || net.IP(server).Equal(lb.ServerID)
+	}
+	switch msgType {
+	case Request:
+		// In RENEWING, the client unicasts to the server that leased its
+		// address, with ciaddr set, so it must answer whatever the bucket.
+		// REBINDING can't be told apart by the packet alone, so is too.
+		if !net.IP(req.CIAddr()).Equal(net.IPv4zero) {
+			return true
+		}
+		fallthrough
+	case Discover, Inform: // Request in INIT-REBOOT
+		if lb.MaxSecs > 0 && binary.BigEndian.Uint16(req.Secs()) >= lb.MaxSecs {
+			return true
+		}
+		return lb.Buckets.Contains(ClientHash(req, options))
+	}
+	return true
+}
+
+// ClientHash returns the client's RFC 3074 hash bucket, of its client
+// identifier if it sent one, otherwise its hardware address.
+func ClientHash(p Packet, options Options) byte {
+	key := options[OptionClientIdentifier]
+	if len(key) == 0 {
+		key = p.CHAddr()
+	}
+	return pearsonHash(key)
+}
+
+// pearsonHash is the hash function of RFC 3074 section 6.
+func pearsonHash(key []byte) byte {
+	hash := byte(len(key))
+	for i := len(key) - 1; i >= 0; i-- {
+		hash = loadbMxTbl[hash^key[i]]
+	}
+	return hash
+}
+
+var loadbMxTbl = [256]byte{
+	251, 175, 119, 215, 81, 14, 79, 191, 103, 49, 181, 143, 186, 157, 0,
+	232, 31, 32, 55, 60, 152, 58, 17, 237, 174, 70, 160, 144, 220, 90, 57,
+	223, 59, 3, 18, 140, 111, 166, 203, 196, 134, 243, 124, 95, 222, 179,
+	197, 65, 180, 48, 36, 15, 107, 46, 233, 130, 165, 30, 123, 161, 209, 23,
+	97, 16, 40, 91, 219, 61, 100, 10, 210, 109, 250, 127, 22, 138, 29, 108,
+	244, 67, 207, 9, 178, 204, 74, 98, 126, 249, 167, 116, 34, 77, 193, 68,
+	200, 121, 5, 20, 113, 71, 35, 128, 13, 182, 94, 25, 226, 227, 199, 75,
+	27, 41, 245, 230, 224, 43, 225, 177, 26, 155, 150, 212, 142, 218, 115, 80,
+	241, 73, 88, 105, 39, 114, 62, 255, 192, 201, 145, 214, 168, 158, 221,
+	148, 154, 122, 12, 84, 82, 163, 44, 139, 228, 236, 205, 242, 217, 11, 170,
+	187, 146, 159, 64, 86, 239, 195, 42, 106, 198, 118, 112, 184, 172, 87,
+	2, 173, 117, 176, 229, 247, 253, 137, 185, 99, 164, 102, 147, 45, 66,
+	231, 52, 141, 211, 194, 206, 246, 238, 56, 110, 78, 248, 63, 240, 189,
+	93, 92, 51, 53, 183, 19, 171, 72, 50, 33, 104, 101, 69, 8, 252, 83, 120,
+	76, 135, 85, 54, 202, 125, 188, 213, 96, 235, 136, 208, 162, 129, 190,
+	132, 156, 38, 47, 1, 7, 254, 24, 4, 216, 131, 89, 21, 28, 133, 37, 153,
+	149, 6, 169, 234, 151,
+}
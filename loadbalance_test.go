@@ -0,0 +1,82 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPearsonHash(t *testing.T) {
+	var seen [256]bool
+	for _, v := range loadbMxTbl {
+		if seen[v] {
+			t.Fatalf("Table, duplicate value: %d", v)
+		}
+		seen[v] = true
+	}
+	// Keys spread over the buckets
+	var buckets [256]int
+	for i := 0; i < 256*16; i++ {
+		buckets[pearsonHash([]byte{0, 1, 2, 3, byte(i >> 8), byte(i)})]++
+	}
+	for b, n := range buckets {
+		if n == 0 || n > 64 {
+			t.Fatalf("Bucket %d, unexpected count: %d", b, n)
+		}
+	}
+}
+
+type echoHandler struct{}
+
+func (echoHandler) ServeDHCP(req Packet, msgType MessageType, options Options) Packet { return req }
+
+func TestLoadBalancer(t *testing.T) {
+	serverID := net.IP{192, 168, 1, 1}
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	id := []byte{1, 0, 1, 2, 3, 4, 6}
+	macBucket := pearsonHash(mac)
+	idBucket := pearsonHash(id)
+	if macBucket == idBucket {
+		t.Fatalf("Test keys share bucket %d", macBucket)
+	}
+
+	for i, test := range []struct {
+		buckets HashBuckets
+		maxSecs uint16
+		mt      MessageType
+		secs    byte
+		options []Option
+		ciaddr  net.IP
+		served  bool
+	}{
+		{BucketRange(int(macBucket), int(macBucket)), 0, Discover, 0, nil, nil, true},
+		{BucketRange(int(idBucket), int(idBucket)), 0, Discover, 0, nil, nil, false},
+		{BucketRange(int(idBucket), int(idBucket)), 0, Discover, 0, []Option{{OptionClientIdentifier, id}}, nil, true},
+		{BucketRange(int(macBucket), int(macBucket)), 0, Discover, 0, []Option{{OptionClientIdentifier, id}}, nil, false},
+		{BucketRange(0, 255), 0, Request, 0, nil, nil, true},
+		{HashBuckets{}, 0, Request, 0, nil, nil, false},
+		{HashBuckets{}, 0, Inform, 0, nil, nil, false},
+		{HashBuckets{}, 0, Request, 0, []Option{{OptionServerIdentifier, serverID}}, nil, true},
+		{BucketRange(0, 255), 0, Request, 0, []Option{{OptionServerIdentifier, []byte{192, 168, 1, 2}}}, nil, false},
+		{HashBuckets{}, 0, Release, 0, nil, nil, true},
+		{HashBuckets{}, 10, Discover, 9, nil, nil, false},
+		{HashBuckets{}, 10, Discover, 10, nil, nil, true},
+		{HashBuckets{}, 0, Request, 0, nil, net.IP{192, 168, 1, 10}, true}, // RENEWING
+	} {
+		lb := NewLoadBalancer(echoHandler{}, serverID, test.buckets)
+		lb.MaxSecs = test.maxSecs
+		req := RequestPacket(test.mt, mac, test.ciaddr, []byte{1, 2, 3, 4}, true, test.options)
+		req.SetSecs([]byte{0, test.secs})
+		if served := lb.ServeDHCP(req, test.mt, req.ParseOptions()) != nil; served != test.served {
+			t.Fatalf("%02d: test %v, unexpected served: %v != %v", i, test.mt, served, test.served)
+		}
+	}
+
+	// Complementary assignments split clients between servers
+	a, b := NewLoadBalancer(echoHandler{}, nil, BucketRange(0, 127)), NewLoadBalancer(echoHandler{}, nil, BucketRange(128, 255))
+	for i := 0; i < 256; i++ {
+		req := RequestPacket(Discover, net.HardwareAddr{0, 1, 2, 3, 4, byte(i)}, nil, nil, true, nil)
+		if a.Serves(req, Discover, req.ParseOptions()) == b.Serves(req, Discover, req.ParseOptions()) {
+			t.Fatalf("%02d: client served by both or neither", i)
+		}
+	}
+}
@@ -0,0 +1,350 @@
+// Package loadgen generates load on DHCP servers, in the manner of perfdhcp:
+// it simulates clients, each with its own hardware address, acquiring leases
+// by four-way exchange (DISCOVER, OFFER, REQUEST, ACK) and then renewing
+// them, and reports the latency of each exchange, and how many requests went
+// unanswered or were NAKed.
+//
+// Requests are sent as if by a relay agent, with giaddr set, so replies are
+// unicast back rather than broadcast to clients, and no raw sockets are
+// needed.  Run against a UDPTransport to test a server over the network, or
+// a HandlerTransport to test a Handler in-process, such as in a benchmark:
+//
+//	func BenchmarkServer(b *testing.B) {
+//		s := dhcp4.NewServer(...)
+//		b.ResetTimer()
+//		r, err := loadgen.Run(context.Background(), loadgen.Config{Clients: b.N, GIAddr: ...}, loadgen.HandlerTransport{s})
+//		...
+//	}
+package loadgen
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// Config defaults
+const (
+	DefaultConcurrency = 100
+	DefaultTimeout     = time.Second
+)
+
+// DefaultFirstMAC is the hardware address of the first client by default.
+var DefaultFirstMAC = net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+
+// Config describes the load to generate.
+type Config struct {
+	Clients  int     // Number of clients simulated
+	Renewals int     // Renewals by each client once bound
+	Rate     float64 // Target exchanges started per second, or 0 for no limit
+	// Concurrency limits the clients exchanging at once, DefaultConcurrency
+	// if 0.
+	Concurrency int
+	Timeout     time.Duration // For each reply, DefaultTimeout if 0
+	GIAddr      net.IP        // Relay agent address, on the subnet to lease from
+	// FirstMAC is the hardware address of the first client, DefaultFirstMAC
+	// if nil.  Each following client's is one more.
+	FirstMAC net.HardwareAddr
+	Options  []dhcp4.Option // Added to every DISCOVER and REQUEST
+}
+
+// Transport exchanges requests for replies.
+type Transport interface {
+	// Exchange sends req, returning its reply, or nil if none arrives within
+	// timeout.
+	Exchange(ctx context.Context, req dhcp4.Packet, timeout time.Duration) (dhcp4.Packet, error)
+}
+
+// HandlerTransport is a Transport passing requests straight to Handler.
+type HandlerTransport struct {
+	Handler dhcp4.Handler
+}
+
+// Exchange returns the Handler's reply to req.
+func (t HandlerTransport) Exchange(ctx context.Context, req dhcp4.Packet, timeout time.Duration) (dhcp4.Packet, error) {
+	options := req.ParseOptions()
+	return t.Handler.ServeDHCP(req, dhcp4.MessageType(options[dhcp4.OptionDHCPMessageType][0]), options), nil
+}
+
+// Report describes the outcome of Run.
+type Report struct {
+	Clients int           // Clients started
+	Bound   int           // Four-way exchanges completed
+	Renewed int           // Renewals ACKed
+	Drops   int           // Requests unanswered within the timeout
+	NAKs    int           // Requests NAKed
+	Errors  int           // Unexpected replies, such as offers without an address
+	Elapsed time.Duration // From the first request to the last reply
+
+	// Latencies of answered requests: DISCOVERs, REQUESTs in four-way
+	// exchanges, and renewal REQUESTs.
+	Discover, Request, Renew Latency
+}
+
+// Latency summarises the latencies of a type of request.
+type Latency struct {
+	N                             int
+	Min, Mean, P50, P90, P99, Max time.Duration
+}
+
+func newLatency(d []time.Duration) Latency {
+	if len(d) == 0 {
+		return Latency{}
+	}
+	sort.Sort(durations(d))
+	var sum time.Duration
+	for _, x := range d {
+		sum += x
+	}
+	pc := func(p int) time.Duration { return d[(len(d)-1)*p/100] }
+	return Latency{N: len(d), Min: d[0], Mean: sum / time.Duration(len(d)),
+		P50: pc(50), P90: pc(90), P99: pc(99), Max: d[len(d)-1]}
+}
+
+type durations []time.Duration
+
+func (d durations) Len() int           { return len(d) }
+func (d durations) Less(i, j int) bool { return d[i] < d[j] }
+func (d durations) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+
+func (l Latency) String() string {
+	if l.N == 0 {
+		return "none"
+	}
+	return fmt.Sprintf("n=%d min=%v mean=%v p50=%v p90=%v p99=%v max=%v", l.N, l.Min, l.Mean, l.P50, l.P90, l.P99, l.Max)
+}
+
+// Rate returns the exchanges completed per second.
+func (r *Report) Rate() float64 {
+	if r.Elapsed <= 0 {
+		return 0
+	}
+	return float64(r.Bound+r.Renewed) / r.Elapsed.Seconds()
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("clients %d, bound %d, renewed %d, drops %d, NAKs %d, errors %d in %v (%.1f exchanges/s)\n"+
+		"discover: %v\nrequest:  %v\nrenew:    %v\n",
+		r.Clients, r.Bound, r.Renewed, r.Drops, r.NAKs, r.Errors, r.Elapsed, r.Rate(), r.Discover, r.Request, r.Renew)
+}
+
+// ErrNoGIAddr is returned by Run if Config.GIAddr isn't set.
+var ErrNoGIAddr = errors.New("loadgen: no giaddr")
+
+// Run simulates c.Clients clients against t, returning a report once they
+// have all finished, or ctx is done.  A client gives up at the first request
+// unanswered or NAKed.  An error is only returned if t fails.
+func Run(ctx context.Context, c Config, t Transport) (*Report, error) {
+	if c.GIAddr.To4() == nil {
+		return nil, ErrNoGIAddr
+	}
+	g := &generator{Config: c, t: t, xid: rand.Uint32()}
+	if g.Concurrency <= 0 {
+		g.Concurrency = DefaultConcurrency
+	}
+	if g.Timeout <= 0 {
+		g.Timeout = DefaultTimeout
+	}
+	if g.FirstMAC == nil {
+		g.FirstMAC = DefaultFirstMAC
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if g.Rate > 0 {
+		g.tokens = make(chan struct{})
+		go g.limit(ctx)
+	}
+
+	clients := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < g.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range clients {
+				if err := g.client(ctx, n); err != nil {
+					g.fail(err)
+					cancel()
+				}
+			}
+		}()
+	}
+	start := time.Now()
+feed:
+	for n := 0; n < g.Clients; n++ {
+		select {
+		case clients <- n:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(clients)
+	wg.Wait()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.report.Elapsed = time.Since(start)
+	g.report.Discover = newLatency(g.latency[0])
+	g.report.Request = newLatency(g.latency[1])
+	g.report.Renew = newLatency(g.latency[2])
+	return &g.report, g.err
+}
+
+// Exchange kinds, indexing generator.latency
+const (
+	discover = iota
+	request
+	renew
+)
+
+type generator struct {
+	Config
+	t      Transport
+	tokens chan struct{} // Rate limiting, if set
+	xid    uint32
+
+	mu      sync.Mutex
+	report  Report
+	latency [3][]time.Duration
+	err     error
+}
+
+// limit issues tokens at Rate until ctx is done.
+func (g *generator) limit(ctx context.Context) {
+	interval := time.Duration(float64(time.Second) / g.Rate)
+	next := time.Now()
+	for {
+		select {
+		case g.tokens <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		next = next.Add(interval)
+		if d := time.Until(next); d > 0 {
+			select {
+			case <-time.After(d):
+			case <-ctx.Done():
+				return
+			}
+		} else if d < -time.Second {
+			next = time.Now() // Don't burst to catch up on long stalls
+		}
+	}
+}
+
+func (g *generator) fail(err error) {
+	g.mu.Lock()
+	if g.err == nil {
+		g.err = err
+	}
+	g.mu.Unlock()
+}
+
+func (g *generator) count(f func(r *Report)) {
+	g.mu.Lock()
+	f(&g.report)
+	g.mu.Unlock()
+}
+
+// mac returns the hardware address of client n.
+func (g *generator) mac(n int) net.HardwareAddr {
+	mac := append(net.HardwareAddr(nil), g.FirstMAC...)
+	carry := uint64(n)
+	for i := len(mac) - 1; i >= 0 && carry > 0; i-- {
+		carry += uint64(mac[i])
+		mac[i] = byte(carry)
+		carry >>= 8
+	}
+	return mac
+}
+
+// client runs client n's exchanges.
+func (g *generator) client(ctx context.Context, n int) error {
+	mac := g.mac(n)
+	g.count(func(r *Report) { r.Clients++ })
+
+	offer, err := g.exchange(ctx, discover, mac, nil)
+	if offer == nil || err != nil {
+		return err
+	}
+	ip, server := offer.YIAddr(), offer.ParseOptions()[dhcp4.OptionServerIdentifier]
+	if ip.Equal(net.IPv4zero) || len(server) != 4 {
+		g.count(func(r *Report) { r.Errors++ })
+		return nil
+	}
+	ack, err := g.exchange(ctx, request, mac, nil,
+		dhcp4.Option{Code: dhcp4.OptionRequestedIPAddress, Value: ip.To4()},
+		dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: server})
+	if ack == nil || err != nil {
+		return err
+	}
+	g.count(func(r *Report) { r.Bound++ })
+
+	for i := 0; i < g.Renewals; i++ {
+		if ack, err = g.exchange(ctx, renew, mac, ip); ack == nil || err != nil {
+			return err
+		}
+		g.count(func(r *Report) { r.Renewed++ })
+	}
+	return nil
+}
+
+// exchange sends a request of kind from mac (and ciaddr, when renewing),
+// returning the reply expected, or nil if none is received.
+func (g *generator) exchange(ctx context.Context, kind int, mac net.HardwareAddr, ciaddr net.IP, extra ...dhcp4.Option) (dhcp4.Packet, error) {
+	if g.tokens != nil {
+		select {
+		case <-g.tokens:
+		case <-ctx.Done():
+			return nil, nil
+		}
+	} else if ctx.Err() != nil {
+		return nil, nil
+	}
+
+	mt, want := dhcp4.Request, dhcp4.ACK
+	if kind == discover {
+		mt, want = dhcp4.Discover, dhcp4.Offer
+	}
+	xid := make([]byte, 4)
+	binary.BigEndian.PutUint32(xid, atomic.AddUint32(&g.xid, 1))
+	req := dhcp4.RequestPacket(mt, mac, ciaddr, xid, false, append(extra, g.Options...))
+	req.SetGIAddr(g.GIAddr.To4())
+	req.SetHops(1)
+
+	start := time.Now()
+	res, err := g.t.Exchange(ctx, req, g.Timeout)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, nil // Cut short
+		}
+		return nil, err
+	}
+	latency := time.Since(start)
+	if res == nil {
+		g.count(func(r *Report) { r.Drops++ })
+		return nil, nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.latency[kind] = append(g.latency[kind], latency)
+	t := res.ParseOptions()[dhcp4.OptionDHCPMessageType]
+	switch {
+	case len(t) == 1 && dhcp4.MessageType(t[0]) == want:
+		return res, nil
+	case len(t) == 1 && dhcp4.MessageType(t[0]) == dhcp4.NAK:
+		g.report.NAKs++
+	default:
+		g.report.Errors++
+	}
+	return nil, nil
+}
@@ -0,0 +1,107 @@
+package loadgen
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// nakHandler NAKs every REQUEST.
+type nakHandler struct{ *dhcp4.Server }
+
+func (h nakHandler) ServeDHCP(p dhcp4.Packet, mt dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	if mt == dhcp4.Request {
+		return dhcp4.ReplyPacket(p, dhcp4.NAK, net.IP{192, 168, 1, 1}, nil, 0, nil)
+	}
+	return h.Server.ServeDHCP(p, mt, options)
+}
+
+func TestRun(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 100, time.Hour, nil, nil)
+	r, err := Run(context.Background(), Config{Clients: 120, Renewals: 2, GIAddr: net.IP{192, 168, 1, 1}, Concurrency: 8}, HandlerTransport{s})
+	if err != nil {
+		t.Fatalf("Run, unexpected error: %v", err)
+	}
+	// The pool runs out after 100 clients
+	if r.Clients != 120 || r.Bound != 100 || r.Renewed != 200 || r.Drops != 20 || r.NAKs != 0 || r.Errors != 0 {
+		t.Fatalf("unexpected report: %v", r)
+	}
+	if r.Discover.N != 100 || r.Request.N != 100 || r.Renew.N != 200 || r.Renew.Max < r.Renew.P50 {
+		t.Fatalf("unexpected latencies: %v", r)
+	}
+	mac := DefaultFirstMAC // Client 0, among the first to DISCOVER
+	found := false
+	s.Leases().Iterate(func(l dhcp4.Lease) bool {
+		found = found || l.HardwareAddr.String() == mac.String()
+		return !found
+	})
+	if !found {
+		t.Fatalf("no lease to %s", mac)
+	}
+
+	// NAKed clients give up
+	s = dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 100, time.Hour, nil, nil)
+	if r, err = Run(context.Background(), Config{Clients: 5, GIAddr: net.IP{192, 168, 1, 1}}, HandlerTransport{nakHandler{s}}); err != nil || r.NAKs != 5 || r.Bound != 0 {
+		t.Fatalf("unexpected NAK report: %v %v", r, err)
+	}
+}
+
+func TestMAC(t *testing.T) {
+	g := &generator{Config: Config{FirstMAC: net.HardwareAddr{0x02, 0, 0, 0, 0xfe, 0xff}}}
+	for i, test := range []struct {
+		n    int
+		want string
+	}{
+		{0, "02:00:00:00:fe:ff"},
+		{1, "02:00:00:00:ff:00"},
+		{0x10101, "02:00:00:02:00:00"},
+	} {
+		if mac := g.mac(test.n).String(); mac != test.want {
+			t.Fatalf("%02d: test %d, unexpected address: %s != %s", i, test.n, mac, test.want)
+		}
+	}
+}
+
+func TestRunRate(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 100, time.Hour, nil, nil)
+	r, err := Run(context.Background(), Config{Clients: 10, GIAddr: net.IP{192, 168, 1, 1}, Rate: 100}, HandlerTransport{s})
+	if err != nil || r.Bound != 10 {
+		t.Fatalf("unexpected report: %v %v", r, err)
+	}
+	// 20 exchanges at 100/s
+	if r.Elapsed < 150*time.Millisecond {
+		t.Fatalf("rate exceeded: %v", r.Elapsed)
+	}
+}
+
+func TestUDPTransport(t *testing.T) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 100, time.Hour, nil, nil)
+	l, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go dhcp4.Serve(l, s)
+
+	tr, err := ListenUDP("127.0.0.1:0", l.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	r, err := Run(context.Background(), Config{Clients: 50, Renewals: 1, GIAddr: net.IP{192, 168, 1, 1}}, tr)
+	if err != nil || r.Bound != 50 || r.Renewed != 50 || r.Drops != 0 {
+		t.Fatalf("unexpected report: %v %v", r, err)
+	}
+}
+
+func BenchmarkServer(b *testing.B) {
+	s := dhcp4.NewServer(net.IP{192, 168, 1, 1}, net.IP{10, 0, 0, 1}, 1<<22, time.Hour, nil, nil)
+	b.ResetTimer()
+	r, err := Run(context.Background(), Config{Clients: b.N, GIAddr: net.IP{192, 168, 1, 1}}, HandlerTransport{s})
+	if err != nil || r.Drops > 0 {
+		b.Fatalf("unexpected report: %v %v", r, err)
+	}
+}
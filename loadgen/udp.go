@@ -0,0 +1,116 @@
+package loadgen
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// UDPTransport is a Transport sending requests to a server over UDP, and
+// matching replies to them by transaction id.  Servers send replies to
+// relayed requests to giaddr, port 67, so the conn should normally be bound
+// there, though servers such as dhcp4.Serve reply to the address requests
+// came from.
+type UDPTransport struct {
+	conn   net.PacketConn
+	server net.Addr
+
+	mu      sync.Mutex
+	pending map[string]chan dhcp4.Packet // By xid
+	err     error                        // Of the reader, once it fails
+	done    chan struct{}
+}
+
+// NewUDPTransport returns a UDPTransport sending to server through conn.  It
+// reads replies from conn until closed.
+func NewUDPTransport(conn net.PacketConn, server net.Addr) *UDPTransport {
+	t := &UDPTransport{conn: conn, server: server,
+		pending: make(map[string]chan dhcp4.Packet), done: make(chan struct{})}
+	go t.read()
+	return t
+}
+
+// ListenUDP returns a UDPTransport sending to server from a UDP socket bound
+// to local, both host:port addresses.
+func ListenUDP(local, server string) (*UDPTransport, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenPacket("udp4", local)
+	if err != nil {
+		return nil, err
+	}
+	return NewUDPTransport(conn, addr), nil
+}
+
+func (t *UDPTransport) read() {
+	defer close(t.done)
+	b := make([]byte, 1500)
+	for {
+		n, _, err := t.conn.ReadFrom(b)
+		if err != nil {
+			t.mu.Lock()
+			t.err = err
+			t.mu.Unlock()
+			return
+		}
+		p := dhcp4.Packet(b[:n])
+		if n < 240 || p.OpCode() != dhcp4.BootReply {
+			continue
+		}
+		t.mu.Lock()
+		if ch, ok := t.pending[string(p.XId())]; ok {
+			select {
+			case ch <- append(dhcp4.Packet(nil), p...):
+			default: // Duplicate
+			}
+		}
+		t.mu.Unlock()
+	}
+}
+
+// Exchange sends req to the server, returning the first reply with its
+// transaction id.
+func (t *UDPTransport) Exchange(ctx context.Context, req dhcp4.Packet, timeout time.Duration) (dhcp4.Packet, error) {
+	ch := make(chan dhcp4.Packet, 1)
+	xid := string(req.XId())
+	t.mu.Lock()
+	if t.err != nil {
+		t.mu.Unlock()
+		return nil, t.err
+	}
+	t.pending[xid] = ch
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.pending, xid)
+		t.mu.Unlock()
+	}()
+
+	if _, err := t.conn.WriteTo(req, t.server); err != nil {
+		return nil, err
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-timer.C:
+		return nil, nil
+	case <-t.done:
+		return nil, t.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Close closes the conn.
+func (t *UDPTransport) Close() error {
+	err := t.conn.Close()
+	<-t.done
+	return err
+}
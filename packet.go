@@ -136,13 +136,33 @@ func NewPacket(opCode OpCode) Packet {
 	return p
 }
 
-// Appends a DHCP option to the end of a packet
+// Appends a DHCP option to the end of a packet.  Any padding after End is
+// removed, so padded packets should be padded again.
 func (p *Packet) AddOption(o OptionCode, value []byte) {
-	*p = append((*p)[:len(*p)-1], []byte{byte(o), byte(len(value))}...) // Strip off End, Add OptionCode and Length
+	*p = append((*p)[:p.end()], []byte{byte(o), byte(len(value))}...) // Strip off End, Add OptionCode and Length
 	*p = append(*p, value...)                                           // Add Option Value
 	*p = append(*p, byte(End))                                          // Add on new End
 }
 
+// end returns the index of the End option, or of the last byte if there
+// isn't one.
+func (p Packet) end() int {
+	for i := 240; i < len(p); {
+		switch OptionCode(p[i]) {
+		case End:
+			return i
+		case Pad:
+			i++
+		default:
+			if i+1 >= len(p) {
+				return len(p) - 1
+			}
+			i += 2 + int(p[i+1])
+		}
+	}
+	return len(p) - 1
+}
+
 // Removes all options from packet.
 func (p *Packet) StripOptions() {
 	*p = append((*p)[:240], byte(End))
@@ -296,6 +316,7 @@ const (
 	OptionStreetTalkServer                           OptionCode = 75
 	OptionStreetTalkDirectoryAssistance              OptionCode = 76
 
+	OptionClientFQDN            OptionCode = 81
 	OptionRelayAgentInformation OptionCode = 82
 
 	// DHCP Extensions
@@ -322,7 +343,8 @@ const (
 	OptionTZPOSIXString    OptionCode = 100
 	OptionTZDatabaseString OptionCode = 101
 
-	OptionDomainSearch OptionCode = 119
+	OptionSubnetSelection OptionCode = 118
+	OptionDomainSearch    OptionCode = 119
 
 	OptionClasslessRouteFormat OptionCode = 121
 	
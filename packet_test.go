@@ -431,3 +431,14 @@ var optionsTests = []struct {
 		},
 	},
 }
+
+func TestPacketAddOptionPadded(t *testing.T) {
+	p := RequestPacket(Discover, net.HardwareAddr{0, 1, 2, 3, 4, 5}, nil, []byte{1, 2, 3, 4}, true, nil)
+	p.AddOption(OptionHostName, []byte("host"))
+	if v := p.ParseOptions()[OptionHostName]; string(v) != "host" {
+		t.Fatalf("padded packet, option not added: %q", v)
+	}
+	if p[len(p)-1] != byte(End) {
+		t.Fatalf("padded packet, unexpected last byte: %d", p[len(p)-1])
+	}
+}
@@ -0,0 +1,247 @@
+// Package pxe implements a proxyDHCP server (PXE specification 2.1), which
+// runs alongside a DHCP server that leases addresses, telling PXE clients
+// where to boot from.
+//
+// A Proxy answers only PXE clients: those whose vendor class (option 60)
+// starts "PXEClient", and which send their architecture (option 93).  Its
+// offers lease no address, but name the boot server (siaddr) and boot file,
+// and carry PXE vendor options (option 43).  Without a menu, clients
+// download the boot file straight away; with one, they choose an item, and
+// request its boot file from the boot server (BootHandler), port 4011.
+package pxe
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// PXE options
+const (
+	OptionClientSystemArchitecture dhcp4.OptionCode = 93 // RFC 4578
+	OptionClientNetworkInterface   dhcp4.OptionCode = 94
+	OptionClientMachineIdentifier  dhcp4.OptionCode = 97 // UUID
+)
+
+// PXE vendor sub-options, of option 43
+const (
+	discoveryControl = 6
+	bootServers      = 8
+	bootMenu         = 9
+	menuPrompt       = 10
+	bootItem         = 71
+)
+
+// Discovery control bits
+const (
+	noBroadcast   = 1 << 0 // Don't broadcast to find boot servers
+	noMulticast   = 1 << 1 // Nor multicast
+	bootFileGiven = 1 << 3 // Download the boot file given, without a menu
+)
+
+// Client system architectures (RFC 4578, IANA)
+const (
+	ArchBIOS     uint16 = 0
+	ArchEFIIA32  uint16 = 6
+	ArchEFIBC    uint16 = 7
+	ArchEFIX64   uint16 = 9
+	ArchEFIARM32 uint16 = 10
+	ArchEFIARM64 uint16 = 11
+)
+
+// ServerPort is the port of the boot server exchange.
+const ServerPort = 4011
+
+// MenuItem is an entry in a boot menu.
+type MenuItem struct {
+	Type        uint16 // Boot server type, 0 for a local boot
+	Description string
+	File        string // Boot file, the Proxy's if empty
+}
+
+// Proxy is a proxyDHCP Handler, answering PXE clients' DISCOVERs.  Use
+// BootHandler to serve the boot server exchange.
+type Proxy struct {
+	ServerID   net.IP // The proxy's address
+	BootServer net.IP // TFTP server address (siaddr), ServerID if nil
+	File       string // Boot file
+	// Files are boot files by client architecture (option 93), overriding
+	// File.
+	Files map[uint16]string
+	// Menu, if not empty, is offered to clients, showing Prompt for up to
+	// MenuTimeout (at most 254 seconds) before the first item is booted.  If
+	// MenuTimeout is 0, the first item is booted immediately.
+	Menu        []MenuItem
+	Prompt      string
+	MenuTimeout time.Duration
+}
+
+// New returns a Proxy at serverID, offering file to PXE clients.
+func New(serverID net.IP, file string) *Proxy {
+	return &Proxy{ServerID: serverID, File: file}
+}
+
+// client returns the architecture of the PXE client that sent a request
+// with options, and false if it's not a PXE client.
+func client(options dhcp4.Options) (uint16, bool) {
+	arch := options[OptionClientSystemArchitecture]
+	if !bytes.HasPrefix(options[dhcp4.OptionVendorClassIdentifier], []byte("PXEClient")) || len(arch) < 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(arch), true
+}
+
+// file returns the boot file for a client of arch choosing item type t, or
+// any if t is negative.
+func (p *Proxy) file(arch uint16, t int) string {
+	for _, item := range p.Menu {
+		if int(item.Type) == t && item.File != "" {
+			return item.File
+		}
+	}
+	if f, ok := p.Files[arch]; ok {
+		return f
+	}
+	return p.File
+}
+
+// ServeDHCP answers DISCOVERs from PXE clients with offers of where to boot
+// from, and REQUESTs for those offers (to this server) with ACKs.
+func (p *Proxy) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	arch, ok := client(options)
+	if !ok {
+		return nil
+	}
+	mt := dhcp4.Offer
+	switch msgType {
+	case dhcp4.Discover:
+	case dhcp4.Request:
+		if !net.IP(options[dhcp4.OptionServerIdentifier]).Equal(p.ServerID) {
+			return nil
+		}
+		mt = dhcp4.ACK
+	default:
+		return nil
+	}
+	vendor := p.vendorOptions()
+	res := p.reply(req, mt, options, vendor)
+	if len(p.Menu) == 0 {
+		res.SetFile([]byte(p.file(arch, -1)))
+	}
+	return res
+}
+
+// reply returns a reply to req of type mt, with PXE vendor options.
+func (p *Proxy) reply(req dhcp4.Packet, mt dhcp4.MessageType, options dhcp4.Options, vendor []byte) dhcp4.Packet {
+	opts := []dhcp4.Option{
+		{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient")},
+		{Code: dhcp4.OptionVendorSpecificInformation, Value: vendor},
+	}
+	if uuid, ok := options[OptionClientMachineIdentifier]; ok {
+		opts = append(opts, dhcp4.Option{Code: OptionClientMachineIdentifier, Value: uuid})
+	}
+	res := dhcp4.ReplyPacket(req, mt, p.ServerID.To4(), nil, 0, opts)
+	res.SetCIAddr(req.CIAddr())
+	siaddr := p.BootServer
+	if siaddr == nil {
+		siaddr = p.ServerID
+	}
+	res.SetSIAddr(siaddr)
+	return res
+}
+
+// vendorOptions returns the option 43 value of offers.
+func (p *Proxy) vendorOptions() []byte {
+	if len(p.Menu) == 0 {
+		return subOptions(discoveryControl, []byte{noBroadcast | noMulticast | bootFileGiven})
+	}
+	var servers, menu []byte
+	for _, item := range p.Menu {
+		t := []byte{byte(item.Type >> 8), byte(item.Type)}
+		if item.Type != 0 { // Local boot needs no server
+			servers = append(append(append(servers, t...), 1), p.ServerID.To4()...)
+		}
+		d := item.Description
+		if len(d) > 255 {
+			d = d[:255]
+		}
+		menu = append(append(append(menu, t...), byte(len(d))), d...)
+	}
+	timeout := 0
+	if p.MenuTimeout > 0 {
+		timeout = 254 // 255 would wait forever
+		if p.MenuTimeout < 254*time.Second {
+			timeout = int(p.MenuTimeout / time.Second)
+		}
+	}
+	return subOptions(
+		discoveryControl, []byte{noBroadcast | noMulticast},
+		bootServers, servers,
+		bootMenu, menu,
+		menuPrompt, append([]byte{byte(timeout)}, p.Prompt...))
+}
+
+// subOptions encodes pairs of sub-option codes and values, ending with End.
+// Empty values are skipped.
+func subOptions(pairs ...interface{}) []byte {
+	var b []byte
+	for i := 0; i < len(pairs); i += 2 {
+		if v := pairs[i+1].([]byte); len(v) > 0 {
+			b = append(append(b, byte(pairs[i].(int)), byte(len(v))), v...)
+		}
+	}
+	return append(b, byte(dhcp4.End))
+}
+
+// subOption returns sub-option code of option 43 value b, or nil.
+func subOption(b []byte, code byte) []byte {
+	for len(b) >= 2 && b[0] != byte(dhcp4.End) && len(b) >= 2+int(b[1]) {
+		if b[0] == code {
+			return b[2 : 2+b[1]]
+		}
+		b = b[2+b[1]:]
+	}
+	return nil
+}
+
+// BootHandler returns the Handler of the boot server exchange, on port
+// 4011, which answers REQUESTs for menu items with their boot files.
+func (p *Proxy) BootHandler() dhcp4.Handler { return bootHandler{p} }
+
+type bootHandler struct{ *Proxy }
+
+func (h bootHandler) ServeDHCP(req dhcp4.Packet, msgType dhcp4.MessageType, options dhcp4.Options) dhcp4.Packet {
+	arch, ok := client(options)
+	if !ok || msgType != dhcp4.Request && msgType != dhcp4.Inform {
+		return nil
+	}
+	item := subOption(options[dhcp4.OptionVendorSpecificInformation], bootItem)
+	t := -1
+	var vendor []byte
+	if len(item) == 4 { // Type and layer
+		t = int(binary.BigEndian.Uint16(item))
+		vendor = subOptions(bootItem, []byte{item[0], item[1], item[2] & 0x7f, item[3]})
+	} else {
+		vendor = subOptions()
+	}
+	res := h.reply(req, dhcp4.ACK, options, vendor)
+	res.SetFile([]byte(h.file(arch, t)))
+	return res
+}
+
+// ListenAndServe serves p on ports 67 and 4011, until either fails.
+func ListenAndServe(p *Proxy) error {
+	boot, err := net.ListenPacket("udp4", ":"+strconv.Itoa(ServerPort))
+	if err != nil {
+		return err
+	}
+	defer boot.Close()
+	errs := make(chan error, 2)
+	go func() { errs <- dhcp4.Serve(boot, p.BootHandler()) }()
+	go func() { errs <- dhcp4.ListenAndServe(p) }()
+	return <-errs
+}
@@ -0,0 +1,161 @@
+package pxe
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+var (
+	serverIP = net.IP{192, 168, 1, 2}
+	mac      = net.HardwareAddr{0, 1, 2, 3, 4, 5}
+)
+
+// pxeOptions are those of a PXE client of architecture arch.
+func pxeOptions(arch byte, extra ...dhcp4.Option) []dhcp4.Option {
+	return append([]dhcp4.Option{
+		{Code: dhcp4.OptionVendorClassIdentifier, Value: []byte("PXEClient:Arch:00000:UNDI:002001")},
+		{Code: OptionClientSystemArchitecture, Value: []byte{0, arch}},
+		{Code: OptionClientMachineIdentifier, Value: []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}},
+	}, extra...)
+}
+
+func exchange(h dhcp4.Handler, mt dhcp4.MessageType, ciaddr net.IP, options []dhcp4.Option) (dhcp4.Packet, dhcp4.Options) {
+	req := dhcp4.RequestPacket(mt, mac, ciaddr, []byte{1, 2, 3, 4}, true, options)
+	res := h.ServeDHCP(req, mt, req.ParseOptions())
+	if res == nil {
+		return nil, nil
+	}
+	return res, res.ParseOptions()
+}
+
+func TestProxy(t *testing.T) {
+	p := New(serverIP, "undionly.kpxe")
+	p.Files = map[uint16]string{ArchEFIX64: "ipxe.efi"}
+	p.BootServer = net.IP{192, 168, 1, 3}
+
+	tests := []struct {
+		mt      dhcp4.MessageType
+		options []dhcp4.Option
+		reply   dhcp4.MessageType // 0 for none
+		file    string
+	}{
+		{dhcp4.Discover, pxeOptions(0), dhcp4.Offer, "undionly.kpxe"},
+		{dhcp4.Discover, pxeOptions(9), dhcp4.Offer, "ipxe.efi"},
+		{dhcp4.Discover, nil, 0, ""},               // Not PXE
+		{dhcp4.Discover, pxeOptions(0)[:1], 0, ""}, // No architecture
+		{dhcp4.Request, pxeOptions(0, dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: serverIP}), dhcp4.ACK, "undionly.kpxe"},
+		{dhcp4.Request, pxeOptions(0, dhcp4.Option{Code: dhcp4.OptionServerIdentifier, Value: []byte{192, 168, 1, 1}}), 0, ""},
+		{dhcp4.Release, pxeOptions(0), 0, ""},
+	}
+	for i, test := range tests {
+		res, opts := exchange(p, test.mt, nil, test.options)
+		if test.reply == 0 {
+			if res != nil {
+				t.Fatalf("%02d: test %v, unexpected reply: %v", i, test, opts)
+			}
+			continue
+		}
+		if res == nil || dhcp4.MessageType(opts[dhcp4.OptionDHCPMessageType][0]) != test.reply {
+			t.Fatalf("%02d: test %v, expected %v: %v", i, test, test.reply, opts)
+		}
+		if !res.YIAddr().Equal(net.IPv4zero) {
+			t.Fatalf("%02d: test %v, unexpected yiaddr %s", i, test, res.YIAddr())
+		}
+		if !res.SIAddr().Equal(p.BootServer) || string(bytes.TrimRight(res.File(), "\x00")) != test.file {
+			t.Fatalf("%02d: test %v, unexpected boot server %s file %q", i, test, res.SIAddr(), res.File())
+		}
+		if string(opts[dhcp4.OptionVendorClassIdentifier]) != "PXEClient" || len(opts[OptionClientMachineIdentifier]) != 17 {
+			t.Fatalf("%02d: test %v, unexpected options: %v", i, test, opts)
+		}
+		if !net.IP(opts[dhcp4.OptionServerIdentifier]).Equal(serverIP) {
+			t.Fatalf("%02d: test %v, unexpected server identifier: %v", i, test, opts)
+		}
+		if c := subOption(opts[dhcp4.OptionVendorSpecificInformation], discoveryControl); !bytes.Equal(c, []byte{0x0b}) {
+			t.Fatalf("%02d: test %v, unexpected discovery control: %v", i, test, c)
+		}
+	}
+}
+
+func TestProxyMenu(t *testing.T) {
+	p := New(serverIP, "undionly.kpxe")
+	p.Menu = []MenuItem{
+		{Type: 0, Description: "Local boot"},
+		{Type: 0x8001, Description: "Install", File: "install.kpxe"},
+	}
+	p.Prompt, p.MenuTimeout = "Press F8", 5*time.Second
+
+	res, opts := exchange(p, dhcp4.Discover, nil, pxeOptions(0))
+	if res == nil || len(bytes.TrimRight(res.File(), "\x00")) != 0 {
+		t.Fatalf("Discover, expected offer without boot file: %v", res)
+	}
+	vendor := opts[dhcp4.OptionVendorSpecificInformation]
+	for _, test := range []struct {
+		code byte
+		want []byte
+	}{
+		{discoveryControl, []byte{0x03}},
+		{bootServers, []byte{0x80, 0x01, 1, 192, 168, 1, 2}},
+		{bootMenu, []byte("\x00\x00\x0aLocal boot\x80\x01\x07Install")},
+		{menuPrompt, []byte("\x05Press F8")},
+	} {
+		if v := subOption(vendor, test.code); !bytes.Equal(v, test.want) {
+			t.Fatalf("sub-option %d, unexpected value %q != %q", test.code, v, test.want)
+		}
+	}
+
+	for i, test := range []struct {
+		timeout time.Duration
+		want    byte
+	}{
+		{0, 0}, // First item booted immediately
+		{1500 * time.Millisecond, 1},
+		{254 * time.Second, 254},
+		{255 * time.Second, 254}, // Not forever
+		{time.Hour, 254},
+	} {
+		p.MenuTimeout = test.timeout
+		_, opts := exchange(p, dhcp4.Discover, nil, pxeOptions(0))
+		if v := subOption(opts[dhcp4.OptionVendorSpecificInformation], menuPrompt); len(v) == 0 || v[0] != test.want {
+			t.Fatalf("%02d: test %v, unexpected menu timeout: %v", i, test.timeout, v)
+		}
+	}
+
+	// The boot server exchange, on port 4011
+	boot := p.BootHandler()
+	ciaddr := net.IP{192, 168, 1, 50}
+	tests := []struct {
+		item []byte // Sub-option 71
+		file string
+	}{
+		{[]byte{0x80, 0x01, 0, 0}, "install.kpxe"},
+		{[]byte{0x80, 0x01, 0x80, 1}, "install.kpxe"}, // Credentials unsupported
+		{nil, "undionly.kpxe"},
+	}
+	for i, test := range tests {
+		var vendor []byte
+		if test.item != nil {
+			vendor = subOptions(bootItem, test.item)
+		}
+		res, opts := exchange(boot, dhcp4.Request, ciaddr, pxeOptions(0, dhcp4.Option{Code: dhcp4.OptionVendorSpecificInformation, Value: vendor}))
+		if res == nil || dhcp4.MessageType(opts[dhcp4.OptionDHCPMessageType][0]) != dhcp4.ACK {
+			t.Fatalf("%02d: test %v, expected ACK: %v", i, test, opts)
+		}
+		if !res.CIAddr().Equal(ciaddr) || !res.YIAddr().Equal(net.IPv4zero) {
+			t.Fatalf("%02d: test %v, unexpected addresses %s %s", i, test, res.CIAddr(), res.YIAddr())
+		}
+		if f := string(bytes.TrimRight(res.File(), "\x00")); f != test.file {
+			t.Fatalf("%02d: test %v, unexpected file %q", i, test, f)
+		}
+		item := subOption(opts[dhcp4.OptionVendorSpecificInformation], bootItem)
+		if test.item != nil && !bytes.Equal(item, []byte{test.item[0], test.item[1], test.item[2] & 0x7f, test.item[3]}) {
+			t.Fatalf("%02d: test %v, unexpected boot item %v", i, test, item)
+		}
+	}
+	if res, _ := exchange(boot, dhcp4.Discover, nil, pxeOptions(0)); res != nil {
+		t.Fatalf("Discover on boot server, unexpected reply")
+	}
+}
@@ -0,0 +1,53 @@
+package relay
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+)
+
+// udpConn is a Conn over a UDP socket, learning and choosing interfaces from
+// IP control messages.
+type udpConn struct {
+	*ipv4.PacketConn
+}
+
+// NewConn returns a Conn over pc, a UDP socket.
+func NewConn(pc net.PacketConn) (Conn, error) {
+	p := ipv4.NewPacketConn(pc)
+	if err := p.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		return nil, err
+	}
+	return udpConn{p}, nil
+}
+
+// ListenUDP returns a Conn over a UDP socket listening on laddr, such as
+// ":67".
+func ListenUDP(laddr string) (Conn, error) {
+	pc, err := net.ListenPacket("udp4", laddr)
+	if err != nil {
+		return nil, err
+	}
+	c, err := NewConn(pc)
+	if err != nil {
+		pc.Close()
+	}
+	return c, err
+}
+
+func (c udpConn) ReadFrom(b []byte) (int, int, net.Addr, error) {
+	n, cm, addr, err := c.PacketConn.ReadFrom(b)
+	ifIndex := 0
+	if cm != nil {
+		ifIndex = cm.IfIndex
+	}
+	return n, ifIndex, addr, err
+}
+
+func (c udpConn) WriteTo(b []byte, ifIndex int, addr net.Addr) (int, error) {
+	var cm *ipv4.ControlMessage
+	if ifIndex != 0 {
+		cm = &ipv4.ControlMessage{IfIndex: ifIndex}
+	}
+	return c.PacketConn.WriteTo(b, cm, addr)
+}
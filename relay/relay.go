@@ -0,0 +1,236 @@
+// Package relay implements a DHCP relay agent (RFC 1542), passing requests
+// from clients on its links to servers elsewhere, and their replies back.
+//
+// Requests from clients are given the relay's address on their link as
+// giaddr, telling servers which subnet to lease from and where to reply,
+// along with relay agent information (option 82, RFC 3046) identifying the
+// link.  The information is stripped from replies before they are delivered.
+package relay
+
+import (
+	"errors"
+	"net"
+
+	"github.com/krolaw/dhcp4"
+)
+
+// DefaultMaxHops is the default limit on relay agents a request may pass
+// through (RFC 1542 section 4.1.1).
+const DefaultMaxHops = 4
+
+// Relay agent information sub-options (RFC 3046)
+const (
+	AgentCircuitID = 1
+	AgentRemoteID  = 2
+)
+
+// Interface is a link to clients.
+type Interface struct {
+	Name  string
+	Index int    // Interface index, identifying the link to Conn
+	Addr  net.IP // The relay's address on the link, sent as giaddr
+	// CircuitID and RemoteID, if not nil, are sent as the sub-options of
+	// option 82 identifying the link.
+	CircuitID, RemoteID []byte
+}
+
+// NewInterface returns the Interface of the network interface name.  Its
+// address is the interface's first IPv4 address, its circuit id its name and
+// its remote id its hardware address.
+func NewInterface(name string) (*Interface, error) {
+	ifi, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.To4() != nil {
+			i := &Interface{Name: name, Index: ifi.Index, Addr: n.IP.To4(), CircuitID: []byte(name)}
+			if len(ifi.HardwareAddr) > 0 {
+				i.RemoteID = []byte(ifi.HardwareAddr)
+			}
+			return i, nil
+		}
+	}
+	return nil, errors.New("relay: no IPv4 address on " + name)
+}
+
+// agentInfo returns the option 82 value identifying i, or nil if empty.
+func (i *Interface) agentInfo() []byte {
+	var b []byte
+	if i.CircuitID != nil {
+		b = append(append(b, AgentCircuitID, byte(len(i.CircuitID))), i.CircuitID...)
+	}
+	if i.RemoteID != nil {
+		b = append(append(b, AgentRemoteID, byte(len(i.RemoteID))), i.RemoteID...)
+	}
+	return b
+}
+
+// Relay relays between clients on Interfaces and Servers.
+type Relay struct {
+	Servers     []*net.UDPAddr
+	Interfaces  []*Interface
+	MaxHops     int  // DefaultMaxHops if 0
+	NoAgentInfo bool // Don't add option 82
+}
+
+// New returns a Relay between the clients on interfaces and servers.
+func New(servers []*net.UDPAddr, interfaces ...*Interface) *Relay {
+	return &Relay{Servers: servers, Interfaces: interfaces}
+}
+
+// Conn carries a relay's packets, reporting the interface index packets
+// were received on, and sending on a given interface.
+type Conn interface {
+	ReadFrom(b []byte) (n int, ifIndex int, addr net.Addr, err error)
+	// WriteTo sends b to addr, on interface ifIndex, or as routed if 0.
+	WriteTo(b []byte, ifIndex int, addr net.Addr) (n int, err error)
+}
+
+// Serve relays packets read from conn, until it fails.
+func (r *Relay) Serve(conn Conn) error {
+	b := make([]byte, 1500)
+	for {
+		n, ifIndex, addr, err := conn.ReadFrom(b)
+		if err != nil {
+			return err
+		}
+		p := dhcp4.Packet(b[:n])
+		if n < 240 || p.HLen() > 16 {
+			continue
+		}
+		switch p.OpCode() {
+		case dhcp4.BootRequest:
+			i := r.iface(func(i *Interface) bool { return i.Index == ifIndex })
+			if i == nil {
+				continue
+			}
+			if req := r.Request(p, i); req != nil {
+				for _, s := range r.Servers {
+					if _, err := conn.WriteTo(req, 0, s); err != nil {
+						return err
+					}
+				}
+			}
+		case dhcp4.BootReply:
+			if !r.fromServer(addr) {
+				continue
+			}
+			if res, i, dst := r.Reply(p); res != nil {
+				if _, err := conn.WriteTo(res, i.Index, dst); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+func (r *Relay) iface(match func(*Interface) bool) *Interface {
+	for _, i := range r.Interfaces {
+		if match(i) {
+			return i
+		}
+	}
+	return nil
+}
+
+// fromServer returns true if addr is one of the servers.
+func (r *Relay) fromServer(addr net.Addr) bool {
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	for _, s := range r.Servers {
+		if s.IP.Equal(a.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *Relay) maxHops() int {
+	if r.MaxHops > 0 {
+		return r.MaxHops
+	}
+	return DefaultMaxHops
+}
+
+// Request returns req, received from interface i, as relayed to servers, or
+// nil if it's to be dropped: it has passed through too many relays, or has
+// already passed through this one, or it's from a client claiming to be a
+// relay with option 82 but no giaddr (RFC 3046 section 2.1).  If giaddr is
+// unset, it's set to i's address and option 82 added.
+func (r *Relay) Request(req dhcp4.Packet, i *Interface) dhcp4.Packet {
+	hops := int(req.Hops())
+	if hops >= r.maxHops() {
+		return nil
+	}
+	giaddr := net.IP(req.GIAddr())
+	if r.iface(func(i *Interface) bool { return i.Addr.Equal(giaddr) }) != nil {
+		return nil // Loop
+	}
+	p := append(dhcp4.Packet(nil), req...)
+	p.SetHops(byte(hops + 1))
+	if giaddr.Equal(net.IPv4zero) {
+		if _, ok := req.ParseOptions()[dhcp4.OptionRelayAgentInformation]; ok {
+			return nil
+		}
+		p.SetGIAddr(i.Addr)
+		if info := i.agentInfo(); !r.NoAgentInfo && info != nil {
+			p.AddOption(dhcp4.OptionRelayAgentInformation, info) // Last, as required
+			p.PadToMinSize()
+		}
+	}
+	return p
+}
+
+// Reply returns res, received from a server, as delivered to its client,
+// with the interface and address to send it to, or nil if it's not for a
+// client of the relay.  Option 82 is removed.  Replies are broadcast on the
+// client's link, unless the client has an address (ciaddr), as clients
+// without addresses can't be unicast to without writing to the ARP cache
+// (RFC 1542 section 5.4).  NAKs are always broadcast (RFC 2131 section 4.1).
+func (r *Relay) Reply(res dhcp4.Packet) (dhcp4.Packet, *Interface, *net.UDPAddr) {
+	giaddr := net.IP(res.GIAddr())
+	i := r.iface(func(i *Interface) bool { return i.Addr.Equal(giaddr) })
+	if i == nil {
+		return nil, nil, nil
+	}
+	p := withoutOption(res, dhcp4.OptionRelayAgentInformation)
+	dst := &net.UDPAddr{IP: net.IPv4bcast, Port: 68}
+	t := p.ParseOptions()[dhcp4.OptionDHCPMessageType]
+	nak := len(t) == 1 && dhcp4.MessageType(t[0]) == dhcp4.NAK
+	if ciaddr := net.IP(p.CIAddr()); !ciaddr.Equal(net.IPv4zero) && !nak {
+		dst.IP = append(net.IP(nil), ciaddr...)
+	}
+	return p, i, dst
+}
+
+// withoutOption returns a copy of p without option code.
+func withoutOption(p dhcp4.Packet, code dhcp4.OptionCode) dhcp4.Packet {
+	if _, ok := p.ParseOptions()[code]; !ok {
+		return append(dhcp4.Packet(nil), p...)
+	}
+	q := append(dhcp4.Packet(nil), p[:240]...)
+	q = append(q, byte(dhcp4.End))
+	for opts := p.Options(); len(opts) >= 2 && dhcp4.OptionCode(opts[0]) != dhcp4.End; {
+		if dhcp4.OptionCode(opts[0]) == dhcp4.Pad {
+			opts = opts[1:]
+			continue
+		}
+		n := 2 + int(opts[1])
+		if len(opts) < n {
+			break
+		}
+		if dhcp4.OptionCode(opts[0]) != code {
+			q.AddOption(dhcp4.OptionCode(opts[0]), opts[2:n])
+		}
+		opts = opts[n:]
+	}
+	q.PadToMinSize()
+	return q
+}
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/krolaw/dhcp4"
+)
+
+var (
+	eth1   = &Interface{Name: "eth1", Index: 2, Addr: net.IP{192, 168, 1, 254}, CircuitID: []byte("eth1"), RemoteID: []byte{1, 2, 3, 4, 5, 6}}
+	server = &net.UDPAddr{IP: net.IP{10, 0, 0, 1}, Port: 67}
+	client = net.HardwareAddr{0, 1, 2, 3, 4, 5}
+)
+
+func TestRequest(t *testing.T) {
+	info := []byte{AgentCircuitID, 4, 'e', 't', 'h', '1', AgentRemoteID, 6, 1, 2, 3, 4, 5, 6}
+	r := New([]*net.UDPAddr{server}, eth1)
+	for i, test := range []struct {
+		hops    byte
+		giaddr  net.IP
+		options []dhcp4.Option
+		dropped bool
+		info    []byte // Expected option 82
+	}{
+		{0, nil, nil, false, info},
+		{3, nil, nil, false, info},
+		{4, nil, nil, true, nil}, // Too many hops
+		{1, net.IP{10, 1, 1, 1}, nil, false, nil},
+		{1, net.IP{192, 168, 1, 254}, nil, true, nil}, // Loop
+		{0, nil, []dhcp4.Option{{Code: dhcp4.OptionRelayAgentInformation, Value: []byte{1, 1, 'x'}}}, true, nil},
+		{1, net.IP{10, 1, 1, 1}, []dhcp4.Option{{Code: dhcp4.OptionRelayAgentInformation, Value: []byte{1, 1, 'x'}}}, false, []byte{1, 1, 'x'}},
+	} {
+		req := dhcp4.RequestPacket(dhcp4.Discover, client, nil, []byte{1, 2, 3, 4}, true, test.options)
+		req.SetHops(test.hops)
+		if test.giaddr != nil {
+			req.SetGIAddr(test.giaddr)
+		}
+		p := r.Request(req, eth1)
+		if (p == nil) != test.dropped {
+			t.Fatalf("%02d: test %d %v, unexpected drop: %v", i, test.hops, test.giaddr, p == nil)
+		}
+		if p == nil {
+			continue
+		}
+		options := p.ParseOptions()
+		giaddr := test.giaddr
+		if giaddr == nil {
+			giaddr = eth1.Addr
+		}
+		if p.Hops() != test.hops+1 || !net.IP(p.GIAddr()).Equal(giaddr) || !bytes.Equal(options[dhcp4.OptionRelayAgentInformation], test.info) {
+			t.Fatalf("%02d: test %d %v, unexpected request: hops %d giaddr %v info %v", i, test.hops, test.giaddr, p.Hops(), p.GIAddr(), options[dhcp4.OptionRelayAgentInformation])
+		}
+		if dhcp4.MessageType(options[dhcp4.OptionDHCPMessageType][0]) != dhcp4.Discover || !bytes.Equal(req.CHAddr(), p.CHAddr()) {
+			t.Fatalf("%02d: request altered: %v", i, options)
+		}
+	}
+}
+
+func TestReply(t *testing.T) {
+	r := New([]*net.UDPAddr{server}, eth1)
+	req := dhcp4.RequestPacket(dhcp4.Request, client, nil, []byte{1, 2, 3, 4}, false, nil)
+	for i, test := range []struct {
+		mt     dhcp4.MessageType
+		ciaddr net.IP
+		giaddr net.IP
+		dst    net.IP // nil if dropped
+	}{
+		{dhcp4.Offer, nil, eth1.Addr, net.IPv4bcast},
+		{dhcp4.ACK, net.IP{192, 168, 1, 20}, eth1.Addr, net.IP{192, 168, 1, 20}},
+		{dhcp4.NAK, net.IP{192, 168, 1, 20}, eth1.Addr, net.IPv4bcast},
+		{dhcp4.ACK, nil, net.IP{10, 1, 1, 1}, nil}, // Not ours
+	} {
+		req.SetGIAddr(test.giaddr)
+		res := dhcp4.ReplyPacket(req, test.mt, server.IP, net.IP{192, 168, 1, 20}, time.Hour, []dhcp4.Option{
+			{Code: dhcp4.OptionRelayAgentInformation, Value: eth1.agentInfo()},
+			{Code: dhcp4.OptionRouter, Value: []byte{192, 168, 1, 254}},
+		})
+		if test.ciaddr != nil {
+			res.SetCIAddr(test.ciaddr)
+		}
+		p, i2, dst := r.Reply(res)
+		if test.dst == nil {
+			if p != nil {
+				t.Fatalf("%02d: test %v, unexpected reply to %v", i, test.mt, dst)
+			}
+			continue
+		}
+		options := p.ParseOptions()
+		if i2 != eth1 || !dst.IP.Equal(test.dst) || dst.Port != 68 {
+			t.Fatalf("%02d: test %v, unexpected destination %v %v", i, test.mt, i2, dst)
+		}
+		if _, ok := options[dhcp4.OptionRelayAgentInformation]; ok || len(options[dhcp4.OptionRouter]) != 4 || len(p) < 272 {
+			t.Fatalf("%02d: test %v, unexpected options: %v", i, test.mt, options)
+		}
+	}
+}
+
+type packet struct {
+	b       []byte
+	ifIndex int
+	addr    net.Addr
+}
+
+// memConn is a Conn whose upstream writes are answered by a server, which
+// echoes option 82 (RFC 3046 section 2.2).
+type memConn struct {
+	s    *dhcp4.Server
+	in   chan packet
+	sent chan packet // To clients
+}
+
+func (c *memConn) ReadFrom(b []byte) (int, int, net.Addr, error) {
+	p, ok := <-c.in
+	if !ok {
+		return 0, 0, nil, errors.New("closed")
+	}
+	return copy(b, p.b), p.ifIndex, p.addr, nil
+}
+
+func (c *memConn) WriteTo(b []byte, ifIndex int, addr net.Addr) (int, error) {
+	p := append(dhcp4.Packet(nil), b...)
+	if ifIndex != 0 {
+		c.sent <- packet{p, ifIndex, addr}
+		return len(b), nil
+	}
+	options := p.ParseOptions()
+	res := c.s.ServeDHCP(p, dhcp4.MessageType(options[dhcp4.OptionDHCPMessageType][0]), options)
+	if res != nil {
+		if info, ok := options[dhcp4.OptionRelayAgentInformation]; ok {
+			res.AddOption(dhcp4.OptionRelayAgentInformation, info)
+		}
+		c.in <- packet{res, 1, addr}
+	}
+	return len(b), nil
+}
+
+func TestServe(t *testing.T) {
+	s := dhcp4.NewConfiguredServer(&dhcp4.ServerConfig{
+		ServerID:  server.IP,
+		LeaseTime: time.Hour,
+		Subnets: dhcp4.NewSubnetSelector(nil,
+			&dhcp4.Subnet{Net: net.IPNet{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(24, 32)}, Pools: []*dhcp4.Pool{{Start: net.IP{10, 0, 0, 10}, Range: 10}}},
+			&dhcp4.Subnet{Net: net.IPNet{IP: net.IP{192, 168, 1, 0}, Mask: net.CIDRMask(24, 32)}, Pools: []*dhcp4.Pool{{Start: net.IP{192, 168, 1, 10}, Range: 10}}}),
+	}, nil)
+	c := &memConn{s: s, in: make(chan packet, 4), sent: make(chan packet, 4)}
+	r := New([]*net.UDPAddr{server}, eth1)
+	done := make(chan error)
+	go func() { done <- r.Serve(c) }()
+
+	exchange := func(req dhcp4.Packet, ifIndex int) dhcp4.Packet {
+		c.in <- packet{req, ifIndex, &net.UDPAddr{IP: net.IPv4zero, Port: 68}}
+		select {
+		case p := <-c.sent:
+			if p.ifIndex != eth1.Index || !p.addr.(*net.UDPAddr).IP.Equal(net.IPv4bcast) {
+				t.Fatalf("reply sent to %d %v", p.ifIndex, p.addr)
+			}
+			return p.b
+		case <-time.After(100 * time.Millisecond):
+			return nil
+		}
+	}
+	offer := exchange(dhcp4.RequestPacket(dhcp4.Discover, client, nil, []byte{1, 2, 3, 4}, true, nil), eth1.Index)
+	if offer == nil || !dhcp4.IPInRange(net.IP{192, 168, 1, 10}, net.IP{192, 168, 1, 19}, offer.YIAddr()) {
+		t.Fatalf("unexpected offer: %v", offer)
+	}
+	if _, ok := offer.ParseOptions()[dhcp4.OptionRelayAgentInformation]; ok {
+		t.Fatalf("option 82 not stripped")
+	}
+	if p := exchange(dhcp4.RequestPacket(dhcp4.Discover, client, nil, []byte{1, 2, 3, 4}, true, nil), 3); p != nil {
+		t.Fatalf("relayed from unknown interface: %v", p)
+	}
+	close(c.in)
+	<-done
+}
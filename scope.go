@@ -0,0 +1,72 @@
+package dhcp4
+
+import "sort"
+
+// OptionScope holds the options configured at one level of a server's
+// configuration: global, shared network, subnet, pool, client class or host
+// reservation.  Options in inner scopes override those in outer ones.
+type OptionScope struct {
+	// Options to send, if requested.  A nil value removes an option set by
+	// an outer scope.
+	Options Options
+	// ForceSend lists options sent even if the client didn't request them.
+	ForceSend []OptionCode
+}
+
+// MergeOptions returns the effective options of scopes, ordered from
+// outermost to innermost.  Nil scopes are skipped.
+func MergeOptions(scopes ...*OptionScope) Options {
+	o := make(Options)
+	for _, s := range scopes {
+		if s == nil {
+			continue
+		}
+		for code, v := range s.Options {
+			if v == nil {
+				delete(o, code)
+			} else {
+				o[code] = v
+			}
+		}
+	}
+	return o
+}
+
+// ResolveOptions returns the options to send a client from scopes, ordered
+// from outermost to innermost (e.g. global, shared network, subnet, pool,
+// classes, host).  Options are those in the client's parameter request list
+// prl, in its order, followed by any forced options not requested.  If prl
+// is nil, all options are sent, in code order.
+func ResolveOptions(prl []byte, scopes ...*OptionScope) []Option {
+	o := MergeOptions(scopes...)
+	if prl == nil {
+		codes := make([]int, 0, len(o))
+		for code := range o {
+			codes = append(codes, int(code))
+		}
+		sort.Ints(codes)
+		opts := make([]Option, len(codes))
+		for i, code := range codes {
+			opts[i] = Option{Code: OptionCode(code), Value: o[OptionCode(code)]}
+		}
+		return opts
+	}
+
+	opts := o.SelectOrder(prl)
+	sent := make(map[OptionCode]bool, len(opts))
+	for _, opt := range opts {
+		sent[opt.Code] = true
+	}
+	for _, s := range scopes {
+		if s == nil {
+			continue
+		}
+		for _, code := range s.ForceSend {
+			if v, ok := o[code]; ok && !sent[code] {
+				opts = append(opts, Option{Code: code, Value: v})
+				sent[code] = true
+			}
+		}
+	}
+	return opts
+}
@@ -0,0 +1,67 @@
+package dhcp4
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestResolveOptions(t *testing.T) {
+	global := &OptionScope{Options: Options{
+		OptionDomainNameServer:           []byte{8, 8, 8, 8},
+		OptionDomainName:                 []byte("example.com"),
+		OptionNetworkTimeProtocolServers: []byte{10, 0, 0, 1},
+	}}
+	_, n, _ := net.ParseCIDR("10.0.1.0/24")
+	pool := &Pool{Start: net.IP{10, 0, 1, 100}, Range: 50, Scope: OptionScope{
+		Options: Options{OptionDomainName: []byte("pool.example.com")},
+	}}
+	subnet := &Subnet{Net: *n, Pools: []*Pool{pool}, Scope: OptionScope{
+		Options:   Options{OptionRouter: []byte{10, 0, 1, 1}, OptionSubnetMask: []byte{255, 255, 255, 0}},
+		ForceSend: []OptionCode{OptionSubnetMask},
+	}}
+	network := &SharedNetwork{Name: "floor1", Subnets: []*Subnet{subnet}, Scope: OptionScope{
+		Options: Options{OptionDomainNameServer: []byte{10, 0, 0, 53}},
+	}}
+	NewSubnetSelector([]*SharedNetwork{network})
+	class := &OptionScope{
+		Options:   Options{OptionNetworkTimeProtocolServers: nil, OptionVendorClassIdentifier: []byte("PXEClient")},
+		ForceSend: []OptionCode{OptionVendorClassIdentifier, OptionDomainName},
+	}
+	host := &OptionScope{Options: Options{OptionRouter: []byte{10, 0, 1, 2}}}
+
+	scopes := append(append([]*OptionScope{global}, subnet.Scopes(subnet.Pool(net.IP{10, 0, 1, 120}))...), class, host)
+	for i, test := range []struct {
+		prl  []byte
+		opts []Option
+	}{
+		{[]byte{byte(OptionRouter), byte(OptionDomainNameServer), byte(OptionNetworkTimeProtocolServers)}, []Option{
+			{OptionRouter, []byte{10, 0, 1, 2}},
+			{OptionDomainNameServer, []byte{10, 0, 0, 53}},
+			{OptionSubnetMask, []byte{255, 255, 255, 0}},
+			{OptionVendorClassIdentifier, []byte("PXEClient")},
+			{OptionDomainName, []byte("pool.example.com")},
+		}},
+		{[]byte{byte(OptionDomainName), byte(OptionSubnetMask)}, []Option{
+			{OptionDomainName, []byte("pool.example.com")},
+			{OptionSubnetMask, []byte{255, 255, 255, 0}},
+			{OptionVendorClassIdentifier, []byte("PXEClient")},
+		}},
+		{nil, []Option{
+			{OptionSubnetMask, []byte{255, 255, 255, 0}},
+			{OptionRouter, []byte{10, 0, 1, 2}},
+			{OptionDomainNameServer, []byte{10, 0, 0, 53}},
+			{OptionDomainName, []byte("pool.example.com")},
+			{OptionVendorClassIdentifier, []byte("PXEClient")},
+		}},
+	} {
+		if opts := ResolveOptions(test.prl, scopes...); !reflect.DeepEqual(opts, test.opts) {
+			t.Fatalf("%02d: test %v, unexpected options: %v != %v", i, test.prl, opts, test.opts)
+		}
+	}
+
+	// Outside any pool, the pool's options don't apply
+	if o := MergeOptions(subnet.Scopes(subnet.Pool(net.IP{10, 0, 1, 20}))...); o[OptionDomainName] != nil {
+		t.Fatalf("Outside pool, unexpected domain name: %s", o[OptionDomainName])
+	}
+}
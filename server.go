@@ -33,6 +33,9 @@ type ServeConn interface {
 // packets sent to any interface on the system may be delivered to this
 // socket.  See: https://code.google.com/p/go/issues/detail?id=7106
 //
+// Replies to requests relayed by a relay agent (with giaddr set) are sent
+// back to the agent that sent them, rather than broadcast.
+//
 // Additionally, response packets may not return to the same
 // interface that the request was received from.  Writing a custom ServeConn,
 // or using ServeIf() can provide a workaround to this problem.
@@ -67,7 +70,8 @@ func Serve(conn ServeConn, handler Handler) error {
 				return err
 			}
 
-			if net.ParseIP(ipStr).Equal(net.IPv4zero) || req.Broadcast() {
+			relayed := !net.IP(req.GIAddr()).Equal(net.IPv4zero) // Reply to the relay agent
+			if !relayed && (net.ParseIP(ipStr).Equal(net.IPv4zero) || req.Broadcast()) {
 				port, _ := strconv.Atoi(portStr)
 				addr = &net.UDPAddr{IP: net.IPv4bcast, Port: port}
 			}
@@ -0,0 +1,55 @@
+package dhcp4
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// testConn is a ServeConn reading reqs, from from, and recording writes.
+type testConn struct {
+	reqs []Packet
+	from net.Addr
+	dsts []net.Addr
+}
+
+func (c *testConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(c.reqs) == 0 {
+		return 0, nil, errors.New("done")
+	}
+	n := copy(b, c.reqs[0])
+	c.reqs = c.reqs[1:]
+	return n, c.from, nil
+}
+
+func (c *testConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.dsts = append(c.dsts, addr)
+	return len(b), nil
+}
+
+func TestServeReplyAddress(t *testing.T) {
+	relay := &net.UDPAddr{IP: net.IP{10, 0, 0, 254}, Port: 67}
+	for i, test := range []struct {
+		from      *net.UDPAddr
+		giaddr    net.IP
+		broadcast bool
+		want      string
+	}{
+		{&net.UDPAddr{IP: net.IPv4zero, Port: 68}, nil, false, "255.255.255.255:68"},
+		{&net.UDPAddr{IP: net.IP{192, 168, 1, 5}, Port: 68}, nil, true, "255.255.255.255:68"},
+		{&net.UDPAddr{IP: net.IP{192, 168, 1, 5}, Port: 68}, nil, false, "192.168.1.5:68"},
+		{relay, relay.IP, true, "10.0.0.254:67"}, // Relayed
+	} {
+		s := NewServer(net.IP{192, 168, 1, 1}, net.IP{192, 168, 1, 10}, 10, time.Hour, nil, nil)
+		req := RequestPacket(Discover, net.HardwareAddr{0, 1, 2, 3, 4, 5}, nil, []byte{1, 2, 3, 4}, test.broadcast, nil)
+		if test.giaddr != nil {
+			req.SetGIAddr(test.giaddr)
+		}
+		c := &testConn{reqs: []Packet{req}, from: test.from}
+		Serve(c, s)
+		if len(c.dsts) != 1 || c.dsts[0].String() != test.want {
+			t.Fatalf("%02d: test %v, unexpected reply destination: %v != %s", i, test.from, c.dsts, test.want)
+		}
+	}
+}
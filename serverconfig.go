@@ -0,0 +1,107 @@
+package dhcp4
+
+import (
+	"bytes"
+	"net"
+	"time"
+)
+
+// ServerConfig describes what a Server leases, and to whom: subnets and
+// their pools, client classes, host reservations and options.
+type ServerConfig struct {
+	ServerID     net.IP        // Server IP to use
+	LeaseTime    time.Duration // Lease period, unless a scope sets option 51
+	Global       OptionScope   // Options for all clients
+	Subnets      *SubnetSelector
+	Classifier   Classifier // Optional, such as a classify.Classifier
+	Reservations []*Reservation
+}
+
+// ClassSet is a set of client class names.
+type ClassSet map[string]bool
+
+// Classifier assigns clients to classes, which select pools (see
+// Pool.Classes) and options.
+type Classifier interface {
+	Classify(req Packet, options Options) ClassSet
+	// Scopes returns the option scopes of classes, in order of precedence.
+	Scopes(classes ClassSet) []*OptionScope
+}
+
+// Reservation is a host reservation: a fixed address and/or options for a
+// client, identified by client identifier or hardware address.
+type Reservation struct {
+	Name         string
+	HardwareAddr net.HardwareAddr
+	ClientID     []byte
+	IP           net.IP // Fixed address, or nil to lease from pools
+	Hostname     string // Sent as OptionHostName, if set
+	Scope        OptionScope
+}
+
+// holds returns true if r is for the client holding lease l.
+func (r *Reservation) holds(l Lease) bool {
+	if len(l.ClientID) > 0 && len(r.ClientID) > 0 {
+		return bytes.Equal(l.ClientID, r.ClientID)
+	}
+	return len(r.HardwareAddr) > 0 && bytes.Equal(r.HardwareAddr, l.HardwareAddr)
+}
+
+// matches returns true if r is for the client that sent p.
+func (r *Reservation) matches(p Packet, options Options) bool {
+	if id := options[OptionClientIdentifier]; len(id) > 0 && len(r.ClientID) > 0 {
+		return bytes.Equal(id, r.ClientID)
+	}
+	return len(r.HardwareAddr) > 0 && bytes.Equal(r.HardwareAddr, p.CHAddr())
+}
+
+// plan is a ServerConfig indexed for serving.
+type plan struct {
+	*ServerConfig
+	reserved map[string]*Reservation // By fixed address
+}
+
+func newPlan(c *ServerConfig) *plan {
+	c.ServerID = c.ServerID.To4()
+	p := &plan{ServerConfig: c, reserved: make(map[string]*Reservation)}
+	for _, r := range c.Reservations {
+		if r.IP != nil {
+			p.reserved[r.IP.String()] = r
+		}
+	}
+	return p
+}
+
+// reservation returns the reservation for the client that sent p, if any.
+func (p *plan) reservation(req Packet, options Options) *Reservation {
+	for _, r := range p.Reservations {
+		if r.matches(req, options) {
+			return r
+		}
+	}
+	return nil
+}
+
+func (p *plan) classify(req Packet, options Options) ClassSet {
+	if p.Classifier == nil {
+		return nil
+	}
+	return p.Classifier.Classify(req, options)
+}
+
+// valid returns true if lease l may be held under the plan: its address is
+// reserved for its client, or in a pool and not reserved for another.
+// Class restrictions are checked when the client renews.
+func (p *plan) valid(l Lease) bool {
+	if r, ok := p.reserved[l.IP.String()]; ok {
+		return r.holds(l)
+	}
+	for _, n := range p.Subnets.networks {
+		for _, sn := range n.Subnets {
+			if sn.Pool(l.IP) != nil {
+				return true
+			}
+		}
+	}
+	return false
+}
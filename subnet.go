@@ -0,0 +1,167 @@
+package dhcp4
+
+import "net"
+
+// Subnet is an IPv4 subnet served to clients, with the pools of addresses
+// leased on it and options for its clients (such as OptionRouter).
+type Subnet struct {
+	Net     net.IPNet
+	Pools   []*Pool
+	Scope   OptionScope
+	Network *SharedNetwork // The link the subnet is on, set by NewSubnetSelector
+}
+
+// Pool is a range of addresses leased to clients.
+type Pool struct {
+	Start net.IP // Start of IP range to distribute
+	Range int    // Number of IPs to distribute (starting from Start)
+	Scope OptionScope
+	// Classes, if set, restricts the pool to clients in at least one of
+	// these classes (see package classify).
+	Classes []string
+}
+
+// Permits returns true if a client in classes may lease from the pool.
+func (p *Pool) Permits(classes ClassSet) bool {
+	if len(p.Classes) == 0 {
+		return true
+	}
+	for _, c := range p.Classes {
+		if classes[c] {
+			return true
+		}
+	}
+	return false
+}
+
+// Contains returns true if ip is in the pool.
+func (p *Pool) Contains(ip net.IP) bool {
+	if ip.To4() == nil {
+		return false
+	}
+	n := IPRange(p.Start, ip) - 1
+	return n >= 0 && n < p.Range
+}
+
+// Contains returns true if ip is on the subnet.
+func (s *Subnet) Contains(ip net.IP) bool { return s.Net.Contains(ip) }
+
+// Scopes returns the option scopes of the subnet's shared network, the
+// subnet, and pool (if not nil), from outermost to innermost.
+func (s *Subnet) Scopes(pool *Pool) []*OptionScope {
+	scopes := []*OptionScope{&s.Scope}
+	if s.Network != nil {
+		scopes = append([]*OptionScope{&s.Network.Scope}, scopes...)
+	}
+	if pool != nil {
+		scopes = append(scopes, &pool.Scope)
+	}
+	return scopes
+}
+
+// PermittedPools returns the subnet's pools a client in classes may lease
+// from.
+func (s *Subnet) PermittedPools(classes ClassSet) []*Pool {
+	var pools []*Pool
+	for _, p := range s.Pools {
+		if p.Permits(classes) {
+			pools = append(pools, p)
+		}
+	}
+	return pools
+}
+
+// Pool returns the subnet's pool containing ip, or nil.
+func (s *Subnet) Pool(ip net.IP) *Pool {
+	for _, p := range s.Pools {
+		if p.Contains(ip) {
+			return p
+		}
+	}
+	return nil
+}
+
+// SharedNetwork is a set of subnets on the same link.  A client on the link
+// may be leased an address from any of them, whichever subnet its request
+// selects.
+type SharedNetwork struct {
+	Name    string
+	Subnets []*Subnet
+	Scope   OptionScope
+}
+
+// Relay agent information (option 82) sub-options
+const relayLinkSelection = 5 // RFC 3527
+
+// SubnetSelector chooses the subnet a request belongs to.
+type SubnetSelector struct {
+	networks []*SharedNetwork
+}
+
+// NewSubnetSelector returns a SubnetSelector choosing between the subnets of
+// networks, and subnets which are each alone on their link.
+func NewSubnetSelector(networks []*SharedNetwork, subnets ...*Subnet) *SubnetSelector {
+	s := &SubnetSelector{}
+	for _, n := range networks {
+		for _, sn := range n.Subnets {
+			sn.Network = n
+		}
+		s.networks = append(s.networks, n)
+	}
+	for _, sn := range subnets {
+		sn.Network = &SharedNetwork{Name: sn.Net.String(), Subnets: []*Subnet{sn}}
+		s.networks = append(s.networks, sn.Network)
+	}
+	return s
+}
+
+// Networks returns the shared networks subnets are selected from.
+func (s *SubnetSelector) Networks() []*SharedNetwork { return s.networks }
+
+// Select returns the subnet containing req's selection address (see
+// SelectionAddress), or nil if there isn't one.  local is the address of the
+// interface req was received on.  The returned subnet's Network holds the
+// other subnets on the same link.
+func (s *SubnetSelector) Select(req Packet, options Options, local net.IP) *Subnet {
+	ip := SelectionAddress(req, options, local)
+	if ip == nil {
+		return nil
+	}
+	for _, n := range s.networks {
+		for _, sn := range n.Subnets {
+			if sn.Contains(ip) {
+				return sn
+			}
+		}
+	}
+	return nil
+}
+
+// SelectionAddress returns the address identifying the client's subnet,
+// which is, in order of precedence: the link selection sub-option of relay
+// agent information (RFC 3527); the subnet selection option (RFC 3011); the
+// relay agent's address (giaddr); and local, the address of the interface
+// the request was received on.
+func SelectionAddress(req Packet, options Options, local net.IP) net.IP {
+	if ip := relayAgentSubOption(options[OptionRelayAgentInformation], relayLinkSelection); len(ip) == 4 {
+		return net.IP(ip)
+	}
+	if ip := options[OptionSubnetSelection]; len(ip) == 4 {
+		return net.IP(ip)
+	}
+	if ip := net.IP(req.GIAddr()); !ip.Equal(net.IPv4zero) {
+		return ip
+	}
+	return local
+}
+
+// relayAgentSubOption returns sub-option code of relay agent information b.
+func relayAgentSubOption(b []byte, code byte) []byte {
+	for len(b) >= 2 && len(b) >= 2+int(b[1]) {
+		if b[0] == code {
+			return b[2 : 2+b[1]]
+		}
+		b = b[2+b[1]:]
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package dhcp4
+
+import (
+	"net"
+	"testing"
+)
+
+func testSubnet(cidr string) *Subnet {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return &Subnet{Net: *n, Pools: []*Pool{{Start: IPAdd(n.IP, 10), Range: 100}}}
+}
+
+func TestSubnetSelector(t *testing.T) {
+	a, b := testSubnet("10.0.1.0/24"), testSubnet("10.0.2.0/24")
+	shared := &SharedNetwork{Name: "floor1", Subnets: []*Subnet{a, b}}
+	c := testSubnet("192.168.1.0/24")
+	s := NewSubnetSelector([]*SharedNetwork{shared}, c)
+
+	local := net.IP{192, 168, 1, 1}
+	linkSelection := []byte{1, 3, 'e', 't', '0', relayLinkSelection, 4, 10, 0, 2, 0}
+	for i, test := range []struct {
+		giaddr  net.IP
+		options []Option
+		subnet  *Subnet
+	}{
+		{nil, nil, c},
+		{net.IP{10, 0, 1, 1}, nil, a},
+		{net.IP{10, 0, 1, 1}, []Option{{OptionSubnetSelection, []byte{10, 0, 2, 0}}}, b},
+		{net.IP{10, 0, 1, 1}, []Option{{OptionRelayAgentInformation, linkSelection}}, b},
+		{nil, []Option{
+			{OptionRelayAgentInformation, linkSelection},
+			{OptionSubnetSelection, []byte{192, 168, 1, 0}},
+		}, b},
+		{nil, []Option{{OptionRelayAgentInformation, []byte{1, 3, 'e', 't', '0'}}}, c},
+		{nil, []Option{{OptionRelayAgentInformation, []byte{relayLinkSelection, 9, 10}}}, c}, // Malformed
+		{net.IP{172, 16, 0, 1}, nil, nil},
+	} {
+		req := RequestPacket(Discover, net.HardwareAddr{0, 1, 2, 3, 4, 5}, nil, []byte{1, 2, 3, 4}, true, test.options)
+		req.SetGIAddr(test.giaddr)
+		if sn := s.Select(req, req.ParseOptions(), local); sn != test.subnet {
+			t.Fatalf("%02d: test %v, unexpected subnet: %v != %v", i, test.giaddr, sn, test.subnet)
+		}
+	}
+
+	if a.Network != shared || b.Network != shared {
+		t.Fatalf("Shared network not set")
+	}
+	if len(c.Network.Subnets) != 1 || c.Network.Subnets[0] != c {
+		t.Fatalf("Lone subnet, unexpected network: %v", c.Network)
+	}
+}